@@ -1,11 +1,29 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/genc-murat/harborview/internal/alerts"
 	"github.com/genc-murat/harborview/internal/auth"
 	"github.com/genc-murat/harborview/internal/containers"
+	"github.com/genc-murat/harborview/internal/deploy"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/internal/endpoints"
+	"github.com/genc-murat/harborview/internal/events"
 	"github.com/genc-murat/harborview/internal/images"
+	"github.com/genc-murat/harborview/internal/metrics"
+	"github.com/genc-murat/harborview/internal/monitoring"
+	"github.com/genc-murat/harborview/internal/networks"
+	"github.com/genc-murat/harborview/internal/registry"
+	"github.com/genc-murat/harborview/internal/stacks"
+	"github.com/genc-murat/harborview/internal/system"
+	"github.com/genc-murat/harborview/internal/volumes"
+	"github.com/genc-murat/harborview/internal/webhooks"
 	"github.com/genc-murat/harborview/pkg/config"
 	"github.com/genc-murat/harborview/pkg/middleware"
 
@@ -17,20 +35,97 @@ func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	// Stop background work and in-flight requests on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// One Docker client shared by every service of the local daemon
+	cli, err := docker.NewClient()
+	if err != nil {
+		log.Fatalf("Failed to create Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	startupTimeout := 30 * time.Second
+	if cfg.Docker.StartupTimeout > 0 {
+		startupTimeout = time.Duration(cfg.Docker.StartupTimeout) * time.Second
+	}
+	ping, err := docker.WaitReady(ctx, cli, startupTimeout)
+	switch {
+	case err == nil:
+		log.Printf("Connected to Docker daemon at %s (API %s, %s)", cli.DaemonHost(), ping.APIVersion, ping.OSType)
+	case cfg.Docker.Optional:
+		log.Printf("Continuing without the local Docker daemon: %v", err)
+	default:
+		log.Fatalf("Startup health check failed: %v", err)
+	}
+
 	// Create Fiber app
-	app := fiber.New()
+	// Image tarball uploads are far larger than Fiber's 4MB default body limit
+	bodyLimit := fiber.DefaultBodyLimit
+	if cfg.Server.MaxUploadMB > 0 {
+		bodyLimit = cfg.Server.MaxUploadMB * 1024 * 1024
+	}
+	// Bodies are streamed so uploads are not held in memory, and multipart
+	// forms are read by the upload handlers rather than spooled to disk up
+	// front. Fiber does not enforce BodyLimit on streamed bodies, which
+	// middleware.BodyLimit and httputil.Upload do instead.
+	app := fiber.New(fiber.Config{
+		BodyLimit:                    bodyLimit,
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	})
 
 	// Middleware
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",                   // Tüm origin'lere izin ver (geliştirme için)
 		AllowMethods: "GET,POST,PUT,DELETE", // İzin verilen HTTP metodları
 	}))
+	app.Use(middleware.BodyLimit)
+	app.Use(metrics.Middleware)
+	app.Use(webhooks.Audit)
 	app.Use(middleware.AuthMiddleware)
+	app.Use(middleware.RequestContext)
 
 	// Routes
 	auth.RegisterRoutes(app)
-	images.RegisterRoutes(app, cfg)
-	containers.RegisterRoutes(app)
+	if err := images.RegisterRoutes(app, cfg, cli); err != nil {
+		log.Fatalf("Failed to set up image routes: %v", err)
+	}
+	if err := containers.RegisterRoutes(app, cfg, cli); err != nil {
+		log.Fatalf("Failed to set up container routes: %v", err)
+	}
+	networks.RegisterRoutes(app, cfg, cli)
+	volumes.RegisterRoutes(app, cfg, cli)
+	if err := stacks.RegisterRoutes(app, cfg, cli); err != nil {
+		log.Fatalf("Failed to set up stack routes: %v", err)
+	}
+	events.RegisterRoutes(ctx, app, cfg, cli)
+	system.RegisterRoutes(app, cfg, cli)
+	if err := monitoring.RegisterRoutes(ctx, app, cfg, cli); err != nil {
+		log.Fatalf("Failed to set up monitoring: %v", err)
+	}
+	if err := alerts.RegisterRoutes(ctx, app, cfg, cli); err != nil {
+		log.Fatalf("Failed to set up alerts: %v", err)
+	}
+	if err := webhooks.RegisterRoutes(ctx, app, cfg, cli); err != nil {
+		log.Fatalf("Failed to set up webhooks: %v", err)
+	}
+	if err := deploy.RegisterRoutes(ctx, app, cfg, cli); err != nil {
+		log.Fatalf("Failed to set up deploy hooks: %v", err)
+	}
+	registry.RegisterRoutes(app, cfg)
+	if err := endpoints.RegisterRoutes(ctx, app, cfg, cli); err != nil {
+		log.Fatalf("Failed to set up endpoint routes: %v", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down...")
+		if err := app.ShutdownWithTimeout(30 * time.Second); err != nil {
+			log.Printf("Shutdown failed: %v", err)
+		}
+	}()
 
 	// Start server
 	log.Printf("Server starting on %s...", cfg.Server.Port)
@@ -0,0 +1,255 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// Channel types
+const (
+	// ChannelWebhook posts the notification as JSON
+	ChannelWebhook = "webhook"
+	// ChannelSlack posts a message to a Slack-compatible incoming webhook,
+	// which Mattermost, Rocket.Chat and others accept too
+	ChannelSlack = "slack"
+	// ChannelSMTP sends an email
+	ChannelSMTP = "smtp"
+)
+
+// Notification statuses
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+	StatusTest     = "test"
+)
+
+// sendTimeout bounds a single delivery
+const sendTimeout = 15 * time.Second
+
+// Notification is what channels deliver
+type Notification struct {
+	Status string    `json:"status"`
+	Alert  Alert     `json:"alert"`
+	SentAt time.Time `json:"sentAt"`
+}
+
+// Summary is a one-line description of the notification
+func (n Notification) Summary() string {
+	switch n.Status {
+	case StatusTest:
+		return "[TEST] harborview alert channel test"
+	case StatusResolved:
+		return fmt.Sprintf("[RESOLVED] %s: %s", n.Alert.Rule, n.Alert.ContainerName)
+	}
+	return fmt.Sprintf("[%s] %s: %s", strings.ToUpper(n.Alert.Severity), n.Alert.Rule, n.Alert.ContainerName)
+}
+
+// Notifier delivers notifications to one channel
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// ChannelInfo describes a configured channel without its credentials
+type ChannelInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Target is the host notifications go to
+	Target string `json:"target"`
+}
+
+type channel struct {
+	info     ChannelInfo
+	notifier Notifier
+}
+
+// newChannel builds the notifier of a configured channel
+func newChannel(cc config.AlertChannelConfig, httpClient *http.Client) (channel, error) {
+	if !namePattern.MatchString(cc.Name) {
+		return channel{}, fmt.Errorf("invalid channel name %q", cc.Name)
+	}
+	info := ChannelInfo{Name: cc.Name, Type: cc.Type}
+	switch cc.Type {
+	case ChannelWebhook, ChannelSlack:
+		target, err := url.Parse(cc.URL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return channel{}, fmt.Errorf("channel %s needs an http or https url", cc.Name)
+		}
+		info.Target = target.Host
+		notifier := &webhookNotifier{url: cc.URL, headers: cc.Headers, client: httpClient, slack: cc.Type == ChannelSlack}
+		return channel{info: info, notifier: notifier}, nil
+	case ChannelSMTP:
+		if _, _, err := net.SplitHostPort(cc.SMTP.Host); err != nil {
+			return channel{}, fmt.Errorf("channel %s needs an smtp host:port", cc.Name)
+		}
+		if cc.SMTP.From == "" || len(cc.SMTP.To) == 0 {
+			return channel{}, fmt.Errorf("channel %s needs smtp from and to addresses", cc.Name)
+		}
+		info.Target = cc.SMTP.Host
+		notifier := &smtpNotifier{host: cc.SMTP.Host, username: cc.SMTP.Username, password: cc.SMTP.Password, from: cc.SMTP.From, to: cc.SMTP.To}
+		return channel{info: info, notifier: notifier}, nil
+	}
+	return channel{}, fmt.Errorf("channel %s has unknown type %q", cc.Name, cc.Type)
+}
+
+// webhookNotifier posts notifications over HTTP
+type webhookNotifier struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	slack   bool
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	var payload any = notification
+	if w.slack {
+		payload = map[string]string{"text": slackText(notification)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "harborview")
+	for key, value := range w.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+func slackText(n Notification) string {
+	if n.Status == StatusTest {
+		return "*" + n.Summary() + "*"
+	}
+	return fmt.Sprintf("*%s*\n%s", n.Summary(), n.Alert.Message)
+}
+
+// smtpNotifier emails notifications. The connection is upgraded with
+// STARTTLS when the server offers it; credentials are only sent over TLS
+// or to localhost.
+type smtpNotifier struct {
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (s *smtpNotifier) Notify(ctx context.Context, notification Notification) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		hostname, _, _ := net.SplitHostPort(s.host)
+		auth = smtp.PlainAuth("", s.username, s.password, hostname)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", headerSafe(notification.Summary()))
+	fmt.Fprintf(&msg, "Date: %s\r\n", notification.SentAt.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(emailBody(notification))
+
+	return s.send(ctx, auth, msg.Bytes())
+}
+
+// send delivers msg like smtp.SendMail, over a connection that is bounded by
+// ctx's deadline and closed when ctx ends, so a hung server cannot hold it
+func (s *smtpNotifier) send(ctx context.Context, auth smtp.Auth, msg []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	hostname, _, _ := net.SplitHostPort(s.host)
+	c, err := smtp.NewClient(conn, hostname)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: hostname}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server does not support authentication")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	for _, to := range s.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func headerSafe(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+func emailBody(n Notification) string {
+	if n.Status == StatusTest {
+		return "This is a test notification from harborview.\r\n"
+	}
+	a := n.Alert
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\r\n\r\n", a.Message)
+	fmt.Fprintf(&b, "Rule: %s (%s)\r\n", a.Rule, a.Type)
+	fmt.Fprintf(&b, "Severity: %s\r\n", a.Severity)
+	fmt.Fprintf(&b, "Container: %s (%s)\r\n", a.ContainerName, a.ContainerID)
+	fmt.Fprintf(&b, "Since: %s\r\n", a.Since.Format(time.RFC3339))
+	if a.ResolvedAt != nil {
+		fmt.Fprintf(&b, "Resolved: %s\r\n", a.ResolvedAt.Format(time.RFC3339))
+	}
+	return b.String()
+}
@@ -0,0 +1,229 @@
+package alerts
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+func testNotification() Notification {
+	return Notification{
+		Status: StatusFiring,
+		Alert: Alert{
+			ID:            "a1",
+			Rule:          "high-cpu",
+			Type:          RuleCPU,
+			Severity:      "critical",
+			ContainerID:   "0123456789ab",
+			ContainerName: "web",
+			Message:       "CPU at 97%",
+			Since:         time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		},
+		SentAt: time.Date(2024, 5, 1, 12, 5, 0, 0, time.UTC),
+	}
+}
+
+func TestWebhookChannel(t *testing.T) {
+	var got Notification
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	cc := config.AlertChannelConfig{Name: "ops", Type: ChannelWebhook, URL: server.URL, Headers: map[string]string{"X-Token": "t"}}
+	ch, err := newChannel(cc, server.Client())
+	if err != nil {
+		t.Fatalf("newChannel: %v", err)
+	}
+	if err := ch.notifier.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if got.Alert.ID != "a1" || got.Status != StatusFiring {
+		t.Errorf("posted notification = %+v", got)
+	}
+	if header.Get("Content-Type") != "application/json" || header.Get("X-Token") != "t" {
+		t.Errorf("headers = %v", header)
+	}
+}
+
+func TestWebhookChannelErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	ch, err := newChannel(config.AlertChannelConfig{Name: "ops", Type: ChannelWebhook, URL: server.URL}, server.Client())
+	if err != nil {
+		t.Fatalf("newChannel: %v", err)
+	}
+	if err := ch.notifier.Notify(context.Background(), testNotification()); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Notify = %v, want the 502 reported", err)
+	}
+}
+
+func TestSlackChannel(t *testing.T) {
+	var got map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	ch, err := newChannel(config.AlertChannelConfig{Name: "chat", Type: ChannelSlack, URL: server.URL}, server.Client())
+	if err != nil {
+		t.Fatalf("newChannel: %v", err)
+	}
+	if err := ch.notifier.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if want := "*[CRITICAL] high-cpu: web*\nCPU at 97%"; got["text"] != want {
+		t.Errorf("text = %q, want %q", got["text"], want)
+	}
+}
+
+// fakeSMTP accepts one session on a local listener and returns the
+// commands and message it received
+func fakeSMTP(t *testing.T) (addr string, received <-chan []string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	out := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 fake ESMTP")
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 queued")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250-fake")
+				reply("250 8BITMIME")
+			case line == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				out <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		out <- lines
+	}()
+	return listener.Addr().String(), out
+}
+
+func smtpChannel(t *testing.T, host string) channel {
+	t.Helper()
+	cc := config.AlertChannelConfig{Name: "mail", Type: ChannelSMTP}
+	cc.SMTP.Host = host
+	cc.SMTP.From = "harborview@example.com"
+	cc.SMTP.To = []string{"ops@example.com", "dev@example.com"}
+	ch, err := newChannel(cc, nil)
+	if err != nil {
+		t.Fatalf("newChannel: %v", err)
+	}
+	return ch
+}
+
+func TestSMTPChannel(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	ch := smtpChannel(t, addr)
+
+	if err := ch.notifier.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	session := strings.Join(<-received, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<harborview@example.com>",
+		"RCPT TO:<ops@example.com>",
+		"RCPT TO:<dev@example.com>",
+		"Subject: [CRITICAL] high-cpu: web",
+		"Container: web (0123456789ab)",
+	} {
+		if !strings.Contains(session, want) {
+			t.Errorf("session is missing %q:\n%s", want, session)
+		}
+	}
+}
+
+func TestSMTPChannelHungServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		// Never greet, and see the client hang up
+		io.Copy(io.Discard, conn)
+		conn.Close()
+		close(closed)
+	}()
+	ch := smtpChannel(t, listener.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := ch.notifier.Notify(ctx, testNotification()); err == nil {
+		t.Fatal("Notify succeeded against a server that never answered")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Notify took %s after its deadline", elapsed)
+	}
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Error("the connection to the hung server was left open")
+	}
+}
+
+func TestNewChannelValidation(t *testing.T) {
+	tests := []config.AlertChannelConfig{
+		{Name: "bad name", Type: ChannelWebhook, URL: "https://example.com"},
+		{Name: "hook", Type: ChannelWebhook, URL: "ftp://example.com"},
+		{Name: "hook", Type: ChannelSlack},
+		{Name: "mail", Type: ChannelSMTP},
+		{Name: "pager", Type: "pager"},
+	}
+	for _, cc := range tests {
+		if _, err := newChannel(cc, http.DefaultClient); err == nil {
+			t.Errorf("newChannel(%+v) succeeded", cc)
+		}
+	}
+}
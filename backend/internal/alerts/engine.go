@@ -0,0 +1,856 @@
+// Package alerts evaluates alerting rules against a daemon's events and
+// container stats and notifies channels when alerts fire and resolve.
+package alerts
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/events"
+	"github.com/genc-murat/harborview/internal/fsutil"
+	"github.com/genc-murat/harborview/internal/monitoring"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// Alert states
+const (
+	// StatePending alerts meet their condition but not yet for long enough
+	StatePending = "pending"
+	StateFiring  = "firing"
+	// StateResolved alerts are kept for a while after their condition ends
+	StateResolved = "resolved"
+)
+
+const (
+	// DefaultInterval is the time between evaluations when config sets none
+	DefaultInterval = 15 * time.Second
+	// resolvedLimit is how many resolved alerts are kept
+	resolvedLimit = 100
+	// killGrace is how long after a kill a container's exit counts as
+	// intended rather than a failure
+	killGrace = time.Minute
+	// stopHistory bounds how far back container stops are remembered for
+	// restarts rules
+	stopHistory = 24 * time.Hour
+)
+
+// AlertService interface for dependency injection
+type AlertService interface {
+	Alerts(state string) []Alert
+	Rules() []Rule
+	GetRule(name string) (Rule, error)
+	CreateRule(rule Rule) (Rule, error)
+	UpdateRule(name string, rule Rule) (Rule, error)
+	DeleteRule(name string) error
+	Silences() []Silence
+	CreateSilence(silence Silence) (Silence, error)
+	DeleteSilence(id string) error
+	Channels() []ChannelStatus
+	TestChannel(ctx context.Context, name string) error
+}
+
+// Alert is a rule's condition holding for one container
+type Alert struct {
+	ID             string            `json:"id"`
+	Rule           string            `json:"rule"`
+	Type           string            `json:"type"`
+	Severity       string            `json:"severity"`
+	ContainerID    string            `json:"containerId"`
+	ContainerName  string            `json:"containerName"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	State          string            `json:"state"`
+	Value          float64           `json:"value"`
+	Message        string            `json:"message"`
+	Since          time.Time         `json:"since"`
+	FiredAt        *time.Time        `json:"firedAt,omitempty"`
+	ResolvedAt     *time.Time        `json:"resolvedAt,omitempty"`
+	Silenced       bool              `json:"silenced"`
+	LastNotifiedAt *time.Time        `json:"lastNotifiedAt,omitempty"`
+}
+
+// ChannelStatus is a channel with the outcome of its last delivery
+type ChannelStatus struct {
+	ChannelInfo
+	LastSentAt *time.Time `json:"lastSentAt,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+}
+
+// observation is the state of one container against one rule
+type observation struct {
+	containerID string
+	name        string
+	labels      map[string]string
+	value       float64
+	message     string
+	// since is when the condition began, if known better than now
+	since time.Time
+}
+
+// exit is a container that stopped with a failure
+type exit struct {
+	name   string
+	labels map[string]string
+	code   string
+	at     time.Time
+}
+
+// stop is a container stopping, counted by restarts rules
+type stop struct {
+	name   string
+	labels map[string]string
+	at     []time.Time
+}
+
+// Collector is the part of the monitoring collector the engine evaluates
+// rules against
+type Collector interface {
+	Containers() []types.Container
+	Samples() []monitoring.Sample
+}
+
+// Engine evaluates the rules of one daemon
+type Engine struct {
+	file      string
+	interval  time.Duration
+	hub       *events.Hub
+	collector Collector
+	// now is the engine's clock
+	now func() time.Time
+
+	channels     map[string]channel
+	channelNames []string
+
+	mu sync.Mutex
+	// ctx is the context Run was given, which bounds deliveries
+	ctx      context.Context
+	rules    map[string]*Rule
+	silences map[string]Silence
+	alerts   map[string]*Alert
+	resolved []Alert
+	// exits, stops, kills and unhealthy are container state gathered from
+	// events and listings, keyed by container ID
+	exits     map[string]exit
+	stops     map[string]*stop
+	kills     map[string]time.Time
+	unhealthy map[string]time.Time
+	delivery  map[string]ChannelStatus
+}
+
+// storedState is the rules and silences persisted through the API
+type storedState struct {
+	Rules    []Rule    `json:"rules"`
+	Silences []Silence `json:"silences"`
+}
+
+// NewEngine creates an engine for the rules and channels in cfg plus those
+// persisted through the API. It evaluates against hub and collector once
+// running.
+func NewEngine(cfg config.Config, hub *events.Hub, collector Collector) (*Engine, error) {
+	interval := DefaultInterval
+	if cfg.Alerts.Interval > 0 {
+		interval = time.Duration(cfg.Alerts.Interval) * time.Second
+	}
+	e := &Engine{
+		file:      cfg.Alerts.File,
+		interval:  interval,
+		ctx:       context.Background(),
+		hub:       hub,
+		collector: collector,
+		now:       time.Now,
+		channels:  make(map[string]channel),
+		rules:     make(map[string]*Rule),
+		silences:  make(map[string]Silence),
+		alerts:    make(map[string]*Alert),
+		exits:     make(map[string]exit),
+		stops:     make(map[string]*stop),
+		kills:     make(map[string]time.Time),
+		unhealthy: make(map[string]time.Time),
+		delivery:  make(map[string]ChannelStatus),
+	}
+	httpClient := &http.Client{Timeout: sendTimeout}
+	for _, cc := range cfg.Alerts.Channels {
+		ch, err := newChannel(cc, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := e.channels[cc.Name]; ok {
+			return nil, fmt.Errorf("duplicate channel %s", cc.Name)
+		}
+		e.channels[cc.Name] = ch
+		e.channelNames = append(e.channelNames, cc.Name)
+	}
+	sort.Strings(e.channelNames)
+
+	for _, rc := range cfg.Alerts.Rules {
+		rule := ruleFromConfig(rc)
+		if err := e.addRule(rule); err != nil {
+			return nil, fmt.Errorf("invalid alert rule %q: %w", rc.Name, err)
+		}
+	}
+
+	stored, err := e.readFile()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range stored.Rules {
+		rule.Source = SourceAPI
+		if err := e.addRule(rule); err != nil {
+			log.Printf("Skipping stored alert rule %s: %v", rule.Name, err)
+		}
+	}
+	for _, silence := range stored.Silences {
+		e.silences[silence.ID] = silence
+	}
+	return e, nil
+}
+
+// addRule validates and registers a rule
+func (e *Engine) addRule(rule Rule) error {
+	if err := rule.normalize(); err != nil {
+		return err
+	}
+	for _, name := range rule.Channels {
+		if _, ok := e.channels[name]; !ok {
+			return errdefs.InvalidParameter(fmt.Errorf("unknown channel %q", name))
+		}
+	}
+	if _, ok := e.rules[rule.Name]; ok {
+		return errdefs.Conflict(fmt.Errorf("rule %s already exists", rule.Name))
+	}
+	e.rules[rule.Name] = &rule
+	return nil
+}
+
+// Run follows container events and evaluates the rules every interval
+// until ctx is cancelled
+func (e *Engine) Run(ctx context.Context) {
+	e.mu.Lock()
+	e.ctx = ctx
+	e.mu.Unlock()
+	go e.watch(ctx)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Evaluate(e.now())
+		}
+	}
+}
+
+// watch records container exits and stops from the event stream,
+// subscribing again whenever the subscription is dropped
+func (e *Engine) watch(ctx context.Context) {
+	filter := events.Filter{Types: []string{"container"}, Actions: []string{"die", "kill", "start", "destroy"}}
+	for {
+		sub := e.hub.Subscribe(filter, events.Cursor{})
+		func() {
+			defer sub.Close()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event, ok := <-sub.C:
+					if !ok {
+						return
+					}
+					e.record(event)
+				}
+			}
+		}()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// eventAttributes are the event attributes that are not container labels
+var eventAttributes = []string{"name", "image", "exitCode", "signal", "execDuration"}
+
+// record updates container state from an event
+func (e *Engine) record(event events.Event) {
+	labels := make(map[string]string, len(event.Attributes))
+	for key, value := range event.Attributes {
+		labels[key] = value
+	}
+	for _, key := range eventAttributes {
+		delete(labels, key)
+	}
+	name := event.Attributes["name"]
+	id := event.ActorID
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch event.Action {
+	case "kill":
+		e.kills[id] = event.Time
+	case "die":
+		// Exits that follow a kill were asked for, by a stop or restart
+		if killed, ok := e.kills[id]; ok && event.Time.Sub(killed) < killGrace {
+			delete(e.kills, id)
+			return
+		}
+		s, ok := e.stops[id]
+		if !ok {
+			s = &stop{}
+			e.stops[id] = s
+		}
+		s.name, s.labels = name, labels
+		s.at = append(s.at, event.Time)
+		if code := event.Attributes["exitCode"]; code != "" && code != "0" {
+			e.exits[id] = exit{name: name, labels: labels, code: code, at: event.Time}
+		}
+	case "start":
+		delete(e.exits, id)
+	case "destroy":
+		delete(e.exits, id)
+		delete(e.stops, id)
+		delete(e.kills, id)
+	}
+}
+
+// Evaluate checks every rule at now and sends the notifications due
+func (e *Engine) Evaluate(now time.Time) {
+	containers := e.collector.Containers()
+	samples := e.collector.Samples()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Track when containers became unhealthy, so the first evaluation
+	// after a failed check starts the clock
+	seen := make(map[string]bool, len(containers))
+	for _, ctr := range containers {
+		if ctr.State == "running" && strings.Contains(ctr.Status, "(unhealthy)") {
+			seen[ctr.ID] = true
+			if _, ok := e.unhealthy[ctr.ID]; !ok {
+				e.unhealthy[ctr.ID] = now
+			}
+		}
+	}
+	for id := range e.unhealthy {
+		if !seen[id] {
+			delete(e.unhealthy, id)
+		}
+	}
+	for id, s := range e.stops {
+		s.at = dropBefore(s.at, now.Add(-stopHistory))
+		if len(s.at) == 0 {
+			delete(e.stops, id)
+		}
+	}
+	for id, killed := range e.kills {
+		if now.Sub(killed) > killGrace {
+			delete(e.kills, id)
+		}
+	}
+
+	for _, rule := range e.rules {
+		var observed []observation
+		switch rule.Type {
+		case RuleExited:
+			for id, x := range e.exits {
+				observed = append(observed, observation{
+					containerID: id, name: x.name, labels: x.labels, since: x.at,
+					message: fmt.Sprintf("Container %s exited with code %s", x.name, x.code),
+				})
+			}
+		case RuleRestarts:
+			for id, s := range e.stops {
+				count := len(dropBefore(s.at, now.Add(-rule.window)))
+				if float64(count) >= rule.Threshold {
+					observed = append(observed, observation{
+						containerID: id, name: s.name, labels: s.labels, value: float64(count),
+						message: fmt.Sprintf("Container %s stopped %d times in %s", s.name, count, rule.Window),
+					})
+				}
+			}
+		case RuleUnhealthy:
+			for _, ctr := range containers {
+				since, ok := e.unhealthy[ctr.ID]
+				if !ok {
+					continue
+				}
+				name := containerName(ctr.Names, ctr.ID)
+				observed = append(observed, observation{
+					containerID: ctr.ID, name: name, labels: ctr.Labels, since: since,
+					message: fmt.Sprintf("Container %s is unhealthy", name),
+				})
+			}
+		case RuleCPU:
+			for _, sample := range samples {
+				if sample.CPUPercent > rule.Threshold {
+					observed = append(observed, observation{
+						containerID: sample.ContainerID, name: sample.Name, labels: sample.Labels, value: sample.CPUPercent,
+						message: fmt.Sprintf("Container %s is using %.1f%% CPU", sample.Name, sample.CPUPercent),
+					})
+				}
+			}
+		case RuleMemory:
+			for _, sample := range samples {
+				if sample.MemoryLimit == 0 {
+					continue
+				}
+				percent := float64(sample.MemoryUsage) * 100 / float64(sample.MemoryLimit)
+				if percent > rule.Threshold {
+					observed = append(observed, observation{
+						containerID: sample.ContainerID, name: sample.Name, labels: sample.Labels, value: percent,
+						message: fmt.Sprintf("Container %s is using %.1f%% of its memory limit", sample.Name, percent),
+					})
+				}
+			}
+		}
+		e.apply(rule, observed, now)
+	}
+
+	e.expireSilences(now)
+	e.dispatch(now)
+}
+
+// apply moves the alerts of rule between states given what holds now
+func (e *Engine) apply(rule *Rule, observed []observation, now time.Time) {
+	active := make(map[string]bool, len(observed))
+	for _, o := range observed {
+		if !rule.selects(o.name, o.labels) {
+			continue
+		}
+		id := alertID(rule.Name, o.containerID)
+		active[id] = true
+		alert, ok := e.alerts[id]
+		if !ok {
+			since := now
+			if !o.since.IsZero() && o.since.Before(now) {
+				since = o.since
+			}
+			alert = &Alert{
+				ID:          id,
+				Rule:        rule.Name,
+				Type:        rule.Type,
+				Severity:    rule.Severity,
+				ContainerID: o.containerID,
+				State:       StatePending,
+				Since:       since,
+			}
+			e.alerts[id] = alert
+		}
+		alert.ContainerName, alert.Labels = o.name, o.labels
+		alert.Value, alert.Message = o.value, o.message
+		if alert.State == StatePending && now.Sub(alert.Since) >= rule.forDuration {
+			fired := now
+			alert.State, alert.FiredAt = StateFiring, &fired
+		}
+	}
+
+	for id, alert := range e.alerts {
+		if alert.Rule != rule.Name || active[id] {
+			continue
+		}
+		delete(e.alerts, id)
+		if alert.State != StateFiring {
+			continue
+		}
+		resolved := now
+		alert.State, alert.ResolvedAt = StateResolved, &resolved
+		// Only alerts that were announced are announced as resolved
+		if alert.LastNotifiedAt != nil {
+			e.notify(rule, StatusResolved, alert, now)
+		}
+		e.resolved = append(e.resolved, *alert)
+		if len(e.resolved) > resolvedLimit {
+			e.resolved = e.resolved[len(e.resolved)-resolvedLimit:]
+		}
+	}
+}
+
+// dispatch notifies firing alerts that have not been announced, or whose
+// repeat interval has passed. Silenced alerts are announced once their
+// silence ends.
+func (e *Engine) dispatch(now time.Time) {
+	for _, alert := range e.alerts {
+		if alert.State != StateFiring {
+			continue
+		}
+		alert.Silenced = e.silenced(alert, now)
+		if alert.Silenced {
+			continue
+		}
+		rule := e.rules[alert.Rule]
+		due := alert.LastNotifiedAt == nil ||
+			(rule.repeatInterval > 0 && now.Sub(*alert.LastNotifiedAt) >= rule.repeatInterval)
+		if due {
+			e.notify(rule, StatusFiring, alert, now)
+		}
+	}
+}
+
+func (e *Engine) silenced(alert *Alert, now time.Time) bool {
+	for _, silence := range e.silences {
+		if silence.Active(now) && silence.Matches(alert) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify hands the alert to the rule's channels. e.mu must be held.
+func (e *Engine) notify(rule *Rule, status string, alert *Alert, now time.Time) {
+	notified := now
+	alert.LastNotifiedAt = &notified
+	notification := Notification{Status: status, Alert: *alert, SentAt: now}
+	names := rule.Channels
+	if len(names) == 0 {
+		names = e.channelNames
+	}
+	for _, name := range names {
+		if ch, ok := e.channels[name]; ok {
+			go e.deliver(e.ctx, ch, notification)
+		}
+	}
+}
+
+// deliver sends a notification and records the outcome
+func (e *Engine) deliver(ctx context.Context, ch channel, notification Notification) {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	err := ch.notifier.Notify(ctx, notification)
+	if err != nil {
+		log.Printf("Failed to send alert %s to channel %s: %v", notification.Alert.ID, ch.info.Name, err)
+	}
+	e.recordDelivery(ch, err)
+}
+
+func (e *Engine) recordDelivery(ch channel, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := e.delivery[ch.info.Name]
+	status.ChannelInfo = ch.info
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+	} else {
+		sent := e.now()
+		status.LastSentAt = &sent
+	}
+	e.delivery[ch.info.Name] = status
+}
+
+// expireSilences drops silences that have ended. e.mu must be held.
+func (e *Engine) expireSilences(now time.Time) {
+	expired := false
+	for id, silence := range e.silences {
+		if !now.Before(silence.EndsAt) {
+			delete(e.silences, id)
+			expired = true
+		}
+	}
+	if expired {
+		if err := e.writeFile(); err != nil {
+			log.Printf("Failed to store alert silences: %v", err)
+		}
+	}
+}
+
+// Alerts returns the pending and firing alerts, then the recently resolved
+// ones, newest first. state narrows the list to one state.
+func (e *Engine) Alerts(state string) []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	alerts := []Alert{}
+	for _, alert := range e.alerts {
+		if state == "" || alert.State == state {
+			alerts = append(alerts, *alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Since.After(alerts[j].Since) })
+	if state == "" || state == StateResolved {
+		for i := len(e.resolved) - 1; i >= 0; i-- {
+			alerts = append(alerts, e.resolved[i])
+		}
+	}
+	return alerts
+}
+
+// Rules returns every rule, sorted by name
+func (e *Engine) Rules() []Rule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rules := make([]Rule, 0, len(e.rules))
+	for _, rule := range e.rules {
+		rules = append(rules, *rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// GetRule returns one rule
+func (e *Engine) GetRule(name string) (Rule, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rule, ok := e.rules[name]
+	if !ok {
+		return Rule{}, errdefs.NotFound(fmt.Errorf("rule %s not found", name))
+	}
+	return *rule, nil
+}
+
+// CreateRule adds a rule through the API and persists it
+func (e *Engine) CreateRule(rule Rule) (Rule, error) {
+	rule.Source = SourceAPI
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.addRule(rule); err != nil {
+		return Rule{}, err
+	}
+	if err := e.writeFile(); err != nil {
+		delete(e.rules, rule.Name)
+		return Rule{}, err
+	}
+	return *e.rules[rule.Name], nil
+}
+
+// UpdateRule replaces an API-managed rule. Its alerts start over under the
+// new definition.
+func (e *Engine) UpdateRule(name string, rule Rule) (Rule, error) {
+	rule.Name, rule.Source = name, SourceAPI
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	previous, err := e.editableRule(name)
+	if err != nil {
+		return Rule{}, err
+	}
+	delete(e.rules, name)
+	if err := e.addRule(rule); err != nil {
+		e.rules[name] = previous
+		return Rule{}, err
+	}
+	if err := e.writeFile(); err != nil {
+		e.rules[name] = previous
+		return Rule{}, err
+	}
+	e.dropAlerts(name)
+	return *e.rules[name], nil
+}
+
+// DeleteRule removes an API-managed rule and its alerts
+func (e *Engine) DeleteRule(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	previous, err := e.editableRule(name)
+	if err != nil {
+		return err
+	}
+	delete(e.rules, name)
+	if err := e.writeFile(); err != nil {
+		e.rules[name] = previous
+		return err
+	}
+	e.dropAlerts(name)
+	return nil
+}
+
+func (e *Engine) editableRule(name string) (*Rule, error) {
+	rule, ok := e.rules[name]
+	switch {
+	case !ok:
+		return nil, errdefs.NotFound(fmt.Errorf("rule %s not found", name))
+	case rule.Source == SourceConfig:
+		return nil, errdefs.Forbidden(fmt.Errorf("rule %s is defined in config and cannot be changed through the API", name))
+	}
+	return rule, nil
+}
+
+func (e *Engine) dropAlerts(rule string) {
+	for id, alert := range e.alerts {
+		if alert.Rule == rule {
+			delete(e.alerts, id)
+		}
+	}
+}
+
+// Silences returns the current and future silences, ending soonest first
+func (e *Engine) Silences() []Silence {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	silences := make([]Silence, 0, len(e.silences))
+	for _, silence := range e.silences {
+		silences = append(silences, silence)
+	}
+	sort.Slice(silences, func(i, j int) bool { return silences[i].EndsAt.Before(silences[j].EndsAt) })
+	return silences
+}
+
+// CreateSilence adds a silence. It starts now unless StartsAt is set.
+func (e *Engine) CreateSilence(silence Silence) (Silence, error) {
+	now := e.now().UTC()
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = now
+	}
+	switch {
+	case silence.EndsAt.IsZero():
+		return Silence{}, errdefs.InvalidParameter(fmt.Errorf("endsAt is required"))
+	case !silence.EndsAt.After(silence.StartsAt) || !silence.EndsAt.After(now):
+		return Silence{}, errdefs.InvalidParameter(fmt.Errorf("endsAt must be in the future and after startsAt"))
+	}
+	id, err := newID()
+	if err != nil {
+		return Silence{}, err
+	}
+	silence.ID, silence.CreatedAt = id, now
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.silences[id] = silence
+	if err := e.writeFile(); err != nil {
+		delete(e.silences, id)
+		return Silence{}, err
+	}
+	return silence, nil
+}
+
+// DeleteSilence ends a silence early
+func (e *Engine) DeleteSilence(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	silence, ok := e.silences[id]
+	if !ok {
+		return errdefs.NotFound(fmt.Errorf("silence %s not found", id))
+	}
+	delete(e.silences, id)
+	if err := e.writeFile(); err != nil {
+		e.silences[id] = silence
+		return err
+	}
+	return nil
+}
+
+// Channels returns the configured channels with their last delivery
+func (e *Engine) Channels() []ChannelStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	statuses := make([]ChannelStatus, 0, len(e.channelNames))
+	for _, name := range e.channelNames {
+		status, ok := e.delivery[name]
+		if !ok {
+			status.ChannelInfo = e.channels[name].info
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// TestChannel sends a test notification and waits for the outcome
+func (e *Engine) TestChannel(ctx context.Context, name string) error {
+	ch, ok := e.channels[name]
+	if !ok {
+		return errdefs.NotFound(fmt.Errorf("channel %s not found", name))
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	notification := Notification{
+		Status: StatusTest,
+		Alert:  Alert{ID: "test", Rule: "test", Severity: SeverityWarning, ContainerName: "test", Message: "Test notification"},
+		SentAt: e.now().UTC(),
+	}
+	err := ch.notifier.Notify(ctx, notification)
+	e.recordDelivery(ch, err)
+	if err != nil {
+		return fmt.Errorf("failed to send to channel %s: %w", name, err)
+	}
+	return nil
+}
+
+// readFile loads the rules and silences persisted through the API
+func (e *Engine) readFile() (storedState, error) {
+	var stored storedState
+	if e.file == "" {
+		return stored, nil
+	}
+	data, err := os.ReadFile(e.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return stored, nil
+	}
+	if err != nil {
+		return stored, fmt.Errorf("failed to read alerts file: %w", err)
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return stored, fmt.Errorf("failed to decode alerts file: %w", err)
+	}
+	return stored, nil
+}
+
+// writeFile persists the API-managed rules and the silences. e.mu must be
+// held.
+func (e *Engine) writeFile() error {
+	if e.file == "" {
+		return nil
+	}
+
+	stored := storedState{Rules: []Rule{}, Silences: []Silence{}}
+	for _, rule := range e.rules {
+		if rule.Source == SourceAPI {
+			stored.Rules = append(stored.Rules, *rule)
+		}
+	}
+	sort.Slice(stored.Rules, func(i, j int) bool { return stored.Rules[i].Name < stored.Rules[j].Name })
+	for _, silence := range e.silences {
+		stored.Silences = append(stored.Silences, silence)
+	}
+	sort.Slice(stored.Silences, func(i, j int) bool { return stored.Silences[i].ID < stored.Silences[j].ID })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(e.file, data); err != nil {
+		return fmt.Errorf("failed to store alerts: %w", err)
+	}
+	return nil
+}
+
+func alertID(rule, containerID string) string {
+	return rule + ":" + containerID[:min(12, len(containerID))]
+}
+
+func containerName(names []string, id string) string {
+	if len(names) > 0 {
+		return strings.TrimPrefix(names[0], "/")
+	}
+	return id[:min(12, len(id))]
+}
+
+// dropBefore returns the times at or after cutoff; times are in order
+func dropBefore(times []time.Time, cutoff time.Time) []time.Time {
+	for i, t := range times {
+		if !t.Before(cutoff) {
+			return times[i:]
+		}
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,308 @@
+package alerts
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/genc-murat/harborview/internal/monitoring"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// fakeClock is a clock tests move by hand
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// at moves the clock to start plus offset and returns the new time
+func (c *fakeClock) at(offset time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = start.Add(offset)
+	return c.now
+}
+
+var start = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeCollector serves the containers and samples a test sets
+type fakeCollector struct {
+	containers []types.Container
+	samples    []monitoring.Sample
+}
+
+func (f *fakeCollector) Containers() []types.Container { return f.containers }
+
+func (f *fakeCollector) Samples() []monitoring.Sample { return f.samples }
+
+// unhealthy sets the running containers, the named ones failing their
+// health check
+func (f *fakeCollector) unhealthy(names ...string) {
+	f.containers = []types.Container{
+		{ID: "0001aaaaaaaaaaaa", Names: []string{"/web"}, State: "running", Status: "Up 10 minutes (healthy)"},
+		{ID: "0002aaaaaaaaaaaa", Names: []string{"/db"}, State: "running", Status: "Up 10 minutes (healthy)"},
+	}
+	for i := range f.containers {
+		for _, name := range names {
+			if f.containers[i].Names[0] == "/"+name {
+				f.containers[i].Status = "Up 10 minutes (unhealthy)"
+			}
+		}
+	}
+}
+
+// cpu sets the samples to the CPU use of each named container
+func (f *fakeCollector) cpu(percents map[string]float64) {
+	f.samples = nil
+	for name, percent := range percents {
+		f.samples = append(f.samples, monitoring.Sample{ContainerID: name + "-0123456789", Name: name, CPUPercent: percent})
+	}
+}
+
+// fakeNotifier passes notifications on to sent
+type fakeNotifier struct {
+	sent chan Notification
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, notification Notification) error {
+	f.sent <- notification
+	return nil
+}
+
+// expect waits for a notification of each status in turn and then checks
+// no others follow
+func (f *fakeNotifier) expect(t *testing.T, statuses ...string) []Notification {
+	t.Helper()
+	var received []Notification
+	for range statuses {
+		select {
+		case notification := <-f.sent:
+			received = append(received, notification)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d notifications, want %v", len(received), statuses)
+		}
+	}
+	for i, status := range statuses {
+		if received[i].Status != status {
+			t.Errorf("notification %d = %s %s, want %s", i, received[i].Status, received[i].Alert.ID, status)
+		}
+	}
+	select {
+	case notification := <-f.sent:
+		t.Errorf("unexpected %s notification of %s", notification.Status, notification.Alert.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+	return received
+}
+
+func newTestEngine(t *testing.T, rules ...Rule) (*Engine, *fakeCollector, *fakeClock, *fakeNotifier) {
+	t.Helper()
+	collector := &fakeCollector{}
+	engine, err := NewEngine(config.Config{}, nil, collector)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	clock := &fakeClock{now: start}
+	engine.now = clock.Now
+	notifier := &fakeNotifier{sent: make(chan Notification, 16)}
+	engine.channels["ops"] = channel{info: ChannelInfo{Name: "ops", Type: ChannelWebhook}, notifier: notifier}
+	engine.channelNames = []string{"ops"}
+	for _, rule := range rules {
+		if _, err := engine.CreateRule(rule); err != nil {
+			t.Fatalf("CreateRule: %v", err)
+		}
+	}
+	return engine, collector, clock, notifier
+}
+
+// states returns the state of every current and resolved alert by ID
+func states(engine *Engine) map[string][]string {
+	states := map[string][]string{}
+	for _, alert := range engine.Alerts("") {
+		states[alert.ID] = append(states[alert.ID], alert.State)
+	}
+	return states
+}
+
+func TestUnhealthyFor(t *testing.T) {
+	engine, collector, clock, notifier := newTestEngine(t, Rule{Name: "unhealthy", Type: RuleUnhealthy})
+	const web = "unhealthy:0001aaaaaaaa"
+
+	// The container has to stay unhealthy for the default 2m
+	collector.unhealthy("web")
+	for _, offset := range []time.Duration{0, time.Minute, 2*time.Minute - time.Second} {
+		engine.Evaluate(clock.at(offset))
+		if got := engine.Alerts(StatePending); len(got) != 1 || got[0].ContainerName != "web" || !got[0].Since.Equal(start) {
+			t.Fatalf("pending alerts at %s = %+v", offset, got)
+		}
+	}
+	notifier.expect(t)
+
+	engine.Evaluate(clock.at(2 * time.Minute))
+	firing := notifier.expect(t, StatusFiring)[0]
+	if firing.Alert.ID != web || !firing.SentAt.Equal(start.Add(2*time.Minute)) || firing.Alert.Message != "Container web is unhealthy" {
+		t.Errorf("firing notification = %+v", firing)
+	}
+
+	// A container that recovers before 2m never fires and leaves nothing
+	// behind
+	collector.unhealthy("web", "db")
+	engine.Evaluate(clock.at(3 * time.Minute))
+	collector.unhealthy("web")
+	engine.Evaluate(clock.at(4 * time.Minute))
+	notifier.expect(t)
+	if got := states(engine); len(got) != 1 || len(got[web]) != 1 || got[web][0] != StateFiring {
+		t.Errorf("alerts = %v, want web firing only", got)
+	}
+
+	collector.unhealthy()
+	engine.Evaluate(clock.at(5 * time.Minute))
+	resolved := notifier.expect(t, StatusResolved)[0]
+	if resolved.Alert.ResolvedAt == nil || !resolved.Alert.ResolvedAt.Equal(start.Add(5*time.Minute)) {
+		t.Errorf("resolved notification = %+v", resolved)
+	}
+	if got := engine.Alerts(StateResolved); len(got) != 1 || got[0].ID != web {
+		t.Errorf("resolved alerts = %+v", got)
+	}
+
+	// Unhealthy again starts the clock over
+	collector.unhealthy("web")
+	engine.Evaluate(clock.at(6 * time.Minute))
+	engine.Evaluate(clock.at(7 * time.Minute))
+	notifier.expect(t)
+	engine.Evaluate(clock.at(8 * time.Minute))
+	notifier.expect(t, StatusFiring)
+}
+
+func TestCPUFor(t *testing.T) {
+	engine, collector, clock, notifier := newTestEngine(t, Rule{Name: "cpu", Type: RuleCPU})
+	const api = "cpu:api-01234567"
+
+	steps := []struct {
+		offset  time.Duration
+		percent float64
+		state   string
+	}{
+		{0, 95, StatePending},
+		{2 * time.Minute, 99, StatePending},
+		// Dropping below the threshold, or to it, starts over
+		{4 * time.Minute, 90, ""},
+		{5 * time.Minute, 97, StatePending},
+		{9*time.Minute + 59*time.Second, 97, StatePending},
+		{10 * time.Minute, 91, StateFiring},
+		{12 * time.Minute, 93, StateFiring},
+	}
+	for _, step := range steps {
+		collector.cpu(map[string]float64{"api": step.percent, "worker": 40})
+		engine.Evaluate(clock.at(step.offset))
+		got := engine.Alerts("")
+		if step.state == "" {
+			if len(got) != 0 {
+				t.Errorf("alerts at %s = %+v, want none", step.offset, got)
+			}
+			continue
+		}
+		if len(got) != 1 || got[0].ID != api || got[0].State != step.state || got[0].Value != step.percent {
+			t.Errorf("alerts at %s = %+v, want api %s at %v", step.offset, got, step.state, step.percent)
+		}
+	}
+	firing := notifier.expect(t, StatusFiring)[0]
+	if !firing.Alert.Since.Equal(start.Add(5*time.Minute)) || firing.Alert.Value != 91 {
+		t.Errorf("firing notification = %+v", firing.Alert)
+	}
+
+	collector.cpu(map[string]float64{"api": 20})
+	engine.Evaluate(clock.at(13 * time.Minute))
+	notifier.expect(t, StatusResolved)
+}
+
+func TestNotificationDedupe(t *testing.T) {
+	engine, collector, clock, notifier := newTestEngine(t, Rule{Name: "cpu", Type: RuleCPU, For: "0s", RepeatInterval: "10m"})
+
+	// Every evaluation sees the same containers, which keep one alert each
+	// and are announced once until the repeat interval passes
+	collector.cpu(map[string]float64{"api": 95, "web": 99})
+	engine.Evaluate(clock.at(0))
+	notifier.expect(t, StatusFiring, StatusFiring)
+	for _, offset := range []time.Duration{time.Minute, 5 * time.Minute, 10*time.Minute - time.Second} {
+		engine.Evaluate(clock.at(offset))
+	}
+	notifier.expect(t)
+	if got := states(engine); len(got) != 2 {
+		t.Errorf("alerts = %v, want one per container", got)
+	}
+
+	engine.Evaluate(clock.at(10 * time.Minute))
+	notifier.expect(t, StatusFiring, StatusFiring)
+	engine.Evaluate(clock.at(15 * time.Minute))
+	notifier.expect(t)
+
+	// A rule without a repeat interval announces an alert once
+	engine, collector, clock, notifier = newTestEngine(t, Rule{Name: "cpu", Type: RuleCPU, For: "0s"})
+	collector.cpu(map[string]float64{"api": 95})
+	for _, offset := range []time.Duration{0, time.Hour, 24 * time.Hour} {
+		engine.Evaluate(clock.at(offset))
+	}
+	notifier.expect(t, StatusFiring)
+}
+
+func TestSilenceExpiry(t *testing.T) {
+	engine, collector, clock, notifier := newTestEngine(t, Rule{Name: "cpu", Type: RuleCPU, For: "0s"})
+	silence, err := engine.CreateSilence(Silence{Rule: "cpu", Container: "api", EndsAt: start.Add(10 * time.Minute)})
+	if err != nil {
+		t.Fatalf("CreateSilence: %v", err)
+	}
+	if !silence.StartsAt.Equal(start) || !silence.CreatedAt.Equal(start) {
+		t.Errorf("silence = %+v, want it to start now", silence)
+	}
+	if _, err := engine.CreateSilence(Silence{EndsAt: start.Add(-time.Minute)}); err == nil {
+		t.Error("CreateSilence accepted a silence that has already ended")
+	}
+
+	// Only the silenced container stays quiet
+	collector.cpu(map[string]float64{"api": 95, "web": 95})
+	engine.Evaluate(clock.at(0))
+	if got := notifier.expect(t, StatusFiring)[0]; got.Alert.ContainerName != "web" {
+		t.Errorf("notified %s, want web", got.Alert.ContainerName)
+	}
+	engine.Evaluate(clock.at(5 * time.Minute))
+	notifier.expect(t)
+	for _, alert := range engine.Alerts(StateFiring) {
+		if alert.Silenced != (alert.ContainerName == "api") {
+			t.Errorf("%s silenced = %v", alert.ContainerName, alert.Silenced)
+		}
+	}
+
+	// A silenced alert is announced once the silence ends, and the
+	// silence is dropped
+	engine.Evaluate(clock.at(10 * time.Minute))
+	if got := notifier.expect(t, StatusFiring)[0]; got.Alert.ContainerName != "api" || got.Alert.Silenced {
+		t.Errorf("notification after the silence = %+v", got.Alert)
+	}
+	if got := engine.Silences(); len(got) != 0 {
+		t.Errorf("silences = %+v, want the expired one dropped", got)
+	}
+
+	// An alert that resolves while silenced was never announced, so its
+	// resolution is not either
+	engine, collector, clock, notifier = newTestEngine(t, Rule{Name: "cpu", Type: RuleCPU, For: "0s"})
+	if _, err := engine.CreateSilence(Silence{Container: "web", EndsAt: start.Add(time.Hour)}); err != nil {
+		t.Fatalf("CreateSilence: %v", err)
+	}
+	collector.cpu(map[string]float64{"web": 95})
+	engine.Evaluate(clock.at(0))
+	collector.cpu(nil)
+	engine.Evaluate(clock.at(time.Minute))
+	notifier.expect(t)
+	if got := engine.Alerts(StateResolved); len(got) != 1 || got[0].LastNotifiedAt != nil {
+		t.Errorf("resolved alerts = %+v, want web resolved unannounced", got)
+	}
+}
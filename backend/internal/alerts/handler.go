@@ -0,0 +1,194 @@
+package alerts
+
+import (
+	"context"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/events"
+	"github.com/genc-murat/harborview/internal/monitoring"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterRoutes starts evaluating the alert rules against the daemon of
+// the shared client and registers the alert routes. Evaluation runs until
+// ctx is cancelled.
+func RegisterRoutes(ctx context.Context, app *fiber.App, cfg config.Config, cli *client.Client) error {
+	engine, err := NewEngine(cfg, events.Shared(ctx, cfg, cli), monitoring.Shared(ctx, cfg, cli))
+	if err != nil {
+		return err
+	}
+	go engine.Run(ctx)
+
+	var service AlertService = engine
+	alertsGroup := app.Group("/alerts")
+
+	// List alerts, optionally by ?state=pending, firing or resolved
+	alertsGroup.Get("/", func(c *fiber.Ctx) error {
+		return ListAlerts(c, service)
+	})
+
+	// List rules
+	alertsGroup.Get("/rules", func(c *fiber.Ctx) error {
+		return ListRules(c, service)
+	})
+
+	// Add a rule
+	alertsGroup.Post("/rules", func(c *fiber.Ctx) error {
+		return CreateRule(c, service)
+	})
+
+	// Get a rule
+	alertsGroup.Get("/rules/:name", func(c *fiber.Ctx) error {
+		return GetRule(c, service)
+	})
+
+	// Replace an API-managed rule
+	alertsGroup.Put("/rules/:name", func(c *fiber.Ctx) error {
+		return UpdateRule(c, service)
+	})
+
+	// Remove an API-managed rule
+	alertsGroup.Delete("/rules/:name", func(c *fiber.Ctx) error {
+		return DeleteRule(c, service)
+	})
+
+	// List silences
+	alertsGroup.Get("/silences", func(c *fiber.Ctx) error {
+		return ListSilences(c, service)
+	})
+
+	// Add a silence
+	alertsGroup.Post("/silences", func(c *fiber.Ctx) error {
+		return CreateSilence(c, service)
+	})
+
+	// End a silence
+	alertsGroup.Delete("/silences/:id", func(c *fiber.Ctx) error {
+		return DeleteSilence(c, service)
+	})
+
+	// List notification channels
+	alertsGroup.Get("/channels", func(c *fiber.Ctx) error {
+		return c.JSON(service.Channels())
+	})
+
+	// Send a test notification through a channel
+	alertsGroup.Post("/channels/:name/test", func(c *fiber.Ctx) error {
+		return TestChannel(c, service)
+	})
+	return nil
+}
+
+// alertError maps engine errors to statuses
+func alertError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errdefs.IsInvalidParameter(err):
+		status = fiber.StatusBadRequest
+	case errdefs.IsNotFound(err):
+		status = fiber.StatusNotFound
+	case errdefs.IsConflict(err):
+		status = fiber.StatusConflict
+	case errdefs.IsForbidden(err):
+		status = fiber.StatusForbidden
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
+
+// ListAlerts handler for listing alerts
+func ListAlerts(c *fiber.Ctx, service AlertService) error {
+	state := c.Query("state")
+	switch state {
+	case "", StatePending, StateFiring, StateResolved:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "state must be pending, firing or resolved"})
+	}
+	return c.JSON(service.Alerts(state))
+}
+
+// ListRules handler for listing rules
+func ListRules(c *fiber.Ctx, service AlertService) error {
+	return c.JSON(service.Rules())
+}
+
+// GetRule handler for fetching one rule
+func GetRule(c *fiber.Ctx, service AlertService) error {
+	rule, err := service.GetRule(c.Params("name"))
+	if err != nil {
+		return alertError(c, err)
+	}
+	return c.JSON(rule)
+}
+
+// CreateRule handler for adding a rule
+func CreateRule(c *fiber.Ctx, service AlertService) error {
+	var rule Rule
+	if err := c.BodyParser(&rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	created, err := service.CreateRule(rule)
+	if err != nil {
+		return alertError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+// UpdateRule handler for replacing a rule
+func UpdateRule(c *fiber.Ctx, service AlertService) error {
+	var rule Rule
+	if err := c.BodyParser(&rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	updated, err := service.UpdateRule(c.Params("name"), rule)
+	if err != nil {
+		return alertError(c, err)
+	}
+	return c.JSON(updated)
+}
+
+// DeleteRule handler for removing a rule
+func DeleteRule(c *fiber.Ctx, service AlertService) error {
+	if err := service.DeleteRule(c.Params("name")); err != nil {
+		return alertError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Rule removed successfully"})
+}
+
+// ListSilences handler for listing silences
+func ListSilences(c *fiber.Ctx, service AlertService) error {
+	return c.JSON(service.Silences())
+}
+
+// CreateSilence handler for adding a silence
+func CreateSilence(c *fiber.Ctx, service AlertService) error {
+	var silence Silence
+	if err := c.BodyParser(&silence); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	created, err := service.CreateSilence(silence)
+	if err != nil {
+		return alertError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+// DeleteSilence handler for ending a silence
+func DeleteSilence(c *fiber.Ctx, service AlertService) error {
+	if err := service.DeleteSilence(c.Params("id")); err != nil {
+		return alertError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Silence removed successfully"})
+}
+
+// TestChannel handler for sending a test notification
+func TestChannel(c *fiber.Ctx, service AlertService) error {
+	if err := service.TestChannel(c.UserContext(), c.Params("name")); err != nil {
+		if errdefs.IsNotFound(err) {
+			return alertError(c, err)
+		}
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "Test notification sent"})
+}
@@ -0,0 +1,211 @@
+package alerts
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// Rule types
+const (
+	// RuleExited fires when a container exits with a non-zero code, and
+	// resolves when it starts again or is removed
+	RuleExited = "exited"
+	// RuleRestarts fires when a container stopped Threshold times within
+	// Window, which catches restart loops
+	RuleRestarts = "restarts"
+	// RuleUnhealthy fires when a container's health check has failed for
+	// For
+	RuleUnhealthy = "unhealthy"
+	// RuleCPU fires when CPU use stays above Threshold percent of one CPU
+	// for For
+	RuleCPU = "cpu"
+	// RuleMemory fires when memory use stays above Threshold percent of
+	// the container's limit for For
+	RuleMemory = "memory"
+)
+
+// Rule sources
+const (
+	SourceConfig = "config"
+	SourceAPI    = "api"
+)
+
+// Severities
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// namePattern restricts rule names to a single URL path element
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Rule is an alerting rule. Durations are strings such as 5m.
+type Rule struct {
+	Name           string   `json:"name"`
+	Type           string   `json:"type"`
+	Threshold      float64  `json:"threshold,omitempty"`
+	For            string   `json:"for,omitempty"`
+	Window         string   `json:"window,omitempty"`
+	Containers     []string `json:"containers,omitempty"`
+	Labels         []string `json:"labels,omitempty"`
+	Severity       string   `json:"severity"`
+	Channels       []string `json:"channels,omitempty"`
+	RepeatInterval string   `json:"repeatInterval,omitempty"`
+	Source         string   `json:"source"`
+
+	forDuration    time.Duration
+	window         time.Duration
+	repeatInterval time.Duration
+}
+
+func ruleFromConfig(rc config.AlertRuleConfig) Rule {
+	return Rule{
+		Name:           rc.Name,
+		Type:           rc.Type,
+		Threshold:      rc.Threshold,
+		For:            rc.For,
+		Window:         rc.Window,
+		Containers:     rc.Containers,
+		Labels:         rc.Labels,
+		Severity:       rc.Severity,
+		Channels:       rc.Channels,
+		RepeatInterval: rc.RepeatInterval,
+		Source:         SourceConfig,
+	}
+}
+
+// normalize validates the rule, fills in the defaults of its type and
+// parses its durations
+func (r *Rule) normalize() error {
+	if !namePattern.MatchString(r.Name) {
+		return errdefs.InvalidParameter(fmt.Errorf("invalid rule name %q", r.Name))
+	}
+
+	var defaultThreshold float64
+	var defaultFor, defaultWindow string
+	switch r.Type {
+	case RuleExited:
+	case RuleRestarts:
+		defaultThreshold, defaultWindow = 3, "10m"
+	case RuleUnhealthy:
+		defaultFor = "2m"
+	case RuleCPU, RuleMemory:
+		defaultThreshold, defaultFor = 90, "5m"
+	default:
+		return errdefs.InvalidParameter(fmt.Errorf("unknown rule type %q", r.Type))
+	}
+	if r.Threshold < 0 {
+		return errdefs.InvalidParameter(fmt.Errorf("threshold must not be negative"))
+	}
+	if r.Threshold == 0 {
+		r.Threshold = defaultThreshold
+	}
+	if r.For == "" {
+		r.For = defaultFor
+	}
+	if r.Window == "" {
+		r.Window = defaultWindow
+	}
+	if r.Severity == "" {
+		r.Severity = SeverityWarning
+	}
+
+	var err error
+	if r.forDuration, err = parseDuration("for", r.For); err != nil {
+		return err
+	}
+	if r.window, err = parseDuration("window", r.Window); err != nil {
+		return err
+	}
+	if r.repeatInterval, err = parseDuration("repeatInterval", r.RepeatInterval); err != nil {
+		return err
+	}
+	if r.Type == RuleRestarts && r.window == 0 {
+		return errdefs.InvalidParameter(fmt.Errorf("restarts rules need a window"))
+	}
+	for _, pattern := range r.Containers {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errdefs.InvalidParameter(fmt.Errorf("invalid container pattern %q", pattern))
+		}
+	}
+	return nil
+}
+
+func parseDuration(field, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, errdefs.InvalidParameter(fmt.Errorf("invalid %s %q", field, value))
+	}
+	return d, nil
+}
+
+// selects reports whether the rule applies to a container
+func (r *Rule) selects(name string, labels map[string]string) bool {
+	if len(r.Containers) > 0 {
+		matched := false
+		for _, pattern := range r.Containers {
+			if ok, _ := path.Match(pattern, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return matchLabels(r.Labels, labels)
+}
+
+// matchLabels checks key or key=value matches against labels
+func matchLabels(matchers []string, labels map[string]string) bool {
+	for _, matcher := range matchers {
+		key, value, hasValue := strings.Cut(matcher, "=")
+		actual, ok := labels[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Silence mutes the notifications of matching alerts between StartsAt and
+// EndsAt. Empty fields match every alert.
+type Silence struct {
+	ID   string `json:"id"`
+	Rule string `json:"rule,omitempty"`
+	// Container is a container name pattern or ID prefix
+	Container string    `json:"container,omitempty"`
+	Labels    []string  `json:"labels,omitempty"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Active reports whether the silence applies at now
+func (s Silence) Active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+// Matches reports whether the silence covers alert
+func (s Silence) Matches(alert *Alert) bool {
+	if s.Rule != "" && s.Rule != alert.Rule {
+		return false
+	}
+	if s.Container != "" {
+		ok, _ := path.Match(s.Container, alert.ContainerName)
+		if !ok && !strings.HasPrefix(alert.ContainerID, s.Container) {
+			return false
+		}
+	}
+	return matchLabels(s.Labels, alert.Labels)
+}
@@ -2,72 +2,83 @@ package containers
 
 import (
 	"io"
+	"time"
 
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/httputil"
+	"github.com/genc-murat/harborview/internal/signatures"
+	"github.com/genc-murat/harborview/pkg/config"
 	"github.com/gofiber/fiber/v2"
 )
 
-// RegisterRoutes registers all routes for containers
-func RegisterRoutes(app *fiber.App) {
+// RegisterRoutes registers all routes for containers on the shared client
+func RegisterRoutes(app *fiber.App, cfg config.Config, cli *client.Client) error {
 	// Initialize the service
-	service, _ := NewContainerService()
+	service, err := NewContainerService(cfg, cli)
+	if err != nil {
+		return err
+	}
+
+	MountRoutes(app.Group("/containers"), func(*fiber.Ctx) (ContainerService, error) {
+		return service, nil
+	})
+	return nil
+}
 
-	containersGroup := app.Group("/containers")
+// MountRoutes registers the container routes on containersGroup. lookup
+// picks the service each request runs against; a *fiber.Error from it sets
+// the status.
+func MountRoutes(containersGroup fiber.Router, lookup func(c *fiber.Ctx) (ContainerService, error)) {
+	handle := httputil.Bind(lookup)
 
 	// List all containers
-	containersGroup.Get("/", func(c *fiber.Ctx) error {
-		return ListContainers(c, service)
-	})
+	containersGroup.Get("/", handle(ListContainers))
+
+	// Check which containers have a newer image
+	containersGroup.Get("/updates", handle(CheckUpdates))
+
+	// Create a container
+	containersGroup.Post("/", handle(CreateContainer))
 
 	// Inspect a specific container
-	containersGroup.Get("/:id", func(c *fiber.Ctx) error {
-		return InspectContainer(c, service)
-	})
+	containersGroup.Get("/:id", handle(InspectContainer))
 
 	// Start a container
-	containersGroup.Post("/:id/start", func(c *fiber.Ctx) error {
-		return StartContainer(c, service)
-	})
+	containersGroup.Post("/:id/start", handle(StartContainer))
 
 	// Stop a container
-	containersGroup.Post("/:id/stop", func(c *fiber.Ctx) error {
-		return StopContainer(c, service)
-	})
+	containersGroup.Post("/:id/stop", handle(StopContainer))
 
 	// Restart a container
-	containersGroup.Post("/:id/restart", func(c *fiber.Ctx) error {
-		return RestartContainer(c, service)
-	})
+	containersGroup.Post("/:id/restart", handle(RestartContainer))
+
+	// Replace a container with one from the current image of its reference
+	containersGroup.Post("/:id/recreate", handle(RecreateContainer))
+
+	// Check whether a container has a newer image
+	containersGroup.Get("/:id/update", handle(CheckUpdate))
 
 	// Remove a container
-	containersGroup.Delete("/:id", func(c *fiber.Ctx) error {
-		return RemoveContainer(c, service)
-	})
+	containersGroup.Delete("/:id", handle(RemoveContainer))
 
 	// Get logs for a container
-	containersGroup.Get("/:id/logs", func(c *fiber.Ctx) error {
-		return GetContainerLogs(c, service)
-	})
+	containersGroup.Get("/:id/logs", handle(GetContainerLogs))
 
 	// Execute a command in a container
-	containersGroup.Post("/:id/exec", func(c *fiber.Ctx) error {
-		return ExecInContainer(c, service)
-	})
+	containersGroup.Post("/:id/exec", handle(ExecInContainer))
 
 	// Get stats for a container
-	containersGroup.Get("/:id/stats", func(c *fiber.Ctx) error {
-		return GetContainerStats(c, service)
-	})
+	containersGroup.Get("/:id/stats", handle(GetContainerStats))
 
 	// Prune unused containers
-	containersGroup.Post("/prune", func(c *fiber.Ctx) error {
-		return PruneContainers(c, service)
-	})
+	containersGroup.Post("/prune", handle(PruneContainers))
 }
 
 // ListContainers handler for fetching all containers
 func ListContainers(c *fiber.Ctx, service ContainerService) error {
 	all := c.QueryBool("all", false) // Default: show only running containers
-	containers, err := service.ListContainers(all)
+	containers, err := service.ListContainers(c.UserContext(), all)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -77,17 +88,34 @@ func ListContainers(c *fiber.Ctx, service ContainerService) error {
 // InspectContainer handler for inspecting a specific container
 func InspectContainer(c *fiber.Ctx, service ContainerService) error {
 	containerID := c.Params("id")
-	container, err := service.InspectContainer(containerID)
+	container, err := service.InspectContainer(c.UserContext(), containerID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 	return c.JSON(container)
 }
 
+// CreateContainer handler for creating a container. Images rejected by the
+// signing policy get 403.
+func CreateContainer(c *fiber.Ctx, service ContainerService) error {
+	var request CreateRequest
+	if err := c.BodyParser(&request); err != nil || request.Config == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	response, err := service.CreateContainer(c.UserContext(), request)
+	if signatures.IsPolicyViolation(err) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
 // StartContainer handler for starting a container
 func StartContainer(c *fiber.Ctx, service ContainerService) error {
 	containerID := c.Params("id")
-	err := service.StartContainer(containerID)
+	err := service.StartContainer(c.UserContext(), containerID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -98,7 +126,7 @@ func StartContainer(c *fiber.Ctx, service ContainerService) error {
 func StopContainer(c *fiber.Ctx, service ContainerService) error {
 	containerID := c.Params("id")
 	timeout := c.QueryInt("timeout", 10) // Default timeout: 10 seconds
-	err := service.StopContainer(containerID, &timeout)
+	err := service.StopContainer(c.UserContext(), containerID, &timeout)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -109,7 +137,7 @@ func StopContainer(c *fiber.Ctx, service ContainerService) error {
 func RestartContainer(c *fiber.Ctx, service ContainerService) error {
 	containerID := c.Params("id")
 	timeout := c.QueryInt("timeout", 10) // Default timeout: 10 seconds
-	err := service.RestartContainer(containerID, &timeout)
+	err := service.RestartContainer(c.UserContext(), containerID, &timeout)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -120,7 +148,7 @@ func RestartContainer(c *fiber.Ctx, service ContainerService) error {
 func RemoveContainer(c *fiber.Ctx, service ContainerService) error {
 	containerID := c.Params("id")
 	force := c.QueryBool("force", false) // Default: do not force
-	err := service.RemoveContainer(containerID, force)
+	err := service.RemoveContainer(c.UserContext(), containerID, force)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -131,7 +159,7 @@ func RemoveContainer(c *fiber.Ctx, service ContainerService) error {
 func GetContainerLogs(c *fiber.Ctx, service ContainerService) error {
 	containerID := c.Params("id")
 	follow := c.QueryBool("follow", false)
-	logs, err := service.ContainerLogs(containerID, follow)
+	logs, err := service.ContainerLogs(c.UserContext(), containerID, follow)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -150,7 +178,7 @@ func ExecInContainer(c *fiber.Ctx, service ContainerService) error {
 	if err := c.BodyParser(&request); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
-	output, err := service.ExecInContainer(containerID, request.Cmd)
+	output, err := service.ExecInContainer(c.UserContext(), containerID, request.Cmd)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -161,7 +189,7 @@ func ExecInContainer(c *fiber.Ctx, service ContainerService) error {
 func GetContainerStats(c *fiber.Ctx, service ContainerService) error {
 	containerID := c.Params("id")
 	stream := c.QueryBool("stream", false)
-	stats, err := service.Stats(containerID, stream)
+	stats, err := service.Stats(c.UserContext(), containerID, stream)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -173,9 +201,70 @@ func GetContainerStats(c *fiber.Ctx, service ContainerService) error {
 
 // PruneContainers handler for pruning unused containers
 func PruneContainers(c *fiber.Ctx, service ContainerService) error {
-	report, err := service.PruneContainers()
+	report, err := service.PruneContainers(c.UserContext())
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 	return c.JSON(report)
 }
+
+// RecreateContainer handler for replacing a container with the same config
+// and the current image of its reference. ?pull=true pulls the reference
+// first, ?force=true recreates even when the image is unchanged, and
+// ?rollback=false keeps a replacement that fails its health check, which
+// has ?healthTimeout= seconds to pass. A rolled back recreate answers 409:
+// the container is running as before, but was not updated.
+func RecreateContainer(c *fiber.Ctx, service ContainerService) error {
+	healthTimeout := c.QueryInt("healthTimeout", 0)
+	if healthTimeout < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "healthTimeout must not be negative"})
+	}
+	options := RecreateOptions{
+		Pull:          c.QueryBool("pull", false),
+		Force:         c.QueryBool("force", false),
+		Rollback:      c.QueryBool("rollback", true),
+		HealthTimeout: time.Duration(healthTimeout) * time.Second,
+	}
+	result, err := service.RecreateContainer(c.UserContext(), c.Params("id"), options)
+	if err != nil {
+		return recreateError(c, err)
+	}
+	if result.Action == ActionRolledBack {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": result.Error, "result": result})
+	}
+	return c.JSON(result)
+}
+
+// CheckUpdates handler for listing containers with their update status.
+// ?registry=true also asks the registries, which is slower.
+func CheckUpdates(c *fiber.Ctx, service ContainerService) error {
+	statuses, err := service.CheckUpdates(c.UserContext(), c.QueryBool("registry", false))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(statuses)
+}
+
+// CheckUpdate handler for one container's update status
+func CheckUpdate(c *fiber.Ctx, service ContainerService) error {
+	status, err := service.CheckUpdate(c.UserContext(), c.Params("id"), c.QueryBool("registry", false))
+	if err != nil {
+		return recreateError(c, err)
+	}
+	return c.JSON(status)
+}
+
+// recreateError maps unknown containers to 404, containers that cannot be
+// recreated to 400 and images rejected by the signing policy to 403
+func recreateError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errdefs.IsNotFound(err):
+		status = fiber.StatusNotFound
+	case errdefs.IsInvalidParameter(err):
+		status = fiber.StatusBadRequest
+	case signatures.IsPolicyViolation(err):
+		status = fiber.StatusForbidden
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
@@ -0,0 +1,58 @@
+package containers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRecreateRoute(t *testing.T) {
+	tests := []struct {
+		health string
+		status int
+		action string
+	}{
+		{types.Healthy, fiber.StatusOK, ActionRecreated},
+		{types.Unhealthy, fiber.StatusConflict, ActionRolledBack},
+	}
+	for _, tt := range tests {
+		cli := newFakeClient()
+		cli.health = tt.health
+		service, err := NewContainerService(config.Config{}, cli)
+		if err != nil {
+			t.Fatalf("NewContainerService: %v", err)
+		}
+		app := fiber.New()
+		MountRoutes(app.Group("/containers"), func(*fiber.Ctx) (ContainerService, error) {
+			return service, nil
+		})
+
+		resp, err := app.Test(httptest.NewRequest("POST", "/containers/web/recreate", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s replacement: status = %d %s, want %d", tt.health, resp.StatusCode, data, tt.status)
+			continue
+		}
+		var body struct {
+			Action string          `json:"action"`
+			Result *RecreateResult `json:"result"`
+		}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Result != nil {
+			body.Action = body.Result.Action
+		}
+		if body.Action != tt.action {
+			t.Errorf("%s replacement: action = %q, want %q", tt.health, body.Action, tt.action)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package containers
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/genc-murat/harborview/internal/docker"
+)
+
+// Recreate actions
+const (
+	ActionRecreated = "recreated"
+	// ActionUnchanged means the image has not changed, so the container was
+	// kept
+	ActionUnchanged = "unchanged"
+	// ActionRolledBack means the replacement failed and the previous
+	// container was restored
+	ActionRolledBack = "rolled back"
+)
+
+const (
+	// DefaultHealthTimeout is how long a replacement has to become healthy
+	// when the options set no limit
+	DefaultHealthTimeout = 2 * time.Minute
+	// startGrace is how long a replacement without a health check must stay
+	// running to count as started
+	startGrace = 5 * time.Second
+)
+
+// RecreateOptions controls RecreateContainer
+type RecreateOptions struct {
+	// Pull pulls the container's image reference first
+	Pull bool
+	// Force recreates the container even when its image has not changed
+	Force bool
+	// Rollback restores the previous container when the replacement's
+	// health check fails. A replacement that does not start is always
+	// rolled back.
+	Rollback bool
+	// HealthTimeout bounds the wait for the replacement to become healthy
+	HealthTimeout time.Duration
+}
+
+// RecreateResult describes a recreate
+type RecreateResult struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	// ID is the container now holding the name
+	ID              string `json:"id"`
+	PreviousID      string `json:"previousId"`
+	Image           string `json:"image"`
+	ImageID         string `json:"imageId"`
+	PreviousImageID string `json:"previousImageId"`
+	Pulled          bool   `json:"pulled"`
+	// Error is why the replacement was rolled back
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// RecreateContainer replaces a container with one created from the same
+// config, host config and network endpoints, using the image its reference
+// points to now. The previous container is renamed aside and stopped, and
+// only removed once the replacement has started and, when it has a health
+// check, turned healthy. Anonymous volumes are carried over.
+func (s *containerService) RecreateContainer(ctx context.Context, containerID string, options RecreateOptions) (*RecreateResult, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDeploy)
+	defer cancel()
+
+	old, err := s.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if old.Config == nil || old.HostConfig == nil {
+		return nil, fmt.Errorf("container %s has no config", containerID)
+	}
+	name := strings.TrimPrefix(old.Name, "/")
+	ref := old.Config.Image
+	result := &RecreateResult{
+		Name:            name,
+		Action:          ActionRecreated,
+		ID:              old.ID,
+		PreviousID:      old.ID,
+		Image:           ref,
+		PreviousImageID: old.Image,
+	}
+	if old.HostConfig.AutoRemove {
+		return nil, errdefs.InvalidParameter(fmt.Errorf("container %s is removed when it stops, so it cannot be recreated", name))
+	}
+
+	if options.Pull {
+		if isImageID(ref) {
+			return nil, errdefs.InvalidParameter(fmt.Errorf("container %s was created from image ID %s, which cannot be pulled", name, ref))
+		}
+		pullCtx, cancel := s.timeouts.Context(ctx, docker.OpPull)
+		err := s.verifier.Pull(pullCtx, s.cli, ref)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		result.Pulled = true
+	}
+	inspect, _, err := s.cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	result.ImageID = inspect.ID
+	if inspect.ID == old.Image && !options.Force {
+		result.Action = ActionUnchanged
+		return result, nil
+	}
+	if s.verifier.Enabled() {
+		if _, err := s.verifier.CheckLocal(ctx, ref, inspect.RepoDigests); err != nil {
+			return nil, err
+		}
+	}
+
+	// The previous image's defaults are dropped from the config so the new
+	// image's apply
+	var previousImage *types.ImageInspect
+	if previous, _, err := s.cli.ImageInspectWithRaw(ctx, old.Image); err == nil {
+		previousImage = &previous
+	} else {
+		result.Warnings = append(result.Warnings, "the previous image is gone, so its defaults are kept in the new container's config")
+	}
+	spec := Spec{
+		Config:     replacementConfig(old, ref, previousImage),
+		HostConfig: replacementHostConfig(old),
+		Networks:   replacementEndpoints(old),
+	}
+	check := func(ctx context.Context, id string) error {
+		err := s.waitHealthy(ctx, id, options.HealthTimeout)
+		if err != nil && !options.Rollback {
+			result.Warnings = append(result.Warnings, err.Error())
+			return nil
+		}
+		return err
+	}
+	created, warnings, err := Replace(ctx, s.cli, old, spec, ReplaceOptions{Check: check})
+	var rollback *RollbackError
+	if errors.As(err, &rollback) {
+		result.Action, result.Error = ActionRolledBack, rollback.Err.Error()
+		log.Printf("Container %s rolled back: %v", name, rollback.Err)
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.ID = created
+	result.Warnings = append(result.Warnings, warnings...)
+	log.Printf("Container %s recreated as %s", name, created)
+	return result, nil
+}
+
+// waitHealthy waits for a started container to turn healthy, or to keep
+// running for startGrace when it has no health check
+func (s *containerService) waitHealthy(ctx context.Context, id string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	started := time.Now()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		inspect, err := s.cli.ContainerInspect(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to inspect replacement: %w", err)
+		}
+		state := inspect.State
+		if !state.Running || state.Restarting {
+			return fmt.Errorf("replacement exited with code %d", state.ExitCode)
+		}
+		if state.Health == nil || state.Health.Status == types.NoHealthcheck {
+			if time.Since(started) >= startGrace {
+				return nil
+			}
+		} else {
+			switch state.Health.Status {
+			case types.Healthy:
+				return nil
+			case types.Unhealthy:
+				return fmt.Errorf("replacement is unhealthy%s", lastProbe(state.Health))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			return fmt.Errorf("replacement was not healthy within %s", timeout)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func lastProbe(health *types.Health) string {
+	if len(health.Log) == 0 {
+		return ""
+	}
+	output := strings.TrimSpace(health.Log[len(health.Log)-1].Output)
+	if output == "" {
+		return ""
+	}
+	return ": " + output
+}
+
+// replacementConfig is the container's config with image set to ref. What
+// the container inherited from its previous image is dropped, so the new
+// image's environment, command, labels and health check take over.
+func replacementConfig(old types.ContainerJSON, ref string, previousImage *types.ImageInspect) *container.Config {
+	config := *old.Config
+	config.Image = ref
+	// The daemon names containers' hosts after their short ID by default
+	if config.Hostname == shortID(old.ID) {
+		config.Hostname = ""
+	}
+	if previousImage == nil || previousImage.Config == nil {
+		return &config
+	}
+
+	defaults := previousImage.Config
+	config.Env = slices.DeleteFunc(slices.Clone(config.Env), func(env string) bool {
+		return slices.Contains(defaults.Env, env)
+	})
+	if len(config.Labels) > 0 {
+		labels := make(map[string]string, len(config.Labels))
+		for key, value := range config.Labels {
+			if inherited, ok := defaults.Labels[key]; !ok || inherited != value {
+				labels[key] = value
+			}
+		}
+		config.Labels = labels
+	}
+	if slices.Equal(config.Entrypoint, defaults.Entrypoint) {
+		config.Entrypoint = nil
+	}
+	if slices.Equal(config.Cmd, defaults.Cmd) {
+		config.Cmd = nil
+	}
+	if config.WorkingDir == defaults.WorkingDir {
+		config.WorkingDir = ""
+	}
+	if config.User == defaults.User {
+		config.User = ""
+	}
+	if config.StopSignal == defaults.StopSignal {
+		config.StopSignal = ""
+	}
+	if reflect.DeepEqual(config.Healthcheck, defaults.Healthcheck) {
+		config.Healthcheck = nil
+	}
+	if len(config.ExposedPorts) > 0 {
+		ports := nat.PortSet{}
+		for port := range config.ExposedPorts {
+			if _, ok := defaults.ExposedPorts[port]; !ok {
+				ports[port] = struct{}{}
+			}
+		}
+		config.ExposedPorts = ports
+	}
+	if len(config.Volumes) > 0 {
+		volumes := make(map[string]struct{})
+		for target := range config.Volumes {
+			if _, ok := defaults.Volumes[target]; !ok {
+				volumes[target] = struct{}{}
+			}
+		}
+		config.Volumes = volumes
+	}
+	return &config
+}
+
+// replacementHostConfig is the container's host config with its anonymous
+// volumes mounted by name, so their data carries over
+func replacementHostConfig(old types.ContainerJSON) *container.HostConfig {
+	hostConfig := *old.HostConfig
+	hostConfig.Mounts = slices.Clone(hostConfig.Mounts)
+
+	declared := map[string]bool{}
+	for _, m := range hostConfig.Mounts {
+		declared[m.Target] = true
+	}
+	for _, bind := range hostConfig.Binds {
+		if parts := strings.Split(bind, ":"); len(parts) >= 2 {
+			declared[parts[1]] = true
+		}
+	}
+	for _, m := range old.Mounts {
+		if m.Type != mount.TypeVolume || m.Name == "" || declared[m.Destination] {
+			continue
+		}
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+			Type:     mount.TypeVolume,
+			Source:   m.Name,
+			Target:   m.Destination,
+			ReadOnly: !m.RW,
+		})
+	}
+	return &hostConfig
+}
+
+// replacementEndpoints are the container's network endpoints with what the
+// daemon assigned at runtime left out, the network of its network mode
+// first
+func replacementEndpoints(old types.ContainerJSON) []NetworkAttachment {
+	mode := old.HostConfig.NetworkMode
+	if mode.IsContainer() || mode.IsHost() || mode.IsNone() || old.NetworkSettings == nil {
+		return nil
+	}
+	var endpoints []NetworkAttachment
+	for name, settings := range old.NetworkSettings.Networks {
+		if settings == nil {
+			continue
+		}
+		replacement := &network.EndpointSettings{
+			IPAMConfig: settings.IPAMConfig,
+			Links:      settings.Links,
+			DriverOpts: settings.DriverOpts,
+		}
+		// The daemon adds the short ID as an alias on user-defined networks
+		for _, alias := range settings.Aliases {
+			if alias != shortID(old.ID) {
+				replacement.Aliases = append(replacement.Aliases, alias)
+			}
+		}
+		endpoints = append(endpoints, NetworkAttachment{Name: name, Settings: replacement})
+	}
+	primary := string(mode)
+	if mode.IsDefault() {
+		primary = network.NetworkBridge
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if (endpoints[i].Name == primary) != (endpoints[j].Name == primary) {
+			return endpoints[i].Name == primary
+		}
+		return endpoints[i].Name < endpoints[j].Name
+	})
+	return endpoints
+}
+
+// isImageID reports whether ref is an image ID rather than a name
+func isImageID(ref string) bool {
+	if strings.HasPrefix(ref, "sha256:") {
+		return true
+	}
+	if len(ref) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(ref)
+	return err == nil
+}
+
+func shortID(id string) string {
+	return id[:min(12, len(id))]
+}
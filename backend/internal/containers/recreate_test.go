@@ -0,0 +1,318 @@
+package containers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/pkg/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// fakeContainer is a container of fakeClient
+type fakeContainer struct {
+	id      string
+	name    string
+	imageID string
+	running bool
+	config  *container.Config
+}
+
+// fakeClient keeps containers in memory, records what the service asks of
+// the daemon and fails the operations it is told to
+type fakeClient struct {
+	// images maps references and IDs to image IDs
+	images     map[string]string
+	containers []*fakeContainer
+	// health is the health status replacements report
+	health string
+
+	failCreate      error
+	failStart       error
+	failRemoveAside error
+
+	calls []string
+}
+
+// oldID is the ID of the container fakeClient starts with
+const oldID = "0123456789abcdef"
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		images: map[string]string{"web:latest": "sha256:new", "sha256:new": "sha256:new", "sha256:old": "sha256:old"},
+		containers: []*fakeContainer{{
+			id:      oldID,
+			name:    "web",
+			imageID: "sha256:old",
+			running: true,
+			config:  &container.Config{Image: "web:latest", Env: []string{"MODE=production"}},
+		}},
+		health: types.Healthy,
+	}
+}
+
+func (f *fakeClient) find(ref string) *fakeContainer {
+	for _, c := range f.containers {
+		if c.id == ref || c.name == ref {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *fakeClient) lookup(ref string) (*fakeContainer, error) {
+	if c := f.find(ref); c != nil {
+		return c, nil
+	}
+	return nil, errdefs.NotFound(fmt.Errorf("no such container: %s", ref))
+}
+
+func (f *fakeClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	state := &types.ContainerState{Running: c.running}
+	if c.id != oldID && f.health != "" {
+		state.Health = &types.Health{Status: f.health}
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         c.id,
+			Name:       "/" + c.name,
+			Image:      c.imageID,
+			State:      state,
+			HostConfig: &container.HostConfig{},
+		},
+		Config: c.config,
+	}, nil
+}
+
+func (f *fakeClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	f.calls = append(f.calls, "create "+containerName)
+	if f.failCreate != nil {
+		return container.CreateResponse{}, f.failCreate
+	}
+	if f.find(containerName) != nil {
+		return container.CreateResponse{}, errdefs.Conflict(fmt.Errorf("container name %s is already in use", containerName))
+	}
+	id := fmt.Sprintf("%016d", len(f.containers)+1)
+	f.containers = append(f.containers, &fakeContainer{id: id, name: containerName, imageID: f.images[config.Image], config: config})
+	return container.CreateResponse{ID: id}, nil
+}
+
+func (f *fakeClient) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	f.calls = append(f.calls, "start "+c.name)
+	if f.failStart != nil && c.id != oldID {
+		return f.failStart
+	}
+	c.running = true
+	return nil
+}
+
+func (f *fakeClient) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	f.calls = append(f.calls, "stop "+c.name)
+	c.running = false
+	return nil
+}
+
+func (f *fakeClient) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	f.calls = append(f.calls, "remove "+c.name)
+	if f.failRemoveAside != nil && c.id == oldID {
+		return f.failRemoveAside
+	}
+	f.containers = slices.DeleteFunc(f.containers, func(other *fakeContainer) bool { return other == c })
+	return nil
+}
+
+func (f *fakeClient) ContainerRename(ctx context.Context, containerID, newContainerName string) error {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	f.calls = append(f.calls, "rename "+c.name+" "+newContainerName)
+	c.name = newContainerName
+	return nil
+}
+
+func (f *fakeClient) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	return nil
+}
+
+func (f *fakeClient) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeClient) ImageTag(ctx context.Context, source, target string) error {
+	return nil
+}
+
+func (f *fakeClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	id, ok := f.images[imageID]
+	if !ok {
+		return types.ImageInspect{}, nil, errdefs.NotFound(fmt.Errorf("no such image: %s", imageID))
+	}
+	return types.ImageInspect{ID: id, Config: &container.Config{}}, nil, nil
+}
+
+func (f *fakeClient) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error {
+	return nil
+}
+
+func (f *fakeClient) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeClient) ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (types.IDResponse, error) {
+	return types.IDResponse{}, errors.New("exec is not supported")
+}
+
+func (f *fakeClient) ContainerExecAttach(ctx context.Context, execID string, options container.ExecAttachOptions) (types.HijackedResponse, error) {
+	return types.HijackedResponse{}, errors.New("exec is not supported")
+}
+
+func (f *fakeClient) ContainerStats(ctx context.Context, containerID string, stream bool) (container.StatsResponseReader, error) {
+	return container.StatsResponseReader{Body: io.NopCloser(strings.NewReader("{}"))}, nil
+}
+
+func (f *fakeClient) ContainersPrune(ctx context.Context, pruneFilters filters.Args) (container.PruneReport, error) {
+	return container.PruneReport{}, nil
+}
+
+func TestRecreateContainer(t *testing.T) {
+	const aside = "web-old-0123456789ab"
+	tests := []struct {
+		name     string
+		setup    func(*fakeClient)
+		rollback bool
+		action   string
+		err      string
+		warning  string
+		calls    []string
+	}{
+		{
+			name:     "healthy",
+			rollback: true,
+			action:   ActionRecreated,
+			calls:    []string{"rename web " + aside, "create web", "stop " + aside, "start web", "remove " + aside},
+		},
+		{
+			name:     "unhealthy",
+			setup:    func(f *fakeClient) { f.health = types.Unhealthy },
+			rollback: true,
+			action:   ActionRolledBack,
+			err:      "replacement is unhealthy",
+			calls:    []string{"rename web " + aside, "create web", "stop " + aside, "start web", "remove web", "rename " + aside + " web", "start web"},
+		},
+		{
+			name:     "unhealthy kept",
+			setup:    func(f *fakeClient) { f.health = types.Unhealthy },
+			rollback: false,
+			action:   ActionRecreated,
+			warning:  "replacement is unhealthy",
+			calls:    []string{"rename web " + aside, "create web", "stop " + aside, "start web", "remove " + aside},
+		},
+		{
+			// A replacement that does not start is rolled back either way
+			name:     "start failure",
+			setup:    func(f *fakeClient) { f.failStart = errors.New("port is already allocated") },
+			rollback: false,
+			action:   ActionRolledBack,
+			err:      "failed to start container: port is already allocated",
+			calls:    []string{"rename web " + aside, "create web", "stop " + aside, "start web", "remove web", "rename " + aside + " web", "start web"},
+		},
+		{
+			name:     "aside left behind",
+			setup:    func(f *fakeClient) { f.failRemoveAside = errors.New("device or resource busy") },
+			rollback: true,
+			action:   ActionRecreated,
+			warning:  "failed to remove previous container " + aside + ": device or resource busy",
+			calls:    []string{"rename web " + aside, "create web", "stop " + aside, "start web", "remove " + aside},
+		},
+	}
+	for _, tt := range tests {
+		cli := newFakeClient()
+		if tt.setup != nil {
+			tt.setup(cli)
+		}
+		service, err := NewContainerService(config.Config{}, cli)
+		if err != nil {
+			t.Fatalf("NewContainerService: %v", err)
+		}
+		result, err := service.RecreateContainer(context.Background(), "web", RecreateOptions{Rollback: tt.rollback})
+		if err != nil {
+			t.Errorf("%s: RecreateContainer: %v", tt.name, err)
+			continue
+		}
+		if result.Action != tt.action || !strings.Contains(result.Error, tt.err) || (tt.err == "") != (result.Error == "") {
+			t.Errorf("%s: result = %s %q, want %s %q", tt.name, result.Action, result.Error, tt.action, tt.err)
+		}
+		if tt.warning != "" && !slices.ContainsFunc(result.Warnings, func(w string) bool { return strings.Contains(w, tt.warning) }) {
+			t.Errorf("%s: warnings = %v, want %q", tt.name, result.Warnings, tt.warning)
+		}
+		if !slices.Equal(cli.calls, tt.calls) {
+			t.Errorf("%s: calls = %v, want %v", tt.name, cli.calls, tt.calls)
+		}
+
+		// Whatever happened, a running container holds the name
+		current := cli.find("web")
+		if current == nil || !current.running || current.id != result.ID {
+			t.Errorf("%s: container named web = %+v, result ID %s", tt.name, current, result.ID)
+			continue
+		}
+		if tt.action == ActionRolledBack && current.id != oldID {
+			t.Errorf("%s: the previous container was not restored", tt.name)
+		}
+		if tt.action == ActionRecreated && current.imageID != "sha256:new" {
+			t.Errorf("%s: replacement runs image %s", tt.name, current.imageID)
+		}
+	}
+}
+
+func TestRecreateCreateFailure(t *testing.T) {
+	cli := newFakeClient()
+	cli.failCreate = errdefs.InvalidParameter(errors.New("invalid mount config"))
+	service, err := NewContainerService(config.Config{}, cli)
+	if err != nil {
+		t.Fatalf("NewContainerService: %v", err)
+	}
+
+	_, err = service.RecreateContainer(context.Background(), "web", RecreateOptions{Rollback: true})
+	if err == nil || !errdefs.IsInvalidParameter(err) {
+		t.Fatalf("RecreateContainer = %v, want the create error", err)
+	}
+	// The previous container gets its name back and is never stopped
+	want := []string{"rename web web-old-0123456789ab", "create web", "rename web-old-0123456789ab web"}
+	if !slices.Equal(cli.calls, want) {
+		t.Errorf("calls = %v, want %v", cli.calls, want)
+	}
+	if old := cli.find("web"); old == nil || old.id != oldID || !old.running || len(cli.containers) != 1 {
+		t.Errorf("containers after a failed create = %+v", cli.containers)
+	}
+}
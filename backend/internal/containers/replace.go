@@ -0,0 +1,154 @@
+package containers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ReplaceClient is the part of the Docker client that Create and Replace use
+type ReplaceClient interface {
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error
+	ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error
+	ContainerRename(ctx context.Context, containerID, newContainerName string) error
+	NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error
+}
+
+// NetworkAttachment connects a container to a network
+type NetworkAttachment struct {
+	Name     string
+	Settings *network.EndpointSettings
+}
+
+// Spec is a container to create
+type Spec struct {
+	Name       string
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	// Networks are attached in order, the first one at create time
+	Networks []NetworkAttachment
+}
+
+// ReplaceOptions controls Replace
+type ReplaceOptions struct {
+	// Start starts the replacement even when the previous container was not
+	// running
+	Start bool
+	// Check is run once the replacement has started. An error rolls the
+	// replacement back.
+	Check func(ctx context.Context, id string) error
+}
+
+// RollbackError is returned by Replace when the replacement did not start
+// or failed its check, and the previous container was put back in its place
+type RollbackError struct {
+	Err error
+}
+
+func (e *RollbackError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// Create creates spec's container and attaches its networks, removing it
+// again when an attachment fails
+func Create(ctx context.Context, cli ReplaceClient, spec Spec) (string, error) {
+	var networking *network.NetworkingConfig
+	if len(spec.Networks) > 0 {
+		first := spec.Networks[0]
+		networking = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{first.Name: first.Settings}}
+	}
+	created, err := cli.ContainerCreate(ctx, spec.Config, spec.HostConfig, networking, nil, spec.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+	// Older daemons accept a single network at create time
+	for _, attachment := range spec.Networks[min(1, len(spec.Networks)):] {
+		if err := cli.NetworkConnect(ctx, attachment.Name, created.ID, attachment.Settings); err != nil {
+			discard(context.WithoutCancel(ctx), cli, created.ID)
+			return "", fmt.Errorf("failed to connect network %s: %w", attachment.Name, err)
+		}
+	}
+	return created.ID, nil
+}
+
+// Replace swaps old for a container created from spec, which takes old's
+// name when spec has none. The previous container is renamed aside and stopped, and only
+// removed once the replacement has started and passed options.Check; when
+// anything fails on the way, the replacement is removed and the previous
+// container restored and started again. The replacement's ID is returned
+// with warnings about what could not be cleaned up.
+func Replace(ctx context.Context, cli ReplaceClient, old types.ContainerJSON, spec Spec, options ReplaceOptions) (string, []string, error) {
+	name := strings.TrimPrefix(old.Name, "/")
+	aside := fmt.Sprintf("%s-old-%s", name, shortID(old.ID))
+	if err := cli.ContainerRename(ctx, old.ID, aside); err != nil {
+		return "", nil, fmt.Errorf("failed to rename container: %w", err)
+	}
+	// Undoing must not be cut short by the deadline that made it necessary
+	undo := context.WithoutCancel(ctx)
+	restore := func() {
+		if err := cli.ContainerRename(undo, old.ID, name); err != nil {
+			log.Printf("Failed to rename container %s back to %s: %v", aside, name, err)
+		}
+	}
+
+	if spec.Name == "" {
+		spec.Name = name
+	}
+	created, err := Create(ctx, cli, spec)
+	if err != nil {
+		restore()
+		return "", nil, err
+	}
+
+	running := old.State != nil && (old.State.Running || old.State.Restarting)
+	if running {
+		if err := cli.ContainerStop(ctx, old.ID, container.StopOptions{Timeout: spec.Config.StopTimeout}); err != nil {
+			discard(undo, cli, created)
+			restore()
+			return "", nil, fmt.Errorf("failed to stop container: %w", err)
+		}
+	}
+	if running || options.Start {
+		failure := cli.ContainerStart(ctx, created, container.StartOptions{})
+		if failure != nil {
+			failure = fmt.Errorf("failed to start container: %w", failure)
+		} else if options.Check != nil {
+			failure = options.Check(ctx, created)
+		}
+		if failure != nil {
+			discard(undo, cli, created)
+			restore()
+			if running {
+				if err := cli.ContainerStart(undo, old.ID, container.StartOptions{}); err != nil {
+					return "", nil, fmt.Errorf("replacement failed (%v) and the previous container did not start again: %w", failure, err)
+				}
+			}
+			return "", nil, &RollbackError{Err: failure}
+		}
+	}
+
+	var warnings []string
+	if err := cli.ContainerRemove(ctx, old.ID, container.RemoveOptions{}); err != nil {
+		warnings = append(warnings, fmt.Sprintf("failed to remove previous container %s: %v", aside, err))
+	}
+	return created, warnings, nil
+}
+
+// discard removes a replacement that never took over
+func discard(ctx context.Context, cli ReplaceClient, id string) {
+	if err := cli.ContainerRemove(ctx, id, container.RemoveOptions{Force: true}); err != nil {
+		log.Printf("Failed to remove replacement container %s: %v", id, err)
+	}
+}
@@ -5,43 +5,84 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/network"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/internal/registry"
+	"github.com/genc-murat/harborview/internal/signatures"
+	"github.com/genc-murat/harborview/pkg/config"
 )
 
 // ContainerService interface for dependency injection
 type ContainerService interface {
-	ListContainers(all bool) ([]types.Container, error)
-	InspectContainer(containerID string) (*types.ContainerJSON, error)
-	StartContainer(containerID string) error
-	StopContainer(containerID string, timeout *int) error
-	RestartContainer(containerID string, timeout *int) error
-	RemoveContainer(containerID string, force bool) error
-	ContainerLogs(containerID string, follow bool) (io.ReadCloser, error)
-	ExecInContainer(containerID string, cmd []string) (string, error)
-	Stats(containerID string, stream bool) (io.ReadCloser, error)
-	PruneContainers() (types.ContainersPruneReport, error)
+	ListContainers(ctx context.Context, all bool) ([]types.Container, error)
+	InspectContainer(ctx context.Context, containerID string) (*types.ContainerJSON, error)
+	CreateContainer(ctx context.Context, request CreateRequest) (container.CreateResponse, error)
+	StartContainer(ctx context.Context, containerID string) error
+	StopContainer(ctx context.Context, containerID string, timeout *int) error
+	RestartContainer(ctx context.Context, containerID string, timeout *int) error
+	RemoveContainer(ctx context.Context, containerID string, force bool) error
+	ContainerLogs(ctx context.Context, containerID string, follow bool) (io.ReadCloser, error)
+	ExecInContainer(ctx context.Context, containerID string, cmd []string) (string, error)
+	Stats(ctx context.Context, containerID string, stream bool) (io.ReadCloser, error)
+	PruneContainers(ctx context.Context) (types.ContainersPruneReport, error)
+	RecreateContainer(ctx context.Context, containerID string, options RecreateOptions) (*RecreateResult, error)
+	CheckUpdates(ctx context.Context, checkRegistry bool) ([]UpdateStatus, error)
+	CheckUpdate(ctx context.Context, containerID string, checkRegistry bool) (*UpdateStatus, error)
+}
+
+// ContainerClient is the part of the Docker client the container service
+// needs
+type ContainerClient interface {
+	ReplaceClient
+	signatures.ImagePuller
+	ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error)
+	ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (types.IDResponse, error)
+	ContainerExecAttach(ctx context.Context, execID string, options container.ExecAttachOptions) (types.HijackedResponse, error)
+	ContainerStats(ctx context.Context, containerID string, stream bool) (container.StatsResponseReader, error)
+	ContainersPrune(ctx context.Context, pruneFilters filters.Args) (container.PruneReport, error)
+	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
 }
 
 type containerService struct {
-	cli *client.Client
+	cli      ContainerClient
+	resolver *registry.Resolver
+	verifier *signatures.Verifier
+	timeouts docker.Timeouts
+}
+
+// CreateRequest is the body of a container create call, in the Docker
+// Engine API's own field names
+type CreateRequest struct {
+	Name             string                    `json:"name"`
+	Config           *container.Config         `json:"config"`
+	HostConfig       *container.HostConfig     `json:"hostConfig"`
+	NetworkingConfig *network.NetworkingConfig `json:"networkingConfig"`
 }
 
-// NewContainerService creates a new instance of ContainerService using the Docker SDK
-func NewContainerService() (ContainerService, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+// NewContainerService creates a ContainerService for the daemon behind cli
+func NewContainerService(cfg config.Config, cli ContainerClient) (ContainerService, error) {
+	resolver := registry.NewResolver(cfg)
+	verifier, err := signatures.NewVerifier(cfg, resolver)
 	if err != nil {
 		return nil, err
 	}
 
-	return &containerService{cli: cli}, nil
+	return &containerService{cli: cli, resolver: resolver, verifier: verifier, timeouts: docker.NewTimeouts(cfg)}, nil
 }
 
-func (s *containerService) ListContainers(all bool) ([]types.Container, error) {
-	containers, err := s.cli.ContainerList(context.Background(), container.ListOptions{
+func (s *containerService) ListContainers(ctx context.Context, all bool) ([]types.Container, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	containers, err := s.cli.ContainerList(ctx, container.ListOptions{
 		All: all,
 	})
 	if err != nil {
@@ -50,61 +91,118 @@ func (s *containerService) ListContainers(all bool) ([]types.Container, error) {
 	return containers, nil
 }
 
-func (s *containerService) InspectContainer(containerID string) (*types.ContainerJSON, error) {
-	containerJSON, err := s.cli.ContainerInspect(context.Background(), containerID)
+func (s *containerService) InspectContainer(ctx context.Context, containerID string) (*types.ContainerJSON, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	containerJSON, err := s.cli.ContainerInspect(ctx, containerID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to inspect container: %w", err)
 	}
 	return &containerJSON, nil
 }
 
-func (s *containerService) StartContainer(containerID string) error {
-	if err := s.cli.ContainerStart(context.Background(), containerID, container.StartOptions{}); err != nil {
+// CreateContainer creates a container from a local image. Under a signing
+// policy the image must verify at the registry digest it was pulled at.
+func (s *containerService) CreateContainer(ctx context.Context, request CreateRequest) (container.CreateResponse, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	if request.Config == nil || request.Config.Image == "" {
+		return container.CreateResponse{}, fmt.Errorf("image is required")
+	}
+	if s.verifier.Enabled() {
+		inspect, _, err := s.cli.ImageInspectWithRaw(ctx, request.Config.Image)
+		if err != nil {
+			return container.CreateResponse{}, fmt.Errorf("failed to inspect image: %w", err)
+		}
+		if _, err := s.verifier.CheckLocal(ctx, request.Config.Image, inspect.RepoDigests); err != nil {
+			return container.CreateResponse{}, err
+		}
+	}
+
+	response, err := s.cli.ContainerCreate(ctx, request.Config, request.HostConfig, request.NetworkingConfig, nil, request.Name)
+	if err != nil {
+		return container.CreateResponse{}, fmt.Errorf("failed to create container: %w", err)
+	}
+	log.Printf("Container %s created successfully", response.ID)
+	return response, nil
+}
+
+func (s *containerService) StartContainer(ctx context.Context, containerID string) error {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	if err := s.cli.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
 		return fmt.Errorf("failed to start container: %w", err)
 	}
 	log.Printf("Container %s started successfully", containerID)
 	return nil
 }
 
-func (s *containerService) StopContainer(containerID string, timeout *int) error {
-	if err := s.cli.ContainerStop(context.Background(), containerID, container.StopOptions{Timeout: timeout}); err != nil {
+func (s *containerService) StopContainer(ctx context.Context, containerID string, timeout *int) error {
+	// The daemon waits up to timeout before killing the container
+	var grace time.Duration
+	if timeout != nil {
+		grace = time.Duration(*timeout) * time.Second
+	}
+	ctx, cancel := s.timeouts.ContextWithGrace(ctx, docker.OpDefault, grace)
+	defer cancel()
+
+	if err := s.cli.ContainerStop(ctx, containerID, container.StopOptions{Timeout: timeout}); err != nil {
 		return fmt.Errorf("failed to stop container: %w", err)
 	}
 	log.Printf("Container %s stopped successfully", containerID)
 	return nil
 }
 
-func (s *containerService) RestartContainer(containerID string, timeout *int) error {
-	if err := s.cli.ContainerRestart(context.Background(), containerID, container.StopOptions{Timeout: timeout}); err != nil {
+func (s *containerService) RestartContainer(ctx context.Context, containerID string, timeout *int) error {
+	// The daemon waits up to timeout before killing the container
+	var grace time.Duration
+	if timeout != nil {
+		grace = time.Duration(*timeout) * time.Second
+	}
+	ctx, cancel := s.timeouts.ContextWithGrace(ctx, docker.OpDefault, grace)
+	defer cancel()
+
+	if err := s.cli.ContainerRestart(ctx, containerID, container.StopOptions{Timeout: timeout}); err != nil {
 		return fmt.Errorf("failed to restart container: %w", err)
 	}
 	log.Printf("Container %s restarted successfully", containerID)
 	return nil
 }
 
-func (s *containerService) RemoveContainer(containerID string, force bool) error {
+func (s *containerService) RemoveContainer(ctx context.Context, containerID string, force bool) error {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
 	options := container.RemoveOptions{Force: force}
-	if err := s.cli.ContainerRemove(context.Background(), containerID, options); err != nil {
+	if err := s.cli.ContainerRemove(ctx, containerID, options); err != nil {
 		return fmt.Errorf("failed to remove container: %w", err)
 	}
 	log.Printf("Container %s removed successfully", containerID)
 	return nil
 }
 
-func (s *containerService) ContainerLogs(containerID string, follow bool) (io.ReadCloser, error) {
-	logs, err := s.cli.ContainerLogs(context.Background(), containerID, container.LogsOptions{
+func (s *containerService) ContainerLogs(ctx context.Context, containerID string, follow bool) (io.ReadCloser, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpLogs)
+	logs, err := s.cli.ContainerLogs(ctx, containerID, container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
 		Follow:     follow,
 	})
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("failed to fetch logs for container %s: %w", containerID, err)
 	}
-	return logs, nil
+	return docker.CancelOnClose(logs, cancel), nil
 }
 
-func (s *containerService) ExecInContainer(containerID string, cmd []string) (string, error) {
-	execResp, err := s.cli.ContainerExecCreate(context.Background(), containerID, types.ExecConfig{
+func (s *containerService) ExecInContainer(ctx context.Context, containerID string, cmd []string) (string, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpExec)
+	defer cancel()
+
+	execResp, err := s.cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
 		Cmd:          cmd,
 		AttachStdout: true,
 		AttachStderr: true,
@@ -113,7 +211,7 @@ func (s *containerService) ExecInContainer(containerID string, cmd []string) (st
 		return "", fmt.Errorf("failed to create exec instance: %w", err)
 	}
 
-	attachResp, err := s.cli.ContainerExecAttach(context.Background(), execResp.ID, types.ExecStartCheck{})
+	attachResp, err := s.cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{})
 	if err != nil {
 		return "", fmt.Errorf("failed to attach to exec instance: %w", err)
 	}
@@ -127,16 +225,21 @@ func (s *containerService) ExecInContainer(containerID string, cmd []string) (st
 	return string(output), nil
 }
 
-func (s *containerService) Stats(containerID string, stream bool) (io.ReadCloser, error) {
-	stats, err := s.cli.ContainerStats(context.Background(), containerID, stream)
+func (s *containerService) Stats(ctx context.Context, containerID string, stream bool) (io.ReadCloser, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpStats)
+	stats, err := s.cli.ContainerStats(ctx, containerID, stream)
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("failed to get stats for container %s: %w", containerID, err)
 	}
-	return stats.Body, nil
+	return docker.CancelOnClose(stats.Body, cancel), nil
 }
 
-func (s *containerService) PruneContainers() (types.ContainersPruneReport, error) {
-	report, err := s.cli.ContainersPrune(context.Background(), filters.Args{})
+func (s *containerService) PruneContainers(ctx context.Context) (types.ContainersPruneReport, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpPrune)
+	defer cancel()
+
+	report, err := s.cli.ContainersPrune(ctx, filters.Args{})
 	if err != nil {
 		return types.ContainersPruneReport{}, fmt.Errorf("failed to prune containers: %w", err)
 	}
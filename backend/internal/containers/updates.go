@@ -0,0 +1,199 @@
+package containers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/internal/registry"
+)
+
+// UpdateStatus tells whether a newer image is available for a container's
+// image reference
+type UpdateStatus struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// ImageID is the image the container runs
+	ImageID string `json:"imageId"`
+	// LocalImageID is the image the reference points to locally
+	LocalImageID string `json:"localImageId,omitempty"`
+	// LocalUpdate means the reference has moved to another local image,
+	// so a recreate picks it up without pulling
+	LocalUpdate bool `json:"localUpdate"`
+	// RegistryDigest is the manifest digest the tag has in its registry,
+	// when the registry was asked
+	RegistryDigest string `json:"registryDigest,omitempty"`
+	// RegistryUpdate means the registry has a manifest for the tag that
+	// has not been pulled
+	RegistryUpdate  bool `json:"registryUpdate"`
+	UpdateAvailable bool `json:"updateAvailable"`
+	// Error is why the reference could not be checked
+	Error string `json:"error,omitempty"`
+}
+
+// localImage is what an image reference resolves to locally
+type localImage struct {
+	id          string
+	repoDigests []string
+	err         error
+}
+
+// updateChecker resolves the references of a batch of containers once each
+type updateChecker struct {
+	s        *containerService
+	registry bool
+	local    map[string]localImage
+	remote   map[string]string
+	failed   map[string]error
+}
+
+// CheckUpdates reports, for every container, whether its image reference
+// points to a newer image locally and, with checkRegistry, whether its
+// registry has a manifest that has not been pulled
+func (s *containerService) CheckUpdates(ctx context.Context, checkRegistry bool) ([]UpdateStatus, error) {
+	ctx, cancel := s.timeouts.Context(ctx, updateOp(checkRegistry))
+	defer cancel()
+
+	list, err := s.cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	checker := s.newUpdateChecker(checkRegistry)
+	statuses := make([]UpdateStatus, 0, len(list))
+	for _, c := range list {
+		name := c.ID[:min(12, len(c.ID))]
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		ref := c.Image
+		if strings.HasPrefix(ref, "sha256:") {
+			// Listings show the image ID once the reference has moved to
+			// another image, which is the case this looks for
+			inspect, err := s.cli.ContainerInspect(ctx, c.ID)
+			if client.IsErrNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to inspect container: %w", err)
+			}
+			ref = inspect.Config.Image
+		}
+		statuses = append(statuses, checker.check(ctx, c.ID, name, ref, c.ImageID))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses, nil
+}
+
+// CheckUpdate reports whether a newer image is available for one container
+func (s *containerService) CheckUpdate(ctx context.Context, containerID string, checkRegistry bool) (*UpdateStatus, error) {
+	ctx, cancel := s.timeouts.Context(ctx, updateOp(checkRegistry))
+	defer cancel()
+
+	inspect, err := s.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	status := s.newUpdateChecker(checkRegistry).check(ctx, inspect.ID, strings.TrimPrefix(inspect.Name, "/"), inspect.Config.Image, inspect.Image)
+	return &status, nil
+}
+
+// updateOp is the operation whose timeout bounds an update check. Asking
+// registries takes as long as resolving a pull.
+func updateOp(checkRegistry bool) string {
+	if checkRegistry {
+		return docker.OpPull
+	}
+	return docker.OpDefault
+}
+
+func (s *containerService) newUpdateChecker(checkRegistry bool) *updateChecker {
+	return &updateChecker{
+		s:        s,
+		registry: checkRegistry,
+		local:    make(map[string]localImage),
+		remote:   make(map[string]string),
+		failed:   make(map[string]error),
+	}
+}
+
+func (u *updateChecker) check(ctx context.Context, id, name, ref, imageID string) UpdateStatus {
+	status := UpdateStatus{ID: id, Name: name, Image: ref, ImageID: imageID}
+	if isImageID(ref) || strings.Contains(ref, "@") {
+		status.Error = "the container was created from an image ID or digest, which does not move"
+		return status
+	}
+
+	local, ok := u.local[ref]
+	if !ok {
+		inspect, _, err := u.s.cli.ImageInspectWithRaw(ctx, ref)
+		switch {
+		case err == nil:
+			local = localImage{id: inspect.ID, repoDigests: inspect.RepoDigests}
+		case client.IsErrNotFound(err):
+			// The tag was removed or moved away, so only the registry can
+			// tell
+		default:
+			local = localImage{err: err}
+		}
+		u.local[ref] = local
+	}
+	if local.err != nil {
+		status.Error = fmt.Sprintf("failed to inspect image: %v", local.err)
+		return status
+	}
+	status.LocalImageID = local.id
+	status.LocalUpdate = local.id != "" && local.id != imageID
+
+	if u.registry {
+		digest, err := u.registryDigest(ctx, ref)
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.RegistryDigest = digest
+			status.RegistryUpdate = !slices.ContainsFunc(local.repoDigests, func(repoDigest string) bool {
+				return strings.HasSuffix(repoDigest, "@"+digest)
+			})
+		}
+	}
+	status.UpdateAvailable = status.LocalUpdate || status.RegistryUpdate
+	return status
+}
+
+// registryDigest looks up the manifest digest of ref's tag
+func (u *updateChecker) registryDigest(ctx context.Context, ref string) (string, error) {
+	if digest, ok := u.remote[ref]; ok {
+		return digest, nil
+	}
+	if err, ok := u.failed[ref]; ok {
+		return "", err
+	}
+
+	digest, err := func() (string, error) {
+		parsed, err := registry.ParseReference(ref)
+		if err != nil {
+			return "", err
+		}
+		tag := parsed.Tag
+		if tag == "" {
+			tag = "latest"
+		}
+		registryClient, err := u.s.resolver.Client(parsed.Domain)
+		if err != nil {
+			return "", err
+		}
+		return registryClient.ManifestDigest(ctx, parsed.Repository, tag)
+	}()
+	if err != nil {
+		err = fmt.Errorf("failed to check registry: %w", err)
+		u.failed[ref] = err
+		return "", err
+	}
+	u.remote[ref] = digest
+	return digest, nil
+}
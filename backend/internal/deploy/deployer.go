@@ -0,0 +1,531 @@
+// Package deploy redeploys containers and stacks when their secret hook URL
+// is called, typically by CI or a registry after a push. Each container is
+// recreated from the newest image of its tag and rolled back when the
+// replacement fails its health check.
+package deploy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/containers"
+	"github.com/genc-murat/harborview/internal/fsutil"
+	"github.com/genc-murat/harborview/internal/registry"
+	"github.com/genc-murat/harborview/internal/stacks"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// Deployment statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	// StatusFailed deployments had a container fail or roll back
+	StatusFailed = "failed"
+)
+
+// Triggers
+const (
+	TriggerWebhook  = "webhook"
+	TriggerRegistry = "registry"
+	TriggerManual   = "manual"
+)
+
+// TriggerPrefix is the path hook tokens are appended to
+const TriggerPrefix = "/deploy/trigger/"
+
+// deploymentLimit is how many deployments are kept
+const deploymentLimit = 100
+
+// ErrNoMatch is returned when a registry notification pushed none of the
+// images a hook deploys
+var ErrNoMatch = errors.New("the pushed images are not used by the hook's containers")
+
+// stackPattern restricts stack names to compose project names
+var stackPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// DeployService interface for dependency injection
+type DeployService interface {
+	Hooks() []Hook
+	GetHook(id string) (Hook, error)
+	CreateHook(ctx context.Context, hook Hook) (Hook, error)
+	DeleteHook(id string) error
+	RotateToken(id string) (Hook, error)
+	Trigger(ctx context.Context, token string, pushed []registry.Reference) (Deployment, error)
+	Deploy(ctx context.Context, id string) (Deployment, error)
+	Deployments(hook string) []Deployment
+	GetDeployment(id string) (Deployment, error)
+}
+
+// Hook redeploys one container, by name, or every container of a compose
+// project. Token is the secret part of the trigger URL; it is only
+// returned when the hook is created or its token rotated.
+type Hook struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"`
+	Container   string    `json:"container,omitempty"`
+	Stack       string    `json:"stack,omitempty"`
+	Token       string    `json:"token,omitempty"`
+	TriggerPath string    `json:"triggerPath,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Deployment is one run of a hook
+type Deployment struct {
+	ID      string `json:"id"`
+	Hook    string `json:"hook"`
+	Trigger string `json:"trigger"`
+	// Images are the pushed images a registry notification reported
+	Images     []string   `json:"images,omitempty"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Targets    []Target   `json:"targets"`
+}
+
+// Target is one container of a deployment
+type Target struct {
+	Container string                     `json:"container"`
+	Result    *containers.RecreateResult `json:"result,omitempty"`
+	Error     string                     `json:"error,omitempty"`
+}
+
+// hookState is a hook with the hash of its token. deploying serializes its
+// deployments.
+type hookState struct {
+	hook      Hook
+	tokenHash string
+	deploying sync.Mutex
+}
+
+// storedHook is a hook as persisted
+type storedHook struct {
+	Hook
+	TokenHash string `json:"tokenHash"`
+}
+
+// Deployer runs the deploy hooks of one daemon
+type Deployer struct {
+	file          string
+	healthTimeout time.Duration
+	containers    containers.ContainerService
+
+	// ctx bounds deployments, which outlive the request that triggered them
+	ctx context.Context
+
+	mu          sync.Mutex
+	hooks       map[string]*hookState
+	deployments []*Deployment
+}
+
+// NewDeployer creates a deployer for the hooks persisted in the configured
+// file. Deployments run until ctx is cancelled.
+func NewDeployer(ctx context.Context, cfg config.Config, service containers.ContainerService) (*Deployer, error) {
+	d := &Deployer{
+		file:          cfg.Deploy.File,
+		healthTimeout: containers.DefaultHealthTimeout,
+		containers:    service,
+		ctx:           ctx,
+		hooks:         make(map[string]*hookState),
+	}
+	if cfg.Deploy.HealthTimeout > 0 {
+		d.healthTimeout = time.Duration(cfg.Deploy.HealthTimeout) * time.Second
+	}
+
+	stored, err := d.readFile()
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range stored {
+		d.hooks[hook.ID] = &hookState{hook: hook.Hook, tokenHash: hook.TokenHash}
+	}
+	return d, nil
+}
+
+// Hooks returns every hook, oldest first
+func (d *Deployer) Hooks() []Hook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hooks := make([]Hook, 0, len(d.hooks))
+	for _, state := range d.hooks {
+		hooks = append(hooks, state.hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	return hooks
+}
+
+// GetHook returns one hook
+func (d *Deployer) GetHook(id string) (Hook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state, err := d.hook(id)
+	if err != nil {
+		return Hook{}, err
+	}
+	return state.hook, nil
+}
+
+// CreateHook adds a hook for a container or a stack. Containers are
+// followed by name, since recreating them changes their ID. The result
+// carries the token.
+func (d *Deployer) CreateHook(ctx context.Context, hook Hook) (Hook, error) {
+	switch {
+	case (hook.Container == "") == (hook.Stack == ""):
+		return Hook{}, errdefs.InvalidParameter(fmt.Errorf("a hook needs either a container or a stack"))
+	case hook.Container != "":
+		inspect, err := d.containers.InspectContainer(ctx, hook.Container)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				return Hook{}, errdefs.InvalidParameter(fmt.Errorf("container %s not found", hook.Container))
+			}
+			return Hook{}, err
+		}
+		hook.Container = strings.TrimPrefix(inspect.Name, "/")
+	case !stackPattern.MatchString(hook.Stack):
+		return Hook{}, errdefs.InvalidParameter(fmt.Errorf("invalid stack name %q", hook.Stack))
+	}
+
+	id, err := newID(8)
+	if err != nil {
+		return Hook{}, err
+	}
+	token, err := newID(32)
+	if err != nil {
+		return Hook{}, err
+	}
+	hook.ID, hook.CreatedAt = id, time.Now().UTC()
+	hook.Token, hook.TriggerPath = "", ""
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks[id] = &hookState{hook: hook, tokenHash: hashToken(token)}
+	if err := d.writeFile(); err != nil {
+		delete(d.hooks, id)
+		return Hook{}, err
+	}
+	return withToken(hook, token), nil
+}
+
+// DeleteHook removes a hook. Its running deployment finishes.
+func (d *Deployer) DeleteHook(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state, err := d.hook(id)
+	if err != nil {
+		return err
+	}
+	delete(d.hooks, id)
+	if err := d.writeFile(); err != nil {
+		d.hooks[id] = state
+		return err
+	}
+	return nil
+}
+
+// RotateToken replaces the token of a hook, so its old URL stops working
+func (d *Deployer) RotateToken(id string) (Hook, error) {
+	token, err := newID(32)
+	if err != nil {
+		return Hook{}, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state, err := d.hook(id)
+	if err != nil {
+		return Hook{}, err
+	}
+	previous := state.tokenHash
+	state.tokenHash = hashToken(token)
+	if err := d.writeFile(); err != nil {
+		state.tokenHash = previous
+		return Hook{}, err
+	}
+	return withToken(state.hook, token), nil
+}
+
+// Trigger starts a deployment of the hook holding token. pushed is nil for
+// a plain call; for a registry notification only the containers running
+// one of the pushed images are redeployed, and ErrNoMatch is returned when
+// there are none.
+func (d *Deployer) Trigger(ctx context.Context, token string, pushed []registry.Reference) (Deployment, error) {
+	tokenHash := hashToken(token)
+	d.mu.Lock()
+	var state *hookState
+	for _, candidate := range d.hooks {
+		if candidate.tokenHash == tokenHash {
+			state = candidate
+			break
+		}
+	}
+	d.mu.Unlock()
+	if state == nil {
+		return Deployment{}, errdefs.NotFound(fmt.Errorf("unknown deploy hook"))
+	}
+
+	if pushed == nil {
+		return d.start(ctx, state, TriggerWebhook, nil)
+	}
+	return d.start(ctx, state, TriggerRegistry, pushed)
+}
+
+// Deploy starts a deployment of a hook without its token
+func (d *Deployer) Deploy(ctx context.Context, id string) (Deployment, error) {
+	d.mu.Lock()
+	state, err := d.hook(id)
+	d.mu.Unlock()
+	if err != nil {
+		return Deployment{}, err
+	}
+	return d.start(ctx, state, TriggerManual, nil)
+}
+
+// start resolves the containers of a hook and queues their deployment.
+// pushed narrows the containers for registry triggers.
+func (d *Deployer) start(ctx context.Context, state *hookState, trigger string, pushed []registry.Reference) (Deployment, error) {
+	names, err := d.targets(ctx, state.hook, trigger == TriggerRegistry, pushed)
+	if err != nil {
+		return Deployment{}, err
+	}
+	id, err := newID(8)
+	if err != nil {
+		return Deployment{}, err
+	}
+	deployment := &Deployment{
+		ID:        id,
+		Hook:      state.hook.ID,
+		Trigger:   trigger,
+		Status:    StatusQueued,
+		CreatedAt: time.Now().UTC(),
+		Targets:   make([]Target, len(names)),
+	}
+	for _, ref := range pushed {
+		deployment.Images = append(deployment.Images, ref.Domain+"/"+ref.Repository+":"+ref.Tag)
+	}
+	for i, name := range names {
+		deployment.Targets[i].Container = name
+	}
+
+	d.mu.Lock()
+	d.deployments = append(d.deployments, deployment)
+	if len(d.deployments) > deploymentLimit {
+		d.deployments = d.deployments[len(d.deployments)-deploymentLimit:]
+	}
+	snapshot := deployment.snapshot()
+	d.mu.Unlock()
+
+	go d.run(state, deployment)
+	return snapshot, nil
+}
+
+// targets lists the names of the containers a hook deploys
+func (d *Deployer) targets(ctx context.Context, hook Hook, filter bool, pushed []registry.Reference) ([]string, error) {
+	matches := func(image string) bool {
+		if !filter {
+			return true
+		}
+		for _, ref := range pushed {
+			if sameImage(image, ref) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var names []string
+	if hook.Container != "" {
+		inspect, err := d.containers.InspectContainer(ctx, hook.Container)
+		if err != nil {
+			return nil, err
+		}
+		if matches(inspect.Config.Image) {
+			names = append(names, hook.Container)
+		}
+	} else {
+		list, err := d.containers.ListContainers(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, c := range list {
+			if c.Labels[stacks.LabelProject] != hook.Stack || len(c.Names) == 0 {
+				continue
+			}
+			found = true
+			image := c.Image
+			if filter && strings.HasPrefix(image, "sha256:") {
+				// Listings show the image ID once the tag has moved to
+				// another image, as a pull of the pushed tag does
+				inspect, err := d.containers.InspectContainer(ctx, c.ID)
+				if errdefs.IsNotFound(err) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				image = inspect.Config.Image
+			}
+			if matches(image) {
+				names = append(names, strings.TrimPrefix(c.Names[0], "/"))
+			}
+		}
+		if !found {
+			return nil, errdefs.NotFound(fmt.Errorf("stack %s has no containers", hook.Stack))
+		}
+		sort.Strings(names)
+	}
+	if len(names) == 0 {
+		return nil, ErrNoMatch
+	}
+	return names, nil
+}
+
+// run recreates the containers of a deployment one at a time, after the
+// hook's previous deployment finished
+func (d *Deployer) run(state *hookState, deployment *Deployment) {
+	state.deploying.Lock()
+	defer state.deploying.Unlock()
+
+	d.mu.Lock()
+	deployment.Status = StatusRunning
+	d.mu.Unlock()
+
+	options := containers.RecreateOptions{Pull: true, Rollback: true, HealthTimeout: d.healthTimeout}
+	status := StatusSucceeded
+	for i := range deployment.Targets {
+		name := deployment.Targets[i].Container
+		result, err := d.containers.RecreateContainer(d.ctx, name, options)
+
+		d.mu.Lock()
+		deployment.Targets[i].Result = result
+		if err != nil {
+			deployment.Targets[i].Error = err.Error()
+			status = StatusFailed
+			log.Printf("Deploy hook %s failed to redeploy %s: %v", state.hook.ID, name, err)
+		} else if result.Action == containers.ActionRolledBack {
+			status = StatusFailed
+		}
+		d.mu.Unlock()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	finished := time.Now().UTC()
+	deployment.Status, deployment.FinishedAt = status, &finished
+}
+
+// Deployments returns the recent deployments, newest first. hook narrows
+// them to one hook.
+func (d *Deployer) Deployments(hook string) []Deployment {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deployments := []Deployment{}
+	for i := len(d.deployments) - 1; i >= 0; i-- {
+		if hook == "" || d.deployments[i].Hook == hook {
+			deployments = append(deployments, d.deployments[i].snapshot())
+		}
+	}
+	return deployments
+}
+
+// GetDeployment returns one recent deployment
+func (d *Deployer) GetDeployment(id string) (Deployment, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, deployment := range d.deployments {
+		if deployment.ID == id {
+			return deployment.snapshot(), nil
+		}
+	}
+	return Deployment{}, errdefs.NotFound(fmt.Errorf("deployment %s not found", id))
+}
+
+// snapshot copies a deployment. d.mu must be held.
+func (deployment *Deployment) snapshot() Deployment {
+	copied := *deployment
+	copied.Targets = append([]Target{}, deployment.Targets...)
+	return copied
+}
+
+// hook looks up a hook. d.mu must be held.
+func (d *Deployer) hook(id string) (*hookState, error) {
+	state, ok := d.hooks[id]
+	if !ok {
+		return nil, errdefs.NotFound(fmt.Errorf("deploy hook %s not found", id))
+	}
+	return state, nil
+}
+
+func withToken(hook Hook, token string) Hook {
+	hook.Token, hook.TriggerPath = token, TriggerPrefix+token
+	return hook
+}
+
+// hashToken is what is stored of a token, so the hooks file does not hold
+// working URLs
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// readFile loads the persisted hooks
+func (d *Deployer) readFile() ([]storedHook, error) {
+	if d.file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(d.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deploy hooks file: %w", err)
+	}
+	var stored []storedHook
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode deploy hooks file: %w", err)
+	}
+	return stored, nil
+}
+
+// writeFile persists the hooks. d.mu must be held.
+func (d *Deployer) writeFile() error {
+	if d.file == "" {
+		return nil
+	}
+
+	stored := make([]storedHook, 0, len(d.hooks))
+	for _, state := range d.hooks {
+		stored = append(stored, storedHook{Hook: state.hook, TokenHash: state.tokenHash})
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].ID < stored[j].ID })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(d.file, data); err != nil {
+		return fmt.Errorf("failed to store deploy hooks: %w", err)
+	}
+	return nil
+}
+
+// newID returns size random bytes in hex
+func newID(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
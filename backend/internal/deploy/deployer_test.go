@@ -0,0 +1,234 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/containers"
+	"github.com/genc-murat/harborview/internal/registry"
+	"github.com/genc-murat/harborview/internal/stacks"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// fakeContainers serves the container calls a deployer makes. Containers
+// are named after their images; recreating a container in failures fails
+// with that error, and one in rolledBack is rolled back.
+type fakeContainers struct {
+	containers.ContainerService
+
+	stack      []string
+	failures   map[string]error
+	rolledBack map[string]bool
+
+	mu         sync.Mutex
+	recreated  []string
+	recreating chan struct{}
+}
+
+func (f *fakeContainers) InspectContainer(ctx context.Context, containerID string) (*types.ContainerJSON, error) {
+	return &types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: containerID, Name: "/" + containerID},
+		Config:            &container.Config{Image: "registry.example.com/team/" + containerID + ":latest"},
+	}, nil
+}
+
+func (f *fakeContainers) ListContainers(ctx context.Context, all bool) ([]types.Container, error) {
+	var list []types.Container
+	for _, name := range f.stack {
+		list = append(list, types.Container{
+			ID:     name,
+			Names:  []string{"/" + name},
+			Image:  "registry.example.com/team/" + name + ":latest",
+			Labels: map[string]string{stacks.LabelProject: "shop"},
+		})
+	}
+	return list, nil
+}
+
+func (f *fakeContainers) RecreateContainer(ctx context.Context, containerID string, options containers.RecreateOptions) (*containers.RecreateResult, error) {
+	if f.recreating != nil {
+		<-f.recreating
+	}
+	f.mu.Lock()
+	f.recreated = append(f.recreated, containerID)
+	f.mu.Unlock()
+	if err := f.failures[containerID]; err != nil {
+		return nil, err
+	}
+	result := &containers.RecreateResult{Name: containerID, Action: containers.ActionRecreated}
+	if f.rolledBack[containerID] {
+		result.Action, result.Error = containers.ActionRolledBack, "replacement is unhealthy"
+	}
+	return result, nil
+}
+
+func newDeployer(t *testing.T, file string, service containers.ContainerService) *Deployer {
+	t.Helper()
+	var cfg config.Config
+	cfg.Deploy.File = file
+	deployer, err := NewDeployer(context.Background(), cfg, service)
+	if err != nil {
+		t.Fatalf("NewDeployer: %v", err)
+	}
+	return deployer
+}
+
+// wait returns a deployment once it has finished
+func wait(t *testing.T, deployer *Deployer, id string) Deployment {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deployment, err := deployer.GetDeployment(id)
+		if err != nil {
+			t.Fatalf("GetDeployment: %v", err)
+		}
+		if deployment.FinishedAt != nil {
+			return deployment
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deployment %s did not finish: %+v", id, deployment)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHookTokens(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hooks.json")
+	service := &fakeContainers{}
+	deployer := newDeployer(t, file, service)
+	ctx := context.Background()
+
+	hook, err := deployer.CreateHook(ctx, Hook{Container: "api", Token: "chosen"})
+	if err != nil {
+		t.Fatalf("CreateHook: %v", err)
+	}
+	if hook.Token == "" || hook.Token == "chosen" || hook.TriggerPath != TriggerPrefix+hook.Token {
+		t.Fatalf("created hook = %+v, want a generated token", hook)
+	}
+	if listed, _ := deployer.GetHook(hook.ID); listed.Token != "" || listed.TriggerPath != "" {
+		t.Errorf("GetHook returns the token: %+v", listed)
+	}
+
+	// Only the hash of the token is stored, and it is what a restart
+	// looks tokens up by
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), hook.Token) || !strings.Contains(string(data), hashToken(hook.Token)) {
+		t.Errorf("hooks file = %s, want the token hash and not the token", data)
+	}
+	deployer = newDeployer(t, file, service)
+	deployment, err := deployer.Trigger(ctx, hook.Token, nil)
+	if err != nil {
+		t.Fatalf("Trigger after a restart: %v", err)
+	}
+	if deployment.Hook != hook.ID || deployment.Trigger != TriggerWebhook {
+		t.Errorf("deployment = %+v", deployment)
+	}
+	wait(t, deployer, deployment.ID)
+
+	for _, token := range []string{"", "wrong", hashToken(hook.Token), strings.ToUpper(hook.Token)} {
+		if _, err := deployer.Trigger(ctx, token, nil); !errdefs.IsNotFound(err) {
+			t.Errorf("Trigger(%q) = %v, want not found", token, err)
+		}
+	}
+
+	rotated, err := deployer.RotateToken(hook.ID)
+	if err != nil {
+		t.Fatalf("RotateToken: %v", err)
+	}
+	if _, err := deployer.Trigger(ctx, hook.Token, nil); !errdefs.IsNotFound(err) {
+		t.Errorf("Trigger with the rotated out token = %v, want not found", err)
+	}
+	deployment, err = deployer.Trigger(ctx, rotated.Token, nil)
+	if err != nil {
+		t.Fatalf("Trigger with the new token: %v", err)
+	}
+	wait(t, deployer, deployment.ID)
+
+	if err := deployer.DeleteHook(hook.ID); err != nil {
+		t.Fatalf("DeleteHook: %v", err)
+	}
+	if _, err := newDeployer(t, file, service).Trigger(ctx, rotated.Token, nil); !errdefs.IsNotFound(err) {
+		t.Errorf("Trigger of a deleted hook = %v, want not found", err)
+	}
+}
+
+func TestDeployFailsPartWay(t *testing.T) {
+	service := &fakeContainers{
+		stack:      []string{"web", "api", "worker", "cron"},
+		failures:   map[string]error{"cron": errdefs.NotFound(errors.New("no such image"))},
+		rolledBack: map[string]bool{"api": true},
+		recreating: make(chan struct{}),
+	}
+	deployer := newDeployer(t, "", service)
+	ctx := context.Background()
+	hook, err := deployer.CreateHook(ctx, Hook{Stack: "shop"})
+	if err != nil {
+		t.Fatalf("CreateHook: %v", err)
+	}
+
+	deployment, err := deployer.Deploy(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if deployment.Status != StatusQueued || len(deployment.Targets) != 4 {
+		t.Errorf("started deployment = %+v", deployment)
+	}
+	close(service.recreating)
+	deployment = wait(t, deployer, deployment.ID)
+
+	// A failed container fails the deployment, but the others are still
+	// redeployed
+	if deployment.Status != StatusFailed {
+		t.Errorf("status = %s, want %s", deployment.Status, StatusFailed)
+	}
+	if strings.Join(service.recreated, ",") != "api,cron,web,worker" {
+		t.Errorf("recreated %v, want every container in name order", service.recreated)
+	}
+	targets := map[string]Target{}
+	for _, target := range deployment.Targets {
+		targets[target.Container] = target
+	}
+	if target := targets["api"]; target.Result == nil || target.Result.Action != containers.ActionRolledBack {
+		t.Errorf("api = %+v, want rolled back", target)
+	}
+	if target := targets["cron"]; target.Result != nil || target.Error != "no such image" {
+		t.Errorf("cron = %+v, want its error", target)
+	}
+	for _, name := range []string{"web", "worker"} {
+		if target := targets[name]; target.Result == nil || target.Result.Action != containers.ActionRecreated || target.Error != "" {
+			t.Errorf("%s = %+v, want recreated", name, target)
+		}
+	}
+
+	// A registry notification only redeploys the containers of the pushed
+	// images
+	pushed := []registry.Reference{{Domain: "registry.example.com", Repository: "team/worker", Tag: "latest"}}
+	rotated, err := deployer.RotateToken(hook.ID)
+	if err != nil {
+		t.Fatalf("RotateToken: %v", err)
+	}
+	deployment, err = deployer.Trigger(ctx, rotated.Token, pushed)
+	if err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	deployment = wait(t, deployer, deployment.ID)
+	if len(deployment.Targets) != 1 || deployment.Targets[0].Container != "worker" || deployment.Status != StatusSucceeded {
+		t.Errorf("registry deployment = %+v, want worker redeployed", deployment)
+	}
+	pushed[0].Repository = "team/other"
+	if _, err := deployer.Trigger(ctx, rotated.Token, pushed); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("Trigger for an unused image = %v, want ErrNoMatch", err)
+	}
+}
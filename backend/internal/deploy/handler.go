@@ -0,0 +1,162 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/containers"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterRoutes registers the deploy hook routes for the daemon behind the
+// shared client. Deployments run until ctx is cancelled.
+func RegisterRoutes(ctx context.Context, app *fiber.App, cfg config.Config, cli *client.Client) error {
+	containerService, err := containers.NewContainerService(cfg, cli)
+	if err != nil {
+		return err
+	}
+	deployer, err := NewDeployer(ctx, cfg, containerService)
+	if err != nil {
+		return err
+	}
+
+	var service DeployService = deployer
+	deployGroup := app.Group("/deploy")
+
+	// List hooks
+	deployGroup.Get("/hooks", func(c *fiber.Ctx) error {
+		return c.JSON(service.Hooks())
+	})
+
+	// Add a hook
+	deployGroup.Post("/hooks", func(c *fiber.Ctx) error {
+		return CreateHook(c, service)
+	})
+
+	// Get a hook
+	deployGroup.Get("/hooks/:id", func(c *fiber.Ctx) error {
+		return GetHook(c, service)
+	})
+
+	// Remove a hook
+	deployGroup.Delete("/hooks/:id", func(c *fiber.Ctx) error {
+		return DeleteHook(c, service)
+	})
+
+	// Replace a hook's token
+	deployGroup.Post("/hooks/:id/token", func(c *fiber.Ctx) error {
+		return RotateToken(c, service)
+	})
+
+	// Deploy a hook now
+	deployGroup.Post("/hooks/:id/deploy", func(c *fiber.Ctx) error {
+		return DeployHook(c, service)
+	})
+
+	// List recent deployments, optionally by ?hook=
+	deployGroup.Get("/deployments", func(c *fiber.Ctx) error {
+		return c.JSON(service.Deployments(c.Query("hook")))
+	})
+
+	// Get a deployment
+	deployGroup.Get("/deployments/:id", func(c *fiber.Ctx) error {
+		return GetDeployment(c, service)
+	})
+
+	// Trigger a hook by its secret token, from CI or a registry
+	deployGroup.Post("/trigger/:token", func(c *fiber.Ctx) error {
+		return Trigger(c, service)
+	})
+	return nil
+}
+
+// deployError maps deployer errors to statuses
+func deployError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errdefs.IsInvalidParameter(err):
+		status = fiber.StatusBadRequest
+	case errdefs.IsNotFound(err):
+		status = fiber.StatusNotFound
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
+
+// CreateHook handler for adding a hook
+func CreateHook(c *fiber.Ctx, service DeployService) error {
+	var hook Hook
+	if err := c.BodyParser(&hook); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	created, err := service.CreateHook(c.UserContext(), hook)
+	if err != nil {
+		return deployError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+// GetHook handler for fetching one hook
+func GetHook(c *fiber.Ctx, service DeployService) error {
+	hook, err := service.GetHook(c.Params("id"))
+	if err != nil {
+		return deployError(c, err)
+	}
+	return c.JSON(hook)
+}
+
+// DeleteHook handler for removing a hook
+func DeleteHook(c *fiber.Ctx, service DeployService) error {
+	if err := service.DeleteHook(c.Params("id")); err != nil {
+		return deployError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Deploy hook removed successfully"})
+}
+
+// RotateToken handler for replacing a hook's token
+func RotateToken(c *fiber.Ctx, service DeployService) error {
+	hook, err := service.RotateToken(c.Params("id"))
+	if err != nil {
+		return deployError(c, err)
+	}
+	return c.JSON(hook)
+}
+
+// DeployHook handler for deploying a hook by ID
+func DeployHook(c *fiber.Ctx, service DeployService) error {
+	deployment, err := service.Deploy(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return deployError(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(deployment)
+}
+
+// GetDeployment handler for fetching one deployment
+func GetDeployment(c *fiber.Ctx, service DeployService) error {
+	deployment, err := service.GetDeployment(c.Params("id"))
+	if err != nil {
+		return deployError(c, err)
+	}
+	return c.JSON(deployment)
+}
+
+// Trigger handler for the secret hook URL. A registry notification body
+// redeploys only the containers of the pushed images; notifications that
+// match none are acknowledged without deploying, so registries do not
+// retry them.
+func Trigger(c *fiber.Ctx, service DeployService) error {
+	pushed := ParseNotification(c.Body())
+	if pushed != nil && len(pushed) == 0 {
+		return c.JSON(fiber.Map{"message": "No tags were pushed"})
+	}
+	deployment, err := service.Trigger(c.UserContext(), c.Params("token"), pushed)
+	if errors.Is(err, ErrNoMatch) {
+		return c.JSON(fiber.Map{"message": err.Error()})
+	}
+	if err != nil {
+		return deployError(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(deployment)
+}
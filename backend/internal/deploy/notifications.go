@@ -0,0 +1,84 @@
+package deploy
+
+import (
+	"encoding/json"
+
+	"github.com/genc-murat/harborview/internal/registry"
+)
+
+// distributionEnvelope is the body of a Docker Distribution registry
+// notification
+type distributionEnvelope struct {
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+}
+
+// hubPayload is the body of a Docker Hub webhook
+type hubPayload struct {
+	PushData *struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+}
+
+// ParseNotification reads the tags a registry notification reports pushed.
+// Docker Distribution (registry:2) notifications and Docker Hub webhooks
+// are understood. pushed is nil when body is neither, which makes the call
+// a plain trigger, and empty for a notification without tagged pushes.
+func ParseNotification(body []byte) (pushed []registry.Reference) {
+	if len(body) == 0 {
+		return nil
+	}
+
+	var envelope distributionEnvelope
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Events != nil {
+		pushed = []registry.Reference{}
+		for _, event := range envelope.Events {
+			// Pushes of blobs and untagged manifests come without a tag
+			if event.Action != "push" || event.Target.Tag == "" {
+				continue
+			}
+			name := event.Target.Repository + ":" + event.Target.Tag
+			if event.Request.Host != "" {
+				name = event.Request.Host + "/" + name
+			}
+			if ref, err := registry.ParseReference(name); err == nil {
+				pushed = append(pushed, ref)
+			}
+		}
+		return pushed
+	}
+
+	var hub hubPayload
+	if err := json.Unmarshal(body, &hub); err == nil && hub.PushData != nil {
+		pushed = []registry.Reference{}
+		if ref, err := registry.ParseReference(hub.Repository.RepoName + ":" + hub.PushData.Tag); err == nil {
+			pushed = append(pushed, ref)
+		}
+		return pushed
+	}
+	return nil
+}
+
+// sameImage reports whether image names the repository and tag of ref
+func sameImage(image string, ref registry.Reference) bool {
+	parsed, err := registry.ParseReference(image)
+	if err != nil || parsed.Digest != "" {
+		return false
+	}
+	tag := parsed.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	return parsed.Domain == ref.Domain && parsed.Repository == ref.Repository && tag == ref.Tag
+}
@@ -0,0 +1,47 @@
+// Package docker builds the Docker client shared by every service and the
+// per-operation timeouts applied to calls made through it.
+package docker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// NewClient creates a client for the daemon described by the Docker
+// environment variables. The API version is negotiated with the daemon on
+// first use instead of assuming the SDK's version. Its calls are recorded
+// in the metrics as LocalEndpoint.
+func NewClient() (*client.Client, error) {
+	cli, err := NewInstrumentedClient(LocalEndpoint, client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	return cli, nil
+}
+
+// WaitReady pings the daemon until it answers or timeout passes, which
+// covers harborview starting alongside a daemon that is still booting
+func WaitReady(ctx context.Context, cli *client.Client, timeout time.Duration) (types.Ping, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		attemptCtx, attemptCancel := context.WithTimeout(ctx, 5*time.Second)
+		ping, err := cli.Ping(attemptCtx)
+		attemptCancel()
+		if err == nil {
+			cli.NegotiateAPIVersionPing(ping)
+			return ping, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return types.Ping{}, fmt.Errorf("Docker daemon at %s is not reachable: %w", cli.DaemonHost(), err)
+		case <-time.After(time.Second):
+		}
+	}
+}
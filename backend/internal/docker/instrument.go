@@ -0,0 +1,100 @@
+package docker
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/genc-murat/harborview/internal/metrics"
+)
+
+// LocalEndpoint names the daemon of NewClient in metrics
+const LocalEndpoint = "local"
+
+// NewInstrumentedClient creates a client from options whose API calls are
+// recorded in harborview's metrics under endpoint.
+//
+// The SDK configures the transport of its HTTP client for the host and TLS
+// settings and later reads it back for hijacked connections, so the
+// recording transport cannot be passed in as an option. Instead the client
+// adopts a copy of its configured HTTP client as the last option, and the
+// recorder is wrapped around that client's transport once the SDK is done.
+func NewInstrumentedClient(endpoint string, options ...client.Opt) (*client.Client, error) {
+	var httpClient *http.Client
+	adopt := func(c *client.Client) error {
+		httpClient = c.HTTPClient()
+		return client.WithHTTPClient(httpClient)(c)
+	}
+	cli, err := client.NewClientWithOpts(append(options, adopt)...)
+	if err != nil {
+		return nil, err
+	}
+	httpClient.Transport = &instrumentedTransport{endpoint: endpoint, next: httpClient.Transport}
+	return cli, nil
+}
+
+// instrumentedTransport records the latency and failures of API calls.
+// Latency is the time to the response headers, so streams such as logs and
+// events count once, when they open.
+type instrumentedTransport struct {
+	endpoint string
+	next     http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := 0
+	if err == nil {
+		code = resp.StatusCode
+	}
+	metrics.ObserveDockerCall(t.endpoint, Operation(req.Method, req.URL.Path), start, code)
+	return resp, err
+}
+
+// actions are the path segments after a resource type that name an
+// operation rather than an object
+var actions = map[string]bool{
+	"json": true, "create": true, "prune": true, "load": true, "get": true,
+	"search": true, "df": true,
+}
+
+// Operation names an API call by method and path with object names and
+// IDs replaced, e.g. "POST /containers/{id}/start", so it can be used as a
+// metric label
+func Operation(method, path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	// Drop the version prefix, e.g. v1.45
+	if len(segments) > 0 && strings.HasPrefix(segments[0], "v") && strings.Contains(segments[0], ".") {
+		segments = segments[1:]
+	}
+	switch {
+	case len(segments) == 0 || segments[0] == "":
+		return method + " /"
+	case len(segments) == 1:
+		return method + " /" + segments[0]
+	case actions[segments[1]]:
+		return method + " /" + segments[0] + "/" + segments[1]
+	case segments[0] == "images" || segments[0] == "distribution" || segments[0] == "plugins":
+		// Image references contain slashes, so the action is the last segment
+		// when there is one
+		last := segments[len(segments)-1]
+		if len(segments) > 2 && isImageAction(last) {
+			return method + " /" + segments[0] + "/{name}/" + last
+		}
+		return method + " /" + segments[0] + "/{name}"
+	case len(segments) == 2:
+		return method + " /" + segments[0] + "/{id}"
+	default:
+		return method + " /" + segments[0] + "/{id}/" + segments[len(segments)-1]
+	}
+}
+
+func isImageAction(segment string) bool {
+	switch segment {
+	case "json", "history", "push", "tag", "get", "enable", "disable", "upgrade", "set", "privileges", "pull":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// Operations with their own configurable timeout. Anything else uses
+// OpDefault.
+const (
+	OpDefault = "default"
+	OpPull    = "pull"
+	OpPush    = "push"
+	OpBuild   = "build"
+	OpSave    = "save"
+	OpLoad    = "load"
+	OpScan    = "scan"
+	OpExec    = "exec"
+	OpLogs    = "logs"
+	OpStats   = "stats"
+	OpPrune   = "prune"
+	OpDF      = "df"
+	OpDeploy  = "deploy"
+)
+
+// defaultTimeouts apply when config does not set an operation. Zero means no
+// limit, which suits streams that are meant to stay open.
+var defaultTimeouts = map[string]time.Duration{
+	OpDefault: 30 * time.Second,
+	OpPull:    15 * time.Minute,
+	OpPush:    15 * time.Minute,
+	OpBuild:   30 * time.Minute,
+	OpSave:    30 * time.Minute,
+	OpLoad:    30 * time.Minute,
+	OpScan:    10 * time.Minute,
+	OpExec:    5 * time.Minute,
+	OpLogs:    0,
+	OpStats:   0,
+	OpPrune:   5 * time.Minute,
+	OpDF:      5 * time.Minute,
+	OpDeploy:  30 * time.Minute,
+}
+
+// Timeouts bounds Docker calls per operation
+type Timeouts struct {
+	limits map[string]time.Duration
+}
+
+// NewTimeouts reads the per-operation timeouts, in seconds, from cfg
+func NewTimeouts(cfg config.Config) Timeouts {
+	limits := make(map[string]time.Duration, len(defaultTimeouts))
+	for op, limit := range defaultTimeouts {
+		limits[op] = limit
+	}
+	for op, seconds := range cfg.Docker.Timeouts {
+		limits[op] = time.Duration(seconds) * time.Second
+	}
+	return Timeouts{limits: limits}
+}
+
+// For returns the timeout of op; zero means unlimited
+func (t Timeouts) For(op string) time.Duration {
+	if limit, ok := t.limits[op]; ok {
+		return limit
+	}
+	if limit, ok := t.limits[OpDefault]; ok {
+		return limit
+	}
+	return defaultTimeouts[OpDefault]
+}
+
+// Context derives the context of one op call from the request context
+func (t Timeouts) Context(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	return t.ContextWithGrace(ctx, op, 0)
+}
+
+// ContextWithGrace is Context with extra time on top of op's limit, for
+// calls where the daemon itself waits, such as stopping a container
+func (t Timeouts) ContextWithGrace(ctx context.Context, op string, grace time.Duration) (context.Context, context.CancelFunc) {
+	if limit := t.For(op); limit > 0 {
+		return context.WithTimeout(ctx, limit+grace)
+	}
+	return context.WithCancel(ctx)
+}
+
+// CancelOnClose cancels a stream's context once the stream is closed. A
+// streamed call runs on the context it is given like any other, so handlers
+// that send the stream after returning pass the request's c.Context(),
+// which outlives the handler and ends on shutdown.
+func CancelOnClose(stream io.ReadCloser, cancel context.CancelFunc) io.ReadCloser {
+	return &cancelReader{ReadCloser: stream, cancel: cancel}
+}
+
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (r *cancelReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.cancel)
+	return err
+}
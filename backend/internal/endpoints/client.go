@@ -0,0 +1,204 @@
+package endpoints
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/genc-murat/harborview/internal/docker"
+)
+
+// newClient creates a Docker client for an endpoint. The API version is
+// negotiated on first use, so hosts running older daemons work too. API
+// calls are recorded in the metrics under the endpoint's name.
+func newClient(endpoint Endpoint) (*client.Client, error) {
+	options := []client.Opt{client.WithAPIVersionNegotiation()}
+
+	switch {
+	case endpoint.Host == "":
+		options = append(options, client.FromEnv)
+	case strings.HasPrefix(endpoint.Host, "ssh://"):
+		dial, err := sshDialer(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		// The host is only used for the Host header; every connection goes
+		// through the SSH dialer
+		options = append(options, client.WithHost("http://docker.example.com"), client.WithDialContext(dial))
+	default:
+		if endpoint.TLS != nil {
+			tlsConfig, err := tlsconfig.Client(tlsconfig.Options{
+				CAFile:             endpoint.TLS.CA,
+				CertFile:           endpoint.TLS.Cert,
+				KeyFile:            endpoint.TLS.Key,
+				InsecureSkipVerify: endpoint.TLS.InsecureSkipVerify,
+				ExclusiveRootPools: endpoint.TLS.CA != "",
+			})
+			if err != nil {
+				return nil, fmt.Errorf("invalid TLS settings: %w", err)
+			}
+			options = append(options, client.WithHTTPClient(&http.Client{
+				Transport:     &http.Transport{TLSClientConfig: tlsConfig},
+				CheckRedirect: client.CheckRedirect,
+			}))
+		}
+		options = append(options, client.WithHost(endpoint.Host))
+	}
+
+	return docker.NewInstrumentedClient(endpoint.Name, options...)
+}
+
+// sshDialer connects to the remote daemon through `docker system dial-stdio`
+// run over ssh, the same way the docker CLI handles ssh:// hosts
+func sshDialer(endpoint Endpoint) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	host, args, err := sshArgs(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// The HTTP transport detaches dials from request cancellation, so ctx
+	// only ends a dial nobody waits for anymore, not a pooled connection
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialCommand(exec.CommandContext(ctx, "ssh", args...), host)
+	}, nil
+}
+
+// sshArgs returns the remote host of an ssh:// endpoint and the ssh
+// arguments that run dial-stdio on it. Everything taken from the URL is
+// passed as an option value or after "--", so none of it is read as an
+// option.
+func sshArgs(endpoint Endpoint) (string, []string, error) {
+	target, err := url.Parse(endpoint.Host)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid ssh host: %w", err)
+	}
+	if target.Hostname() == "" || (target.Path != "" && target.Path != "/") {
+		return "", nil, fmt.Errorf("invalid ssh host %q: expected ssh://[user@]host[:port]", endpoint.Host)
+	}
+
+	args := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=30"}
+	if target.User != nil {
+		args = append(args, "-l", target.User.Username())
+	}
+	if target.Port() != "" {
+		args = append(args, "-p", target.Port())
+	}
+	if endpoint.SSHIdentityFile != "" {
+		args = append(args, "-i", endpoint.SSHIdentityFile)
+	}
+	args = append(args, "--", target.Hostname(), "docker", "system", "dial-stdio")
+	return target.Hostname(), args, nil
+}
+
+// commandConn is a net.Conn over a command's stdin and stdout
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr *limitedBuffer
+	remote string
+
+	closeOnce sync.Once
+	waitOnce  sync.Once
+	waitErr   error
+}
+
+func dialCommand(cmd *exec.Cmd, remote string) (net.Conn, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &limitedBuffer{limit: 4096}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ssh: %w", err)
+	}
+	return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout, stderr: stderr, remote: remote}, nil
+}
+
+func (c *commandConn) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if errors.Is(err, io.EOF) {
+		// The command is exiting; waiting also flushes its stderr
+		c.wait()
+		if message := strings.TrimSpace(c.stderr.String()); message != "" {
+			return n, fmt.Errorf("ssh connection closed: %s", message)
+		}
+	}
+	return n, err
+}
+
+func (c *commandConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stdin.Close()
+		if c.cmd.Process != nil {
+			c.cmd.Process.Kill()
+		}
+		c.wait()
+	})
+	return nil
+}
+
+func (c *commandConn) wait() error {
+	c.waitOnce.Do(func() { c.waitErr = c.cmd.Wait() })
+	return c.waitErr
+}
+
+func (c *commandConn) LocalAddr() net.Addr  { return commandAddr("ssh") }
+func (c *commandConn) RemoteAddr() net.Addr { return commandAddr(c.remote) }
+
+// Deadlines are not supported on pipes; the HTTP client's own timeouts and
+// request contexts still apply
+func (c *commandConn) SetDeadline(time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(time.Time) error { return nil }
+
+type commandAddr string
+
+func (a commandAddr) Network() string { return "ssh" }
+func (a commandAddr) String() string  { return string(a) }
+
+// limitedBuffer keeps the first limit bytes written to it
+type limitedBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
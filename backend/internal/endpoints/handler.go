@@ -0,0 +1,238 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/client"
+	"github.com/genc-murat/harborview/internal/containers"
+	"github.com/genc-murat/harborview/internal/events"
+	"github.com/genc-murat/harborview/internal/images"
+	"github.com/genc-murat/harborview/internal/monitoring"
+	"github.com/genc-murat/harborview/internal/networks"
+	"github.com/genc-murat/harborview/internal/stacks"
+	"github.com/genc-murat/harborview/internal/system"
+	"github.com/genc-murat/harborview/internal/volumes"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterRoutes registers the endpoint management routes and mounts the
+// image, container, network, volume, stack, event, system and metrics routes
+// under /endpoints/:endpoint. Endpoints without a host share local, the
+// client of the default routes.
+// Health checks run until ctx is cancelled.
+func RegisterRoutes(ctx context.Context, app *fiber.App, cfg config.Config, local *client.Client) error {
+	manager, err := NewManager(cfg, local)
+	if err != nil {
+		return err
+	}
+	go manager.Monitor(ctx)
+
+	imageServices := newServiceCache(manager, func(_ string, cli *client.Client) (images.ImageService, error) {
+		return images.NewImageService(cfg, cli)
+	})
+	containerServices := newServiceCache(manager, func(_ string, cli *client.Client) (containers.ContainerService, error) {
+		return containers.NewContainerService(cfg, cli)
+	})
+	networkServices := newServiceCache(manager, func(_ string, cli *client.Client) (networks.NetworkService, error) {
+		return networks.NewNetworkService(cfg, cli), nil
+	})
+	volumeServices := newServiceCache(manager, func(_ string, cli *client.Client) (volumes.VolumeService, error) {
+		return volumes.NewVolumeService(cfg, cli), nil
+	})
+	// Stacks are stored per endpoint, except that endpoints sharing local
+	// are the daemon of the default routes and share their stacks
+	stackServices := newServiceCache(manager, func(name string, cli *client.Client) (stacks.StackService, error) {
+		if cli == local {
+			name = ""
+		}
+		return stacks.NewEndpointStackService(cfg, cli, name)
+	})
+	eventServices := newServiceCache(manager, func(_ string, cli *client.Client) (events.EventService, error) {
+		return events.Shared(ctx, cfg, cli), nil
+	})
+	manager.OnRemove(events.Release)
+	systemServices := newServiceCache(manager, func(_ string, cli *client.Client) (system.SystemService, error) {
+		return system.NewSystemService(cfg, cli), nil
+	})
+	metricsCollectors := newServiceCache(manager, func(_ string, cli *client.Client) (*monitoring.Collector, error) {
+		return monitoring.Shared(ctx, cfg, cli), nil
+	})
+	manager.OnRemove(monitoring.Release)
+
+	endpointsGroup := app.Group("/endpoints")
+
+	// List endpoints, optionally filtered by ?label=key=value
+	endpointsGroup.Get("/", func(c *fiber.Ctx) error {
+		return ListEndpoints(c, manager)
+	})
+
+	// Add an endpoint
+	endpointsGroup.Post("/", func(c *fiber.Ctx) error {
+		return AddEndpoint(c, manager)
+	})
+
+	// Get an endpoint with its health
+	endpointsGroup.Get("/:endpoint", func(c *fiber.Ctx) error {
+		return GetEndpoint(c, manager)
+	})
+
+	// Remove an API-managed endpoint
+	endpointsGroup.Delete("/:endpoint", func(c *fiber.Ctx) error {
+		return RemoveEndpoint(c, manager)
+	})
+
+	// Health-check an endpoint now
+	endpointsGroup.Post("/:endpoint/check", func(c *fiber.Ctx) error {
+		return CheckEndpoint(c, manager)
+	})
+
+	images.MountRoutes(endpointsGroup.Group("/:endpoint/images"), func(c *fiber.Ctx) (images.ImageService, error) {
+		return imageServices.get(c.Params("endpoint"))
+	})
+	containers.MountRoutes(endpointsGroup.Group("/:endpoint/containers"), func(c *fiber.Ctx) (containers.ContainerService, error) {
+		return containerServices.get(c.Params("endpoint"))
+	})
+	networks.MountRoutes(endpointsGroup.Group("/:endpoint/networks"), func(c *fiber.Ctx) (networks.NetworkService, error) {
+		return networkServices.get(c.Params("endpoint"))
+	})
+	volumes.MountRoutes(endpointsGroup.Group("/:endpoint/volumes"), func(c *fiber.Ctx) (volumes.VolumeService, error) {
+		return volumeServices.get(c.Params("endpoint"))
+	})
+	stacks.MountRoutes(endpointsGroup.Group("/:endpoint/stacks"), func(c *fiber.Ctx) (stacks.StackService, error) {
+		return stackServices.get(c.Params("endpoint"))
+	})
+	events.MountRoutes(endpointsGroup.Group("/:endpoint/events"), func(c *fiber.Ctx) (events.EventService, error) {
+		return eventServices.get(c.Params("endpoint"))
+	})
+	system.MountRoutes(endpointsGroup.Group("/:endpoint/system"), func(c *fiber.Ctx) (system.SystemService, error) {
+		return systemServices.get(c.Params("endpoint"))
+	})
+	return monitoring.MountRoutes(endpointsGroup.Group("/:endpoint"), cfg, func(c *fiber.Ctx) (*monitoring.Collector, error) {
+		return metricsCollectors.get(c.Params("endpoint"))
+	}, func(c *fiber.Ctx) string {
+		return c.Params("endpoint")
+	})
+}
+
+// ListEndpoints handler for listing endpoints
+func ListEndpoints(c *fiber.Ctx, manager *Manager) error {
+	selector := map[string]string{}
+	for _, label := range c.Context().QueryArgs().PeekMulti("label") {
+		key, value, _ := strings.Cut(string(label), "=")
+		selector[key] = value
+	}
+	return c.JSON(manager.List(selector))
+}
+
+// AddEndpoint handler for registering an endpoint. The new endpoint is
+// checked right away so the response shows whether it is reachable.
+func AddEndpoint(c *fiber.Ctx, manager *Manager) error {
+	var endpoint Endpoint
+	if err := c.BodyParser(&endpoint); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := manager.Add(endpoint); err != nil {
+		return endpointError(c, err, fiber.StatusBadRequest)
+	}
+	manager.Check(c.UserContext(), endpoint.Name)
+
+	status, err := manager.Get(endpoint.Name)
+	if err != nil {
+		return endpointError(c, err, fiber.StatusInternalServerError)
+	}
+	return c.Status(fiber.StatusCreated).JSON(status)
+}
+
+// GetEndpoint handler for fetching one endpoint
+func GetEndpoint(c *fiber.Ctx, manager *Manager) error {
+	status, err := manager.Get(c.Params("endpoint"))
+	if err != nil {
+		return endpointError(c, err, fiber.StatusInternalServerError)
+	}
+	return c.JSON(status)
+}
+
+// RemoveEndpoint handler for deleting an endpoint
+func RemoveEndpoint(c *fiber.Ctx, manager *Manager) error {
+	if err := manager.Remove(c.Params("endpoint")); err != nil {
+		return endpointError(c, err, fiber.StatusInternalServerError)
+	}
+	return c.JSON(fiber.Map{"message": "Endpoint removed successfully"})
+}
+
+// CheckEndpoint handler for running a health check immediately
+func CheckEndpoint(c *fiber.Ctx, manager *Manager) error {
+	health, err := manager.Check(c.UserContext(), c.Params("endpoint"))
+	if err != nil {
+		return endpointError(c, err, fiber.StatusInternalServerError)
+	}
+	return c.JSON(health)
+}
+
+// endpointError maps manager errors to statuses, using fallback for errors
+// it does not know
+func endpointError(c *fiber.Ctx, err error, fallback int) error {
+	return c.Status(errorStatus(err, fallback)).JSON(fiber.Map{"error": err.Error()})
+}
+
+func errorStatus(err error, fallback int) int {
+	var unavailable *UnavailableError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, ErrExists):
+		return fiber.StatusConflict
+	case errors.Is(err, ErrReadOnly):
+		return fiber.StatusForbidden
+	case errors.As(err, &unavailable):
+		return fiber.StatusServiceUnavailable
+	}
+	return fallback
+}
+
+// serviceCache keeps one service per endpoint, rebuilt whenever the
+// endpoint's pooled client changes
+type serviceCache[T any] struct {
+	manager *Manager
+	build   func(string, *client.Client) (T, error)
+
+	mu    sync.Mutex
+	items map[string]cachedService[T]
+}
+
+type cachedService[T any] struct {
+	client  *client.Client
+	service T
+}
+
+// newServiceCache builds services with build, which is given the endpoint's
+// name and client
+func newServiceCache[T any](manager *Manager, build func(string, *client.Client) (T, error)) *serviceCache[T] {
+	return &serviceCache[T]{manager: manager, build: build, items: make(map[string]cachedService[T])}
+}
+
+// get returns the endpoint's service. Errors are *fiber.Error so the mounted
+// routes answer with the right status.
+func (s *serviceCache[T]) get(name string) (T, error) {
+	var zero T
+	cli, err := s.manager.Client(name)
+	if err != nil {
+		return zero, fiber.NewError(errorStatus(err, fiber.StatusBadGateway), err.Error())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.items[name]; ok && cached.client == cli {
+		return cached.service, nil
+	}
+	service, err := s.build(name, cli)
+	if err != nil {
+		return zero, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	s.items[name] = cachedService[T]{client: cli, service: service}
+	return service, nil
+}
@@ -0,0 +1,499 @@
+// Package endpoints manages the Docker hosts harborview talks to. Endpoints
+// come from config or are added through the API; each gets a pooled client
+// that is health-checked in the background.
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/genc-murat/harborview/internal/fsutil"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// Endpoint sources
+const (
+	SourceConfig = "config"
+	SourceAPI    = "api"
+)
+
+// DefaultName is the endpoint created from the Docker environment when config
+// lists none
+const DefaultName = "local"
+
+// checkTimeout bounds a single health check
+const checkTimeout = 10 * time.Second
+
+var (
+	// ErrNotFound is returned for unknown endpoint names
+	ErrNotFound = errors.New("endpoint not found")
+	// ErrExists is returned when adding a name that is already taken
+	ErrExists = errors.New("endpoint already exists")
+	// ErrReadOnly is returned when changing an endpoint defined in config
+	ErrReadOnly = errors.New("endpoint is defined in config and cannot be changed through the API")
+	// ErrCredentials is returned for API endpoints naming credential files
+	// outside the configured credentials directory
+	ErrCredentials = errors.New("credential files must be inside the configured credentials directory")
+)
+
+// namePattern restricts endpoint names to a single URL path element
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// TLSOptions are the client certificate settings of a tcp:// endpoint
+type TLSOptions struct {
+	CA                 string `json:"ca,omitempty"`
+	Cert               string `json:"cert,omitempty"`
+	Key                string `json:"key,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// Endpoint is a Docker host
+type Endpoint struct {
+	Name            string            `json:"name"`
+	Host            string            `json:"host"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	TLS             *TLSOptions       `json:"tls,omitempty"`
+	SSHIdentityFile string            `json:"sshIdentityFile,omitempty"`
+	Source          string            `json:"source"`
+}
+
+// Health is the result of the last health check
+type Health struct {
+	Healthy    bool      `json:"healthy"`
+	CheckedAt  time.Time `json:"checkedAt,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	APIVersion string    `json:"apiVersion,omitempty"`
+	OSType     string    `json:"osType,omitempty"`
+}
+
+// Status is an endpoint with its health
+type Status struct {
+	Endpoint
+	Health Health `json:"health"`
+}
+
+// UnavailableError is returned for endpoints whose last health check failed
+type UnavailableError struct {
+	Name   string
+	Reason string
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("endpoint %s is unavailable: %s", e.Name, e.Reason)
+}
+
+type entry struct {
+	endpoint Endpoint
+	client   *client.Client
+	health   Health
+}
+
+// Manager holds the endpoints and their pooled clients
+type Manager struct {
+	file     string
+	interval time.Duration
+	// credentials is the directory API endpoints take credential files from
+	credentials string
+	// local is the shared client used by endpoints without a host
+	local *client.Client
+
+	mu       sync.RWMutex
+	entries  map[string]*entry
+	onRemove []func(*client.Client)
+}
+
+// NewManager creates a manager for the endpoints in cfg plus those persisted
+// through the API. Endpoints without a host reuse local.
+func NewManager(cfg config.Config, local *client.Client) (*Manager, error) {
+	interval := time.Duration(cfg.Endpoints.HealthInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	m := &Manager{
+		file:        cfg.Endpoints.File,
+		interval:    interval,
+		credentials: cfg.Endpoints.CredentialsDir,
+		local:       local,
+		entries:     make(map[string]*entry),
+	}
+
+	hosts := cfg.Endpoints.Hosts
+	if len(hosts) == 0 {
+		hosts = []config.EndpointConfig{{Name: DefaultName}}
+	}
+	for _, host := range hosts {
+		endpoint := Endpoint{
+			Name:            host.Name,
+			Host:            host.Host,
+			Labels:          host.Labels,
+			SSHIdentityFile: host.SSHIdentityFile,
+			Source:          SourceConfig,
+		}
+		if host.TLS.CA != "" || host.TLS.Cert != "" || host.TLS.InsecureSkipVerify {
+			endpoint.TLS = &TLSOptions{
+				CA:                 host.TLS.CA,
+				Cert:               host.TLS.Cert,
+				Key:                host.TLS.Key,
+				InsecureSkipVerify: host.TLS.InsecureSkipVerify,
+			}
+		}
+		if err := m.add(endpoint); err != nil {
+			return nil, fmt.Errorf("invalid endpoint %q: %w", host.Name, err)
+		}
+	}
+
+	stored, err := m.readFile()
+	if err != nil {
+		return nil, err
+	}
+	for _, endpoint := range stored {
+		endpoint.Source = SourceAPI
+		if err := m.add(endpoint); err != nil {
+			log.Printf("Skipping stored endpoint %s: %v", endpoint.Name, err)
+		}
+	}
+	return m, nil
+}
+
+// List returns every endpoint, sorted by name. When selector is not empty,
+// only endpoints carrying all of its labels are returned.
+func (m *Manager) List(selector map[string]string) []Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	statuses := make([]Status, 0, len(m.entries))
+	for _, e := range m.entries {
+		if matchesLabels(e.endpoint.Labels, selector) {
+			statuses = append(statuses, Status{Endpoint: e.endpoint, Health: e.health})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Get returns one endpoint
+func (m *Manager) Get(name string) (Status, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.entries[name]
+	if !ok {
+		return Status{}, ErrNotFound
+	}
+	return Status{Endpoint: e.endpoint, Health: e.health}, nil
+}
+
+// Add registers an endpoint through the API and persists it
+func (m *Manager) Add(endpoint Endpoint) error {
+	endpoint.Source = SourceAPI
+	if err := m.add(endpoint); err != nil {
+		return err
+	}
+	if err := m.writeFile(); err != nil {
+		m.mu.Lock()
+		delete(m.entries, endpoint.Name)
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Remove deletes an API-managed endpoint and closes its client. The
+// endpoint is kept when the file cannot be written.
+func (m *Manager) Remove(name string) error {
+	m.mu.Lock()
+	e, ok := m.entries[name]
+	switch {
+	case !ok:
+		m.mu.Unlock()
+		return ErrNotFound
+	case e.endpoint.Source == SourceConfig:
+		m.mu.Unlock()
+		return ErrReadOnly
+	}
+	delete(m.entries, name)
+	hooks := m.onRemove
+	m.mu.Unlock()
+
+	if err := m.writeFile(); err != nil {
+		m.mu.Lock()
+		if _, ok := m.entries[name]; !ok {
+			m.entries[name] = e
+		}
+		m.mu.Unlock()
+		return err
+	}
+
+	// The shared local client outlives any endpoint using it
+	if e.client != nil && e.client != m.local {
+		for _, hook := range hooks {
+			hook(e.client)
+		}
+		e.client.Close()
+	}
+	return nil
+}
+
+// OnRemove registers hook to release what was built on the client of an
+// endpoint when the endpoint is removed
+func (m *Manager) OnRemove(hook func(*client.Client)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onRemove = append(m.onRemove, hook)
+}
+
+// Client returns the pooled client of an endpoint. Endpoints that failed
+// their last health check are refused until a check succeeds again.
+func (m *Manager) Client(name string) (*client.Client, error) {
+	m.mu.RLock()
+	e, ok := m.entries[name]
+	if !ok {
+		m.mu.RUnlock()
+		return nil, ErrNotFound
+	}
+	cli, health := e.client, e.health
+	m.mu.RUnlock()
+
+	if !health.CheckedAt.IsZero() && !health.Healthy {
+		return nil, &UnavailableError{Name: name, Reason: health.Error}
+	}
+	if cli != nil {
+		return cli, nil
+	}
+	return m.connect(name)
+}
+
+// Check pings an endpoint and records the result
+func (m *Manager) Check(ctx context.Context, name string) (Health, error) {
+	cli, err := m.connect(name)
+	if errors.Is(err, ErrNotFound) {
+		return Health{}, err
+	}
+
+	health := Health{CheckedAt: time.Now().UTC()}
+	if err != nil {
+		health.Error = err.Error()
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		ping, err := cli.Ping(ctx)
+		cancel()
+		if err != nil {
+			health.Error = err.Error()
+		} else {
+			health.Healthy = true
+			health.APIVersion = ping.APIVersion
+			health.OSType = ping.OSType
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[name]
+	if !ok {
+		return Health{}, ErrNotFound
+	}
+	if e.health.Healthy != health.Healthy && !e.health.CheckedAt.IsZero() {
+		if health.Healthy {
+			log.Printf("Endpoint %s is healthy again", name)
+		} else {
+			log.Printf("Endpoint %s is unhealthy: %s", name, health.Error)
+		}
+	}
+	e.health = health
+	return health, nil
+}
+
+// Monitor health-checks every endpoint until ctx is cancelled
+func (m *Manager) Monitor(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) checkAll(ctx context.Context) {
+	m.mu.RLock()
+	names := make([]string, 0, len(m.entries))
+	for name := range m.entries {
+		names = append(names, name)
+	}
+	m.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			m.Check(ctx, name)
+		}(name)
+	}
+	wg.Wait()
+}
+
+// connect returns the endpoint's client, creating it on first use
+func (m *Manager) connect(name string) (*client.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if e.client == nil && e.endpoint.Host == "" && m.local != nil {
+		e.client = m.local
+	}
+	if e.client == nil {
+		cli, err := newClient(e.endpoint)
+		if err != nil {
+			return nil, err
+		}
+		e.client = cli
+	}
+	return e.client, nil
+}
+
+func (m *Manager) add(endpoint Endpoint) error {
+	if !namePattern.MatchString(endpoint.Name) {
+		return fmt.Errorf("invalid endpoint name %q", endpoint.Name)
+	}
+	if err := validateHost(endpoint); err != nil {
+		return err
+	}
+	// Only config may point at arbitrary files on the server
+	if endpoint.Source == SourceAPI {
+		if err := m.confineCredentials(&endpoint); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[endpoint.Name]; ok {
+		return ErrExists
+	}
+	m.entries[endpoint.Name] = &entry{endpoint: endpoint}
+	return nil
+}
+
+// validateHost checks the host URL without connecting
+func validateHost(endpoint Endpoint) error {
+	if endpoint.Host == "" {
+		if endpoint.Source == SourceAPI {
+			return errors.New("host is required")
+		}
+		return nil
+	}
+	scheme, _, ok := strings.Cut(endpoint.Host, "://")
+	if !ok {
+		return fmt.Errorf("invalid host %q", endpoint.Host)
+	}
+	switch scheme {
+	case "unix", "npipe", "tcp":
+		if _, err := client.ParseHostURL(endpoint.Host); err != nil {
+			return fmt.Errorf("invalid host %q: %w", endpoint.Host, err)
+		}
+	case "ssh":
+		if _, err := sshDialer(endpoint); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported host scheme %q", scheme)
+	}
+	if endpoint.TLS != nil && scheme != "tcp" {
+		return errors.New("TLS settings only apply to tcp:// hosts")
+	}
+	return nil
+}
+
+// confineCredentials resolves the credential files of endpoint inside the
+// credentials directory, failing with ErrCredentials for any outside it
+func (m *Manager) confineCredentials(endpoint *Endpoint) error {
+	paths := []*string{&endpoint.SSHIdentityFile}
+	if endpoint.TLS != nil {
+		tls := *endpoint.TLS
+		endpoint.TLS = &tls
+		paths = append(paths, &endpoint.TLS.CA, &endpoint.TLS.Cert, &endpoint.TLS.Key)
+	}
+	for _, path := range paths {
+		if *path == "" {
+			continue
+		}
+		if m.credentials == "" {
+			return ErrCredentials
+		}
+		resolved, err := fsutil.Within(m.credentials, *path)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCredentials, err)
+		}
+		*path = resolved
+	}
+	return nil
+}
+
+func matchesLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if actual, ok := labels[key]; !ok || (value != "" && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// readFile loads the endpoints persisted through the API
+func (m *Manager) readFile() ([]Endpoint, error) {
+	if m.file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(m.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read endpoints file: %w", err)
+	}
+	var endpoints []Endpoint
+	if err := json.Unmarshal(data, &endpoints); err != nil {
+		return nil, fmt.Errorf("failed to decode endpoints file: %w", err)
+	}
+	return endpoints, nil
+}
+
+// writeFile persists the API-managed endpoints
+func (m *Manager) writeFile() error {
+	if m.file == "" {
+		return nil
+	}
+
+	m.mu.RLock()
+	endpoints := []Endpoint{}
+	for _, e := range m.entries {
+		if e.endpoint.Source == SourceAPI {
+			endpoints = append(endpoints, e.endpoint)
+		}
+	}
+	m.mu.RUnlock()
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Name < endpoints[j].Name })
+
+	data, err := json.MarshalIndent(endpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(m.file, data); err != nil {
+		return fmt.Errorf("failed to store endpoints: %w", err)
+	}
+	return nil
+}
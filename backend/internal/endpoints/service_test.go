@@ -0,0 +1,188 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+func TestRemoveKeepsEndpointWhenWriteFails(t *testing.T) {
+	dir := t.TempDir()
+	var cfg config.Config
+	cfg.Endpoints.File = filepath.Join(dir, "endpoints.json")
+	m, err := NewManager(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if err := m.Add(Endpoint{Name: "edge", Host: "tcp://10.0.0.5:2376"}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := m.Remove(DefaultName); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Remove of a configured endpoint = %v, want ErrReadOnly", err)
+	}
+
+	// A file in place of the directory makes every write fail
+	m.file = filepath.Join(cfg.Endpoints.File, "endpoints.json")
+	if err := m.Remove("edge"); err == nil {
+		t.Fatal("Remove succeeded without storing the change")
+	}
+	if _, err := m.Get("edge"); err != nil {
+		t.Errorf("Get after a failed Remove = %v, want the endpoint kept", err)
+	}
+
+	m.file = cfg.Endpoints.File
+	if err := m.Remove("edge"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := m.Get("edge"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Remove = %v, want ErrNotFound", err)
+	}
+	data, err := os.ReadFile(cfg.Endpoints.File)
+	if err != nil || strings.Contains(string(data), "edge") {
+		t.Errorf("stored endpoints = %s, %v", data, err)
+	}
+}
+
+func TestSSHDialerFollowsContext(t *testing.T) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh is not installed")
+	}
+	dial, err := sshDialer(Endpoint{Name: "edge", Host: "ssh://deploy@10.0.0.5:2222"})
+	if err != nil {
+		t.Fatalf("sshDialer: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if conn, err := dial(ctx, "tcp", "docker.example.com:80"); !errors.Is(err, context.Canceled) {
+		if conn != nil {
+			conn.Close()
+		}
+		t.Errorf("dial with a cancelled context = %v, want it not to start ssh", err)
+	}
+}
+
+func TestAddKeepsNothingWhenWriteFails(t *testing.T) {
+	dir := t.TempDir()
+	var cfg config.Config
+	cfg.Endpoints.File = filepath.Join(dir, "endpoints.json")
+	m, err := NewManager(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	m.file = filepath.Join(dir, "missing", "endpoints.json")
+	if err := os.WriteFile(filepath.Join(dir, "missing"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(Endpoint{Name: "edge", Host: "tcp://10.0.0.5:2376"}); err == nil {
+		t.Fatal("Add succeeded without storing the endpoint")
+	}
+	if _, err := m.Get("edge"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after a failed Add = %v, want the endpoint dropped", err)
+	}
+
+	m.file = cfg.Endpoints.File
+	if err := m.Add(Endpoint{Name: "edge", Host: "tcp://10.0.0.5:2376"}); err != nil {
+		t.Fatalf("Add after a failed Add: %v", err)
+	}
+	if err := m.Add(Endpoint{Name: "edge", Host: "tcp://10.0.0.6:2376"}); !errors.Is(err, ErrExists) {
+		t.Errorf("Add of a taken name = %v, want ErrExists", err)
+	}
+
+	// A new manager reads back what was stored
+	m, err = NewManager(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if status, err := m.Get("edge"); err != nil || status.Source != SourceAPI || status.Host != "tcp://10.0.0.5:2376" {
+		t.Errorf("stored endpoint = %+v, %v", status, err)
+	}
+}
+
+func TestAddConfinesCredentials(t *testing.T) {
+	dir := t.TempDir()
+	credentials := filepath.Join(dir, "credentials")
+	for _, name := range []string{filepath.Join(credentials, "edge", "ca.pem"), filepath.Join(credentials, "id_ed25519"), filepath.Join(dir, "id_rsa")} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte("key"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var cfg config.Config
+	cfg.Endpoints.CredentialsDir = credentials
+	m, err := NewManager(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		endpoint Endpoint
+		ok       bool
+	}{
+		{"tls inside", Endpoint{Host: "tcp://10.0.0.5:2376", TLS: &TLSOptions{CA: "edge/ca.pem"}}, true},
+		{"ssh inside", Endpoint{Host: "ssh://deploy@10.0.0.5", SSHIdentityFile: filepath.Join(credentials, "id_ed25519")}, true},
+		{"no credentials", Endpoint{Host: "tcp://10.0.0.5:2375"}, true},
+		{"ssh outside", Endpoint{Host: "ssh://deploy@10.0.0.5", SSHIdentityFile: filepath.Join(dir, "id_rsa")}, false},
+		{"tls escaping", Endpoint{Host: "tcp://10.0.0.5:2376", TLS: &TLSOptions{CA: "edge/ca.pem", Key: "../id_rsa"}}, false},
+		{"system file", Endpoint{Host: "tcp://10.0.0.5:2376", TLS: &TLSOptions{Cert: "/etc/passwd"}}, false},
+	}
+	for i, tt := range tests {
+		tt.endpoint.Name = fmt.Sprintf("edge%d", i)
+		err := m.Add(tt.endpoint)
+		if tt.ok != (err == nil) || (err != nil && !errors.Is(err, ErrCredentials)) {
+			t.Errorf("%s: Add = %v", tt.name, err)
+		}
+	}
+	if status, err := m.Get("edge0"); err != nil || status.TLS.CA != filepath.Join(credentials, "edge", "ca.pem") {
+		t.Errorf("stored TLS = %+v, %v, want the resolved path", status.TLS, err)
+	}
+
+	// Without a credentials directory API endpoints cannot name files, while
+	// config still can
+	cfg.Endpoints.CredentialsDir = ""
+	cfg.Endpoints.Hosts = []config.EndpointConfig{{Name: "configured", Host: "ssh://deploy@10.0.0.5", SSHIdentityFile: filepath.Join(dir, "id_rsa")}}
+	if m, err = NewManager(cfg, nil); err != nil {
+		t.Fatalf("NewManager with a configured identity file: %v", err)
+	}
+	err = m.Add(Endpoint{Name: "edge", Host: "ssh://deploy@10.0.0.5", SSHIdentityFile: filepath.Join(credentials, "id_ed25519")})
+	if !errors.Is(err, ErrCredentials) {
+		t.Errorf("Add without a credentials directory = %v, want ErrCredentials", err)
+	}
+}
+
+func TestSSHArgs(t *testing.T) {
+	tests := []struct {
+		endpoint Endpoint
+		host     string
+		args     string
+	}{
+		{Endpoint{Host: "ssh://10.0.0.5"}, "10.0.0.5", "-o BatchMode=yes -o ConnectTimeout=30 -- 10.0.0.5 docker system dial-stdio"},
+		{Endpoint{Host: "ssh://deploy@docker.example.com:2222/", SSHIdentityFile: "/keys/id"}, "docker.example.com",
+			"-o BatchMode=yes -o ConnectTimeout=30 -l deploy -p 2222 -i /keys/id -- docker.example.com docker system dial-stdio"},
+		// A user that looks like an option is still the value of -l
+		{Endpoint{Host: "ssh://-oProxyCommand=x@10.0.0.5"}, "10.0.0.5", "-o BatchMode=yes -o ConnectTimeout=30 -l -oProxyCommand=x -- 10.0.0.5 docker system dial-stdio"},
+		{Endpoint{Host: "ssh://[fd00::5]:22"}, "fd00::5", "-o BatchMode=yes -o ConnectTimeout=30 -p 22 -- fd00::5 docker system dial-stdio"},
+	}
+	for _, tt := range tests {
+		host, args, err := sshArgs(tt.endpoint)
+		if err != nil || host != tt.host || strings.Join(args, " ") != tt.args {
+			t.Errorf("sshArgs(%s) = %s %q, %v, want %s %q", tt.endpoint.Host, host, args, err, tt.host, tt.args)
+		}
+	}
+
+	for _, host := range []string{"ssh://", "ssh://deploy@", "ssh://10.0.0.5/var/run/docker.sock", "ssh://10.0.0.5:port"} {
+		if _, _, err := sshArgs(Endpoint{Host: host}); err == nil {
+			t.Errorf("sshArgs(%s) succeeded", host)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package events
+
+import (
+	"strings"
+)
+
+// Filter selects events. Empty fields match everything; values within a
+// field are alternatives and fields must all match.
+type Filter struct {
+	// Types are event types such as container, image, network or volume
+	Types []string
+	// Actions match an action exactly or its prefix before ":", so
+	// health_status matches "health_status: healthy"
+	Actions []string
+	// Labels are key or key=value matches on the actor attributes, which
+	// carry the labels of containers
+	Labels []string
+}
+
+// Matches reports whether event passes the filter
+func (f Filter) Matches(event Event) bool {
+	if len(f.Types) > 0 && !contains(f.Types, event.Type) {
+		return false
+	}
+	if len(f.Actions) > 0 {
+		action, _, _ := strings.Cut(event.Action, ":")
+		if !contains(f.Actions, event.Action) && !contains(f.Actions, action) {
+			return false
+		}
+	}
+	for _, label := range f.Labels {
+		key, value, hasValue := strings.Cut(label, "=")
+		actual, ok := event.Attributes[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
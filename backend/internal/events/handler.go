@@ -0,0 +1,245 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/genc-murat/harborview/internal/httputil"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// heartbeatInterval keeps idle streams open through proxies and notices
+// clients that went away
+const heartbeatInterval = 15 * time.Second
+
+// RegisterRoutes registers all routes for events of the shared client,
+// whose event subscription runs until ctx is cancelled
+func RegisterRoutes(ctx context.Context, app *fiber.App, cfg config.Config, cli *client.Client) {
+	hub := Shared(ctx, cfg, cli)
+
+	MountRoutes(app.Group("/events"), func(*fiber.Ctx) (EventService, error) {
+		return hub, nil
+	})
+}
+
+// MountRoutes registers the event routes on eventsGroup. lookup picks the
+// service each request runs against; a *fiber.Error from it sets the status.
+func MountRoutes(eventsGroup fiber.Router, lookup func(c *fiber.Ctx) (EventService, error)) {
+	handle := httputil.Bind(lookup)
+
+	// Query recent events, filtered by ?type=, ?action=, ?label=, ?after=,
+	// ?since=, ?until= and ?limit=
+	eventsGroup.Get("/", handle(ListEvents))
+
+	// Get the state of the daemon subscription
+	eventsGroup.Get("/status", handle(EventStatus))
+
+	// Stream events as server-sent events
+	eventsGroup.Get("/stream", handle(StreamEvents))
+
+	// Stream events over a WebSocket
+	eventsGroup.Get("/ws", handle(WebSocketEvents))
+}
+
+// queryFilter builds a filter from the repeatable ?type=, ?action= and
+// ?label= parameters
+func queryFilter(c *fiber.Ctx) Filter {
+	values := func(key string) []string {
+		var result []string
+		for _, value := range c.Context().QueryArgs().PeekMulti(key) {
+			if v := strings.TrimSpace(string(value)); v != "" {
+				result = append(result, v)
+			}
+		}
+		return result
+	}
+	return Filter{Types: values("type"), Actions: values("action"), Labels: values("label")}
+}
+
+// parseTime accepts RFC 3339 or Unix seconds, like docker events --since
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or Unix seconds", value)
+	}
+	return t, nil
+}
+
+// resumeCursor is the cursor a stream resumes after: the Last-Event-ID
+// header browsers send when reconnecting, or ?after=
+func resumeCursor(c *fiber.Ctx) (Cursor, error) {
+	cursor := c.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = c.Query("after")
+	}
+	if cursor == "" {
+		return Cursor{}, nil
+	}
+	return ParseCursor(cursor)
+}
+
+// ListEvents handler for querying the recorded events
+func ListEvents(c *fiber.Ctx, service EventService) error {
+	after, err := resumeCursor(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	since, err := parseTime(c.Query("since"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	until, err := parseTime(c.Query("until"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	events := service.History(queryFilter(c), Query{
+		After: after,
+		Since: since,
+		Until: until,
+		Limit: c.QueryInt("limit", 0),
+	})
+	return c.JSON(events)
+}
+
+// EventStatus handler for the daemon subscription state
+func EventStatus(c *fiber.Ctx, service EventService) error {
+	return c.JSON(service.Status())
+}
+
+// StreamEvents handler for server-sent events. Each event carries its
+// cursor as the SSE id, so a reconnecting EventSource resumes where it left
+// off, after a "gap" event if some were lost.
+func StreamEvents(c *fiber.Ctx, service EventService) error {
+	after, err := resumeCursor(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	sub := service.Subscribe(queryFilter(c), after)
+	shutdown := c.Context().Done()
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer sub.Close()
+
+		send := func(event Event) error {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Cursor(), event.Type, data)
+			return w.Flush()
+		}
+		// Tell the client the stream is open even when nothing happens
+		fmt.Fprint(w, ": connected\n\n")
+		if sub.Gap != nil {
+			data, _ := json.Marshal(sub.Gap)
+			fmt.Fprintf(w, "event: gap\ndata: %s\n\n", data)
+		}
+		if w.Flush() != nil {
+			return
+		}
+		for _, event := range sub.Backlog {
+			if send(event) != nil {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case event, ok := <-sub.C:
+				if !ok || send(event) != nil {
+					return
+				}
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+				if w.Flush() != nil {
+					return
+				}
+			case <-shutdown:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// WebSocketEvents handler for streaming events as JSON text messages over
+// a WebSocket. ?after= replays the recorded events after that cursor
+// first, preceded by a "gap" message if some were lost.
+func WebSocketEvents(c *fiber.Ctx, service EventService) error {
+	after, err := resumeCursor(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !isWebSocketUpgrade(c) {
+		c.Set(fiber.HeaderUpgrade, "websocket")
+		return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{"error": "Expected a WebSocket upgrade request"})
+	}
+	filter := queryFilter(c)
+	shutdown := c.Context().Done()
+
+	return upgradeWebSocket(c, func(ws *wsConn) {
+		sub := service.Subscribe(filter, after)
+		defer sub.Close()
+
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			ws.ReadLoop()
+		}()
+
+		if sub.Gap != nil && ws.WriteJSON(sub.Gap) != nil {
+			return
+		}
+		for _, event := range sub.Backlog {
+			if ws.WriteJSON(event) != nil {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case event, ok := <-sub.C:
+				if !ok {
+					// Fell behind or the hub stopped; the client resumes
+					// with ?after=
+					ws.WriteClose(1013)
+					return
+				}
+				if ws.WriteJSON(event) != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if ws.Ping() != nil {
+					return
+				}
+			case <-closed:
+				return
+			case <-shutdown:
+				ws.WriteClose(1001)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// serveHub serves the event routes of hub on a local listener
+func serveHub(t *testing.T, hub *Hub) (app *fiber.App, addr string) {
+	t.Helper()
+	app = fiber.New(fiber.Config{DisableStartupMessage: true})
+	MountRoutes(app.Group("/events"), func(*fiber.Ctx) (EventService, error) {
+		return hub, nil
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+	t.Cleanup(func() { app.Shutdown() })
+	return app, listener.Addr().String()
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var message map[string]any
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatalf("message %s: %v", data, err)
+	}
+	return message
+}
+
+func TestWebSocketEvents(t *testing.T) {
+	hub := testHub(10, 3)
+	_, addr := serveHub(t, hub)
+	epoch := hub.Status().Epoch
+
+	dialer := websocket.Dialer{WriteBufferSize: 256}
+	conn, resp, err := dialer.Dial("ws://"+addr+"/events/ws?after="+epoch+":1", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d", resp.StatusCode)
+	}
+	for _, want := range []float64{2, 3} {
+		if event := readEvent(t, conn); event["seq"] != want || event["epoch"] != epoch {
+			t.Fatalf("backlog event = %v, want seq %v", event, want)
+		}
+	}
+
+	// A message fragmented over several frames is read and discarded
+	w, err := conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(strings.Repeat("x", 1000)))
+	if err := w.Close(); err != nil {
+		t.Fatalf("fragmented write: %v", err)
+	}
+	pong := make(chan string, 1)
+	conn.SetPongHandler(func(data string) error {
+		pong <- data
+		return nil
+	})
+	if err := conn.WriteControl(websocket.PingMessage, []byte("hi"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pongs are handled while reading the next message
+	next := make(chan []byte, 1)
+	go func() {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, _ := conn.ReadMessage()
+		next <- data
+	}()
+	select {
+	case data := <-pong:
+		if data != "hi" {
+			t.Errorf("pong = %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ping was not answered")
+	}
+	publishTest(hub, 4)
+	var event Event
+	if data := <-next; json.Unmarshal(data, &event) != nil || event.Seq != 4 {
+		t.Errorf("live event = %s", data)
+	}
+
+	// Messages over the cap close the connection
+	if err := conn.WriteMessage(websocket.TextMessage, make([]byte, maxClientMessage+1)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("read after an oversized message = %v, want close 1009", err)
+	}
+}
+
+func TestWebSocketEventsGap(t *testing.T) {
+	hub := testHub(10, 2)
+	_, addr := serveHub(t, hub)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/events/ws?after=earlier:7", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if gap := readEvent(t, conn); gap["type"] != "gap" || gap["reason"] != "restarted" || gap["after"] != "earlier:7" {
+		t.Errorf("first message = %v, want a gap", gap)
+	}
+	if event := readEvent(t, conn); event["seq"] != float64(1) {
+		t.Errorf("first event = %v, want the whole history", event)
+	}
+
+	if err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("close handshake = %v, want the close echoed", err)
+			}
+			break
+		}
+	}
+}
+
+func TestWebSocketEventsHandshake(t *testing.T) {
+	hub := testHub(10, 0)
+	app, _ := serveHub(t, hub)
+
+	tests := []struct {
+		name    string
+		header  map[string]string
+		url     string
+		status  int
+		message string
+	}{
+		{"plain request", nil, "/events/ws", fiber.StatusUpgradeRequired, "Expected a WebSocket upgrade request"},
+		{"bad cursor", nil, "/events/ws?after=x:y", fiber.StatusBadRequest, "invalid event cursor"},
+		{
+			"old version",
+			map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ==", "Sec-WebSocket-Version": "8"},
+			"/events/ws",
+			fiber.StatusBadRequest,
+			"unsupported version",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body map[string]string
+			json.NewDecoder(resp.Body).Decode(&body)
+			if resp.StatusCode != tt.status || !strings.Contains(body["error"], tt.message) {
+				t.Errorf("response = %d %v, want %d %q", resp.StatusCode, body, tt.status, tt.message)
+			}
+		})
+	}
+}
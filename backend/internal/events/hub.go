@@ -0,0 +1,495 @@
+// Package events follows the Docker event stream. One subscription per
+// daemon feeds a bounded history and any number of streaming clients.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// DefaultBufferSize is the number of events kept when config sets none
+const DefaultBufferSize = 1000
+
+const (
+	// subscriberBuffer is how many events a client may fall behind before
+	// it is disconnected; it can resume from the history
+	subscriberBuffer = 256
+	// maxBackoff bounds the wait between reconnects to the daemon
+	maxBackoff = 30 * time.Second
+)
+
+// EventService interface for dependency injection
+type EventService interface {
+	History(filter Filter, query Query) []Event
+	Subscribe(filter Filter, after Cursor) *Subscription
+	Status() Status
+}
+
+// Event is a daemon event. Seq orders the events of one hub and, with the
+// hub's Epoch, is the cursor clients resume from.
+type Event struct {
+	Seq        uint64            `json:"seq"`
+	Epoch      string            `json:"epoch"`
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	ActorID    string            `json:"actorId"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Scope      string            `json:"scope,omitempty"`
+	Time       time.Time         `json:"time"`
+}
+
+// Cursor is the position just after the event
+func (e Event) Cursor() Cursor {
+	return Cursor{Epoch: e.Epoch, Seq: e.Seq}
+}
+
+// Cursor is where a client resumes: after the event with Seq, numbered by
+// the hub with Epoch. Seq restarts at 1 whenever a hub is created, so a
+// cursor from another epoch says nothing about the current events.
+type Cursor struct {
+	Epoch string
+	Seq   uint64
+}
+
+// String formats the cursor as "<epoch>:<seq>"
+func (c Cursor) String() string {
+	if c.Epoch == "" {
+		return strconv.FormatUint(c.Seq, 10)
+	}
+	return c.Epoch + ":" + strconv.FormatUint(c.Seq, 10)
+}
+
+// ParseCursor reads "<epoch>:<seq>", or a bare Seq without an epoch
+func ParseCursor(value string) (Cursor, error) {
+	epoch, seq, ok := strings.Cut(value, ":")
+	if !ok {
+		epoch, seq = "", value
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid event cursor %q", value)
+	}
+	return Cursor{Epoch: epoch, Seq: n}, nil
+}
+
+// Gap is sent ahead of the backlog when events after a client's cursor
+// are lost: the hub was recreated, or they dropped out of the history.
+// Type is always "gap", which no daemon event has.
+type Gap struct {
+	Type string `json:"type"`
+	// Reason is "restarted" or "expired"
+	Reason string `json:"reason"`
+	After  string `json:"after"`
+	Epoch  string `json:"epoch"`
+	// OldestSeq is the first event still recorded, if any
+	OldestSeq uint64 `json:"oldestSeq,omitempty"`
+}
+
+// Query narrows a history lookup. Zero values do not restrict.
+type Query struct {
+	// After returns only events with a greater Seq, or every event when
+	// the cursor is from another epoch
+	After Cursor
+	Since time.Time
+	Until time.Time
+	// Limit keeps the most recent matches
+	Limit int
+}
+
+// Status describes the daemon subscription and the history
+type Status struct {
+	Epoch       string     `json:"epoch"`
+	Connected   bool       `json:"connected"`
+	LastError   string     `json:"lastError,omitempty"`
+	Reconnects  int        `json:"reconnects"`
+	LastEvent   *time.Time `json:"lastEvent,omitempty"`
+	Buffered    int        `json:"buffered"`
+	BufferSize  int        `json:"bufferSize"`
+	OldestSeq   uint64     `json:"oldestSeq,omitempty"`
+	LatestSeq   uint64     `json:"latestSeq"`
+	Subscribers int        `json:"subscribers"`
+}
+
+// errStreamClosed is the daemon's event stream ending without an error
+var errStreamClosed = errors.New("event stream closed")
+
+// EventClient is the part of the Docker client the hub needs
+type EventClient interface {
+	Ping(ctx context.Context) (types.Ping, error)
+	Events(ctx context.Context, options dockerevents.ListOptions) (<-chan dockerevents.Message, <-chan error)
+}
+
+// Hub holds the event subscription of one daemon
+type Hub struct {
+	cli EventClient
+	// epoch tells the hub's Seq numbering apart from earlier hubs'
+	epoch string
+
+	mu          sync.Mutex
+	ring        []Event
+	start       int
+	count       int
+	seq         uint64
+	subscribers map[*Subscription]struct{}
+	connected   bool
+	lastErr     string
+	reconnects  int
+	// lastNano and lastKeys are the resume cursor: the time of the newest
+	// event and the events seen at that time, which the daemon sends again
+	lastNano int64
+	lastKeys map[string]bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var (
+	hubsMu sync.Mutex
+	hubs   = map[EventClient]*Hub{}
+)
+
+// Shared returns the hub of cli, starting it on first use, so every route
+// serving a daemon shares a single event subscription. The hub runs until
+// ctx is cancelled or it is closed.
+func Shared(ctx context.Context, cfg config.Config, cli *client.Client) *Hub {
+	hubsMu.Lock()
+	defer hubsMu.Unlock()
+	hub, ok := hubs[cli]
+	if !ok {
+		hub = newHub(cfg, cli)
+		hubs[cli] = hub
+		go hub.run(ctx)
+	}
+	return hub
+}
+
+// Release stops the hub of cli, if any, once the client is discarded
+func Release(cli *client.Client) {
+	hubsMu.Lock()
+	hub, ok := hubs[cli]
+	hubsMu.Unlock()
+	if ok {
+		hub.Close()
+	}
+}
+
+func newHub(cfg config.Config, cli EventClient) *Hub {
+	size := cfg.Events.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &Hub{
+		cli:         cli,
+		epoch:       newEpoch(),
+		ring:        make([]Event, size),
+		subscribers: make(map[*Subscription]struct{}),
+		lastKeys:    make(map[string]bool),
+		done:        make(chan struct{}),
+	}
+}
+
+// newEpoch returns a random hub epoch
+func newEpoch() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Close stops the subscription and disconnects the hub's clients
+func (h *Hub) Close() error {
+	h.closeOnce.Do(func() {
+		close(h.done)
+		hubsMu.Lock()
+		if hubs[h.cli] == h {
+			delete(hubs, h.cli)
+		}
+		hubsMu.Unlock()
+	})
+	return nil
+}
+
+// run follows the daemon's events, reconnecting with backoff. Reconnects
+// resume from the last event seen, so events during a daemon restart are
+// not lost as long as the daemon still has them.
+func (h *Hub) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-h.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	defer h.disconnectAll()
+
+	backoff := time.Second
+	for {
+		err := h.watch(ctx, func() { backoff = time.Second })
+		if ctx.Err() != nil {
+			return
+		}
+
+		h.mu.Lock()
+		h.connected = false
+		h.lastErr = err.Error()
+		h.reconnects++
+		h.mu.Unlock()
+		log.Printf("Docker event stream interrupted, reconnecting in %s: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// watch subscribes once and publishes events until the stream fails or
+// ends. connected is called once the daemon answers.
+func (h *Hub) watch(ctx context.Context, connected func()) error {
+	if _, err := h.cli.Ping(ctx); err != nil {
+		return err
+	}
+
+	options := dockerevents.ListOptions{}
+	h.mu.Lock()
+	if h.lastNano > 0 {
+		options.Since = fmt.Sprintf("%d.%09d", h.lastNano/int64(time.Second), h.lastNano%int64(time.Second))
+	}
+	h.connected = true
+	h.lastErr = ""
+	h.mu.Unlock()
+	connected()
+
+	messages, errs := h.cli.Events(ctx, options)
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return errStreamClosed
+			}
+			h.publish(message)
+		case err, ok := <-errs:
+			if !ok || err == nil {
+				return errStreamClosed
+			}
+			return err
+		}
+	}
+}
+
+// publish records a daemon event and hands it to the matching subscribers
+func (h *Hub) publish(message dockerevents.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Resuming with since repeats the events at the cursor time
+	key := string(message.Type) + "|" + string(message.Action) + "|" + message.Actor.ID
+	switch {
+	case message.TimeNano < h.lastNano:
+		return
+	case message.TimeNano == h.lastNano:
+		if h.lastKeys[key] {
+			return
+		}
+	default:
+		h.lastNano = message.TimeNano
+		clear(h.lastKeys)
+	}
+	h.lastKeys[key] = true
+
+	h.seq++
+	event := Event{
+		Seq:        h.seq,
+		Epoch:      h.epoch,
+		Type:       string(message.Type),
+		Action:     string(message.Action),
+		ActorID:    message.Actor.ID,
+		Attributes: message.Actor.Attributes,
+		Scope:      message.Scope,
+		Time:       time.Unix(0, message.TimeNano).UTC(),
+	}
+	if h.count < len(h.ring) {
+		h.ring[(h.start+h.count)%len(h.ring)] = event
+		h.count++
+	} else {
+		h.ring[h.start] = event
+		h.start = (h.start + 1) % len(h.ring)
+	}
+
+	for sub := range h.subscribers {
+		if !sub.filter.Matches(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			// A client that cannot keep up is dropped rather than slowing
+			// everyone down; it resumes from its last Seq
+			delete(h.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// buffered returns the history, oldest first. h.mu must be held.
+func (h *Hub) buffered() []Event {
+	events := make([]Event, 0, h.count)
+	for i := 0; i < h.count; i++ {
+		events = append(events, h.ring[(h.start+i)%len(h.ring)])
+	}
+	return events
+}
+
+// History returns the recorded events matching filter and query, oldest
+// first
+func (h *Hub) History(filter Filter, query Query) []Event {
+	h.mu.Lock()
+	buffered := h.buffered()
+	after, _ := h.resume(query.After)
+	h.mu.Unlock()
+
+	events := []Event{}
+	for _, event := range buffered {
+		if event.Seq <= after || !filter.Matches(event) {
+			continue
+		}
+		if !query.Since.IsZero() && event.Time.Before(query.Since) {
+			continue
+		}
+		if !query.Until.IsZero() && event.Time.After(query.Until) {
+			continue
+		}
+		events = append(events, event)
+	}
+	if query.Limit > 0 && len(events) > query.Limit {
+		events = events[len(events)-query.Limit:]
+	}
+	return events
+}
+
+// Subscription receives the events matching its filter. C is closed when
+// the client falls too far behind or the hub stops.
+type Subscription struct {
+	C <-chan Event
+	// Backlog holds the recorded events after the requested cursor, to
+	// send before C
+	Backlog []Event
+	// Gap is set when events after the cursor are lost, to send before the
+	// backlog
+	Gap *Gap
+
+	ch     chan Event
+	filter Filter
+	hub    *Hub
+}
+
+// Subscribe starts delivering events matching filter. With a cursor the
+// recorded events after it are returned as the backlog, with no gap or
+// overlap between the backlog and C.
+func (h *Hub) Subscribe(filter Filter, after Cursor) *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, filter: filter, hub: h, Backlog: []Event{}}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if after != (Cursor{}) {
+		var seq uint64
+		seq, sub.Gap = h.resume(after)
+		for _, event := range h.buffered() {
+			if event.Seq > seq && filter.Matches(event) {
+				sub.Backlog = append(sub.Backlog, event)
+			}
+		}
+	}
+	select {
+	case <-h.done:
+		close(ch)
+	default:
+		h.subscribers[sub] = struct{}{}
+	}
+	return sub
+}
+
+// resume returns the Seq of this hub to resume after, and the gap when
+// events after the cursor are lost. h.mu must be held.
+func (h *Hub) resume(after Cursor) (uint64, *Gap) {
+	if after == (Cursor{}) {
+		return 0, nil
+	}
+	gap := &Gap{Type: "gap", After: after.String(), Epoch: h.epoch}
+	oldest := h.seq + 1
+	if h.count > 0 {
+		oldest = h.ring[h.start].Seq
+		gap.OldestSeq = oldest
+	}
+	// A cursor without an epoch from past the newest Seq can only be from
+	// an earlier hub
+	if (after.Epoch != "" && after.Epoch != h.epoch) || after.Seq > h.seq {
+		gap.Reason = "restarted"
+		return 0, gap
+	}
+	if after.Seq+1 < oldest {
+		gap.Reason = "expired"
+		return after.Seq, gap
+	}
+	return after.Seq, nil
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subscribers[s]; ok {
+		delete(s.hub.subscribers, s)
+		close(s.ch)
+	}
+}
+
+// disconnectAll closes every subscription once the hub stops
+func (h *Hub) disconnectAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.ch)
+	}
+	h.connected = false
+}
+
+// Status reports the subscription state
+func (h *Hub) Status() Status {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := Status{
+		Epoch:       h.epoch,
+		Connected:   h.connected,
+		LastError:   h.lastErr,
+		Reconnects:  h.reconnects,
+		Buffered:    h.count,
+		BufferSize:  len(h.ring),
+		LatestSeq:   h.seq,
+		Subscribers: len(h.subscribers),
+	}
+	if h.count > 0 {
+		status.OldestSeq = h.ring[h.start].Seq
+		last := h.ring[(h.start+h.count-1)%len(h.ring)].Time
+		status.LastEvent = &last
+	}
+	return status
+}
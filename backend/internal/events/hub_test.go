@@ -0,0 +1,203 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	dockerevents "github.com/docker/docker/api/types/events"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// testHub returns a hub with a history of size holding n container events
+func testHub(size, n int) *Hub {
+	var cfg config.Config
+	cfg.Events.BufferSize = size
+	hub := newHub(cfg, nil)
+	for i := 1; i <= n; i++ {
+		publishTest(hub, i)
+	}
+	return hub
+}
+
+func publishTest(hub *Hub, i int) {
+	hub.publish(dockerevents.Message{
+		Type:     dockerevents.ContainerEventType,
+		Action:   dockerevents.ActionStart,
+		Actor:    dockerevents.Actor{ID: "c1"},
+		TimeNano: time.Date(2024, 5, 1, 12, 0, i, 0, time.UTC).UnixNano(),
+	})
+}
+
+func seqs(events []Event) []uint64 {
+	result := []uint64{}
+	for _, event := range events {
+		result = append(result, event.Seq)
+	}
+	return result
+}
+
+func TestSubscribeResume(t *testing.T) {
+	hub := testHub(3, 5)
+	epoch := hub.Status().Epoch
+
+	tests := []struct {
+		name    string
+		after   Cursor
+		backlog []uint64
+		gap     string
+	}{
+		{"live only", Cursor{}, []uint64{}, ""},
+		{"within history", Cursor{Epoch: epoch, Seq: 3}, []uint64{4, 5}, ""},
+		{"oldest kept", Cursor{Epoch: epoch, Seq: 2}, []uint64{3, 4, 5}, ""},
+		{"up to date", Cursor{Epoch: epoch, Seq: 5}, []uint64{}, ""},
+		{"older than history", Cursor{Epoch: epoch, Seq: 1}, []uint64{3, 4, 5}, "expired"},
+		{"earlier hub", Cursor{Epoch: "0123456789ab", Seq: 4}, []uint64{3, 4, 5}, "restarted"},
+		{"bare seq", Cursor{Seq: 4}, []uint64{5}, ""},
+		{"bare seq past the newest", Cursor{Seq: 9}, []uint64{3, 4, 5}, "restarted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := hub.Subscribe(Filter{}, tt.after)
+			defer sub.Close()
+			if got := seqs(sub.Backlog); !slices.Equal(got, tt.backlog) {
+				t.Errorf("backlog = %v, want %v", got, tt.backlog)
+			}
+			switch {
+			case tt.gap == "" && sub.Gap != nil:
+				t.Errorf("unexpected gap %+v", sub.Gap)
+			case tt.gap != "" && (sub.Gap == nil || sub.Gap.Reason != tt.gap):
+				t.Errorf("gap = %+v, want %s", sub.Gap, tt.gap)
+			case sub.Gap != nil && (sub.Gap.Type != "gap" || sub.Gap.Epoch != epoch || sub.Gap.OldestSeq != 3):
+				t.Errorf("gap = %+v", sub.Gap)
+			}
+		})
+	}
+
+	sub := hub.Subscribe(Filter{}, Cursor{Epoch: epoch, Seq: 5})
+	defer sub.Close()
+	publishTest(hub, 6)
+	if event := <-sub.C; event.Seq != 6 || event.Epoch != epoch {
+		t.Errorf("live event = %+v", event)
+	}
+}
+
+func TestHistoryAcrossEpochs(t *testing.T) {
+	hub := testHub(10, 4)
+	epoch := hub.Status().Epoch
+
+	if got := seqs(hub.History(Filter{}, Query{After: Cursor{Epoch: epoch, Seq: 2}})); !slices.Equal(got, []uint64{3, 4}) {
+		t.Errorf("history after 2 = %v", got)
+	}
+	if got := seqs(hub.History(Filter{}, Query{After: Cursor{Epoch: "earlier", Seq: 2}})); !slices.Equal(got, []uint64{1, 2, 3, 4}) {
+		t.Errorf("history after a cursor of an earlier hub = %v, want every event", got)
+	}
+	if other := testHub(10, 0); other.Status().Epoch == epoch {
+		t.Error("two hubs share an epoch")
+	}
+}
+
+// fakeEventClient serves the streams of streams, one per Events call; later
+// calls get a stream that stays open
+type fakeEventClient struct {
+	mu      sync.Mutex
+	streams []func() (chan dockerevents.Message, chan error)
+}
+
+func (f *fakeEventClient) Ping(ctx context.Context) (types.Ping, error) {
+	return types.Ping{APIVersion: "1.47"}, nil
+}
+
+func (f *fakeEventClient) Events(ctx context.Context, options dockerevents.ListOptions) (<-chan dockerevents.Message, <-chan error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.streams) == 0 {
+		return make(chan dockerevents.Message), make(chan error)
+	}
+	stream := f.streams[0]
+	f.streams = f.streams[1:]
+	return stream()
+}
+
+// closedMessages ends the stream without an error, as the client does
+// when the daemon's response body ends
+func closedMessages() (chan dockerevents.Message, chan error) {
+	messages := make(chan dockerevents.Message)
+	close(messages)
+	return messages, make(chan error)
+}
+
+func closedErrors() (chan dockerevents.Message, chan error) {
+	errs := make(chan error)
+	close(errs)
+	return make(chan dockerevents.Message), errs
+}
+
+func oneEvent() (chan dockerevents.Message, chan error) {
+	messages := make(chan dockerevents.Message, 1)
+	messages <- dockerevents.Message{Type: dockerevents.ContainerEventType, Action: dockerevents.ActionStart, Actor: dockerevents.Actor{ID: "c1"}, TimeNano: time.Now().UnixNano()}
+	return messages, make(chan error)
+}
+
+func TestWatchClosedStream(t *testing.T) {
+	for name, stream := range map[string]func() (chan dockerevents.Message, chan error){"messages": closedMessages, "errors": closedErrors} {
+		hub := newHub(config.Config{}, &fakeEventClient{streams: []func() (chan dockerevents.Message, chan error){stream}})
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err := hub.watch(ctx, func() {})
+		if !errors.Is(err, errStreamClosed) || ctx.Err() != nil {
+			t.Errorf("watch with closed %s = %v, want it to end with the stream", name, err)
+		}
+		if status := hub.Status(); status.LatestSeq != 0 {
+			t.Errorf("closed %s published %d events", name, status.LatestSeq)
+		}
+		cancel()
+	}
+
+	// The hub subscribes again and carries on
+	cli := &fakeEventClient{streams: []func() (chan dockerevents.Message, chan error){closedMessages, oneEvent}}
+	hub := newHub(config.Config{}, cli)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.run(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := hub.Status()
+		if status.LatestSeq == 1 {
+			if status.Reconnects != 1 || !status.Connected {
+				t.Errorf("status = %+v, want one reconnect", status)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no event after the stream closed: %+v", status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	tests := map[string]Cursor{
+		"42":          {Seq: 42},
+		"a1b2c3:42":   {Epoch: "a1b2c3", Seq: 42},
+		"a1b2c3:0":    {Epoch: "a1b2c3"},
+		"0123abcd:18": {Epoch: "0123abcd", Seq: 18},
+	}
+	for value, want := range tests {
+		got, err := ParseCursor(value)
+		if err != nil || got != want {
+			t.Errorf("ParseCursor(%q) = %+v, %v, want %+v", value, got, err, want)
+		}
+		if got.String() != value {
+			t.Errorf("%+v.String() = %q, want %q", got, got.String(), value)
+		}
+	}
+	for _, value := range []string{"", "abc", "a1b2:", "a1b2:-1"} {
+		if _, err := ParseCursor(value); err == nil {
+			t.Errorf("ParseCursor(%q) succeeded", value)
+		}
+	}
+}
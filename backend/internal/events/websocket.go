@@ -0,0 +1,98 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// The WebSocket side pushes events as text messages. Messages from the
+// client are read and discarded, which keeps control frames answered.
+
+const (
+	// maxClientMessage bounds the messages read from the client; larger
+	// ones close the connection with 1009
+	maxClientMessage = 4096
+	writeTimeout     = 10 * time.Second
+)
+
+var upgrader = websocket.FastHTTPUpgrader{
+	HandshakeTimeout: writeTimeout,
+	// The API allows every origin, see the CORS middleware
+	CheckOrigin: func(*fasthttp.RequestCtx) bool { return true },
+	Error: func(ctx *fasthttp.RequestCtx, status int, reason error) {
+		body, _ := json.Marshal(fiber.Map{"error": reason.Error()})
+		ctx.Response.Header.Set("Sec-WebSocket-Version", "13")
+		ctx.SetStatusCode(status)
+		ctx.SetContentType(fiber.MIMEApplicationJSON)
+		ctx.SetBody(body)
+	},
+}
+
+// isWebSocketUpgrade reports whether the request asks for a WebSocket
+func isWebSocketUpgrade(c *fiber.Ctx) bool {
+	return websocket.FastHTTPIsWebSocketUpgrade(c.Context())
+}
+
+// upgradeWebSocket answers the handshake and hands the connection to serve
+// once the response is sent. The fiber context must not be used by serve.
+func upgradeWebSocket(c *fiber.Ctx, serve func(*wsConn)) error {
+	// A failed handshake has its error response written by the upgrader
+	upgrader.Upgrade(c.Context(), func(conn *websocket.Conn) {
+		defer conn.Close()
+		conn.SetReadLimit(maxClientMessage)
+		serve(&wsConn{conn: conn})
+	})
+	return nil
+}
+
+// wsConn is a server-side WebSocket connection. Messages are written from
+// one goroutine and read from another; control frames may come from both.
+type wsConn struct {
+	conn *websocket.Conn
+}
+
+// WriteText sends a text message
+func (ws *wsConn) WriteText(data []byte) error {
+	if err := ws.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	return ws.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// WriteJSON sends value as a text message
+func (ws *wsConn) WriteJSON(value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return ws.WriteText(data)
+}
+
+// Ping sends a ping, which the client answers to keep the connection alive
+func (ws *wsConn) Ping() error {
+	return ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
+
+// WriteClose starts the closing handshake with a status code
+func (ws *wsConn) WriteClose(code int) error {
+	return ws.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(writeTimeout))
+}
+
+// ReadLoop reads messages until the client closes the connection or it
+// fails. Pings are answered and close frames echoed while reading.
+func (ws *wsConn) ReadLoop() error {
+	for {
+		_, r, err := ws.conn.NextReader()
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "state", "store.json")
+
+	for _, content := range []string{`{"v":1}`, `{"v":2}`} {
+		if err := WriteFileAtomic(name, []byte(content)); err != nil {
+			t.Fatalf("WriteFileAtomic: %v", err)
+		}
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != content {
+			t.Fatalf("content = %q, %v, want %q", data, err, content)
+		}
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("mode = %o, want 600", perm)
+	}
+	entries, err := os.ReadDir(filepath.Dir(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want no temporary files left", len(entries))
+	}
+}
+
+func TestWriteFileAtomicFailure(t *testing.T) {
+	dir := t.TempDir()
+	// A directory in the way cannot be replaced by a file
+	name := filepath.Join(dir, "taken")
+	if err := os.Mkdir(name, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(name, "keep"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(name, []byte("data")); err == nil {
+		t.Fatal("WriteFileAtomic replaced a directory")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want the temporary file removed", len(entries))
+	}
+}
+
+func TestWithin(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "credentials")
+	outside := filepath.Join(dir, "outside.pem")
+	for _, name := range []string{filepath.Join(root, "edge", "key.pem"), outside} {
+		if err := WriteFileAtomic(name, []byte("key")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := filepath.EvalSymlinks(filepath.Join(root, "edge", "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{filepath.Join(root, "edge", "key.pem"), true},
+		{"edge/key.pem", true},
+		{filepath.Join(root, "edge", "..", "edge", "key.pem"), true},
+		{outside, false},
+		{"../outside.pem", false},
+		{"link.pem", false},
+		{root, false},
+		{"missing.pem", false},
+	}
+	for _, tt := range tests {
+		resolved, err := Within(root, tt.name)
+		if tt.ok != (err == nil) {
+			t.Errorf("Within(%s) = %s, %v", tt.name, resolved, err)
+		}
+		if tt.ok && resolved != want {
+			t.Errorf("Within(%s) = %s", tt.name, resolved)
+		}
+	}
+}
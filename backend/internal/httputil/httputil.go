@@ -0,0 +1,38 @@
+// Package httputil holds the request handling shared by the API packages:
+// binding handlers to the service of each request and answering errors.
+package httputil
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Status returns the status of a *fiber.Error in err's chain, or 500
+func Status(err error) int {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// Error answers a request with err as {"error": ...}, under Status(err)
+func Error(c *fiber.Ctx, err error) error {
+	return c.Status(Status(err)).JSON(fiber.Map{"error": err.Error()})
+}
+
+// Bind returns a wrapper that runs handlers against the service lookup
+// picks for each request. A lookup error is answered with Error, so a
+// *fiber.Error from it sets the status.
+func Bind[S any](lookup func(c *fiber.Ctx) (S, error)) func(handler func(*fiber.Ctx, S) error) fiber.Handler {
+	return func(handler func(*fiber.Ctx, S) error) fiber.Handler {
+		return func(c *fiber.Ctx) error {
+			service, err := lookup(c)
+			if err != nil {
+				return Error(c, err)
+			}
+			return handler(c, service)
+		}
+	}
+}
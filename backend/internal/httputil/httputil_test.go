@@ -0,0 +1,55 @@
+package httputil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBind(t *testing.T) {
+	app := fiber.New()
+	handle := Bind(func(c *fiber.Ctx) (string, error) {
+		switch c.Query("endpoint") {
+		case "":
+			return "local", nil
+		case "missing":
+			return "", fmt.Errorf("lookup: %w", fiber.NewError(fiber.StatusNotFound, "endpoint missing not found"))
+		default:
+			return "", errors.New("daemon unreachable")
+		}
+	})
+	app.Get("/", handle(func(c *fiber.Ctx, service string) error {
+		return c.SendString(service)
+	}))
+
+	tests := []struct {
+		url    string
+		status int
+		error  string
+	}{
+		{"/", fiber.StatusOK, ""},
+		{"/?endpoint=missing", fiber.StatusNotFound, "lookup: endpoint missing not found"},
+		{"/?endpoint=down", fiber.StatusInternalServerError, "daemon unreachable"},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", tt.url, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.url, resp.StatusCode, tt.status)
+		}
+		if tt.error == "" {
+			continue
+		}
+		var body map[string]string
+		json.NewDecoder(resp.Body).Decode(&body)
+		if body["error"] != tt.error {
+			t.Errorf("GET %s error = %q, want %q", tt.url, body["error"], tt.error)
+		}
+	}
+}
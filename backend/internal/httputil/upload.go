@@ -0,0 +1,95 @@
+package httputil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrMissingFile is returned by Upload for a multipart form without a "file"
+// field
+var ErrMissingFile = errors.New("missing file upload")
+
+// Upload returns the file sent either as the "file" field of a multipart
+// form or as the raw request body, or nil when the body is empty. Bodies are
+// streamed, so Fiber does not apply its BodyLimit to them: reading past it
+// fails with fiber.ErrRequestEntityTooLarge instead.
+func Upload(c *fiber.Ctx) (io.ReadCloser, error) {
+	var body io.Reader
+	if stream := c.Context().RequestBodyStream(); stream != nil {
+		body = &limitedReader{r: stream, remaining: int64(c.App().Config().BodyLimit), exceeded: c.Context().SetConnectionClose}
+	} else if len(c.Body()) > 0 {
+		body = bytes.NewReader(c.Body())
+	}
+
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		if body == nil || c.Request().Header.ContentLength() == 0 {
+			return nil, nil
+		}
+		return upload{body, body}, nil
+	}
+	// The form is read part by part rather than with c.FormFile, which would
+	// spool the whole of it to disk first
+	boundary := string(c.Request().Header.MultipartFormBoundary())
+	if body == nil || boundary == "" {
+		return nil, ErrMissingFile
+	}
+	form := multipart.NewReader(body, boundary)
+	for {
+		part, err := form.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, ErrMissingFile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return upload{part, body}, nil
+		}
+		part.Close()
+	}
+}
+
+// upload reads a file out of a request body, and reads the rest of the body
+// when closed so the connection can take the next request
+type upload struct {
+	io.Reader
+	body io.Reader
+}
+
+func (u upload) Close() error {
+	_, err := io.Copy(io.Discard, u.body)
+	return err
+}
+
+// limitedReader fails with fiber.ErrRequestEntityTooLarge once r goes on
+// past remaining bytes. The rest of the body is left unread, so exceeded
+// closes the connection.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  func()
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Only a body that goes on past the limit is too large
+		var one [1]byte
+		n, err := l.r.Read(one[:])
+		if n > 0 {
+			l.exceeded()
+			return 0, fiber.ErrRequestEntityTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
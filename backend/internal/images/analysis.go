@@ -0,0 +1,188 @@
+package images
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/genc-murat/harborview/internal/docker"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// LayerAnalysis reports what each layer of an image changes and how many
+// bytes are spent on files that later layers overwrite or delete.
+type LayerAnalysis struct {
+	Image          string          `json:"image"`
+	TotalSize      int64           `json:"totalSize"`
+	WastedBytes    int64           `json:"wastedBytes"`
+	Efficiency     float64         `json:"efficiency"`
+	Layers         []LayerReport   `json:"layers"`
+	LargestFiles   []FileInfo      `json:"largestFiles"`
+	DuplicateFiles []DuplicateFile `json:"duplicateFiles"`
+}
+
+// LayerReport lists the files a layer adds, modifies and deletes. The path
+// lists are capped at the requested limit; the counts are always complete.
+// WastedBytes counts bytes stored in this layer that a later layer
+// overwrites or deletes.
+type LayerReport struct {
+	Index         int      `json:"index"`
+	DiffID        string   `json:"diffID,omitempty"`
+	CreatedBy     string   `json:"createdBy,omitempty"`
+	Size          int64    `json:"size"`
+	AddedCount    int      `json:"addedCount"`
+	ModifiedCount int      `json:"modifiedCount"`
+	DeletedCount  int      `json:"deletedCount"`
+	Added         []string `json:"added"`
+	Modified      []string `json:"modified"`
+	Deleted       []string `json:"deleted"`
+	WastedBytes   int64    `json:"wastedBytes"`
+}
+
+// FileInfo is a file version stored in a layer
+type FileInfo struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Layer int    `json:"layer"`
+}
+
+// DuplicateFile is a path stored by more than one layer. WastedBytes counts
+// every copy that is not visible in the final filesystem.
+type DuplicateFile struct {
+	Path        string `json:"path"`
+	Count       int    `json:"count"`
+	TotalSize   int64  `json:"totalSize"`
+	WastedBytes int64  `json:"wastedBytes"`
+	Layers      []int  `json:"layers"`
+}
+
+// AnalyzeImage exports imageName and walks every layer tar. limit caps the
+// per-layer path lists and the largest/duplicate file lists.
+func (s *imageService) AnalyzeImage(ctx context.Context, imageName string, limit int) (*LayerAnalysis, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpScan)
+	defer cancel()
+
+	archive, err := s.exportLayers(ctx, imageName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := analyzeLayers(archive.Layers, limit)
+	analysis.Image = imageName
+	annotateLayers(analysis.Layers, archive.Config)
+	return analysis, nil
+}
+
+// analyzeLayers replays the layers in order against a model of the final
+// filesystem to classify every change and account for wasted bytes.
+func analyzeLayers(layers []*layerContents, limit int) *LayerAnalysis {
+	analysis := &LayerAnalysis{Layers: make([]LayerReport, len(layers))}
+	current := make(map[string]FileInfo)
+	versions := make(map[string][]FileInfo)
+	var allFiles []FileInfo
+
+	// remove drops path and everything below it from the current filesystem,
+	// charging the removed bytes to the layer that stored them
+	remove := func(report *LayerReport, target string, includeSelf bool) {
+		prefix := strings.TrimSuffix(target, "/") + "/"
+		for p, file := range current {
+			if (includeSelf && p == target) || strings.HasPrefix(p, prefix) {
+				delete(current, p)
+				report.DeletedCount++
+				appendCapped(&report.Deleted, p, limit)
+				analysis.Layers[file.Layer].WastedBytes += file.Size
+			}
+		}
+	}
+
+	for i, layer := range layers {
+		report := &analysis.Layers[i]
+		report.Index = i
+		report.Added, report.Modified, report.Deleted = []string{}, []string{}, []string{}
+
+		for _, dir := range layer.opaque {
+			remove(report, dir, false)
+		}
+		for _, whiteout := range layer.whiteouts {
+			remove(report, whiteout, true)
+		}
+		for _, file := range layer.files {
+			file.Layer = i
+			report.Size += file.Size
+			if previous, ok := current[file.Path]; ok {
+				report.ModifiedCount++
+				appendCapped(&report.Modified, file.Path, limit)
+				analysis.Layers[previous.Layer].WastedBytes += previous.Size
+			} else {
+				report.AddedCount++
+				appendCapped(&report.Added, file.Path, limit)
+			}
+			current[file.Path] = file
+			versions[file.Path] = append(versions[file.Path], file)
+			allFiles = append(allFiles, file)
+		}
+		analysis.TotalSize += report.Size
+	}
+
+	for _, layer := range analysis.Layers {
+		analysis.WastedBytes += layer.WastedBytes
+	}
+	if analysis.TotalSize > 0 {
+		analysis.Efficiency = 1 - float64(analysis.WastedBytes)/float64(analysis.TotalSize)
+	} else {
+		analysis.Efficiency = 1
+	}
+
+	sort.Slice(allFiles, func(a, b int) bool { return allFiles[a].Size > allFiles[b].Size })
+	analysis.LargestFiles = allFiles[:min(limit, len(allFiles))]
+
+	analysis.DuplicateFiles = []DuplicateFile{}
+	for p, stored := range versions {
+		if len(stored) < 2 {
+			continue
+		}
+		duplicate := DuplicateFile{Path: p, Count: len(stored)}
+		for _, file := range stored {
+			duplicate.TotalSize += file.Size
+			duplicate.Layers = append(duplicate.Layers, file.Layer)
+		}
+		duplicate.WastedBytes = duplicate.TotalSize
+		if final, ok := current[p]; ok {
+			duplicate.WastedBytes -= final.Size
+		}
+		analysis.DuplicateFiles = append(analysis.DuplicateFiles, duplicate)
+	}
+	sort.Slice(analysis.DuplicateFiles, func(a, b int) bool {
+		return analysis.DuplicateFiles[a].WastedBytes > analysis.DuplicateFiles[b].WastedBytes
+	})
+	analysis.DuplicateFiles = analysis.DuplicateFiles[:min(limit, len(analysis.DuplicateFiles))]
+	return analysis
+}
+
+// annotateLayers copies diff IDs and the Dockerfile step that created each
+// layer from the image config. History entries marked empty_layer (ENV,
+// CMD, ...) have no layer and are skipped.
+func annotateLayers(reports []LayerReport, config ocispec.Image) {
+	for i := range reports {
+		if i < len(config.RootFS.DiffIDs) {
+			reports[i].DiffID = config.RootFS.DiffIDs[i].String()
+		}
+	}
+	layer := 0
+	for _, history := range config.History {
+		if history.EmptyLayer {
+			continue
+		}
+		if layer >= len(reports) {
+			break
+		}
+		reports[layer].CreatedBy = history.CreatedBy
+		layer++
+	}
+}
+
+func appendCapped(list *[]string, item string, limit int) {
+	if len(*list) < limit {
+		*list = append(*list, item)
+	}
+}
@@ -0,0 +1,77 @@
+package images
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported compression formats for saved image tarballs
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// compressionExtension returns the file extension for a compression format
+func compressionExtension(compression string) (string, error) {
+	switch compression {
+	case CompressionNone:
+		return ".tar", nil
+	case CompressionGzip:
+		return ".tar.gz", nil
+	case CompressionZstd:
+		return ".tar.zst", nil
+	default:
+		return "", fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// compressWriter wraps w so that everything written is compressed with the
+// given format. Closing the returned writer flushes it but does not close w.
+func compressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// compressStream returns a reader producing the compressed form of src. The
+// compression runs in a goroutine and src is closed once it is drained or the
+// returned reader is closed.
+func compressStream(src io.ReadCloser, compression string) (io.ReadCloser, error) {
+	if compression == CompressionNone {
+		return src, nil
+	}
+
+	pr, pw := io.Pipe()
+	cw, err := compressWriter(pw, compression)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer src.Close()
+		_, err := io.Copy(cw, src)
+		if closeErr := cw.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
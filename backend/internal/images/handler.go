@@ -1,75 +1,104 @@
 package images
 
 import (
+	"errors"
+	"io"
 	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/httputil"
+	"github.com/genc-murat/harborview/internal/signatures"
 	"github.com/genc-murat/harborview/pkg/config"
 	"github.com/gofiber/fiber/v2"
 )
 
-// RegisterRoutes registers all routes for images
-func RegisterRoutes(app *fiber.App, cfg config.Config) {
+// RegisterRoutes registers all routes for images on the shared client
+func RegisterRoutes(app *fiber.App, cfg config.Config, cli *client.Client) error {
 	// Initialize the service with the provided config
-	service, _ := NewImageService()
+	service, err := NewImageService(cfg, cli)
+	if err != nil {
+		return err
+	}
+
+	MountRoutes(app.Group("/images"), func(*fiber.Ctx) (ImageService, error) {
+		return service, nil
+	})
+	return nil
+}
 
-	imagesGroup := app.Group("/images")
+// MountRoutes registers the image routes on imagesGroup. lookup picks the
+// service each request runs against; a *fiber.Error from it sets the status.
+func MountRoutes(imagesGroup fiber.Router, lookup func(c *fiber.Ctx) (ImageService, error)) {
+	handle := httputil.Bind(lookup)
 
 	// Existing routes
-	imagesGroup.Get("/", func(c *fiber.Ctx) error {
-		return GetImages(c, service)
-	})
+	imagesGroup.Get("/", handle(GetImages))
 
-	imagesGroup.Get("/:name/tags", func(c *fiber.Ctx) error {
-		return GetTags(c, service)
-	})
+	// Repository names contain slashes, so /tags takes them as ?image=
+	imagesGroup.Get("/tags", handle(GetTags))
 
-	imagesGroup.Delete("/:name", func(c *fiber.Ctx) error {
-		return RemoveImage(c, service)
-	})
+	imagesGroup.Get("/:name/tags", handle(GetTags))
 
-	imagesGroup.Get("/search", func(c *fiber.Ctx) error {
-		return SearchImages(c, service)
-	})
+	// The tag routes take ?image= too, and come before /:name so they are
+	// not taken for image names
+	imagesGroup.Post("/tag", handle(TagImage))
 
-	imagesGroup.Get("/:name/history", func(c *fiber.Ctx) error {
-		return GetImageHistory(c, service)
-	})
+	imagesGroup.Delete("/tag", handle(UntagImage))
 
-	imagesGroup.Delete("/unused", func(c *fiber.Ctx) error {
-		return DeleteUnusedImages(c, service)
-	})
+	imagesGroup.Post("/retag", handle(RetagImage))
+
+	imagesGroup.Delete("/:name", handle(RemoveImage))
+
+	imagesGroup.Post("/:name/tag", handle(TagImage))
+
+	imagesGroup.Delete("/:name/tag", handle(UntagImage))
+
+	imagesGroup.Post("/:name/retag", handle(RetagImage))
+
+	imagesGroup.Get("/search", handle(SearchImages))
+
+	imagesGroup.Get("/:name/history", handle(GetImageHistory))
+
+	imagesGroup.Get("/:name/analysis", handle(AnalyzeImage))
+
+	imagesGroup.Get("/:name/packages", handle(GetInventory))
+
+	imagesGroup.Get("/:name/vulnerabilities", handle(GetVulnerabilities))
+
+	imagesGroup.Post("/:name/vulnerabilities", handle(ScanImage))
+
+	imagesGroup.Get("/:name/sbom", handle(GetSBOM))
+
+	imagesGroup.Get("/:name/signatures", handle(VerifyImage))
+
+	imagesGroup.Delete("/unused", handle(DeleteUnusedImages))
 
 	// New routes
-	imagesGroup.Post("/build", func(c *fiber.Ctx) error {
-		return BuildImage(c, service)
-	})
+	imagesGroup.Post("/build", handle(BuildImage))
 
-	imagesGroup.Post("/pull", func(c *fiber.Ctx) error {
-		return PullImage(c, service)
-	})
+	imagesGroup.Post("/pull", handle(PullImage))
 
-	imagesGroup.Post("/push/:name", func(c *fiber.Ctx) error {
-		return PushImage(c, service)
-	})
+	imagesGroup.Post("/push/:name", handle(PushImage))
 
-	imagesGroup.Post("/save", func(c *fiber.Ctx) error {
-		return SaveImage(c, service)
-	})
+	imagesGroup.Post("/save", handle(SaveImage))
 
-	imagesGroup.Post("/prune", func(c *fiber.Ctx) error {
-		return PruneImages(c, service)
-	})
+	imagesGroup.Post("/export", handle(ExportImage))
 
-	imagesGroup.Get("/:name/inspect", func(c *fiber.Ctx) error {
-		return InspectImage(c, service)
-	})
+	imagesGroup.Post("/load", handle(LoadImage))
+
+	imagesGroup.Post("/import", handle(ImportImage))
+
+	imagesGroup.Post("/prune", handle(PruneImages))
+
+	imagesGroup.Get("/:name/inspect", handle(InspectImage))
 }
 
 // GetImages handler for fetching all images
 func GetImages(c *fiber.Ctx, service ImageService) error {
-	images, err := service.GetImages()
+	images, err := service.GetImages(c.UserContext())
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
@@ -78,10 +107,18 @@ func GetImages(c *fiber.Ctx, service ImageService) error {
 	return c.JSON(images)
 }
 
-// GetTags handler for fetching tags for an image
+// GetTags handler for listing the registry tags of an image's repository,
+// named by ?image= or, for names without a slash, the path
 func GetTags(c *fiber.Ctx, service ImageService) error {
-	imageName := c.Params("name")
-	tags, err := service.GetTags(imageName)
+	imageName := c.Query("image", c.Params("name"))
+	if imageName == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "image is required"})
+	}
+	n := c.QueryInt("n", 25) // Each tag costs registry requests, so keep pages small
+	tags, err := service.GetTags(c.UserContext(), imageName, n, c.Query("last"))
+	if errdefs.IsInvalidParameter(err) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
@@ -93,19 +130,93 @@ func GetTags(c *fiber.Ctx, service ImageService) error {
 // RemoveImage handler for deleting an image
 func RemoveImage(c *fiber.Ctx, service ImageService) error {
 	imageName := c.Params("name")
-	err := service.RemoveImage(imageName)
+	force := c.QueryBool("force", false) // Default: do not force
+	report, err := service.RemoveImage(c.UserContext(), imageName, force)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
-	return c.JSON(fiber.Map{"message": "Image removed successfully"})
+	return c.JSON(report)
+}
+
+// tagRequest is the body accepted by the tag and retag handlers
+type tagRequest struct {
+	Repo string `json:"repo"`
+	Tag  string `json:"tag"`
+}
+
+// target returns the repo:tag reference, defaulting the tag to latest
+func (r tagRequest) target() string {
+	if r.Tag == "" {
+		return r.Repo + ":latest"
+	}
+	return r.Repo + ":" + r.Tag
+}
+
+// TagImage handler for adding a tag to an existing image
+func TagImage(c *fiber.Ctx, service ImageService) error {
+	imageName := c.Query("image", c.Params("name"))
+	if imageName == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "image is required"})
+	}
+	var request tagRequest
+	if err := c.BodyParser(&request); err != nil || request.Repo == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := service.TagImage(c.UserContext(), imageName, request.target()); err != nil {
+		return tagError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Image tagged successfully", "target": request.target()})
+}
+
+// UntagImage handler for removing a single tag from an image
+func UntagImage(c *fiber.Ctx, service ImageService) error {
+	imageName := c.Query("image", c.Params("name"))
+	if imageName == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "image is required"})
+	}
+	report, err := service.UntagImage(c.UserContext(), imageName)
+	if err != nil {
+		return tagError(c, err)
+	}
+	return c.JSON(report)
+}
+
+// RetagImage handler for moving a tag to a new repo:tag
+func RetagImage(c *fiber.Ctx, service ImageService) error {
+	imageName := c.Query("image", c.Params("name"))
+	if imageName == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "image is required"})
+	}
+	var request tagRequest
+	if err := c.BodyParser(&request); err != nil || request.Repo == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	report, err := service.RetagImage(c.UserContext(), imageName, request.target())
+	if err != nil {
+		return tagError(c, err)
+	}
+	return c.JSON(report)
+}
+
+// tagError maps unknown images to 404, and references that are not tags or
+// do not parse to 400
+func tagError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errdefs.IsNotFound(err):
+		status = fiber.StatusNotFound
+	case errdefs.IsInvalidParameter(err):
+		status = fiber.StatusBadRequest
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
 }
 
 // SearchImages handler for searching images by a query
 func SearchImages(c *fiber.Ctx, service ImageService) error {
 	query := c.Query("q", "")
-	images, err := service.SearchImages(query)
+	images, err := service.SearchImages(c.UserContext(), query)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
@@ -117,7 +228,7 @@ func SearchImages(c *fiber.Ctx, service ImageService) error {
 // GetImageHistory handler for fetching the history of an image
 func GetImageHistory(c *fiber.Ctx, service ImageService) error {
 	imageName := c.Params("name")
-	history, err := service.GetImageHistory(imageName)
+	history, err := service.GetImageHistory(c.UserContext(), imageName)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
@@ -126,9 +237,81 @@ func GetImageHistory(c *fiber.Ctx, service ImageService) error {
 	return c.JSON(history)
 }
 
+// AnalyzeImage handler for the per-layer file and wasted-space report
+func AnalyzeImage(c *fiber.Ctx, service ImageService) error {
+	imageName := c.Params("name")
+	limit := c.QueryInt("limit", 20)
+	if limit <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limit must be positive"})
+	}
+	analysis, err := service.AnalyzeImage(c.UserContext(), imageName, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(analysis)
+}
+
+// GetInventory handler for listing the packages installed in an image
+func GetInventory(c *fiber.Ctx, service ImageService) error {
+	imageName := c.Params("name")
+	inventory, err := service.Inventory(c.UserContext(), imageName)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(inventory)
+}
+
+// GetVulnerabilities handler for the stored vulnerability report of an image
+func GetVulnerabilities(c *fiber.Ctx, service ImageService) error {
+	imageName := c.Params("name")
+	refresh := c.QueryBool("refresh", false) // Default: reuse the stored report
+	report, err := service.GetVulnerabilities(c.UserContext(), imageName, refresh)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(report)
+}
+
+// ScanImage handler for rescanning an image for vulnerabilities
+func ScanImage(c *fiber.Ctx, service ImageService) error {
+	imageName := c.Params("name")
+	report, err := service.ScanImage(c.UserContext(), imageName)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(report)
+}
+
+// GetSBOM handler for the CycloneDX or SPDX bill of materials of an image
+func GetSBOM(c *fiber.Ctx, service ImageService) error {
+	imageName := c.Params("name")
+	format := c.Query("format", SBOMCycloneDX)
+	contentType, ok := SBOMContentTypes[format]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "format must be cyclonedx or spdx"})
+	}
+	refresh := c.QueryBool("refresh", false) // Default: reuse the cached SBOM
+	document, err := service.GenerateSBOM(c.UserContext(), imageName, format, refresh)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.Send(document)
+}
+
+// VerifyImage handler for checking an image's signatures and attestations
+func VerifyImage(c *fiber.Ctx, service ImageService) error {
+	imageName := c.Params("name")
+	result, err := service.VerifyImage(c.UserContext(), imageName)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(result)
+}
+
 // DeleteUnusedImages handler for deleting unused images
 func DeleteUnusedImages(c *fiber.Ctx, service ImageService) error {
-	err := service.DeleteUnusedImages()
+	err := service.DeleteUnusedImages(c.UserContext())
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
@@ -138,8 +321,12 @@ func DeleteUnusedImages(c *fiber.Ctx, service ImageService) error {
 }
 
 func BuildImage(c *fiber.Ctx, service ImageService) error {
-	// Parse build context from the request body
-	buildContext := c.Body() // Assuming the build context is sent in the request body
+	// The build context is a tarball streamed in the request body
+	buildContext, err := uploadReader(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer buildContext.Close()
 
 	// Parse tags query parameter into a slice of strings
 	tagsQuery := c.Query("tags", "")
@@ -153,11 +340,9 @@ func BuildImage(c *fiber.Ctx, service ImageService) error {
 	}
 
 	// Call the BuildImage service method
-	imageID, err := service.BuildImage(c.Context(), strings.NewReader(string(buildContext)), options)
+	imageID, err := service.BuildImage(c.UserContext(), buildContext, options)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return httputil.Error(c, err) // 413 once the context passes the body limit
 	}
 
 	return c.JSON(fiber.Map{
@@ -168,7 +353,10 @@ func BuildImage(c *fiber.Ctx, service ImageService) error {
 func PullImage(c *fiber.Ctx, service ImageService) error {
 	imageName := c.Query("name")
 	tag := c.Query("tag", "latest")
-	err := service.PullImage(c.Context(), imageName, tag)
+	err := service.PullImage(c.UserContext(), imageName, tag)
+	if signatures.IsPolicyViolation(err) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -178,30 +366,124 @@ func PullImage(c *fiber.Ctx, service ImageService) error {
 func PushImage(c *fiber.Ctx, service ImageService) error {
 	imageName := c.Params("name")
 	options := image.PushOptions{}
-	err := service.PushImage(c.Context(), imageName, options)
+	err := service.PushImage(c.UserContext(), imageName, options)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 	return c.JSON(fiber.Map{"message": "Image pushed successfully"})
 }
 
+// SaveImage handler for downloading images as a `docker save` tarball
 func SaveImage(c *fiber.Ctx, service ImageService) error {
 	var request struct {
-		Images []string `json:"images"`
-		Output string   `json:"output"`
+		Images      []string `json:"images"`
+		Compression string   `json:"compression"`
 	}
-	if err := c.BodyParser(&request); err != nil {
+	if err := c.BodyParser(&request); err != nil || len(request.Images) == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
-	err := service.SaveImage(c.Context(), request.Images, request.Output)
+	ext, err := compressionExtension(request.Compression)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// The tarball is sent after the handler returns, which cancels
+	// UserContext, so the save runs on the context of the whole request.
+	// That ends on shutdown, and the stream is closed when the client goes.
+	stream, err := service.SaveImage(c.Context(), request.Images, request.Compression)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
-	return c.JSON(fiber.Map{"message": "Images saved successfully"})
+
+	c.Attachment(downloadName(request.Images) + ext)
+	return c.SendStream(stream)
+}
+
+// ExportImage handler for writing images into the server's export directory
+func ExportImage(c *fiber.Ctx, service ImageService) error {
+	var request struct {
+		Images      []string `json:"images"`
+		Filename    string   `json:"filename"`
+		Compression string   `json:"compression"`
+	}
+	if err := c.BodyParser(&request); err != nil || len(request.Images) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	path, err := service.ExportImage(c.UserContext(), request.Images, request.Filename, request.Compression)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "Images saved successfully", "path": path})
+}
+
+// downloadName derives an attachment file name from the first image name
+func downloadName(imageNames []string) string {
+	name := strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(imageNames[0])
+	if len(imageNames) > 1 {
+		name += "-and-more"
+	}
+	return name
+}
+
+// LoadImage handler for loading a `docker save` tarball upload
+func LoadImage(c *fiber.Ctx, service ImageService) error {
+	upload, err := uploadReader(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer upload.Close()
+
+	loaded, err := service.LoadImage(c.UserContext(), upload)
+	if err != nil {
+		return httputil.Error(c, err) // 413 once the upload passes the body limit
+	}
+	return c.JSON(fiber.Map{"loaded": loaded})
+}
+
+// ImportImage handler for importing a root filesystem tarball upload
+func ImportImage(c *fiber.Ctx, service ImageService) error {
+	ref, tag := c.Query("repo"), c.Query("tag")
+	if ref == "" && tag != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "tag requires repo"})
+	}
+	if tag != "" {
+		ref += ":" + tag
+	}
+
+	upload, err := uploadReader(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer upload.Close()
+
+	options := image.ImportOptions{
+		Message:  c.Query("message"),
+		Platform: c.Query("platform"),
+	}
+	// Each change is a Dockerfile instruction, e.g. changes=ENV+FOO=bar&changes=CMD+["/app"]
+	for _, change := range c.Context().QueryArgs().PeekMulti("changes") {
+		options.Changes = append(options.Changes, string(change))
+	}
+
+	imageID, err := service.ImportImage(c.UserContext(), upload, ref, options)
+	if err != nil {
+		return httputil.Error(c, err) // 413 once the upload passes the body limit
+	}
+	return c.JSON(fiber.Map{"imageID": imageID, "ref": ref})
+}
+
+// uploadReader returns the tarball sent either as the "file" field of a
+// multipart form or as the raw request body, capped at the body limit.
+func uploadReader(c *fiber.Ctx) (io.ReadCloser, error) {
+	upload, err := httputil.Upload(c)
+	if err == nil && upload == nil {
+		return nil, errors.New("empty request body")
+	}
+	return upload, err
 }
 
 func PruneImages(c *fiber.Ctx, service ImageService) error {
-	report, err := service.PruneImages(c.Context())
+	report, err := service.PruneImages(c.UserContext())
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -210,7 +492,7 @@ func PruneImages(c *fiber.Ctx, service ImageService) error {
 
 func InspectImage(c *fiber.Ctx, service ImageService) error {
 	imageName := c.Params("name")
-	inspect, err := service.InspectImage(imageName)
+	inspect, err := service.InspectImage(c.UserContext(), imageName)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
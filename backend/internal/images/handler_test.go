@@ -0,0 +1,157 @@
+package images
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/genc-murat/harborview/pkg/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestImportImage(t *testing.T) {
+	daemon, cli := newFakeDaemon(t)
+	service, err := NewImageService(config.Config{}, cli)
+	if err != nil {
+		t.Fatalf("NewImageService: %v", err)
+	}
+	app := fiber.New()
+	app.Post("/images/import", func(c *fiber.Ctx) error {
+		return ImportImage(c, service)
+	})
+
+	tests := []struct {
+		query  string
+		status int
+		call   string
+	}{
+		{"tag=1.0", fiber.StatusBadRequest, ""},
+		{"repo=team/app&tag=1.0", fiber.StatusOK, "repo=team%2Fapp%3A1.0"},
+		{"repo=team/app", fiber.StatusOK, "repo=team%2Fapp"},
+	}
+	for _, tt := range tests {
+		before := len(daemon.calls())
+		resp, err := app.Test(httptest.NewRequest("POST", "/images/import?"+tt.query, strings.NewReader("rootfs")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("import ?%s = %d, want %d", tt.query, resp.StatusCode, tt.status)
+		}
+		calls := daemon.calls()[before:]
+		if tt.call == "" {
+			if len(calls) != 0 {
+				t.Errorf("import ?%s called the daemon: %v", tt.query, calls)
+			}
+			continue
+		}
+		if len(calls) != 1 || !strings.Contains(calls[0], tt.call) {
+			t.Errorf("import ?%s daemon calls = %v, want %s", tt.query, calls, tt.call)
+		}
+	}
+}
+
+func TestTagRoutes(t *testing.T) {
+	daemon, cli := newFakeDaemon(t)
+	daemon.images = map[string][]string{
+		"team/app:1.0": {"team/app:1.0"},
+		"0123abcd":     {"team/app:1.0"},
+	}
+	service, err := NewImageService(config.Config{}, cli)
+	if err != nil {
+		t.Fatalf("NewImageService: %v", err)
+	}
+	app := fiber.New()
+	MountRoutes(app.Group("/images"), func(*fiber.Ctx) (ImageService, error) {
+		return service, nil
+	})
+
+	tests := []struct {
+		method, url, body string
+		status            int
+	}{
+		{"POST", "/images/tag?image=team/app:1.0", `{"repo":"team/app","tag":"2.0"}`, fiber.StatusCreated},
+		{"POST", "/images/tag", `{"repo":"team/app","tag":"2.0"}`, fiber.StatusBadRequest},
+		{"DELETE", "/images/tag?image=team/app:1.0", "", fiber.StatusOK},
+		{"DELETE", "/images/tag?image=0123abcd", "", fiber.StatusBadRequest},
+		{"DELETE", "/images/tag?image=team/other:1.0", "", fiber.StatusNotFound},
+		{"POST", "/images/retag?image=team/app:1.0", `{"repo":"team/app","tag":"2.0"}`, fiber.StatusOK},
+		{"POST", "/images/retag?image=team/app:1.0", `{"repo":"team/app","tag":"1.0"}`, fiber.StatusBadRequest},
+		{"POST", "/images/retag?image=0123abcd", `{"repo":"team/app","tag":"2.0"}`, fiber.StatusBadRequest},
+		{"POST", "/images/retag?image=team/other:1.0", `{"repo":"team/other","tag":"2.0"}`, fiber.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s %s = %d, want %d", tt.method, tt.url, tt.body, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestUploadLimit(t *testing.T) {
+	daemon, cli := newFakeDaemon(t)
+	service, err := NewImageService(config.Config{}, cli)
+	if err != nil {
+		t.Fatalf("NewImageService: %v", err)
+	}
+	// As the server is set up in main, with a small limit
+	app := fiber.New(fiber.Config{BodyLimit: 1024, StreamRequestBody: true, DisablePreParseMultipartForm: true})
+	app.Use(middleware.BodyLimit)
+	MountRoutes(app.Group("/images"), func(*fiber.Ctx) (ImageService, error) {
+		return service, nil
+	})
+
+	small, large := strings.Repeat("x", 512), strings.Repeat("x", 4096)
+	form := func(content string) (string, string) {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		part, _ := w.CreateFormFile("file", "image.tar")
+		part.Write([]byte(content))
+		w.Close()
+		return body.String(), w.FormDataContentType()
+	}
+	smallForm, smallType := form(small)
+	largeForm, largeType := form(large)
+	tests := []struct {
+		name, url, body, contentType string
+		chunked                      bool
+		status                       int
+	}{
+		{"small load", "/images/load", small, "application/x-tar", false, fiber.StatusOK},
+		{"small chunked load", "/images/load", small, "application/x-tar", true, fiber.StatusOK},
+		{"declared too large", "/images/load", large, "application/x-tar", false, fiber.StatusRequestEntityTooLarge},
+		{"chunked too large", "/images/load", large, "application/x-tar", true, fiber.StatusRequestEntityTooLarge},
+		{"chunked build too large", "/images/build", large, "application/x-tar", true, fiber.StatusRequestEntityTooLarge},
+		{"json too large", "/images/retag?image=app", `{"repo":"` + large + `"}`, "application/json", false, fiber.StatusRequestEntityTooLarge},
+		{"small form", "/images/import", smallForm, smallType, true, fiber.StatusOK},
+		{"chunked form too large", "/images/import", largeForm, largeType, true, fiber.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.url, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		if tt.chunked {
+			req.ContentLength, req.TransferEncoding = -1, []string{"chunked"}
+		}
+		before := len(daemon.calls())
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: POST %s = %d %s, want %d", tt.name, tt.url, resp.StatusCode, body, tt.status)
+		}
+		if tt.status == fiber.StatusRequestEntityTooLarge && !tt.chunked && len(daemon.calls()) != before {
+			t.Errorf("%s: a body over the limit reached the daemon", tt.name)
+		}
+	}
+}
@@ -0,0 +1,773 @@
+package images
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/genc-murat/harborview/internal/docker"
+)
+
+// Package types reported in an inventory
+const (
+	PackageDeb      = "deb"
+	PackageApk      = "apk"
+	PackageRpm      = "rpm"
+	PackageNpm      = "npm"
+	PackagePypi     = "pypi"
+	PackageGolang   = "golang"
+	PackageCargo    = "cargo"
+	PackageGem      = "gem"
+	PackageComposer = "composer"
+)
+
+// maxInventoryFile bounds the size of a package database or lockfile read
+// into memory
+const maxInventoryFile = 64 * 1024 * 1024
+
+// maxBinarySize bounds the executables read to look for Go build info
+const maxBinarySize = 256 * 1024 * 1024
+
+// elfMagic starts every Linux executable
+var elfMagic = []byte{0x7f, 'E', 'L', 'F'}
+
+// Package is an installed OS or language package found in an image
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Type    string `json:"type"`
+	// Ecosystem is the OSV ecosystem name, e.g. "Debian:12" or "npm"
+	Ecosystem string `json:"ecosystem"`
+	// Source is the source package an OS package was built from
+	Source   string   `json:"source,omitempty"`
+	Arch     string   `json:"arch,omitempty"`
+	Licenses []string `json:"licenses,omitempty"`
+	// Location is the file the package was found in
+	Location string `json:"location"`
+	PURL     string `json:"purl"`
+}
+
+// OSRelease is the distribution described by /etc/os-release
+type OSRelease struct {
+	ID         string `json:"id"`
+	VersionID  string `json:"versionID"`
+	PrettyName string `json:"prettyName,omitempty"`
+}
+
+// Inventory is every package found in an image filesystem
+type Inventory struct {
+	ImageID  string    `json:"imageID"`
+	OS       OSRelease `json:"os"`
+	Packages []Package `json:"packages"`
+	Warnings []string  `json:"warnings,omitempty"`
+}
+
+// rpmDatabases are the rpm database files. They are detected but not
+// parsed: reading them needs a SQLite or BerkeleyDB reader.
+var rpmDatabases = map[string]bool{
+	"/var/lib/rpm/rpmdb.sqlite":          true,
+	"/var/lib/rpm/Packages":              true,
+	"/var/lib/rpm/Packages.db":           true,
+	"/usr/lib/sysimage/rpm/rpmdb.sqlite": true,
+}
+
+// lockfileParsers maps lockfile base names to their parser
+var lockfileParsers = map[string]func(data []byte, location string) []Package{
+	"package-lock.json":   parseNpmLock,
+	"npm-shrinkwrap.json": parseNpmLock,
+	"yarn.lock":           parseYarnLock,
+	"requirements.txt":    parseRequirements,
+	"Pipfile.lock":        parsePipfileLock,
+	"poetry.lock":         parsePoetryLock,
+	"Cargo.lock":          parseCargoLock,
+	"Gemfile.lock":        parseGemfileLock,
+	"composer.lock":       parseComposerLock,
+}
+
+// nodeModulePattern matches the package.json of an installed npm package
+var nodeModulePattern = regexp.MustCompile(`/node_modules/(@[^/]+/)?[^/@][^/]*/package\.json$`)
+
+// isInventoryFile reports whether a file feeds the package inventory
+func isInventoryFile(name string) bool {
+	base := path.Base(name)
+	switch {
+	case name == "/etc/os-release" || name == "/usr/lib/os-release":
+		return true
+	case name == "/var/lib/dpkg/status" || strings.HasPrefix(name, "/var/lib/dpkg/status.d/"):
+		return true
+	case name == "/lib/apk/db/installed":
+		return true
+	case rpmDatabases[name]:
+		return true
+	case nodeModulePattern.MatchString(name):
+		return true
+	case strings.HasSuffix(name, ".dist-info/METADATA") || strings.HasSuffix(name, ".egg-info/PKG-INFO"):
+		return true
+	case lockfileParsers[base] != nil:
+		// Lockfiles vendored inside dependencies describe their own
+		// development setup, not what is installed
+		return !strings.Contains(name, "/node_modules/")
+	}
+	return false
+}
+
+// collectInventoryFile keeps the raw contents of inventory files and the
+// modules embedded in Go executables. rpm databases are only recorded, not
+// read.
+func collectInventoryFile(name string, header *tar.Header, r io.Reader) (any, bool) {
+	if header.Mode&0o111 != 0 && header.Size <= maxBinarySize && !isInventoryFile(name) {
+		return collectGoBinary(name, r)
+	}
+	if !isInventoryFile(name) || header.Size > maxInventoryFile {
+		return nil, false
+	}
+	if rpmDatabases[name] {
+		return []byte(nil), true
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Inventory exports imageName and lists the packages in its final filesystem
+func (s *imageService) Inventory(ctx context.Context, imageName string) (*Inventory, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpScan)
+	defer cancel()
+
+	inspect, _, err := s.cli.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	archive, err := s.exportLayers(ctx, imageName, collectInventoryFile)
+	if err != nil {
+		return nil, err
+	}
+	inventory := buildInventory(archive.finalFiles())
+	inventory.ImageID = inspect.ID
+	return inventory, nil
+}
+
+// collectGoBinary reads the build info of a Go executable. Other files are
+// rejected after reading their first bytes. Executables are spooled to a
+// temporary file, since build info is read from sections spread over the
+// file and binaries can be large.
+func collectGoBinary(name string, r io.Reader) (any, bool) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(elfMagic))
+	if err != nil || !bytes.Equal(magic, elfMagic) {
+		return nil, false
+	}
+	spool, err := os.CreateTemp("", "harborview-binary-*")
+	if err != nil {
+		return nil, false
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	if _, err := io.Copy(spool, buffered); err != nil {
+		return nil, false
+	}
+	info, err := buildinfo.Read(spool)
+	if err != nil {
+		return nil, false
+	}
+	return goBinaryPackages(info, name), true
+}
+
+// goBinaryPackages lists the Go toolchain, main module and dependencies a
+// binary was built from. The toolchain is reported as "stdlib", the name OSV
+// uses for standard library vulnerabilities.
+func goBinaryPackages(info *debug.BuildInfo, location string) []Package {
+	packages := []Package{
+		languagePackage(PackageGolang, "stdlib", strings.TrimPrefix(info.GoVersion, "go"), location),
+	}
+	if info.Main.Path != "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		packages = append(packages, languagePackage(PackageGolang, info.Main.Path, info.Main.Version, location))
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		if dep.Path != "" && dep.Version != "" && dep.Version != "(devel)" {
+			packages = append(packages, languagePackage(PackageGolang, dep.Path, dep.Version, location))
+		}
+	}
+	return packages
+}
+
+// buildInventory parses the collected files of an image filesystem
+func buildInventory(files map[string]any) *Inventory {
+	inventory := &Inventory{Packages: []Package{}}
+	for _, name := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		if data, ok := files[name].([]byte); ok {
+			inventory.OS = parseOSRelease(data)
+			break
+		}
+	}
+	osEcosystem := osvEcosystem(inventory.OS)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if binaryPackages, ok := files[name].([]Package); ok {
+			inventory.Packages = append(inventory.Packages, binaryPackages...)
+			continue
+		}
+		data, ok := files[name].([]byte)
+		if !ok {
+			continue
+		}
+		base := path.Base(name)
+		switch {
+		case name == "/var/lib/dpkg/status" || strings.HasPrefix(name, "/var/lib/dpkg/status.d/"):
+			inventory.Packages = append(inventory.Packages, parseDpkgStatus(data, name, inventory.OS, osEcosystem)...)
+		case name == "/lib/apk/db/installed":
+			inventory.Packages = append(inventory.Packages, parseApkInstalled(data, name, inventory.OS, osEcosystem)...)
+		case rpmDatabases[name]:
+			inventory.Warnings = append(inventory.Warnings, fmt.Sprintf("rpm database %s found but rpm packages are not inventoried", name))
+		case strings.HasSuffix(name, "/package.json"):
+			inventory.Packages = append(inventory.Packages, parseNodeModule(data, name)...)
+		case strings.HasSuffix(name, "/METADATA") || strings.HasSuffix(name, "/PKG-INFO"):
+			inventory.Packages = append(inventory.Packages, parsePythonMetadata(data, name)...)
+		case lockfileParsers[base] != nil:
+			inventory.Packages = append(inventory.Packages, lockfileParsers[base](data, name)...)
+		}
+	}
+
+	inventory.Packages = dedupePackages(inventory.Packages)
+	return inventory
+}
+
+// parseOSRelease reads the KEY=value lines of os-release
+func parseOSRelease(data []byte) OSRelease {
+	var release OSRelease
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			release.ID = value
+		case "VERSION_ID":
+			release.VersionID = value
+		case "PRETTY_NAME":
+			release.PrettyName = value
+		}
+	}
+	return release
+}
+
+// osvEcosystem maps a distribution to its OSV ecosystem name
+func osvEcosystem(release OSRelease) string {
+	switch release.ID {
+	case "":
+		return ""
+	case "debian":
+		major, _, _ := strings.Cut(release.VersionID, ".")
+		if major == "" {
+			return "Debian"
+		}
+		return "Debian:" + major
+	case "ubuntu":
+		return "Ubuntu:" + release.VersionID
+	case "alpine":
+		parts := strings.SplitN(release.VersionID, ".", 3)
+		if len(parts) < 2 {
+			return "Alpine"
+		}
+		return "Alpine:v" + parts[0] + "." + parts[1]
+	default:
+		name := strings.ToUpper(release.ID[:1]) + release.ID[1:]
+		if release.VersionID == "" {
+			return name
+		}
+		return name + ":" + release.VersionID
+	}
+}
+
+// parseControlParagraphs splits Debian control-style data into paragraphs
+// of fields, joining continuation lines
+func parseControlParagraphs(data []byte) []map[string]string {
+	var paragraphs []map[string]string
+	current := map[string]string{}
+	lastKey := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.TrimSpace(line) == "":
+			if len(current) > 0 {
+				paragraphs = append(paragraphs, current)
+				current = map[string]string{}
+			}
+		case line[0] == ' ' || line[0] == '\t':
+			if lastKey != "" {
+				current[lastKey] += "\n" + strings.TrimSpace(line)
+			}
+		default:
+			key, value, ok := strings.Cut(line, ":")
+			if ok {
+				lastKey = key
+				current[key] = strings.TrimSpace(value)
+			}
+		}
+	}
+	if len(current) > 0 {
+		paragraphs = append(paragraphs, current)
+	}
+	return paragraphs
+}
+
+func parseDpkgStatus(data []byte, location string, release OSRelease, ecosystem string) []Package {
+	var packages []Package
+	for _, fields := range parseControlParagraphs(data) {
+		// distroless status.d files have no Status field
+		if status, ok := fields["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+		if fields["Package"] == "" || fields["Version"] == "" {
+			continue
+		}
+		source, _, _ := strings.Cut(fields["Source"], " ")
+		pkg := Package{
+			Name:      fields["Package"],
+			Version:   fields["Version"],
+			Type:      PackageDeb,
+			Ecosystem: ecosystem,
+			Source:    source,
+			Arch:      fields["Architecture"],
+			Location:  location,
+		}
+		pkg.PURL = osPURL("deb", release, pkg)
+		packages = append(packages, pkg)
+	}
+	return packages
+}
+
+func parseApkInstalled(data []byte, location string, release OSRelease, ecosystem string) []Package {
+	var packages []Package
+	var pkg Package
+	flush := func() {
+		if pkg.Name != "" && pkg.Version != "" {
+			pkg.Type = PackageApk
+			pkg.Ecosystem = ecosystem
+			pkg.Location = location
+			pkg.PURL = osPURL("apk", release, pkg)
+			packages = append(packages, pkg)
+		}
+		pkg = Package{}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+		value := line[2:]
+		switch line[0] {
+		case 'P':
+			pkg.Name = value
+		case 'V':
+			pkg.Version = value
+		case 'A':
+			pkg.Arch = value
+		case 'o':
+			pkg.Source = value
+		case 'L':
+			pkg.Licenses = splitLicenses(value)
+		}
+	}
+	flush()
+	return packages
+}
+
+func parseNodeModule(data []byte, location string) []Package {
+	var manifest struct {
+		Name    string          `json:"name"`
+		Version string          `json:"version"`
+		License json.RawMessage `json:"license"`
+	}
+	if json.Unmarshal(data, &manifest) != nil || manifest.Name == "" || manifest.Version == "" {
+		return nil
+	}
+	pkg := languagePackage(PackageNpm, manifest.Name, manifest.Version, location)
+	var license string
+	if json.Unmarshal(manifest.License, &license) == nil && license != "" {
+		pkg.Licenses = splitLicenses(license)
+	}
+	return []Package{pkg}
+}
+
+func parsePythonMetadata(data []byte, location string) []Package {
+	var name, version, license string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break // headers end at the first blank line
+		}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Name":
+			name = value
+		case "Version":
+			version = value
+		case "License":
+			license = value
+		}
+	}
+	if name == "" || version == "" {
+		return nil
+	}
+	pkg := languagePackage(PackagePypi, name, version, location)
+	if license != "" && license != "UNKNOWN" && !strings.Contains(license, "\n") {
+		pkg.Licenses = []string{license}
+	}
+	return []Package{pkg}
+}
+
+func parseNpmLock(data []byte, location string) []Package {
+	var lock struct {
+		Packages map[string]struct {
+			Version string `json:"version"`
+			Link    bool   `json:"link"`
+		} `json:"packages"`
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+	if json.Unmarshal(data, &lock) != nil {
+		return nil
+	}
+
+	var packages []Package
+	if len(lock.Packages) > 0 {
+		for key, entry := range lock.Packages {
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 || entry.Link || entry.Version == "" {
+				continue
+			}
+			packages = append(packages, languagePackage(PackageNpm, key[i+len("node_modules/"):], entry.Version, location))
+		}
+		return packages
+	}
+
+	// lockfileVersion 1 nests dependencies of dependencies
+	var walk func(deps map[string]json.RawMessage)
+	walk = func(deps map[string]json.RawMessage) {
+		for name, raw := range deps {
+			var entry struct {
+				Version      string                     `json:"version"`
+				Dependencies map[string]json.RawMessage `json:"dependencies"`
+			}
+			if json.Unmarshal(raw, &entry) != nil {
+				continue
+			}
+			if entry.Version != "" {
+				packages = append(packages, languagePackage(PackageNpm, name, entry.Version, location))
+			}
+			walk(entry.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return packages
+}
+
+// parseYarnLock handles both classic (`version "1.0.0"`) and berry
+// (`version: 1.0.0`) lockfiles
+func parseYarnLock(data []byte, location string) []Package {
+	var packages []Package
+	name := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case line[0] != ' ' && strings.HasSuffix(line, ":"):
+			spec, _, _ := strings.Cut(strings.TrimSuffix(line, ":"), ",")
+			spec = strings.Trim(strings.TrimSpace(spec), `"`)
+			if at := strings.LastIndex(spec, "@"); at > 0 {
+				name = spec[:at]
+			} else {
+				name = ""
+			}
+		case name != "" && strings.HasPrefix(strings.TrimSpace(line), "version"):
+			version := strings.TrimPrefix(strings.TrimSpace(line), "version")
+			version = strings.Trim(strings.TrimSpace(strings.TrimPrefix(version, ":")), `"`)
+			if version != "" && version != "0.0.0-use.local" {
+				packages = append(packages, languagePackage(PackageNpm, name, version, location))
+			}
+			name = ""
+		}
+	}
+	return packages
+}
+
+// requirementPattern matches pinned requirements such as `Django[argon2]==4.2.1`
+var requirementPattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)(\[[^\]]*\])?\s*===?\s*([^\s;#]+)`)
+
+func parseRequirements(data []byte, location string) []Package {
+	var packages []Package
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		match := requirementPattern.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match != nil {
+			packages = append(packages, languagePackage(PackagePypi, match[1], match[3], location))
+		}
+	}
+	return packages
+}
+
+func parsePipfileLock(data []byte, location string) []Package {
+	var lock map[string]json.RawMessage
+	if json.Unmarshal(data, &lock) != nil {
+		return nil
+	}
+	var packages []Package
+	for _, section := range []string{"default", "develop"} {
+		var deps map[string]struct {
+			Version string `json:"version"`
+		}
+		if json.Unmarshal(lock[section], &deps) != nil {
+			continue
+		}
+		for name, dep := range deps {
+			if version := strings.TrimPrefix(dep.Version, "=="); version != "" {
+				packages = append(packages, languagePackage(PackagePypi, name, version, location))
+			}
+		}
+	}
+	return packages
+}
+
+// parseTOMLPackages reads the name and version of each [[package]] table,
+// which is all poetry.lock and Cargo.lock need
+func parseTOMLPackages(data []byte, packageType, location string) []Package {
+	var packages []Package
+	var name, version string
+	inPackage := false
+	flush := func() {
+		if inPackage && name != "" && version != "" {
+			packages = append(packages, languagePackage(packageType, name, version, location))
+		}
+		name, version = "", ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			flush()
+			inPackage = line == "[[package]]"
+			continue
+		}
+		if !inPackage {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		switch strings.TrimSpace(key) {
+		case "name":
+			name = value
+		case "version":
+			version = value
+		}
+	}
+	flush()
+	return packages
+}
+
+func parsePoetryLock(data []byte, location string) []Package {
+	return parseTOMLPackages(data, PackagePypi, location)
+}
+
+func parseCargoLock(data []byte, location string) []Package {
+	return parseTOMLPackages(data, PackageCargo, location)
+}
+
+// gemSpecPattern matches a top-level gem in the specs section, e.g.
+// `    rails (7.0.4)`; deeper indentation lists its dependencies
+var gemSpecPattern = regexp.MustCompile(`^    ([^\s(]+) \(([^)]+)\)$`)
+
+func parseGemfileLock(data []byte, location string) []Package {
+	var packages []Package
+	inSpecs := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "specs:" {
+			inSpecs = true
+			continue
+		}
+		if line == "" || (line[0] != ' ') {
+			inSpecs = false
+			continue
+		}
+		if !inSpecs {
+			continue
+		}
+		if match := gemSpecPattern.FindStringSubmatch(line); match != nil {
+			// Platform-specific gems are listed as `nokogiri (1.15.0-x86_64-linux)`
+			version, _, _ := strings.Cut(match[2], "-")
+			packages = append(packages, languagePackage(PackageGem, match[1], version, location))
+		}
+	}
+	return packages
+}
+
+func parseComposerLock(data []byte, location string) []Package {
+	var lock struct {
+		Packages    []composerPackage `json:"packages"`
+		PackagesDev []composerPackage `json:"packages-dev"`
+	}
+	if json.Unmarshal(data, &lock) != nil {
+		return nil
+	}
+	var packages []Package
+	for _, dep := range append(lock.Packages, lock.PackagesDev...) {
+		if dep.Name != "" && dep.Version != "" {
+			pkg := languagePackage(PackageComposer, dep.Name, strings.TrimPrefix(dep.Version, "v"), location)
+			pkg.Licenses = dep.License
+			packages = append(packages, pkg)
+		}
+	}
+	return packages
+}
+
+type composerPackage struct {
+	Name    string   `json:"name"`
+	Version string   `json:"version"`
+	License []string `json:"license"`
+}
+
+// languageEcosystems maps language package types to OSV ecosystems
+var languageEcosystems = map[string]string{
+	PackageNpm:      "npm",
+	PackagePypi:     "PyPI",
+	PackageGolang:   "Go",
+	PackageCargo:    "crates.io",
+	PackageGem:      "RubyGems",
+	PackageComposer: "Packagist",
+}
+
+// languagePackage builds a language package with its ecosystem and purl
+func languagePackage(packageType, name, version, location string) Package {
+	pkg := Package{
+		Name:      name,
+		Version:   version,
+		Type:      packageType,
+		Ecosystem: languageEcosystems[packageType],
+		Location:  location,
+	}
+	purlName := name
+	if packageType == PackagePypi {
+		purlName = normalizePythonName(name)
+	}
+	pkg.PURL = purl(packageType, purlName, version, nil)
+	return pkg
+}
+
+// osPURL builds the purl of a deb or apk package
+func osPURL(packageType string, release OSRelease, pkg Package) string {
+	qualifiers := url.Values{}
+	if pkg.Arch != "" {
+		qualifiers.Set("arch", pkg.Arch)
+	}
+	if release.ID != "" {
+		qualifiers.Set("distro", release.ID+"-"+release.VersionID)
+	}
+	return purl(packageType, release.ID+"/"+pkg.Name, pkg.Version, qualifiers)
+}
+
+// purl formats a package URL, percent-encoding each name segment
+func purl(packageType, name, version string, qualifiers url.Values) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	result := "pkg:" + packageType + "/" + strings.Join(segments, "/")
+	if version != "" {
+		result += "@" + url.PathEscape(version)
+	}
+	if len(qualifiers) > 0 {
+		result += "?" + qualifiers.Encode()
+	}
+	return result
+}
+
+var (
+	pythonNameSeparators = regexp.MustCompile(`[-_.]+`)
+	licenseSeparators    = regexp.MustCompile(`\s+(?:AND|OR|and|or)\s+|\s*[,;]\s*`)
+)
+
+// normalizePythonName applies PEP 503 name normalisation
+func normalizePythonName(name string) string {
+	return strings.ToLower(pythonNameSeparators.ReplaceAllString(name, "-"))
+}
+
+// splitLicenses splits an SPDX-ish expression or list into license names
+func splitLicenses(value string) []string {
+	var licenses []string
+	for _, license := range licenseSeparators.Split(value, -1) {
+		if license = strings.Trim(strings.TrimSpace(license), "()"); license != "" {
+			licenses = append(licenses, license)
+		}
+	}
+	return licenses
+}
+
+// dedupePackages drops repeated name/version/type/location entries and
+// sorts the result for stable output
+func dedupePackages(packages []Package) []Package {
+	seen := make(map[string]bool, len(packages))
+	unique := packages[:0]
+	for _, pkg := range packages {
+		key := pkg.Type + "\x00" + pkg.Name + "\x00" + pkg.Version + "\x00" + pkg.Location
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, pkg)
+	}
+	sort.Slice(unique, func(a, b int) bool {
+		if unique[a].Type != unique[b].Type {
+			return unique[a].Type < unique[b].Type
+		}
+		if unique[a].Name != unique[b].Name {
+			return unique[a].Name < unique[b].Name
+		}
+		return unique[a].Version < unique[b].Version
+	})
+	return unique
+}
@@ -0,0 +1,240 @@
+package images
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// packageIDs lists packages as sorted name@version strings
+func packageIDs(packages []Package) []string {
+	ids := make([]string, len(packages))
+	for i, pkg := range packages {
+		ids[i] = pkg.Name + "@" + pkg.Version
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestParseDpkgStatus(t *testing.T) {
+	status := `Package: libssl3
+Status: install ok installed
+Architecture: amd64
+Source: openssl (3.0.11-1~deb12u2)
+Version: 3.0.11-1~deb12u2
+Description: Secure Sockets Layer toolkit
+ This package is part of the OpenSSL project.
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0-1
+
+Package: tzdata
+Status: install ok installed
+Architecture: all
+Version: 2024a-0+deb12u1
+`
+	release := OSRelease{ID: "debian", VersionID: "12"}
+	packages := parseDpkgStatus([]byte(status), "var/lib/dpkg/status", release, "Debian:12")
+	if got := packageIDs(packages); !slices.Equal(got, []string{"libssl3@3.0.11-1~deb12u2", "tzdata@2024a-0+deb12u1"}) {
+		t.Fatalf("packages = %v", got)
+	}
+	ssl := packages[0]
+	if ssl.Source != "openssl" || ssl.Arch != "amd64" || ssl.Ecosystem != "Debian:12" || ssl.Type != PackageDeb {
+		t.Errorf("libssl3 = %+v", ssl)
+	}
+	if want := "pkg:deb/debian/libssl3@3.0.11-1~deb12u2?arch=amd64&distro=debian-12"; ssl.PURL != want {
+		t.Errorf("PURL = %s, want %s", ssl.PURL, want)
+	}
+
+	// distroless status.d entries have no Status field
+	distroless := parseDpkgStatus([]byte("Package: base-files\nVersion: 12.4\n"), "var/lib/dpkg/status.d/base", release, "Debian:12")
+	if got := packageIDs(distroless); !slices.Equal(got, []string{"base-files@12.4"}) {
+		t.Errorf("distroless packages = %v", got)
+	}
+}
+
+func TestParseApkInstalled(t *testing.T) {
+	installed := `C:Q1abc=
+P:musl
+V:1.2.4-r2
+A:x86_64
+o:musl
+L:MIT
+
+P:busybox
+V:1.36.1-r15
+A:x86_64
+L:GPL-2.0-only AND bzip2-1.0.6
+
+P:incomplete
+A:x86_64
+`
+	release := OSRelease{ID: "alpine", VersionID: "3.19.1"}
+	packages := parseApkInstalled([]byte(installed), "lib/apk/db/installed", release, "Alpine:v3.19")
+	if got := packageIDs(packages); !slices.Equal(got, []string{"busybox@1.36.1-r15", "musl@1.2.4-r2"}) {
+		t.Fatalf("packages = %v", got)
+	}
+	busybox := packages[1]
+	if !slices.Equal(busybox.Licenses, []string{"GPL-2.0-only", "bzip2-1.0.6"}) {
+		t.Errorf("busybox licenses = %v", busybox.Licenses)
+	}
+	if musl := packages[0]; musl.Source != "musl" || musl.Type != PackageApk || musl.Ecosystem != "Alpine:v3.19" {
+		t.Errorf("musl = %+v", musl)
+	}
+}
+
+func TestParseLockfiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]byte, string) []Package
+		data  string
+		want  []string
+	}{
+		{
+			name:  "npm v3",
+			parse: parseNpmLock,
+			data: `{"lockfileVersion": 3, "packages": {
+				"": {"name": "app", "version": "1.0.0"},
+				"node_modules/express": {"version": "4.18.2"},
+				"node_modules/express/node_modules/debug": {"version": "2.6.9"},
+				"node_modules/@types/node": {"version": "20.1.0"},
+				"node_modules/local": {"link": true}
+			}}`,
+			want: []string{"@types/node@20.1.0", "debug@2.6.9", "express@4.18.2"},
+		},
+		{
+			name:  "npm v1",
+			parse: parseNpmLock,
+			data: `{"lockfileVersion": 1, "dependencies": {
+				"express": {"version": "4.18.2", "dependencies": {"debug": {"version": "2.6.9"}}},
+				"ms": {"version": "2.1.3"}
+			}}`,
+			want: []string{"debug@2.6.9", "express@4.18.2", "ms@2.1.3"},
+		},
+		{
+			name:  "yarn classic",
+			parse: parseYarnLock,
+			data: `# yarn lockfile v1
+
+"@babel/core@^7.0.0", "@babel/core@^7.1.0":
+  version "7.23.0"
+  resolved "https://registry.yarnpkg.com/@babel/core/-/core-7.23.0.tgz"
+
+lodash@^4.17.21:
+  version "4.17.21"
+`,
+			want: []string{"@babel/core@7.23.0", "lodash@4.17.21"},
+		},
+		{
+			name:  "yarn berry",
+			parse: parseYarnLock,
+			data: `__metadata:
+  version: 6
+
+"lodash@npm:^4.17.21":
+  version: 4.17.21
+
+"app@workspace:.":
+  version: 0.0.0-use.local
+`,
+			want: []string{"lodash@4.17.21"},
+		},
+		{
+			name:  "requirements",
+			parse: parseRequirements,
+			data: `# pinned
+Django[argon2]==4.2.1
+requests === 2.31.0 ; python_version > "3.7"
+flask>=2.0
+-r other.txt
+`,
+			want: []string{"Django@4.2.1", "requests@2.31.0"},
+		},
+		{
+			name:  "poetry",
+			parse: parsePoetryLock,
+			data: `[[package]]
+name = "certifi"
+version = "2023.7.22"
+
+[package.extras]
+name = "not-a-package"
+version = "0"
+
+[[package]]
+name = "idna"
+version = "3.4"
+
+[metadata]
+lock-version = "2.0"
+`,
+			want: []string{"certifi@2023.7.22", "idna@3.4"},
+		},
+		{
+			name:  "cargo",
+			parse: parseCargoLock,
+			data: `version = 3
+
+[[package]]
+name = "serde"
+version = "1.0.188"
+dependencies = [
+ "serde_derive",
+]
+`,
+			want: []string{"serde@1.0.188"},
+		},
+		{
+			name:  "gemfile",
+			parse: parseGemfileLock,
+			data: `GEM
+  remote: https://rubygems.org/
+  specs:
+    nokogiri (1.15.4-x86_64-linux)
+      racc (~> 1.4)
+    rails (7.0.8)
+
+PLATFORMS
+  x86_64-linux
+
+DEPENDENCIES
+  rails (~> 7.0)
+`,
+			want: []string{"nokogiri@1.15.4", "rails@7.0.8"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := packageIDs(tt.parse([]byte(tt.data), "app/lock")); !slices.Equal(got, tt.want) {
+				t.Errorf("packages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectGoBinary(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	binary, err := os.Open(executable)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer binary.Close()
+
+	collected, ok := collectGoBinary("usr/bin/app", binary)
+	if !ok {
+		t.Fatal("collectGoBinary did not read the test binary")
+	}
+	packages := collected.([]Package)
+	if stdlib := packages[0]; stdlib.Name != "stdlib" || !strings.HasPrefix(stdlib.Version, "1.") || stdlib.Location != "usr/bin/app" {
+		t.Errorf("toolchain package = %+v", stdlib)
+	}
+
+	if _, ok := collectGoBinary("usr/bin/script", strings.NewReader("#!/bin/sh\necho hi\n")); ok {
+		t.Error("collectGoBinary accepted a shell script")
+	}
+}
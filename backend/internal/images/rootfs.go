@@ -0,0 +1,221 @@
+package images
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxMetadataBlob bounds the non-layer entries (manifest.json, image configs)
+// kept in memory while walking a `docker save` archive
+const maxMetadataBlob = 4 * 1024 * 1024
+
+// Overlay whiteout markers used in layer tars
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// fileCollector is called for every regular file of every layer while the
+// layer streams past. It returns what it extracted from the file and whether
+// the file should be kept at all. Paths are absolute, e.g. "/etc/os-release".
+type fileCollector func(name string, header *tar.Header, r io.Reader) (any, bool)
+
+// layerContents is what a single layer tar contains
+type layerContents struct {
+	files     []FileInfo
+	whiteouts []string
+	opaque    []string
+	collected map[string]any
+}
+
+// imageArchive is an exported image with its layers in order
+type imageArchive struct {
+	Config ocispec.Image
+	Layers []*layerContents
+}
+
+// saveManifest is an entry of manifest.json in a `docker save` archive
+type saveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// exportLayers exports imageName with `docker save` and summarises each
+// layer, passing every file through collect when it is not nil.
+func (s *imageService) exportLayers(ctx context.Context, imageName string, collect fileCollector) (*imageArchive, error) {
+	stream, err := s.cli.ImageSave(ctx, []string{imageName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export image: %w", err)
+	}
+	defer stream.Close()
+
+	layers, metadata, links, err := readSaveArchive(stream, collect)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image archive: %w", err)
+	}
+
+	var manifests []saveManifest
+	if err := json.Unmarshal(metadata["manifest.json"], &manifests); err != nil || len(manifests) == 0 {
+		return nil, errors.New("image archive has no manifest.json")
+	}
+	manifest := manifests[0]
+
+	archive := &imageArchive{Layers: make([]*layerContents, len(manifest.Layers))}
+	if raw, ok := metadata[manifest.Config]; ok {
+		json.Unmarshal(raw, &archive.Config)
+	}
+	for i, name := range manifest.Layers {
+		if target, ok := links[name]; ok {
+			name = target
+		}
+		if contents, ok := layers[name]; ok {
+			archive.Layers[i] = contents
+		} else {
+			// Empty layers carry no tar header and are not recognised as tars
+			archive.Layers[i] = &layerContents{}
+		}
+	}
+	return archive, nil
+}
+
+// finalFiles replays the layers and returns what the collector kept for each
+// file still visible in the final filesystem. A file overwritten by a later
+// layer that the collector did not keep disappears, as it would on disk.
+func (a *imageArchive) finalFiles() map[string]any {
+	final := make(map[string]any)
+	removeTree := func(dir string, includeSelf bool) {
+		prefix := strings.TrimSuffix(dir, "/") + "/"
+		for p := range final {
+			if (includeSelf && p == dir) || strings.HasPrefix(p, prefix) {
+				delete(final, p)
+			}
+		}
+	}
+
+	for _, layer := range a.Layers {
+		for _, dir := range layer.opaque {
+			removeTree(dir, false)
+		}
+		for _, whiteout := range layer.whiteouts {
+			removeTree(whiteout, true)
+		}
+		for _, file := range layer.files {
+			if value, ok := layer.collected[file.Path]; ok {
+				final[file.Path] = value
+			} else {
+				delete(final, file.Path)
+			}
+		}
+	}
+	return final
+}
+
+// readSaveArchive walks a `docker save` tar once. Layer tars are summarised
+// as they stream past, since manifest.json may come after them; small
+// non-layer entries are kept verbatim. links maps legacy symlinked layer
+// paths to their targets.
+func readSaveArchive(r io.Reader, collect fileCollector) (layers map[string]*layerContents, metadata map[string][]byte, links map[string]string, err error) {
+	layers = make(map[string]*layerContents)
+	metadata = make(map[string][]byte)
+	links = make(map[string]string)
+
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return layers, metadata, links, nil
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		name := path.Clean(header.Name)
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			links[name] = path.Join(path.Dir(name), header.Linkname)
+			continue
+		case tar.TypeReg:
+		default:
+			continue
+		}
+
+		buffered := bufio.NewReaderSize(archive, 64*1024)
+		peek, _ := buffered.Peek(512)
+		switch {
+		case isGzip(peek):
+			gz, err := gzip.NewReader(buffered)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			contents, err := readLayer(gz, collect)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to read layer %s: %w", name, err)
+			}
+			layers[name] = contents
+		case isTar(peek):
+			contents, err := readLayer(buffered, collect)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to read layer %s: %w", name, err)
+			}
+			layers[name] = contents
+		case header.Size <= maxMetadataBlob:
+			data, err := io.ReadAll(buffered)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			metadata[name] = data
+		}
+	}
+}
+
+// readLayer lists the files and whiteouts of a layer tar
+func readLayer(r io.Reader, collect fileCollector) (*layerContents, error) {
+	contents := &layerContents{collected: make(map[string]any)}
+	layer := tar.NewReader(r)
+	for {
+		header, err := layer.Next()
+		if err == io.EOF {
+			return contents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := "/" + strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		dir, base := path.Split(name)
+		switch {
+		case base == whiteoutOpaque:
+			contents.opaque = append(contents.opaque, path.Clean(dir))
+		case strings.HasPrefix(base, whiteoutPrefix):
+			contents.whiteouts = append(contents.whiteouts, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+		case header.Typeflag == tar.TypeDir:
+		default:
+			contents.files = append(contents.files, FileInfo{Path: name, Size: header.Size})
+			if collect != nil && header.Typeflag == tar.TypeReg {
+				if value, ok := collect(name, header, layer); ok {
+					contents.collected[name] = value
+				}
+			}
+		}
+	}
+}
+
+func isGzip(peek []byte) bool {
+	return len(peek) >= 2 && peek[0] == 0x1f && peek[1] == 0x8b
+}
+
+func isTar(peek []byte) bool {
+	return len(peek) >= 262 && bytes.Equal(peek[257:262], []byte("ustar"))
+}
@@ -0,0 +1,379 @@
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/github/go-spdx/v2/spdxexp/spdxlicenses"
+	"github.com/google/uuid"
+)
+
+// SBOM formats
+const (
+	SBOMCycloneDX = "cyclonedx"
+	SBOMSPDX      = "spdx"
+)
+
+// SBOMContentTypes are the media types of each SBOM format
+var SBOMContentTypes = map[string]string{
+	SBOMCycloneDX: "application/vnd.cyclonedx+json",
+	SBOMSPDX:      "application/spdx+json",
+}
+
+// spdxList indexes the SPDX license list by lower-cased ID, since license
+// IDs match case-insensitively
+type spdxList struct {
+	licenses   map[string]string
+	exceptions map[string]string
+}
+
+var spdxLicenses = sync.OnceValue(func() spdxList {
+	list := spdxList{licenses: map[string]string{}, exceptions: map[string]string{}}
+	for _, id := range append(spdxlicenses.GetLicenses(), spdxlicenses.GetDeprecated()...) {
+		list.licenses[strings.ToLower(id)] = id
+	}
+	for _, id := range spdxlicenses.GetExceptions() {
+		list.exceptions[strings.ToLower(id)] = id
+	}
+	return list
+})
+
+// licenseRefInvalid matches the characters a LicenseRef may not contain
+var licenseRefInvalid = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// spdxLicenseID returns the canonical SPDX ID of license, which may carry
+// the "+" operator and a "WITH" exception, or false when it is not on the
+// SPDX license list
+func spdxLicenseID(license string) (string, bool) {
+	list := spdxLicenses()
+	name, exception, hasException := strings.Cut(strings.TrimSpace(license), " WITH ")
+	id, plus := strings.CutSuffix(strings.TrimSpace(name), "+")
+	id, ok := list.licenses[strings.ToLower(id)]
+	if !ok {
+		return "", false
+	}
+	if plus {
+		id += "+"
+	}
+	if hasException {
+		exception, ok := list.exceptions[strings.ToLower(strings.TrimSpace(exception))]
+		if !ok {
+			return "", false
+		}
+		id += " WITH " + exception
+	}
+	return id, true
+}
+
+// licenseRef names a license that is not on the SPDX list, or returns ""
+// when nothing of the name is left to refer to
+func licenseRef(license string) string {
+	sanitized := strings.Trim(licenseRefInvalid.ReplaceAllString(license, "-"), "-.")
+	if sanitized == "" {
+		return ""
+	}
+	return "LicenseRef-" + sanitized
+}
+
+// GenerateSBOM returns the SBOM of imageName in format. Documents are cached
+// per image ID and format; refresh regenerates them.
+func (s *imageService) GenerateSBOM(ctx context.Context, imageName, format string, refresh bool) ([]byte, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpScan)
+	defer cancel()
+
+	if _, ok := SBOMContentTypes[format]; !ok {
+		return nil, fmt.Errorf("unsupported SBOM format %q", format)
+	}
+	kind := "sbom-" + format
+
+	inspect, _, err := s.cli.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	if !refresh {
+		var cached json.RawMessage
+		found, err := s.reports.load(kind, inspect.ID, &cached)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return cached, nil
+		}
+	}
+
+	inventory, err := s.Inventory(ctx, imageName)
+	if err != nil {
+		return nil, err
+	}
+	subject := sbomSubject{Name: imageName, ImageID: inventory.ImageID, RepoDigests: inspect.RepoDigests}
+
+	var document any
+	switch format {
+	case SBOMCycloneDX:
+		document = cycloneDXDocument(subject, inventory)
+	case SBOMSPDX:
+		document = spdxDocument(subject, inventory)
+	}
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SBOM: %w", err)
+	}
+
+	if err := s.reports.save(kind, inspect.ID, json.RawMessage(data)); err != nil {
+		log.Printf("Failed to cache %s SBOM for %s: %v", format, imageName, err)
+	}
+	return data, nil
+}
+
+// sbomSubject is the image an SBOM describes
+type sbomSubject struct {
+	Name        string
+	ImageID     string
+	RepoDigests []string
+}
+
+// CycloneDX 1.5 JSON, reduced to the fields harborview fills in
+type cycloneDXBOM struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string `json:"timestamp"`
+	Tools     struct {
+		Components []cycloneDXComponent `json:"components"`
+	} `json:"tools"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	BOMRef     string              `json:"bom-ref,omitempty"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Licenses   []cycloneDXLicense  `json:"licenses,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXLicense struct {
+	License struct {
+		ID   string `json:"id,omitempty"`
+		Name string `json:"name,omitempty"`
+	} `json:"license"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func cycloneDXDocument(subject sbomSubject, inventory *Inventory) cycloneDXBOM {
+	bom := cycloneDXBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid.NewString(),
+		Version:      1,
+		Components:   []cycloneDXComponent{},
+	}
+	bom.Metadata.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	bom.Metadata.Tools.Components = []cycloneDXComponent{{Type: "application", Name: "harborview"}}
+	bom.Metadata.Component = cycloneDXComponent{
+		Type:    "container",
+		BOMRef:  subject.ImageID,
+		Name:    subject.Name,
+		Version: subject.ImageID,
+	}
+	for _, digest := range subject.RepoDigests {
+		bom.Metadata.Component.Properties = append(bom.Metadata.Component.Properties, cycloneDXProperty{Name: "harborview:image:repoDigest", Value: digest})
+	}
+
+	if inventory.OS.ID != "" {
+		bom.Components = append(bom.Components, cycloneDXComponent{
+			Type:    "operating-system",
+			BOMRef:  "os:" + inventory.OS.ID + "@" + inventory.OS.VersionID,
+			Name:    inventory.OS.ID,
+			Version: inventory.OS.VersionID,
+		})
+	}
+	for _, pkg := range inventory.Packages {
+		component := cycloneDXComponent{
+			Type:    "library",
+			BOMRef:  pkg.PURL + "#" + pkg.Location,
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			PURL:    pkg.PURL,
+			Properties: []cycloneDXProperty{
+				{Name: "harborview:package:type", Value: pkg.Type},
+				{Name: "harborview:package:location", Value: pkg.Location},
+			},
+		}
+		if pkg.Source != "" {
+			component.Properties = append(component.Properties, cycloneDXProperty{Name: "harborview:package:source", Value: pkg.Source})
+		}
+		for _, name := range pkg.Licenses {
+			var license cycloneDXLicense
+			if id, ok := spdxLicenseID(name); ok && !strings.Contains(id, " ") && !strings.HasSuffix(id, "+") {
+				license.License.ID = id
+			} else {
+				license.License.Name = name
+			}
+			component.Licenses = append(component.Licenses, license)
+		}
+		bom.Components = append(bom.Components, component)
+	}
+	return bom
+}
+
+// SPDX 2.3 JSON, reduced to the fields harborview fills in
+type spdxSBOM struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+	// ExtractedLicenses declares every LicenseRef the packages use
+	ExtractedLicenses []spdxExtractedLicense `json:"hasExtractedLicensingInfos,omitempty"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxExtractedLicense struct {
+	LicenseID     string `json:"licenseId"`
+	Name          string `json:"name"`
+	ExtractedText string `json:"extractedText"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func spdxDocument(subject sbomSubject, inventory *Inventory) spdxSBOM {
+	const imageID = "SPDXRef-Image"
+	document := spdxSBOM{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              subject.Name,
+		DocumentNamespace: "https://harborview/spdx/" + url.PathEscape(subject.Name) + "-" + uuid.NewString(),
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: harborview"},
+		},
+		Packages: []spdxPackage{{
+			Name:             subject.Name,
+			SPDXID:           imageID,
+			VersionInfo:      subject.ImageID,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+			PrimaryPurpose:   "CONTAINER",
+		}},
+		Relationships: []spdxRelationship{{
+			SPDXElementID:      "SPDXRef-DOCUMENT",
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: imageID,
+		}},
+	}
+
+	extracted := map[string]bool{}
+	for i, pkg := range inventory.Packages {
+		id := fmt.Sprintf("SPDXRef-Package-%s-%d", pkg.Type, i)
+		declared, refs := spdxLicenseExpression(pkg.Licenses)
+		for _, ref := range slices.Sorted(maps.Keys(refs)) {
+			if !extracted[ref] {
+				extracted[ref] = true
+				document.ExtractedLicenses = append(document.ExtractedLicenses, spdxExtractedLicense{
+					LicenseID:     ref,
+					Name:          refs[ref],
+					ExtractedText: refs[ref],
+				})
+			}
+		}
+		document.Packages = append(document.Packages, spdxPackage{
+			Name:             pkg.Name,
+			SPDXID:           id,
+			VersionInfo:      pkg.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  declared,
+			CopyrightText:    "NOASSERTION",
+			SourceInfo:       "found in " + pkg.Location,
+			PrimaryPurpose:   "LIBRARY",
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  pkg.PURL,
+			}},
+		})
+		document.Relationships = append(document.Relationships, spdxRelationship{
+			SPDXElementID:      imageID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: id,
+		})
+	}
+	return document
+}
+
+// spdxLicenseExpression joins licenses with AND. Licenses on the SPDX list
+// are used by ID and others as a LicenseRef, returned with the license name
+// it stands for. Without any usable license it gives up with NOASSERTION.
+func spdxLicenseExpression(licenses []string) (string, map[string]string) {
+	var terms []string
+	refs := map[string]string{}
+	for _, license := range licenses {
+		if id, ok := spdxLicenseID(license); ok {
+			terms = append(terms, id)
+		} else if ref := licenseRef(license); ref != "" {
+			terms = append(terms, ref)
+			refs[ref] = license
+		}
+	}
+	if len(terms) == 0 {
+		return "NOASSERTION", nil
+	}
+	return strings.Join(terms, " AND "), refs
+}
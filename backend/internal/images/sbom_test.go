@@ -0,0 +1,61 @@
+package images
+
+import (
+	"testing"
+)
+
+func TestSPDXLicenseExpression(t *testing.T) {
+	tests := []struct {
+		licenses []string
+		want     string
+		refs     map[string]string
+	}{
+		{nil, "NOASSERTION", nil},
+		{[]string{"MIT"}, "MIT", nil},
+		{[]string{"mit", "apache-2.0"}, "MIT AND Apache-2.0", nil},
+		{[]string{"GPL-2.0+"}, "GPL-2.0+", nil},
+		{[]string{"GPL-2.0-only WITH Classpath-exception-2.0"}, "GPL-2.0-only WITH Classpath-exception-2.0", nil},
+		{[]string{"GPL-2.0-only WITH Made-Up-exception"}, "LicenseRef-GPL-2.0-only-WITH-Made-Up-exception", map[string]string{
+			"LicenseRef-GPL-2.0-only-WITH-Made-Up-exception": "GPL-2.0-only WITH Made-Up-exception",
+		}},
+		{[]string{"MIT", "Custom (see COPYING)"}, "MIT AND LicenseRef-Custom-see-COPYING", map[string]string{
+			"LicenseRef-Custom-see-COPYING": "Custom (see COPYING)",
+		}},
+		{[]string{"MadeUp-1.0"}, "LicenseRef-MadeUp-1.0", map[string]string{"LicenseRef-MadeUp-1.0": "MadeUp-1.0"}},
+		{[]string{"()", "  "}, "NOASSERTION", nil},
+	}
+	for _, tt := range tests {
+		got, refs := spdxLicenseExpression(tt.licenses)
+		if got != tt.want {
+			t.Errorf("spdxLicenseExpression(%q) = %q, want %q", tt.licenses, got, tt.want)
+		}
+		if len(refs) != len(tt.refs) {
+			t.Errorf("spdxLicenseExpression(%q) refs = %v, want %v", tt.licenses, refs, tt.refs)
+		}
+		for ref, name := range tt.refs {
+			if refs[ref] != name {
+				t.Errorf("spdxLicenseExpression(%q) refs[%s] = %q, want %q", tt.licenses, ref, refs[ref], name)
+			}
+		}
+	}
+}
+
+func TestSPDXDocumentExtractedLicenses(t *testing.T) {
+	inventory := &Inventory{Packages: []Package{
+		{Name: "a", Version: "1", Type: PackageNpm, Licenses: []string{"Custom"}},
+		{Name: "b", Version: "1", Type: PackageNpm, Licenses: []string{"Custom", "MIT"}},
+	}}
+	document := spdxDocument(sbomSubject{Name: "app:1", ImageID: "sha256:1"}, inventory)
+	if len(document.ExtractedLicenses) != 1 || document.ExtractedLicenses[0].LicenseID != "LicenseRef-Custom" {
+		t.Errorf("extracted licenses = %+v, want LicenseRef-Custom once", document.ExtractedLicenses)
+	}
+	if got := document.Packages[2].LicenseDeclared; got != "LicenseRef-Custom AND MIT" {
+		t.Errorf("LicenseDeclared = %q", got)
+	}
+
+	bom := cycloneDXDocument(sbomSubject{Name: "app:1"}, inventory)
+	licenses := bom.Components[1].Licenses
+	if licenses[0].License.Name != "Custom" || licenses[1].License.ID != "MIT" {
+		t.Errorf("CycloneDX licenses = %+v", licenses)
+	}
+}
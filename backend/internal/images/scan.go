@@ -0,0 +1,124 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/genc-murat/harborview/internal/docker"
+)
+
+// vulnerabilityReportKind names vulnerability reports in the report store
+const vulnerabilityReportKind = "vulnerabilities"
+
+// severityRank orders findings from most to least severe
+var severityRank = map[string]int{
+	SeverityCritical: 0,
+	SeverityHigh:     1,
+	SeverityMedium:   2,
+	SeverityLow:      3,
+	SeverityUnknown:  4,
+}
+
+// Finding is a vulnerability affecting an installed package
+type Finding struct {
+	ID           string   `json:"id"`
+	Aliases      []string `json:"aliases,omitempty"`
+	Summary      string   `json:"summary,omitempty"`
+	Severity     string   `json:"severity"`
+	Score        float64  `json:"score,omitempty"`
+	Package      string   `json:"package"`
+	Version      string   `json:"version"`
+	Type         string   `json:"type"`
+	Ecosystem    string   `json:"ecosystem"`
+	FixedVersion string   `json:"fixedVersion,omitempty"`
+	Location     string   `json:"location"`
+	PURL         string   `json:"purl"`
+}
+
+// VulnerabilityReport is the result of scanning one image
+type VulnerabilityReport struct {
+	Image        string         `json:"image"`
+	ImageID      string         `json:"imageID"`
+	ScannedAt    time.Time      `json:"scannedAt"`
+	Database     VulnDBStatus   `json:"database"`
+	OS           OSRelease      `json:"os"`
+	PackageCount int            `json:"packageCount"`
+	Summary      map[string]int `json:"summary"`
+	Findings     []Finding      `json:"findings"`
+	Warnings     []string       `json:"warnings,omitempty"`
+}
+
+// ScanImage inventories imageName's packages, matches them against the
+// vulnerability database and stores the report under the image ID
+func (s *imageService) ScanImage(ctx context.Context, imageName string) (*VulnerabilityReport, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpScan)
+	defer cancel()
+
+	if err := s.vulnDB.refresh(); err != nil {
+		return nil, err
+	}
+	inventory, err := s.Inventory(ctx, imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VulnerabilityReport{
+		Image:        imageName,
+		ImageID:      inventory.ImageID,
+		ScannedAt:    time.Now().UTC(),
+		Database:     s.vulnDB.Status(),
+		OS:           inventory.OS,
+		PackageCount: len(inventory.Packages),
+		Summary:      make(map[string]int),
+		Findings:     []Finding{},
+		Warnings:     inventory.Warnings,
+	}
+	for _, pkg := range inventory.Packages {
+		report.Findings = append(report.Findings, s.vulnDB.Match(pkg)...)
+	}
+	sort.SliceStable(report.Findings, func(a, b int) bool {
+		fa, fb := report.Findings[a], report.Findings[b]
+		if severityRank[fa.Severity] != severityRank[fb.Severity] {
+			return severityRank[fa.Severity] < severityRank[fb.Severity]
+		}
+		if fa.Score != fb.Score {
+			return fa.Score > fb.Score
+		}
+		return fa.Package < fb.Package
+	})
+	for _, finding := range report.Findings {
+		report.Summary[finding.Severity]++
+	}
+
+	if err := s.reports.save(vulnerabilityReportKind, report.ImageID, report); err != nil {
+		log.Printf("Failed to store vulnerability report for %s: %v", imageName, err)
+	}
+	log.Printf("Scanned %s: %d packages, %d vulnerabilities", imageName, report.PackageCount, len(report.Findings))
+	return report, nil
+}
+
+// GetVulnerabilities returns the stored report for the image's current ID,
+// scanning when there is none or refresh is set
+func (s *imageService) GetVulnerabilities(ctx context.Context, imageName string, refresh bool) (*VulnerabilityReport, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpScan)
+	defer cancel()
+
+	if !refresh {
+		inspect, _, err := s.cli.ImageInspectWithRaw(ctx, imageName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect image: %w", err)
+		}
+		var report VulnerabilityReport
+		found, err := s.reports.load(vulnerabilityReportKind, inspect.ID, &report)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return &report, nil
+		}
+	}
+	return s.ScanImage(ctx, imageName)
+}
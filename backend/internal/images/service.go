@@ -3,57 +3,96 @@ package images
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 
+	distref "github.com/distribution/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/internal/registry"
+	"github.com/genc-murat/harborview/internal/signatures"
+	"github.com/genc-murat/harborview/pkg/config"
 )
 
 // ImageService interface for dependency injection
 type ImageService interface {
-	GetImages() ([]string, error)
-	GetTags(imageName string) ([]string, error)
-	RemoveImage(imageName string) error
-	SearchImages(query string) ([]string, error)
-	DeleteUnusedImages() error
-	GetImageHistory(imageName string) ([]image.HistoryResponseItem, error)
+	GetImages(ctx context.Context) ([]string, error)
+	GetTags(ctx context.Context, imageName string, n int, last string) (*TagList, error)
+	RemoveImage(ctx context.Context, imageName string, force bool) ([]image.DeleteResponse, error)
+	TagImage(ctx context.Context, source, target string) error
+	UntagImage(ctx context.Context, reference string) ([]image.DeleteResponse, error)
+	RetagImage(ctx context.Context, source, target string) ([]image.DeleteResponse, error)
+	SearchImages(ctx context.Context, query string) ([]string, error)
+	DeleteUnusedImages(ctx context.Context) error
+	GetImageHistory(ctx context.Context, imageName string) ([]image.HistoryResponseItem, error)
+	AnalyzeImage(ctx context.Context, imageName string, limit int) (*LayerAnalysis, error)
+	Inventory(ctx context.Context, imageName string) (*Inventory, error)
+	ScanImage(ctx context.Context, imageName string) (*VulnerabilityReport, error)
+	GetVulnerabilities(ctx context.Context, imageName string, refresh bool) (*VulnerabilityReport, error)
+	VerifyImage(ctx context.Context, imageName string) (*signatures.Result, error)
+	GenerateSBOM(ctx context.Context, imageName, format string, refresh bool) ([]byte, error)
 	BuildImage(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (string, error)
 	PullImage(ctx context.Context, imageName, tag string) error
 	PushImage(ctx context.Context, imageName string, options image.PushOptions) error
-	SaveImage(ctx context.Context, imageNames []string, outputFile string) error
+	SaveImage(ctx context.Context, imageNames []string, compression string) (io.ReadCloser, error)
+	ExportImage(ctx context.Context, imageNames []string, filename, compression string) (string, error)
+	LoadImage(ctx context.Context, input io.Reader) ([]string, error)
+	ImportImage(ctx context.Context, input io.Reader, ref string, options image.ImportOptions) (string, error)
 	PruneImages(ctx context.Context) (image.PruneReport, error)
-	InspectImage(imageName string) (*types.ImageInspect, error)
+	InspectImage(ctx context.Context, imageName string) (*types.ImageInspect, error)
 }
 
 type imageService struct {
-	cli *client.Client
+	cli        *client.Client
+	registries *registry.Resolver
+	verifier   *signatures.Verifier
+	vulnDB     *VulnDB
+	reports    *reportStore
+	exportDir  string
+	timeouts   docker.Timeouts
 }
 
-// NewImageService creates a new instance of ImageService using the Docker SDK
-func NewImageService() (ImageService, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+// exportFilenamePattern restricts server-side export names to a single path
+// element without separators or leading dots.
+var exportFilenamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// NewImageService creates an ImageService for the daemon behind cli
+func NewImageService(cfg config.Config, cli *client.Client) (ImageService, error) {
+	registries := registry.NewResolver(cfg)
+	verifier, err := signatures.NewVerifier(cfg, registries)
 	if err != nil {
 		return nil, err
 	}
 
-	// Optional: Set custom options based on config
-	// For example, if your config has a specific host:
-	// cli, err = client.NewClientWithOpts(client.WithHost(cfg.Registry.BaseURL))
-	// if err != nil {
-	//     return nil, err
-	// }
-
-	return &imageService{cli: cli}, nil
+	return &imageService{
+		cli:        cli,
+		registries: registries,
+		verifier:   verifier,
+		vulnDB:     sharedVulnDB(cfg.Scanning.VulnDB),
+		reports:    newReportStore(cfg.Scanning.ReportDir),
+		exportDir:  cfg.Images.ExportDir,
+		timeouts:   docker.NewTimeouts(cfg),
+	}, nil
 }
 
-func (s *imageService) GetImages() ([]string, error) {
-	images, err := s.cli.ImageList(context.Background(), image.ListOptions{})
+func (s *imageService) GetImages(ctx context.Context) ([]string, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	images, err := s.cli.ImageList(ctx, image.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -67,33 +106,85 @@ func (s *imageService) GetImages() ([]string, error) {
 	return imageNames, nil
 }
 
-func (s *imageService) GetTags(imageName string) ([]string, error) {
-	// With Docker SDK, we get tags when listing images.
-	// This function needs adjustment depending on how you want to use it.
-	// If you have the image ID, you can inspect the image to get the RepoTags.
-	imageSummary, _, err := s.cli.ImageInspectWithRaw(context.Background(), imageName)
+func (s *imageService) RemoveImage(ctx context.Context, imageName string, force bool) ([]image.DeleteResponse, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	report, err := s.cli.ImageRemove(ctx, imageName, image.RemoveOptions{
+		Force:         force,
+		PruneChildren: true,
+	})
 	if err != nil {
 		return nil, err
 	}
-	return imageSummary.RepoTags, nil
+	log.Printf("Successfully removed image: %s", imageName)
+	return report, nil
+}
 
+func (s *imageService) TagImage(ctx context.Context, source, target string) error {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	if err := s.cli.ImageTag(ctx, source, target); err != nil {
+		return fmt.Errorf("failed to tag image: %w", err)
+	}
+	log.Printf("Tagged image %s as %s", source, target)
+	return nil
 }
 
-func (s *imageService) RemoveImage(imageName string) error {
+// UntagImage removes a single repo:tag reference. The image itself is only
+// deleted by the daemon when the reference was the last one pointing at it,
+// and untagged parent layers are kept.
+func (s *imageService) UntagImage(ctx context.Context, reference string) ([]image.DeleteResponse, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
 
-	_, err := s.cli.ImageRemove(context.Background(), imageName, image.RemoveOptions{
-		Force:         false, // Consider adding force option
-		PruneChildren: true,
+	tag, err := s.tagOf(ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+	report, err := s.cli.ImageRemove(ctx, tag, image.RemoveOptions{
+		Force:         false,
+		PruneChildren: false,
 	})
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to untag image: %w", err)
 	}
-	log.Printf("Successfully removed image: %s", imageName)
-	return nil
+	log.Printf("Untagged image: %s", tag)
+	return report, nil
+}
+
+// RetagImage moves a tag: target is added first so the image always keeps at
+// least one reference, then source is untagged. Both are checked before
+// anything changes, so a source that is an image ID, a digest or the target
+// itself is rejected rather than leaving the image half moved or removed.
+func (s *imageService) RetagImage(ctx context.Context, source, target string) ([]image.DeleteResponse, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	tag, err := s.tagOf(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	named, err := normalizeTag(target)
+	if err != nil {
+		return nil, err
+	}
+	if named == tag {
+		return nil, errdefs.InvalidParameter(fmt.Errorf("%s is already tagged %s", source, target))
+	}
+
+	if err := s.TagImage(ctx, tag, named); err != nil {
+		return nil, err
+	}
+	return s.UntagImage(ctx, tag)
 }
 
-func (s *imageService) SearchImages(query string) ([]string, error) {
-	images, err := s.GetImages()
+func (s *imageService) SearchImages(ctx context.Context, query string) ([]string, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	images, err := s.GetImages(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -108,8 +199,11 @@ func (s *imageService) SearchImages(query string) ([]string, error) {
 
 }
 
-func (s *imageService) DeleteUnusedImages() error {
-	images, err := s.cli.ImageList(context.Background(), image.ListOptions{
+func (s *imageService) DeleteUnusedImages(ctx context.Context) error {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpPrune)
+	defer cancel()
+
+	images, err := s.cli.ImageList(ctx, image.ListOptions{
 		All: true, // Include dangling images
 	})
 	if err != nil {
@@ -118,7 +212,7 @@ func (s *imageService) DeleteUnusedImages() error {
 
 	for _, image := range images {
 		if len(image.RepoTags) == 0 || (len(image.RepoTags) == 1 && image.RepoTags[0] == "<none>:<none>") { // Check for dangling images
-			if err := s.RemoveImage(image.ID); err != nil { // Use image ID for removal
+			if _, err := s.RemoveImage(ctx, image.ID, false); err != nil { // Use image ID for removal
 				log.Printf("Failed to delete image %s: %v", image.ID, err)
 			} else {
 				log.Printf("Deleted unused image: %s", image.ID)
@@ -128,9 +222,11 @@ func (s *imageService) DeleteUnusedImages() error {
 	return nil
 }
 
-func (s *imageService) GetImageHistory(imageName string) ([]image.HistoryResponseItem, error) {
+func (s *imageService) GetImageHistory(ctx context.Context, imageName string) ([]image.HistoryResponseItem, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
 
-	history, err := s.cli.ImageHistory(context.Background(), imageName)
+	history, err := s.cli.ImageHistory(ctx, imageName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get image history: %w", err)
 	}
@@ -138,6 +234,9 @@ func (s *imageService) GetImageHistory(imageName string) ([]image.HistoryRespons
 }
 
 func (s *imageService) BuildImage(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (string, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpBuild)
+	defer cancel()
+
 	response, err := s.cli.ImageBuild(ctx, buildContext, options)
 	if err != nil {
 		return "", fmt.Errorf("failed to build image: %w", err)
@@ -160,23 +259,17 @@ func (s *imageService) BuildImage(ctx context.Context, buildContext io.Reader, o
 	return imageID, nil
 }
 
+// PullImage pulls imageName:tag under the signing policy
 func (s *imageService) PullImage(ctx context.Context, imageName, tag string) error {
-	imageRef := fmt.Sprintf("%s:%s", imageName, tag)
-	response, err := s.cli.ImagePull(ctx, imageRef, image.PullOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to pull image: %w", err)
-	}
-	defer response.Close()
-
-	// Print output for logging
-	scanner := bufio.NewScanner(response)
-	for scanner.Scan() {
-		log.Println(scanner.Text())
-	}
-	return nil
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpPull)
+	defer cancel()
+	return s.verifier.Pull(ctx, s.cli, fmt.Sprintf("%s:%s", imageName, tag))
 }
 
 func (s *imageService) PushImage(ctx context.Context, imageName string, options image.PushOptions) error {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpPush)
+	defer cancel()
+
 	response, err := s.cli.ImagePush(ctx, imageName, options)
 	if err != nil {
 		return fmt.Errorf("failed to push image: %w", err)
@@ -191,27 +284,130 @@ func (s *imageService) PushImage(ctx context.Context, imageName string, options
 	return nil
 }
 
-func (s *imageService) SaveImage(ctx context.Context, imageNames []string, outputFile string) error {
+// SaveImage returns the `docker save` tarball for imageNames, compressed
+// with the given format. The save runs on ctx, bounded by the save timeout,
+// and ends when the caller closes the returned stream.
+func (s *imageService) SaveImage(ctx context.Context, imageNames []string, compression string) (io.ReadCloser, error) {
+	if _, err := compressionExtension(compression); err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpSave)
 	response, err := s.cli.ImageSave(ctx, imageNames)
 	if err != nil {
-		return fmt.Errorf("failed to save images: %w", err)
+		cancel()
+		return nil, fmt.Errorf("failed to save images: %w", err)
 	}
-	defer response.Close()
+	stream, err := compressStream(response, compression)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return docker.CancelOnClose(stream, cancel), nil
+}
 
-	file, err := os.Create(outputFile)
+// ExportImage writes the `docker save` tarball for imageNames into the
+// configured export directory and returns the path written. filename must be
+// a plain file name; the compression extension is appended when missing and
+// existing files are never overwritten.
+func (s *imageService) ExportImage(ctx context.Context, imageNames []string, filename, compression string) (string, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpSave)
+	defer cancel()
+
+	if s.exportDir == "" {
+		return "", errors.New("server-side export is disabled")
+	}
+	if !exportFilenamePattern.MatchString(filename) {
+		return "", fmt.Errorf("invalid export filename %q", filename)
+	}
+	ext, err := compressionExtension(compression)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(filename, ext) {
+		filename += ext
+	}
+	outputFile := filepath.Join(s.exportDir, filename)
+
+	file, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return "", fmt.Errorf("failed to create file: %w", err)
 	}
 	defer file.Close()
 
-	if _, err := io.Copy(file, response); err != nil {
-		return fmt.Errorf("failed to write image tar: %w", err)
+	stream, err := s.SaveImage(ctx, imageNames, compression)
+	if err != nil {
+		os.Remove(outputFile)
+		return "", err
+	}
+	defer stream.Close()
+
+	if _, err := io.Copy(file, stream); err != nil {
+		os.Remove(outputFile)
+		return "", fmt.Errorf("failed to write image tar: %w", err)
 	}
 	log.Printf("Images saved to %s", outputFile)
-	return nil
+	return outputFile, nil
+}
+
+// LoadImage streams a `docker save` tarball into the daemon and returns the
+// references (repo:tag, or image ID for untagged images) that were loaded.
+func (s *imageService) LoadImage(ctx context.Context, input io.Reader) ([]string, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpLoad)
+	defer cancel()
+
+	response, err := s.cli.ImageLoad(ctx, input, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	defer response.Body.Close()
+
+	var loaded []string
+	err = decodeMessages(response.Body, func(msg jsonmessage.JSONMessage) {
+		line := strings.TrimSpace(msg.Stream)
+		for _, prefix := range []string{"Loaded image: ", "Loaded image ID: "} {
+			if ref, ok := strings.CutPrefix(line, prefix); ok {
+				loaded = append(loaded, ref)
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	log.Printf("Loaded images: %s", strings.Join(loaded, ", "))
+	return loaded, nil
+}
+
+// ImportImage creates an image from a root filesystem tarball, optionally
+// tagging it as ref and applying Dockerfile `changes`. It returns the new
+// image ID.
+func (s *imageService) ImportImage(ctx context.Context, input io.Reader, ref string, options image.ImportOptions) (string, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpLoad)
+	defer cancel()
+
+	source := image.ImportSource{Source: input, SourceName: "-"}
+	response, err := s.cli.ImageImport(ctx, source, ref, options)
+	if err != nil {
+		return "", fmt.Errorf("failed to import image: %w", err)
+	}
+	defer response.Close()
+
+	var imageID string
+	err = decodeMessages(response, func(msg jsonmessage.JSONMessage) {
+		if strings.HasPrefix(msg.Status, "sha256:") {
+			imageID = msg.Status
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to import image: %w", err)
+	}
+	log.Printf("Imported image %s as %s", imageID, ref)
+	return imageID, nil
 }
 
 func (s *imageService) PruneImages(ctx context.Context) (image.PruneReport, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpPrune)
+	defer cancel()
+
 	report, err := s.cli.ImagesPrune(ctx, filters.Args{})
 	if err != nil {
 		return image.PruneReport{}, fmt.Errorf("failed to prune images: %w", err)
@@ -219,14 +415,68 @@ func (s *imageService) PruneImages(ctx context.Context) (image.PruneReport, erro
 	return report, nil
 }
 
-func (s *imageService) InspectImage(imageName string) (*types.ImageInspect, error) {
-	inspect, _, err := s.cli.ImageInspectWithRaw(context.Background(), imageName)
+func (s *imageService) InspectImage(ctx context.Context, imageName string) (*types.ImageInspect, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	inspect, _, err := s.cli.ImageInspectWithRaw(ctx, imageName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to inspect image: %w", err)
 	}
 	return &inspect, nil
 }
 
+// decodeMessages reads a daemon JSON message stream, calling fn for each
+// message and returning the first error the daemon reported.
+func decodeMessages(r io.Reader, fn func(jsonmessage.JSONMessage)) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if msg.ErrorMessage != "" {
+			return errors.New(msg.ErrorMessage)
+		}
+		fn(msg)
+	}
+}
+
+// tagOf returns reference in its familiar repo:tag form after checking it
+// names a tag of an existing image, rather than an image ID or a digest
+func (s *imageService) tagOf(ctx context.Context, reference string) (string, error) {
+	inspect, _, err := s.cli.ImageInspectWithRaw(ctx, reference)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image: %w", err)
+	}
+	tag, err := normalizeTag(reference)
+	if err == nil && slices.ContainsFunc(inspect.RepoTags, func(repoTag string) bool {
+		named, err := normalizeTag(repoTag)
+		return err == nil && named == tag
+	}) {
+		return tag, nil
+	}
+	return "", errdefs.InvalidParameter(fmt.Errorf("%s is not a tag of image %s", reference, inspect.ID))
+}
+
+// normalizeTag returns the familiar repo:tag form of reference, with the tag
+// defaulting to latest, so docker.io/library/app:latest and app compare equal
+func normalizeTag(reference string) (string, error) {
+	named, err := distref.ParseNormalizedNamed(reference)
+	if err != nil {
+		return "", errdefs.InvalidParameter(err)
+	}
+	if _, ok := named.(distref.Digested); ok {
+		return "", errdefs.InvalidParameter(fmt.Errorf("%s is a digest, not a tag", reference))
+	}
+	return distref.FamiliarString(distref.TagNameOnly(named)), nil
+}
+
 // Helper function for case-insensitive string match
 func containsCaseInsensitive(str, substr string) bool {
 	return strings.Contains(strings.ToLower(str), strings.ToLower(substr))
@@ -0,0 +1,252 @@
+package images
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/registry/registrytest"
+	"github.com/genc-murat/harborview/internal/signatures"
+	"github.com/genc-murat/harborview/pkg/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// fakeDaemon records the Docker API requests it answers. Pulls stream a
+// single progress message, saves stream one chunk and then hang until the
+// client goes, inspects answer from images, removals report the name
+// untagged, and everything else succeeds empty.
+type fakeDaemon struct {
+	mu       sync.Mutex
+	requests []string
+
+	// images maps the names an image can be inspected by to its tags
+	images map[string][]string
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Strip the /v1.xx version prefix
+	path := r.URL.Path
+	if i := strings.Index(path[1:], "/"); strings.HasPrefix(path, "/v1.") && i > 0 {
+		path = path[i+1:]
+	}
+	d.mu.Lock()
+	d.requests = append(d.requests, r.Method+" "+path+"?"+r.URL.RawQuery)
+	d.mu.Unlock()
+
+	if name, ok := strings.CutSuffix(strings.TrimPrefix(path, "/images/"), "/json"); ok && r.Method == "GET" {
+		repoTags, found := d.images[name]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such image: ` + name + `"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(types.ImageInspect{ID: "sha256:0123abcd", RepoTags: repoTags})
+		return
+	}
+
+	if name, ok := strings.CutPrefix(path, "/images/"); ok && r.Method == "DELETE" {
+		json.NewEncoder(w).Encode([]image.DeleteResponse{{Untagged: name}})
+		return
+	}
+
+	switch path {
+	case "/images/create":
+		w.Write([]byte(`{"status":"Pulled"}` + "\n"))
+		return
+	case "/images/get":
+		w.Write([]byte("tarball"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (d *fakeDaemon) calls() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.requests...)
+}
+
+func newFakeDaemon(t *testing.T) (*fakeDaemon, *client.Client) {
+	t.Helper()
+	daemon := &fakeDaemon{}
+	server := httptest.NewServer(daemon)
+	t.Cleanup(server.Close)
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")), client.WithVersion("1.45"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return daemon, cli
+}
+
+func TestPullImageEnforcesSigning(t *testing.T) {
+	reg, server := registrytest.NewServer()
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	trusted, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	untrusted, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	platform := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	signed := reg.PushImage("team/app", "signed", platform, time.Now(), []byte("signed"))
+	reg.SignImage("team/app", signed.Digest.String(), trusted)
+	reg.PushImage("team/app", "unsigned", platform, time.Now(), []byte("unsigned"))
+	other := reg.PushImage("team/app", "other-key", platform, time.Now(), []byte("other"))
+	reg.SignImage("team/app", other.Digest.String(), untrusted)
+
+	der, _ := x509.MarshalPKIXPublicKey(trusted.Public())
+	var cfg config.Config
+	cfg.Registry.BaseURL = server.URL
+	cfg.Signing.Policy = signatures.PolicyEnforce
+	cfg.Signing.PublicKeys = []string{string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))}
+
+	tests := []struct {
+		tag      string
+		rejected bool
+	}{
+		{"signed", false},
+		{"unsigned", true},
+		{"other-key", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			daemon, cli := newFakeDaemon(t)
+			service, err := NewImageService(cfg, cli)
+			if err != nil {
+				t.Fatalf("NewImageService: %v", err)
+			}
+
+			err = service.PullImage(context.Background(), host+"/team/app", tt.tag)
+			calls := daemon.calls()
+			if tt.rejected {
+				if !signatures.IsPolicyViolation(err) {
+					t.Errorf("PullImage = %v, want a policy violation", err)
+				}
+				if len(calls) != 0 {
+					t.Errorf("the daemon was called for a rejected image: %v", calls)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("PullImage: %v", err)
+			}
+			// The verified digest is pulled and then tagged with the name asked for
+			if len(calls) != 2 || !strings.Contains(calls[0], "/images/create?") || !strings.Contains(calls[0], strings.Replace(signed.Digest.String(), ":", "%3A", 1)) {
+				t.Fatalf("daemon calls = %v, want a pull of the signed digest", calls)
+			}
+			if !strings.Contains(calls[1], "/tag?") || !strings.Contains(calls[1], "tag=signed") {
+				t.Errorf("daemon calls = %v, want the pinned image tagged", calls)
+			}
+		})
+	}
+}
+
+func TestSaveImageFollowsContext(t *testing.T) {
+	_, cli := newFakeDaemon(t)
+	service, err := NewImageService(config.Config{}, cli)
+	if err != nil {
+		t.Fatalf("NewImageService: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := service.SaveImage(ctx, []string{"app:1"}, CompressionNone)
+	if err != nil {
+		t.Fatalf("SaveImage: %v", err)
+	}
+	defer stream.Close()
+	chunk := make([]byte, len("tarball"))
+	if _, err := io.ReadFull(stream, chunk); err != nil {
+		t.Fatalf("reading the tarball: %v", err)
+	}
+
+	// Cancelling the caller's context ends a save the daemon is still sending
+	cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(stream)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("save ended cleanly after its context was cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("save kept running after its context was cancelled")
+	}
+}
+
+func TestRetagImage(t *testing.T) {
+	daemon, cli := newFakeDaemon(t)
+	tags := []string{"team/app:1.0", "team/app:latest"}
+	daemon.images = map[string][]string{
+		"team/app:1.0":              tags,
+		"team/app":                  tags,
+		"docker.io/team/app:latest": tags,
+		"team/app:latest":           tags,
+		"0123abcd":                  tags,
+		"team/app@sha256:" + strings.Repeat("a", 64): tags,
+	}
+	service, err := NewImageService(config.Config{}, cli)
+	if err != nil {
+		t.Fatalf("NewImageService: %v", err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		source, target string
+		check          func(error) bool
+	}{
+		{"same tag", "team/app:1.0", "team/app:1.0", errdefs.IsInvalidParameter},
+		{"same tag, another form", "docker.io/team/app:latest", "team/app:latest", errdefs.IsInvalidParameter},
+		{"image ID", "0123abcd", "team/app:2.0", errdefs.IsInvalidParameter},
+		{"digest", "team/app@sha256:" + strings.Repeat("a", 64), "team/app:2.0", errdefs.IsInvalidParameter},
+		{"bad target", "team/app:1.0", "Team/App:2.0", errdefs.IsInvalidParameter},
+		{"missing image", "team/other:1.0", "team/other:2.0", errdefs.IsNotFound},
+	}
+	for _, tt := range tests {
+		before := len(daemon.calls())
+		if _, err := service.RetagImage(ctx, tt.source, tt.target); !tt.check(err) {
+			t.Errorf("%s: RetagImage = %v", tt.name, err)
+		}
+		// Nothing is tagged or removed once a retag is rejected
+		for _, call := range daemon.calls()[before:] {
+			if !strings.HasPrefix(call, "GET ") {
+				t.Errorf("%s: rejected retag called %s", tt.name, call)
+			}
+		}
+	}
+
+	before := len(daemon.calls())
+	if _, err := service.RetagImage(ctx, "team/app", "team/app:stable"); err != nil {
+		t.Fatalf("RetagImage: %v", err)
+	}
+	var changes []string
+	for _, call := range daemon.calls()[before:] {
+		if !strings.HasPrefix(call, "GET ") {
+			changes = append(changes, call)
+		}
+	}
+	want := []string{"POST /images/team/app:latest/tag?repo=team%2Fapp&tag=stable", "DELETE /images/team/app:latest?noprune=1"}
+	if len(changes) != 2 || changes[0] != want[0] || changes[1] != want[1] {
+		t.Errorf("daemon calls = %q, want %q", changes, want)
+	}
+}
@@ -0,0 +1,77 @@
+package images
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/genc-murat/harborview/internal/fsutil"
+)
+
+// reportStore keeps JSON reports keyed by kind and image digest. Reports are
+// written under dir/<kind>/<digest>.json when dir is set and kept in memory
+// otherwise, so they survive restarts only with a directory configured.
+type reportStore struct {
+	dir string
+
+	mu     sync.Mutex
+	memory map[string][]byte
+}
+
+func newReportStore(dir string) *reportStore {
+	return &reportStore{dir: dir, memory: make(map[string][]byte)}
+}
+
+// load decodes the stored report into out, reporting whether one existed
+func (s *reportStore) load(kind, digest string, out any) (bool, error) {
+	data, err := s.read(kind, digest)
+	if err != nil || data == nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return false, fmt.Errorf("failed to decode stored %s report: %w", kind, err)
+	}
+	return true, nil
+}
+
+// save stores report under kind and digest, replacing any previous one
+func (s *reportStore) save(kind, digest string, report any) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		s.memory[kind+"/"+digest] = data
+		return nil
+	}
+
+	if err := fsutil.WriteFileAtomic(s.path(kind, digest), data); err != nil {
+		return fmt.Errorf("failed to store %s report: %w", kind, err)
+	}
+	return nil
+}
+
+func (s *reportStore) read(kind, digest string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		return s.memory[kind+"/"+digest], nil
+	}
+	data, err := os.ReadFile(s.path(kind, digest))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// path maps "sha256:abc" to dir/kind/sha256-abc.json
+func (s *reportStore) path(kind, digest string) string {
+	name := strings.NewReplacer(":", "-", "/", "-").Replace(digest)
+	return filepath.Join(s.dir, kind, name+".json")
+}
@@ -0,0 +1,198 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/internal/registry"
+)
+
+// TagList is one page of the tags a repository has in its registry
+type TagList struct {
+	Repository string      `json:"repository"`
+	Registry   string      `json:"registry"`
+	Tags       []RemoteTag `json:"tags"`
+	Next       string      `json:"next,omitempty"`
+}
+
+// RemoteTag is a registry tag and whether it is already pulled. UpToDate is
+// true when the local copy was pulled from the tag's current digest.
+type RemoteTag struct {
+	Tag       string   `json:"tag"`
+	Digest    string   `json:"digest"`
+	Platforms []string `json:"platforms"`
+	Local     bool     `json:"local"`
+	UpToDate  bool     `json:"upToDate"`
+	Error     string   `json:"error,omitempty"`
+}
+
+const (
+	// MaxTagPage caps the tags listed per page, since every tag costs a
+	// registry request
+	MaxTagPage = 100
+	// tagLookups is how many tags of a page are resolved at once
+	tagLookups = 4
+	// platformCacheSize bounds the cached platforms of manifest digests
+	platformCacheSize = 4096
+)
+
+// platformCache holds the platforms of manifests by registry, repository
+// and digest. Digests name immutable content, so entries never go stale,
+// and a listing only needs a HEAD request for tags whose digest was seen
+// before. HEAD requests do not count against Docker Hub's pull limit.
+var platformCache = struct {
+	sync.Mutex
+	entries map[string][]string
+}{entries: map[string][]string{}}
+
+// GetTags lists the tags of imageName's repository in the registry it
+// belongs to: the configured registry for its host, Docker Hub for docker.io
+// names, or the image's own registry anonymously otherwise. Tags are
+// resolved a few at a time, and n is capped at MaxTagPage.
+func (s *imageService) GetTags(ctx context.Context, imageName string, n int, last string) (*TagList, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	ref, err := registry.ParseReference(imageName)
+	if err != nil {
+		return nil, errdefs.InvalidParameter(err)
+	}
+	client, err := s.registries.Client(ref.Domain)
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 || n > MaxTagPage {
+		n = MaxTagPage
+	}
+
+	tags, next, err := client.Tags(ctx, ref.Repository, n, last)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags for %s: %w", ref.Familiar, err)
+	}
+	localTags, localDigests, err := s.localReferences(ctx, ref.Familiar)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &TagList{Repository: ref.Familiar, Registry: client.Host(), Tags: make([]RemoteTag, len(tags)), Next: next}
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, tagLookups)
+	for i, tag := range tags {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			list.Tags[i] = s.remoteTag(ctx, client, ref.Repository, tag, localTags[tag], localDigests)
+		}()
+	}
+	wg.Wait()
+	return list, nil
+}
+
+// remoteTag resolves a tag's digest with a HEAD request, and its platforms
+// from the manifest unless they are cached
+func (s *imageService) remoteTag(ctx context.Context, client *registry.Client, repo, tag string, local bool, localDigests map[string]bool) RemoteTag {
+	remote := RemoteTag{Tag: tag, Platforms: []string{}, Local: local}
+	digest, err := client.ManifestDigest(ctx, repo, tag)
+	if err != nil {
+		remote.Error = err.Error()
+		return remote
+	}
+	remote.Digest = digest
+	remote.UpToDate = local && localDigests[digest]
+
+	key := client.Host() + "/" + repo + "@" + digest
+	platformCache.Lock()
+	platforms, ok := platformCache.entries[key]
+	platformCache.Unlock()
+	if ok {
+		remote.Platforms = platforms
+		return remote
+	}
+
+	manifest, err := client.Manifest(ctx, repo, digest)
+	if err != nil {
+		remote.Error = err.Error()
+		return remote
+	}
+	platforms, complete := s.manifestPlatforms(ctx, client, repo, manifest)
+	remote.Platforms = platforms
+	if complete {
+		platformCache.Lock()
+		if len(platformCache.entries) >= platformCacheSize {
+			clear(platformCache.entries)
+		}
+		platformCache.entries[key] = platforms
+		platformCache.Unlock()
+	}
+	return remote
+}
+
+// manifestPlatforms returns os/arch[/variant] for each image a manifest
+// covers. Single-image manifests need their config fetched; platforms that
+// cannot be determined are left out rather than failing the listing, and
+// complete is false then.
+func (s *imageService) manifestPlatforms(ctx context.Context, client *registry.Client, repo string, manifest *registry.Manifest) (platforms []string, complete bool) {
+	platforms = []string{}
+	if manifest.IsIndex() {
+		for _, descriptor := range manifest.Index.Manifests {
+			if descriptor.Platform == nil || descriptor.Platform.OS == "unknown" {
+				continue
+			}
+			platforms = append(platforms, platformString(descriptor.Platform.OS, descriptor.Platform.Architecture, descriptor.Platform.Variant))
+		}
+		return platforms, true
+	}
+
+	config, err := client.ImageConfig(ctx, repo, manifest.Manifest.Config.Digest.String())
+	if err != nil {
+		return platforms, false
+	}
+	return append(platforms, platformString(config.OS, config.Architecture, config.Variant)), true
+}
+
+// localReferences returns the tags and repo digests of locally stored images
+// of repository (in familiar form, e.g. "nginx")
+func (s *imageService) localReferences(ctx context.Context, repository string) (map[string]bool, map[string]bool, error) {
+	images, err := s.cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list local images: %w", err)
+	}
+
+	tags := make(map[string]bool)
+	digests := make(map[string]bool)
+	for _, img := range images {
+		for _, repoTag := range img.RepoTags {
+			if name, tag, ok := cutTag(repoTag); ok && name == repository {
+				tags[tag] = true
+			}
+		}
+		for _, repoDigest := range img.RepoDigests {
+			if name, digest, ok := strings.Cut(repoDigest, "@"); ok && name == repository {
+				digests[digest] = true
+			}
+		}
+	}
+	return tags, digests, nil
+}
+
+// cutTag splits "repo:tag", ignoring the colon of a registry port
+func cutTag(repoTag string) (name, tag string, ok bool) {
+	i := strings.LastIndex(repoTag, ":")
+	if i < 0 || strings.Contains(repoTag[i:], "/") {
+		return repoTag, "", false
+	}
+	return repoTag[:i], repoTag[i+1:], true
+}
+
+func platformString(os, architecture, variant string) string {
+	if variant != "" {
+		return os + "/" + architecture + "/" + variant
+	}
+	return os + "/" + architecture
+}
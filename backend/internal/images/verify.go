@@ -0,0 +1,26 @@
+package images
+
+import (
+	"context"
+
+	"github.com/docker/docker/client"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/internal/signatures"
+)
+
+// VerifyImage reports the signatures and attestations of imageName. Local
+// images are verified at the digest they were pulled at; names not present
+// locally are resolved in their registry.
+func (s *imageService) VerifyImage(ctx context.Context, imageName string) (*signatures.Result, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	inspect, _, err := s.cli.ImageInspectWithRaw(ctx, imageName)
+	if client.IsErrNotFound(err) {
+		return s.verifier.Verify(ctx, imageName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.verifier.VerifyLocal(ctx, imageName, inspect.RepoDigests)
+}
@@ -0,0 +1,227 @@
+package images
+
+import (
+	"strings"
+	"unicode"
+)
+
+// compareVersions orders two versions of a package type, returning -1, 0 or
+// 1. Debian versions use dpkg's algorithm; everything else uses a generic
+// comparison that handles semver, PEP 440 and apk closely enough for
+// vulnerability range checks.
+func compareVersions(packageType, a, b string) int {
+	if packageType == PackageDeb {
+		return compareDebian(a, b)
+	}
+	return compareGeneric(a, b)
+}
+
+// compareDebian implements dpkg's [epoch:]upstream[-revision] ordering
+func compareDebian(a, b string) int {
+	epochA, upstreamA, revisionA := splitDebian(a)
+	epochB, upstreamB, revisionB := splitDebian(b)
+	if c := compareNumeric(epochA, epochB); c != 0 {
+		return c
+	}
+	if c := compareDebianPart(upstreamA, upstreamB); c != 0 {
+		return c
+	}
+	return compareDebianPart(revisionA, revisionB)
+}
+
+func splitDebian(version string) (epoch, upstream, revision string) {
+	epoch = "0"
+	if before, after, ok := strings.Cut(version, ":"); ok {
+		epoch, version = before, after
+	}
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		return epoch, version[:i], version[i+1:]
+	}
+	return epoch, version, ""
+}
+
+// compareDebianPart alternates between non-digit runs, compared with dpkg's
+// character order (~ sorts before everything, letters before symbols), and
+// digit runs, compared numerically.
+func compareDebianPart(a, b string) int {
+	for a != "" || b != "" {
+		var nonDigitA, nonDigitB string
+		nonDigitA, a = splitRun(a, false)
+		nonDigitB, b = splitRun(b, false)
+		if c := compareDebianText(nonDigitA, nonDigitB); c != 0 {
+			return c
+		}
+
+		var digitA, digitB string
+		digitA, a = splitRun(a, true)
+		digitB, b = splitRun(b, true)
+		if c := compareNumeric(digitA, digitB); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareDebianText(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var ca, cb int
+		if i < len(a) {
+			ca = debianOrder(a[i])
+		}
+		if i < len(b) {
+			cb = debianOrder(b[i])
+		}
+		if ca != cb {
+			return sign(ca - cb)
+		}
+	}
+	return 0
+}
+
+func debianOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case unicode.IsLetter(rune(c)):
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+// prereleaseMarkers are alphabetic segments that sort before the release
+// they precede, e.g. 1.0rc1 < 1.0 and 1.0.0-beta.2 < 1.0.0
+var prereleaseMarkers = map[string]bool{
+	"alpha": true, "a": true, "beta": true, "b": true, "c": true, "rc": true,
+	"pre": true, "preview": true, "dev": true, "snapshot": true,
+}
+
+// versionToken is a numeric or alphabetic run of a version
+type versionToken struct {
+	text string
+	// patch marks a single letter right after a number and not followed by
+	// one, such as the c of OpenSSL's 1.1.1c or Alpine's 1.1.1w-r1. Such
+	// letters are patch suffixes, newer than the bare number.
+	patch bool
+}
+
+func (t versionToken) prerelease() bool {
+	return !t.patch && prereleaseMarkers[t.text]
+}
+
+// compareGeneric compares versions token by token, numeric runs as numbers
+// and alphabetic runs as text. When one version runs out, the longer one is
+// older if its next token is a pre-release marker and newer otherwise, so
+// 1.2_rc1 < 1.2 < 1.2-r1 < 1.2.1 and 1.1.1 < 1.1.1c < 1.1.1d.
+func compareGeneric(a, b string) int {
+	tokensA := versionTokens(a)
+	tokensB := versionTokens(b)
+	for i := 0; i < len(tokensA) && i < len(tokensB); i++ {
+		ta, tb := tokensA[i], tokensB[i]
+		numericA, numericB := isDigits(ta.text), isDigits(tb.text)
+		switch {
+		case numericA && numericB:
+			if c := compareNumeric(ta.text, tb.text); c != 0 {
+				return c
+			}
+		case numericA:
+			// 1.0.1 > 1.0rc1 and 1.2.1 > 1.2-r1: a number outranks the
+			// suffix of a shorter version
+			return 1
+		case numericB:
+			return -1
+		case ta.patch != tb.patch:
+			// 1.1.1a-r0 > 1.1.1-r5: a patch letter outranks a suffix
+			if ta.patch {
+				return 1
+			}
+			return -1
+		default:
+			if c := strings.Compare(ta.text, tb.text); c != 0 {
+				return c
+			}
+		}
+	}
+
+	switch {
+	case len(tokensA) > len(tokensB):
+		if tokensA[len(tokensB)].prerelease() {
+			return -1
+		}
+		return 1
+	case len(tokensA) < len(tokensB):
+		if tokensB[len(tokensA)].prerelease() {
+			return 1
+		}
+		return -1
+	}
+	return 0
+}
+
+// versionTokens splits a version into lower-cased alphanumeric runs,
+// dropping a leading "v" and any "+build" metadata. A letter directly after
+// a number is a patch suffix unless digits follow it, as in PEP 440's
+// 1.0a1 pre-releases.
+func versionTokens(version string) []versionToken {
+	version = strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(version, "v"), "V"))
+	version, _, _ = strings.Cut(version, "+")
+
+	var tokens []versionToken
+	afterNumber := false
+	for version != "" {
+		c := version[0]
+		switch {
+		case c >= '0' && c <= '9':
+			var run string
+			run, version = splitRun(version, true)
+			tokens = append(tokens, versionToken{text: run})
+			afterNumber = true
+		case c >= 'a' && c <= 'z':
+			end := strings.IndexFunc(version, func(r rune) bool { return r < 'a' || r > 'z' })
+			if end < 0 {
+				end = len(version)
+			}
+			digitNext := end < len(version) && version[end] >= '0' && version[end] <= '9'
+			tokens = append(tokens, versionToken{text: version[:end], patch: afterNumber && end == 1 && !digitNext})
+			version = version[end:]
+			afterNumber = false
+		default:
+			version = version[1:]
+			afterNumber = false
+		}
+	}
+	return tokens
+}
+
+// splitRun splits s after its leading run of digits (or non-digits)
+func splitRun(s string, digits bool) (run, rest string) {
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9') == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareNumeric compares digit strings of any length
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return sign(len(a) - len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package images
+
+import "testing"
+
+func TestCompareGeneric(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2.3+build.5", "1.2.3", 0},
+		{"1.2.10", "1.2.9", 1},
+		{"1.2", "1.2.1", -1},
+		{"1.0.0-beta.2", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+		{"1.0rc1", "1.0", -1},
+		{"1.0rc1", "1.0.1", -1},
+		{"1.2_rc1", "1.2", -1},
+		{"1.2", "1.2-r1", -1},
+		{"1.2-r1", "1.2.1", -1},
+		// PEP 440 pre-releases
+		{"1.0a1", "1.0", -1},
+		{"1.0b2", "1.0", -1},
+		{"1.0a1", "1.0b1", -1},
+		// OpenSSL-style patch letters are newer than the bare release
+		{"1.1.1c", "1.1.1", 1},
+		{"1.1.1", "1.1.1c", -1},
+		{"1.1.1c", "1.1.1d", -1},
+		{"1.1.1w", "1.1.1c", 1},
+		{"1.1.1w-r1", "1.1.1w-r0", 1},
+		{"1.1.1a-r0", "1.1.1-r5", 1},
+		{"1.0.2k", "1.1.0", -1},
+	}
+	for _, tt := range tests {
+		if got := compareGeneric(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareGeneric(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCompareDebian(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0-1", "1.0-1", 0},
+		{"1.0-1", "1.0-2", -1},
+		{"1:0.9", "2.0", 1},
+		{"1.0~rc1-1", "1.0-1", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0a", "1.0+", -1},
+		{"2.36-9+deb12u4", "2.36-9+deb12u10", -1},
+		{"3.0.11-1~deb12u2", "3.0.11-1", -1},
+		{"1.2.3", "1.2.3-0", 0},
+		{"10.0", "9.9", 1},
+	}
+	for _, tt := range tests {
+		if got := compareDebian(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareDebian(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package images
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severity levels, from CVSS v3 qualitative ratings
+const (
+	SeverityCritical = "CRITICAL"
+	SeverityHigh     = "HIGH"
+	SeverityMedium   = "MEDIUM"
+	SeverityLow      = "LOW"
+	SeverityUnknown  = "UNKNOWN"
+)
+
+// osvEntry is the subset of the OSV schema (https://ossf.github.io/osv-schema/)
+// used for matching
+type osvEntry struct {
+	ID       string   `json:"id"`
+	Summary  string   `json:"summary"`
+	Details  string   `json:"details"`
+	Aliases  []string `json:"aliases"`
+	Severity []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	Affected         []osvAffected `json:"affected"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges []struct {
+		Type   string              `json:"type"`
+		Events []map[string]string `json:"events"`
+	} `json:"ranges"`
+	Versions          []string `json:"versions"`
+	EcosystemSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"ecosystem_specific"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+// vulnIndex holds every OSV entry that affects a package, keyed by
+// ecosystem family and normalised package name
+type vulnIndex map[string][]*osvEntry
+
+// VulnDB is an offline vulnerability database read from OSV JSON. The path
+// may be a single JSON file (one entry, an array of entries or an object with
+// a "vulnerabilities" array), an osv.dev all.zip export, or a directory of
+// either. The database is reloaded when the path's modification time changes.
+type VulnDB struct {
+	path string
+
+	mu       sync.RWMutex
+	index    vulnIndex
+	entries  int
+	modTime  time.Time
+	loadedAt time.Time
+}
+
+// VulnDBStatus describes the loaded database
+type VulnDBStatus struct {
+	Path     string    `json:"path"`
+	Entries  int       `json:"entries"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
+// NewVulnDB creates a database backed by path. Nothing is read until the
+// first lookup.
+func NewVulnDB(path string) *VulnDB {
+	return &VulnDB{path: path}
+}
+
+// vulnDBs holds the databases shared by every service scanning with the same
+// file, so per-endpoint services load it once
+var (
+	vulnDBsMu sync.Mutex
+	vulnDBs   = make(map[string]*VulnDB)
+)
+
+func sharedVulnDB(path string) *VulnDB {
+	vulnDBsMu.Lock()
+	defer vulnDBsMu.Unlock()
+	db, ok := vulnDBs[path]
+	if !ok {
+		db = NewVulnDB(path)
+		vulnDBs[path] = db
+	}
+	return db
+}
+
+// Status returns what is currently loaded
+func (db *VulnDB) Status() VulnDBStatus {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return VulnDBStatus{Path: db.path, Entries: db.entries, LoadedAt: db.loadedAt}
+}
+
+// refresh (re)loads the database when the file changed since the last load
+func (db *VulnDB) refresh() error {
+	if db.path == "" {
+		return errors.New("no vulnerability database is configured")
+	}
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return fmt.Errorf("failed to open vulnerability database: %w", err)
+	}
+
+	db.mu.RLock()
+	current := db.index != nil && info.ModTime().Equal(db.modTime)
+	db.mu.RUnlock()
+	if current {
+		return nil
+	}
+
+	index := make(vulnIndex)
+	entries := 0
+	add := func(entry *osvEntry) {
+		entries++
+		seen := make(map[string]bool)
+		for _, affected := range entry.Affected {
+			key := vulnKey(affected.Package.Ecosystem, affected.Package.Name)
+			if !seen[key] {
+				seen[key] = true
+				index[key] = append(index[key], entry)
+			}
+		}
+	}
+	if info.IsDir() {
+		err = filepath.WalkDir(db.path, func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			return loadOSVFile(name, add)
+		})
+	} else {
+		err = loadOSVFile(db.path, add)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load vulnerability database: %w", err)
+	}
+
+	db.mu.Lock()
+	db.index, db.entries, db.modTime, db.loadedAt = index, entries, info.ModTime(), time.Now()
+	db.mu.Unlock()
+	return nil
+}
+
+// loadOSVFile reads OSV entries from a .json or .zip file; other files are
+// ignored so a database directory may hold READMEs and the like
+func loadOSVFile(name string, add func(*osvEntry)) error {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return decodeOSV(data, add)
+	case ".zip":
+		archive, err := zip.OpenReader(name)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+		for _, file := range archive.File {
+			if !strings.HasSuffix(file.Name, ".json") {
+				continue
+			}
+			r, err := file.Open()
+			if err != nil {
+				return err
+			}
+			data, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+			if err := decodeOSV(data, add); err != nil {
+				return fmt.Errorf("%s: %w", file.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeOSV accepts a single entry, an array of entries or an object with a
+// "vulnerabilities" array
+func decodeOSV(data []byte, add func(*osvEntry)) error {
+	var entries []*osvEntry
+	trimmed := strings.TrimSpace(string(data))
+	switch {
+	case strings.HasPrefix(trimmed, "["):
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return err
+		}
+	default:
+		var wrapper struct {
+			Vulnerabilities []*osvEntry `json:"vulnerabilities"`
+		}
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return err
+		}
+		entries = wrapper.Vulnerabilities
+		if entries == nil {
+			var single osvEntry
+			if err := json.Unmarshal(data, &single); err != nil {
+				return err
+			}
+			entries = []*osvEntry{&single}
+		}
+	}
+	for _, entry := range entries {
+		if entry.ID != "" {
+			add(entry)
+		}
+	}
+	return nil
+}
+
+// Match returns the findings for pkg
+func (db *VulnDB) Match(pkg Package) []Finding {
+	if pkg.Ecosystem == "" {
+		return nil
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// OSV indexes Debian by source package and Alpine by origin
+	names := []string{pkg.Name}
+	if pkg.Source != "" && pkg.Source != pkg.Name {
+		names = append(names, pkg.Source)
+	}
+
+	var findings []Finding
+	seen := make(map[string]bool)
+	for _, name := range names {
+		for _, entry := range db.index[vulnKey(pkg.Ecosystem, name)] {
+			if seen[entry.ID] {
+				continue
+			}
+			for _, affected := range entry.Affected {
+				if vulnKey(affected.Package.Ecosystem, affected.Package.Name) != vulnKey(pkg.Ecosystem, name) ||
+					!ecosystemMatches(affected.Package.Ecosystem, pkg.Ecosystem) {
+					continue
+				}
+				vulnerable, fixed := affected.affects(pkg.Type, pkg.Version)
+				if !vulnerable {
+					continue
+				}
+				seen[entry.ID] = true
+				severity, score := entry.severity(affected)
+				findings = append(findings, Finding{
+					ID:           entry.ID,
+					Aliases:      entry.Aliases,
+					Summary:      entry.Summary,
+					Severity:     severity,
+					Score:        score,
+					Package:      pkg.Name,
+					Version:      pkg.Version,
+					Type:         pkg.Type,
+					Ecosystem:    pkg.Ecosystem,
+					FixedVersion: fixed,
+					Location:     pkg.Location,
+					PURL:         pkg.PURL,
+				})
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// affects reports whether version falls in one of the affected ranges and
+// the first fixed version of the range it falls in
+func (a osvAffected) affects(packageType, version string) (bool, string) {
+	for _, listed := range a.Versions {
+		if listed == version {
+			return true, ""
+		}
+	}
+
+	for _, r := range a.Ranges {
+		if r.Type != "ECOSYSTEM" && r.Type != "SEMVER" {
+			continue // GIT ranges need commit history
+		}
+		affected, fixed := false, ""
+		for _, event := range r.Events {
+			switch {
+			case event["introduced"] != "":
+				introduced := event["introduced"]
+				if introduced == "0" || compareVersions(packageType, version, introduced) >= 0 {
+					affected = true
+				}
+			case event["fixed"] != "":
+				if compareVersions(packageType, version, event["fixed"]) >= 0 {
+					affected = false
+				} else if affected && fixed == "" {
+					fixed = event["fixed"]
+				}
+			case event["last_affected"] != "":
+				if compareVersions(packageType, version, event["last_affected"]) > 0 {
+					affected = false
+				}
+			}
+		}
+		if affected {
+			return true, fixed
+		}
+	}
+	return false, ""
+}
+
+// severity picks the most specific rating available: the ecosystem's own,
+// the database's, then one computed from a CVSS v3 vector
+func (e *osvEntry) severity(affected osvAffected) (string, float64) {
+	var score float64
+	for _, s := range e.Severity {
+		if strings.HasPrefix(s.Type, "CVSS_V3") {
+			if computed, ok := cvss3BaseScore(s.Score); ok && computed > score {
+				score = computed
+			}
+		}
+	}
+	for _, rated := range []string{affected.EcosystemSpecific.Severity, affected.DatabaseSpecific.Severity, e.DatabaseSpecific.Severity} {
+		if severity := normalizeSeverity(rated); severity != SeverityUnknown {
+			return severity, score
+		}
+	}
+	if score > 0 {
+		return severityForScore(score), score
+	}
+	return SeverityUnknown, 0
+}
+
+func normalizeSeverity(severity string) string {
+	switch strings.ToUpper(strings.TrimSpace(severity)) {
+	case "CRITICAL":
+		return SeverityCritical
+	case "HIGH", "IMPORTANT":
+		return SeverityHigh
+	case "MEDIUM", "MODERATE":
+		return SeverityMedium
+	case "LOW", "NEGLIGIBLE", "UNIMPORTANT":
+		return SeverityLow
+	}
+	return SeverityUnknown
+}
+
+func severityForScore(score float64) string {
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	}
+	return SeverityUnknown
+}
+
+// cvss3Weights are the CVSS v3.x base metric weights
+var cvss3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// cvss3BaseScore computes the base score of a CVSS:3.x vector
+func cvss3BaseScore(vector string) (float64, bool) {
+	if !strings.HasPrefix(vector, "CVSS:3") {
+		return 0, false
+	}
+	metrics := make(map[string]string)
+	for _, part := range strings.Split(vector, "/")[1:] {
+		if key, value, ok := strings.Cut(part, ":"); ok {
+			metrics[key] = value
+		}
+	}
+
+	weight := func(metric string) (float64, bool) {
+		w, ok := cvss3Weights[metric][metrics[metric]]
+		return w, ok
+	}
+	changed := metrics["S"] == "C"
+	var privileges float64
+	switch metrics["PR"] {
+	case "N":
+		privileges = 0.85
+	case "L":
+		privileges = map[bool]float64{false: 0.62, true: 0.68}[changed]
+	case "H":
+		privileges = map[bool]float64{false: 0.27, true: 0.5}[changed]
+	default:
+		return 0, false
+	}
+
+	values := make(map[string]float64)
+	for _, metric := range []string{"AV", "AC", "UI", "C", "I", "A"} {
+		w, ok := weight(metric)
+		if !ok {
+			return 0, false
+		}
+		values[metric] = w
+	}
+
+	iss := 1 - (1-values["C"])*(1-values["I"])*(1-values["A"])
+	var impact float64
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	} else {
+		impact = 6.42 * iss
+	}
+	if impact <= 0 {
+		return 0, true
+	}
+	exploitability := 8.22 * values["AV"] * values["AC"] * privileges * values["UI"]
+	if changed {
+		return roundUp(math.Min(1.08*(impact+exploitability), 10)), true
+	}
+	return roundUp(math.Min(impact+exploitability, 10)), true
+}
+
+// roundUp is the CVSS v3.1 Roundup function
+func roundUp(value float64) float64 {
+	scaled := int(math.Round(value * 100000))
+	if scaled%10000 == 0 {
+		return float64(scaled) / 100000
+	}
+	return float64(scaled/10000+1) / 10
+}
+
+// vulnKey indexes by ecosystem family ("debian" for "Debian:12") so one
+// lookup finds entries for every release; ecosystemMatches then checks the
+// release itself
+func vulnKey(ecosystem, name string) string {
+	family, _, _ := strings.Cut(ecosystem, ":")
+	family = strings.ToLower(family)
+	if family == "pypi" {
+		name = normalizePythonName(name)
+	}
+	return family + "/" + name
+}
+
+// ecosystemMatches compares an OSV ecosystem such as "Ubuntu:22.04:LTS" or
+// "Alpine:v3.19" with a package's ecosystem. An entry without a release
+// applies to every release.
+func ecosystemMatches(affected, pkg string) bool {
+	affected, pkg = strings.ToLower(affected), strings.ToLower(pkg)
+	if !strings.Contains(affected, ":") || affected == pkg {
+		return true
+	}
+	return strings.HasPrefix(affected, pkg+":")
+}
@@ -0,0 +1,88 @@
+package images
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAffects(t *testing.T) {
+	var affected osvAffected
+	err := json.Unmarshal([]byte(`{
+		"ranges": [
+			{"type": "GIT", "events": [{"introduced": "0"}]},
+			{"type": "ECOSYSTEM", "events": [{"introduced": "1.0.0"}, {"fixed": "1.2.5"}, {"introduced": "2.0.0"}, {"last_affected": "2.1.0"}]},
+			{"type": "ECOSYSTEM", "events": [{"introduced": "1.1.1"}, {"fixed": "1.1.1d"}]}
+		],
+		"versions": ["0.9.9"]
+	}`), &affected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		version  string
+		affected bool
+		fixed    string
+	}{
+		{"0.9.9", true, ""},
+		{"0.9.8", false, ""},
+		{"1.0.0", true, "1.2.5"},
+		{"1.2.4", true, "1.2.5"},
+		{"1.2.5", false, ""},
+		{"1.9.0", false, ""},
+		{"2.0.0", true, ""},
+		{"2.1.0", true, ""},
+		{"2.1.1", false, ""},
+		{"1.1.1c", true, "1.2.5"},
+		{"1.1.1d", true, "1.2.5"},
+		{"3.0.0", false, ""},
+	}
+	for _, tt := range tests {
+		got, fixed := affected.affects(PackageApk, tt.version)
+		if got != tt.affected || fixed != tt.fixed {
+			t.Errorf("affects(%q) = %v, %q, want %v, %q", tt.version, got, fixed, tt.affected, tt.fixed)
+		}
+	}
+}
+
+func TestAffectsPatchLetters(t *testing.T) {
+	var affected osvAffected
+	json.Unmarshal([]byte(`{"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1.1.1d"}]}]}`), &affected)
+	tests := map[string]bool{
+		"1.1.1":    true,
+		"1.1.1c":   true,
+		"1.1.1d":   false,
+		"1.1.1w":   false,
+		"1.1.0l":   true,
+		"3.0.0":    false,
+		"1.1.1-r0": true,
+	}
+	for version, want := range tests {
+		if got, _ := affected.affects(PackageApk, version); got != want {
+			t.Errorf("affects(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestCVSS3BaseScore(t *testing.T) {
+	tests := []struct {
+		vector string
+		score  float64
+		ok     bool
+	}{
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8, true},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H", 10.0, true},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", 6.1, true},
+		{"CVSS:3.0/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:N/A:N", 5.5, true},
+		{"CVSS:3.1/AV:N/AC:H/PR:H/UI:R/S:U/C:L/I:N/A:N", 2.0, true},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N", 0, true},
+		{"CVSS:3.1/AV:N/AC:L/PR:X/UI:N/S:U/C:H/I:H/A:H", 0, false},
+		{"AV:N/AC:L/Au:N/C:P/I:P/A:P", 0, false},
+	}
+	for _, tt := range tests {
+		score, ok := cvss3BaseScore(tt.vector)
+		if score != tt.score || ok != tt.ok {
+			t.Errorf("cvss3BaseScore(%q) = %v, %v, want %v, %v", tt.vector, score, ok, tt.score, tt.ok)
+		}
+	}
+}
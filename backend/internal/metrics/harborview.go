@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/genc-murat/harborview/internal/httputil"
+	"github.com/gofiber/fiber/v2"
+)
+
+// harborview's own metrics, recorded for the whole process
+var (
+	httpRequests = NewCounterVec("harborview_http_requests_total",
+		"HTTP requests served, by route and status.", "method", "route", "status")
+	httpDuration = NewHistogramVec("harborview_http_request_duration_seconds",
+		"Time to serve HTTP requests, by route. Streams count until the handler returns.", DefaultBuckets, "method", "route")
+	dockerCalls = NewHistogramVec("harborview_docker_api_request_duration_seconds",
+		"Time until the Docker API answered, by endpoint and operation.", DefaultBuckets, "endpoint", "operation")
+	dockerErrors = NewCounterVec("harborview_docker_api_errors_total",
+		"Docker API calls that failed, by endpoint, operation and status code; code is \"error\" when no response arrived.", "endpoint", "operation", "code")
+)
+
+// Self collects harborview's own metrics
+var Self Collector = collectors{httpRequests, httpDuration, dockerCalls, dockerErrors}
+
+type collectors []Collector
+
+func (c collectors) Collect() []Family {
+	var families []Family
+	for _, collector := range c {
+		families = append(families, collector.Collect()...)
+	}
+	return families
+}
+
+// Middleware counts requests and their latency by route template, so
+// /containers/:id is one series rather than one per container
+func Middleware(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		// The error handler sets the status after the middleware returns
+		status = httputil.Status(err)
+	}
+	route := c.Route().Path
+	if route == "/" && c.Path() != "/" {
+		// Only the global middleware matched
+		route = "unmatched"
+	}
+	method := c.Method()
+	httpRequests.Inc(method, route, strconv.Itoa(status))
+	httpDuration.ObserveDuration(start, method, route)
+	return err
+}
+
+// ObserveDockerCall records a Docker API call. code is the HTTP status, or
+// 0 when the call failed without a response.
+func ObserveDockerCall(endpoint, operation string, start time.Time, code int) {
+	dockerCalls.ObserveDuration(start, endpoint, operation)
+	switch {
+	case code == 0:
+		dockerErrors.Inc(endpoint, operation, "error")
+	case code >= 400:
+		dockerErrors.Inc(endpoint, operation, strconv.Itoa(code))
+	}
+}
@@ -0,0 +1,334 @@
+// Package metrics records harborview's own metrics and writes metric
+// families in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric types
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets suit request latencies, in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Label is a label name and value
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one series of a family. Histograms set Buckets, Sum and Count
+// instead of Value.
+type Sample struct {
+	Labels []Label
+	Value  float64
+
+	Buckets []Bucket
+	Sum     float64
+	Count   uint64
+}
+
+// Bucket is a cumulative histogram bucket
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// Family is a named metric with its series
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Collector is anything that produces metric families at scrape time
+type Collector interface {
+	Collect() []Family
+}
+
+// labelKey joins label values into a map key
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func zipLabels(names, values []string) []Label {
+	labels := make([]Label, len(names))
+	for i, name := range names {
+		labels[i] = Label{Name: name, Value: values[i]}
+	}
+	return labels
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec creates a counter with the given label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+}
+
+// Add increases the series of values by delta
+func (c *CounterVec) Add(delta float64, values ...string) {
+	key := labelKey(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labels: append([]string(nil), values...)}
+		c.values[key] = v
+	}
+	v.value += delta
+}
+
+// Inc increases the series of values by one
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Collect returns the counter's family
+func (c *CounterVec) Collect() []Family {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	family := Family{Name: c.name, Help: c.help, Type: TypeCounter}
+	for _, v := range c.values {
+		family.Samples = append(family.Samples, Sample{Labels: zipLabels(c.labels, v.labels), Value: v.value})
+	}
+	return []Family{family}
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec creates a histogram with the given upper bounds and label
+// names
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+}
+
+// Observe records value in the series of values
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	key := labelKey(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{labels: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			v.counts[i]++
+		}
+	}
+	v.sum += value
+	v.count++
+}
+
+// ObserveDuration records the time since start in seconds
+func (h *HistogramVec) ObserveDuration(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Collect returns the histogram's family
+func (h *HistogramVec) Collect() []Family {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	family := Family{Name: h.name, Help: h.help, Type: TypeHistogram}
+	for _, v := range h.values {
+		sample := Sample{Labels: zipLabels(h.labels, v.labels), Sum: v.sum, Count: v.count}
+		for i, bound := range h.buckets {
+			sample.Buckets = append(sample.Buckets, Bucket{UpperBound: bound, Count: v.counts[i]})
+		}
+		family.Samples = append(family.Samples, sample)
+	}
+	return []Family{family}
+}
+
+// Write writes families in the text exposition format. Series are sorted
+// so scrapes are stable, and extra labels are added to every series.
+func Write(w io.Writer, families []Family, extra []Label) error {
+	out := bufio.NewWriter(w)
+	for _, family := range families {
+		if len(family.Samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "# HELP %s %s\n", family.Name, escapeHelp(family.Help))
+		fmt.Fprintf(out, "# TYPE %s %s\n", family.Name, family.Type)
+
+		samples := append([]Sample(nil), family.Samples...)
+		sort.SliceStable(samples, func(i, j int) bool {
+			return formatLabels(samples[i].Labels, nil) < formatLabels(samples[j].Labels, nil)
+		})
+		for _, sample := range samples {
+			labels := append(append([]Label(nil), sample.Labels...), extra...)
+			if family.Type != TypeHistogram {
+				fmt.Fprintf(out, "%s%s %s\n", family.Name, formatLabels(labels, nil), formatValue(sample.Value))
+				continue
+			}
+			for _, bucket := range sample.Buckets {
+				le := Label{Name: "le", Value: formatValue(bucket.UpperBound)}
+				fmt.Fprintf(out, "%s_bucket%s %d\n", family.Name, formatLabels(labels, &le), bucket.Count)
+			}
+			inf := Label{Name: "le", Value: "+Inf"}
+			fmt.Fprintf(out, "%s_bucket%s %d\n", family.Name, formatLabels(labels, &inf), sample.Count)
+			fmt.Fprintf(out, "%s_sum%s %s\n", family.Name, formatLabels(labels, nil), formatValue(sample.Sum))
+			fmt.Fprintf(out, "%s_count%s %d\n", family.Name, formatLabels(labels, nil), sample.Count)
+		}
+	}
+	return out.Flush()
+}
+
+func formatLabels(labels []Label, last *Label) string {
+	if len(labels) == 0 && last == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label.Name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(label.Value))
+		b.WriteByte('"')
+	}
+	if last != nil {
+		if len(labels) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(last.Name + `="` + last.Value + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+// LabelName turns an arbitrary string, such as a container label key, into
+// a valid Prometheus label name
+func LabelName(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9' && i > 0:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// reservedLabels are the label names harborview's own series use. A
+// constant label with one of these names would clash with them.
+var reservedLabels = map[string]bool{
+	// harborview's own metrics
+	"method": true, "route": true, "status": true, "operation": true, "code": true,
+	// host metrics
+	"endpoint": true, "id": true, "name": true, "image": true, "state": true,
+	"interface": true, "type": true, "tag": true, "volume": true, "driver": true,
+	// histograms and summaries
+	"le": true, "quantile": true,
+}
+
+// ConstantLabels turns configured labels into label names and values added
+// to every series, sorted so scrapes are stable. Names reserved by
+// harborview's series or by Prometheus, and names that end up the same once
+// made valid, are rejected.
+func ConstantLabels(configured map[string]string) ([]Label, error) {
+	labels := make([]Label, 0, len(configured))
+	sources := make(map[string]string, len(configured))
+	var errs []error
+	for key, value := range configured {
+		name := LabelName(key)
+		switch {
+		case name == "":
+			errs = append(errs, errors.New("metrics label name is empty"))
+			continue
+		case reservedLabels[name], strings.HasPrefix(name, "__"), strings.HasPrefix(name, "container_label_"):
+			errs = append(errs, fmt.Errorf("metrics label %q is reserved", key))
+			continue
+		}
+		if other, ok := sources[name]; ok {
+			first, second := min(key, other), max(key, other)
+			errs = append(errs, fmt.Errorf("metrics labels %q and %q both become %q", first, second, name))
+			continue
+		}
+		sources[name] = key
+		labels = append(labels, Label{Name: name, Value: value})
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels, nil
+}
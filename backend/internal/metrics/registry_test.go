@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func write(t *testing.T, families []Family, extra []Label) string {
+	t.Helper()
+	var b strings.Builder
+	if err := Write(&b, families, extra); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return b.String()
+}
+
+func TestWrite(t *testing.T) {
+	families := []Family{
+		{Name: "harborview_empty", Help: "Skipped.", Type: TypeGauge},
+		{
+			Name: "harborview_containers", Help: "Containers,\nby state \\ status.", Type: TypeGauge,
+			Samples: []Sample{
+				{Labels: []Label{{Name: "state", Value: "running"}}, Value: 3},
+				{Labels: []Label{{Name: "state", Value: "exited"}}, Value: 1},
+			},
+		},
+		{
+			Name: "harborview_container_info", Help: "Container names.", Type: TypeGauge,
+			Samples: []Sample{
+				{Labels: []Label{{Name: "name", Value: "quote\"back\\slash\nnewline"}}, Value: 1},
+			},
+		},
+		{
+			Name: "harborview_load", Help: "Unbounded.", Type: TypeGauge,
+			Samples: []Sample{{Value: math.Inf(1)}},
+		},
+	}
+	want := `# HELP harborview_containers Containers,\nby state \\ status.
+# TYPE harborview_containers gauge
+harborview_containers{state="exited",endpoint="local"} 1
+harborview_containers{state="running",endpoint="local"} 3
+# HELP harborview_container_info Container names.
+# TYPE harborview_container_info gauge
+harborview_container_info{name="quote\"back\\slash\nnewline",endpoint="local"} 1
+# HELP harborview_load Unbounded.
+# TYPE harborview_load gauge
+harborview_load{endpoint="local"} +Inf
+`
+	if got := write(t, families, []Label{{Name: "endpoint", Value: "local"}}); got != want {
+		t.Errorf("Write =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteHistogram(t *testing.T) {
+	h := NewHistogramVec("harborview_request_seconds", "Request time.", []float64{0.1, 1}, "route")
+	for _, value := range []float64{0.05, 0.5, 0.5, 5} {
+		h.Observe(value, "/images")
+	}
+	want := `# HELP harborview_request_seconds Request time.
+# TYPE harborview_request_seconds histogram
+harborview_request_seconds_bucket{route="/images",le="0.1"} 1
+harborview_request_seconds_bucket{route="/images",le="1"} 3
+harborview_request_seconds_bucket{route="/images",le="+Inf"} 4
+harborview_request_seconds_sum{route="/images"} 6.05
+harborview_request_seconds_count{route="/images"} 4
+`
+	if got := write(t, h.Collect(), nil); got != want {
+		t.Errorf("Write =\n%s\nwant\n%s", got, want)
+	}
+
+	// A histogram without labels still names its buckets
+	bare := NewHistogramVec("harborview_bare_seconds", "Bare.", []float64{1})
+	bare.Observe(2)
+	got := write(t, bare.Collect(), nil)
+	for _, line := range []string{`harborview_bare_seconds_bucket{le="1"} 0`, `harborview_bare_seconds_bucket{le="+Inf"} 1`, "harborview_bare_seconds_count 1"} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("Write = %q, missing %q", got, line)
+		}
+	}
+}
+
+func TestConstantLabels(t *testing.T) {
+	labels, err := ConstantLabels(map[string]string{"region": "eu", "instance-id": "a1"})
+	if err != nil {
+		t.Fatalf("ConstantLabels: %v", err)
+	}
+	want := []Label{{Name: "instance_id", Value: "a1"}, {Name: "region", Value: "eu"}}
+	if len(labels) != len(want) || labels[0] != want[0] || labels[1] != want[1] {
+		t.Errorf("ConstantLabels = %v, want %v", labels, want)
+	}
+
+	tests := []struct {
+		labels map[string]string
+		error  string
+	}{
+		{map[string]string{"endpoint": "prod"}, `"endpoint" is reserved`},
+		{map[string]string{"id": "1"}, `"id" is reserved`},
+		{map[string]string{"le": "1"}, `"le" is reserved`},
+		{map[string]string{"__name__": "x"}, `"__name__" is reserved`},
+		{map[string]string{"container_label_team": "x"}, `"container_label_team" is reserved`},
+		{map[string]string{"": "x"}, "name is empty"},
+		{map[string]string{"team-name": "a", "team.name": "b"}, `"team-name" and "team.name" both become "team_name"`},
+	}
+	for _, tt := range tests {
+		_, err := ConstantLabels(tt.labels)
+		if err == nil || !strings.Contains(err.Error(), tt.error) {
+			t.Errorf("ConstantLabels(%v) = %v, want %q", tt.labels, err, tt.error)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+// Package monitoring samples the containers and disk usage of a Docker
+// host in the background, exposes them as Prometheus metrics and keeps a
+// downsampled history of each container's usage.
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/internal/system"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+const (
+	// DefaultInterval is the time between container stats samples when
+	// config sets none
+	DefaultInterval = 15 * time.Second
+	// DefaultDiskUsageInterval is the time between disk usage refreshes.
+	// Disk usage walks every volume, so it is sampled far less often.
+	DefaultDiskUsageInterval = 5 * time.Minute
+	// sampleWorkers bounds the concurrent stats calls of one sample
+	sampleWorkers = 8
+	// saveInterval is how often history is written to disk
+	saveInterval = 5 * time.Minute
+)
+
+// Sample is a point-in-time reading of one running container. Counters
+// are cumulative since the container started.
+type Sample struct {
+	ContainerID string            `json:"containerId"`
+	Name        string            `json:"name"`
+	Image       string            `json:"image"`
+	Labels      map[string]string `json:"-"`
+	Time        time.Time         `json:"time"`
+
+	CPUSeconds float64 `json:"cpuSeconds"`
+	// CPUPercent is the share of one CPU used since the previous sample, so
+	// a container busy on two CPUs reads 200; it is 0 on the first sample
+	CPUPercent  float64 `json:"cpuPercent"`
+	MemoryUsage uint64  `json:"memoryUsage"`
+	MemoryLimit uint64  `json:"memoryLimit"`
+	// Networks are received and transmitted bytes by interface
+	Networks   map[string]NetworkSample `json:"networks,omitempty"`
+	BlockRead  uint64                   `json:"blockRead"`
+	BlockWrite uint64                   `json:"blockWrite"`
+	PIDs       uint64                   `json:"pids"`
+
+	// cpuTotal and systemTotal are the raw counters CPUPercent is computed
+	// from on the next sample
+	cpuTotal    uint64
+	systemTotal uint64
+}
+
+// NetworkSample is the traffic of one container interface
+type NetworkSample struct {
+	RxBytes uint64 `json:"rxBytes"`
+	TxBytes uint64 `json:"txBytes"`
+}
+
+// Collector samples one daemon until it is closed
+type Collector struct {
+	cli          *client.Client
+	timeouts     docker.Timeouts
+	system       system.SystemService
+	interval     time.Duration
+	diskInterval time.Duration
+	labels       []string
+	history      *history
+
+	mu         sync.RWMutex
+	containers []types.Container
+	samples    map[string]Sample
+	disk       *system.DiskUsage
+	up         bool
+	// daemonID is only read and written by the sampling goroutine
+	daemonID string
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var (
+	collectorsMu sync.Mutex
+	collectors   = map[*client.Client]*Collector{}
+)
+
+// Shared returns the collector of cli, starting it on first use, so every
+// route serving a daemon reads the same samples. It runs until ctx is
+// cancelled or it is closed.
+func Shared(ctx context.Context, cfg config.Config, cli *client.Client) *Collector {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+	collector, ok := collectors[cli]
+	if !ok {
+		collector = newCollector(cfg, cli)
+		collectors[cli] = collector
+		go collector.run(ctx)
+	}
+	return collector
+}
+
+// Release stops the collector of cli, if any, once the client is discarded
+func Release(cli *client.Client) {
+	collectorsMu.Lock()
+	collector, ok := collectors[cli]
+	collectorsMu.Unlock()
+	if ok {
+		collector.Close()
+	}
+}
+
+func newCollector(cfg config.Config, cli *client.Client) *Collector {
+	interval := DefaultInterval
+	if cfg.Metrics.Interval > 0 {
+		interval = time.Duration(cfg.Metrics.Interval) * time.Second
+	}
+	diskInterval := DefaultDiskUsageInterval
+	if cfg.Metrics.DiskUsageInterval > 0 {
+		diskInterval = time.Duration(cfg.Metrics.DiskUsageInterval) * time.Second
+	}
+	return &Collector{
+		cli:          cli,
+		timeouts:     docker.NewTimeouts(cfg),
+		system:       system.NewSystemService(cfg, cli),
+		interval:     interval,
+		diskInterval: diskInterval,
+		labels:       cfg.Metrics.ContainerLabels,
+		history:      newHistory(cfg, interval),
+		samples:      make(map[string]Sample),
+		done:         make(chan struct{}),
+	}
+}
+
+// Close stops sampling
+func (c *Collector) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		collectorsMu.Lock()
+		if collectors[c.cli] == c {
+			delete(collectors, c.cli)
+		}
+		collectorsMu.Unlock()
+	})
+	return nil
+}
+
+// Containers returns the containers of the latest listing
+func (c *Collector) Containers() []types.Container {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]types.Container(nil), c.containers...)
+}
+
+// Samples returns the latest sample of every running container
+func (c *Collector) Samples() []Sample {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	samples := make([]Sample, 0, len(c.samples))
+	for _, sample := range c.samples {
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+func (c *Collector) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	c.sample(ctx)
+	c.refreshDiskUsage(ctx)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	diskTicker := time.NewTicker(c.diskInterval)
+	defer diskTicker.Stop()
+	saveTicker := time.NewTicker(saveInterval)
+	defer saveTicker.Stop()
+	defer c.saveHistory()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.sample(ctx)
+		case <-diskTicker.C:
+			c.refreshDiskUsage(ctx)
+		case <-saveTicker.C:
+			c.saveHistory()
+		}
+	}
+}
+
+// History returns the stored usage of a container, which may since have
+// been removed
+func (c *Collector) History(ref string, query HistoryQuery) (*History, error) {
+	return c.history.query(ref, query, time.Now())
+}
+
+// identify loads the history kept for the daemon. History files are named
+// by daemon ID, so they follow the daemon rather than how it is reached.
+func (c *Collector) identify(ctx context.Context) {
+	if c.history.dir == "" || c.daemonID != "" {
+		return
+	}
+	ctx, cancel := c.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+	info, err := c.cli.Info(ctx)
+	if err != nil || info.ID == "" {
+		return
+	}
+	c.daemonID = info.ID
+	if err := c.history.setDaemon(info.ID); err != nil {
+		log.Printf("Failed to load metrics history: %v", err)
+	}
+}
+
+func (c *Collector) saveHistory() {
+	if err := c.history.save(); err != nil {
+		log.Printf("Failed to save metrics history: %v", err)
+	}
+}
+
+// sample lists the containers and reads the stats of the running ones
+func (c *Collector) sample(ctx context.Context) {
+	listCtx, cancel := c.timeouts.Context(ctx, docker.OpDefault)
+	containers, err := c.cli.ContainerList(listCtx, container.ListOptions{All: true})
+	cancel()
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to list containers for metrics: %v", err)
+		}
+		c.mu.Lock()
+		c.up = false
+		c.mu.Unlock()
+		return
+	}
+
+	c.mu.RLock()
+	previous := c.samples
+	c.mu.RUnlock()
+
+	var running []types.Container
+	for _, ctr := range containers {
+		if ctr.State == "running" {
+			running = append(running, ctr)
+		}
+	}
+
+	samples := make(map[string]Sample, len(running))
+	var samplesMu sync.Mutex
+	jobs := make(chan types.Container)
+	var wg sync.WaitGroup
+	for range min(sampleWorkers, len(running)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctr := range jobs {
+				prev, hasPrev := previous[ctr.ID]
+				sample, err := c.sampleContainer(ctx, ctr, prev, hasPrev)
+				if err != nil {
+					// The container may have stopped since it was listed
+					continue
+				}
+				samplesMu.Lock()
+				samples[ctr.ID] = sample
+				samplesMu.Unlock()
+			}
+		}()
+	}
+	for _, ctr := range running {
+		jobs <- ctr
+	}
+	close(jobs)
+	wg.Wait()
+
+	c.mu.Lock()
+	c.containers = containers
+	c.samples = samples
+	c.up = true
+	c.mu.Unlock()
+
+	c.identify(ctx)
+	taken := make([]Sample, 0, len(samples))
+	for _, sample := range samples {
+		taken = append(taken, sample)
+	}
+	c.history.add(taken, time.Now())
+}
+
+func (c *Collector) sampleContainer(ctx context.Context, ctr types.Container, prev Sample, hasPrev bool) (Sample, error) {
+	ctx, cancel := c.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	reader, err := c.cli.ContainerStatsOneShot(ctx, ctr.ID)
+	if err != nil {
+		return Sample{}, err
+	}
+	defer reader.Body.Close()
+	var stats container.StatsResponse
+	if err := json.NewDecoder(reader.Body).Decode(&stats); err != nil {
+		return Sample{}, fmt.Errorf("failed to decode stats: %w", err)
+	}
+
+	sample := Sample{
+		ContainerID: ctr.ID,
+		Name:        containerName(ctr),
+		Image:       ctr.Image,
+		Labels:      ctr.Labels,
+		Time:        stats.Read,
+		CPUSeconds:  float64(stats.CPUStats.CPUUsage.TotalUsage) / float64(time.Second),
+		MemoryUsage: memoryUsage(stats),
+		MemoryLimit: stats.MemoryStats.Limit,
+		PIDs:        stats.PidsStats.Current,
+		cpuTotal:    stats.CPUStats.CPUUsage.TotalUsage,
+		systemTotal: stats.CPUStats.SystemUsage,
+	}
+	if sample.Time.IsZero() {
+		sample.Time = time.Now()
+	}
+	if hasPrev {
+		sample.CPUPercent = cpuPercent(prev, sample, stats)
+	}
+	if len(stats.Networks) > 0 {
+		sample.Networks = make(map[string]NetworkSample, len(stats.Networks))
+		for name, network := range stats.Networks {
+			sample.Networks[name] = NetworkSample{RxBytes: network.RxBytes, TxBytes: network.TxBytes}
+		}
+	}
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			sample.BlockRead += entry.Value
+		case "write":
+			sample.BlockWrite += entry.Value
+		}
+	}
+	if stats.StorageStats.ReadSizeBytes > 0 || stats.StorageStats.WriteSizeBytes > 0 {
+		// Windows daemons report storage instead of block I/O
+		sample.BlockRead = stats.StorageStats.ReadSizeBytes
+		sample.BlockWrite = stats.StorageStats.WriteSizeBytes
+	}
+	return sample, nil
+}
+
+// memoryUsage leaves out the page cache the kernel can reclaim, as docker
+// stats does
+func memoryUsage(stats container.StatsResponse) uint64 {
+	if stats.MemoryStats.PrivateWorkingSet > 0 {
+		// Windows
+		return stats.MemoryStats.PrivateWorkingSet
+	}
+	usage := stats.MemoryStats.Usage
+	// cgroup v1 reports total_inactive_file, v2 inactive_file
+	for _, key := range []string{"total_inactive_file", "inactive_file"} {
+		if inactive, ok := stats.MemoryStats.Stats[key]; ok {
+			if inactive < usage {
+				return usage - inactive
+			}
+			return usage
+		}
+	}
+	return usage
+}
+
+// cpuPercent computes CPU use between two samples. One-shot stats carry no
+// previous reading, so the collector keeps its own.
+func cpuPercent(prev, sample Sample, stats container.StatsResponse) float64 {
+	if sample.cpuTotal < prev.cpuTotal {
+		// The container restarted
+		return 0
+	}
+	// Windows daemons report no system usage
+	if sample.systemTotal <= prev.systemTotal {
+		return 0
+	}
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpus == 0 {
+		cpus = 1
+	}
+	cpuDelta := float64(sample.cpuTotal - prev.cpuTotal)
+	return cpuDelta / float64(sample.systemTotal-prev.systemTotal) * cpus * 100
+}
+
+// refreshDiskUsage reads image, container and volume disk usage
+func (c *Collector) refreshDiskUsage(ctx context.Context) {
+	usage, err := c.system.DiskUsage(ctx, true)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to get disk usage for metrics: %v", err)
+		}
+		return
+	}
+	c.mu.Lock()
+	c.disk = usage
+	c.mu.Unlock()
+}
+
+func containerName(ctr types.Container) string {
+	if len(ctr.Names) > 0 {
+		return strings.TrimPrefix(ctr.Names[0], "/")
+	}
+	return ctr.ID[:min(12, len(ctr.ID))]
+}
@@ -0,0 +1,160 @@
+package monitoring
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/internal/httputil"
+	"github.com/genc-murat/harborview/internal/metrics"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterRoutes registers /metrics, which reports harborview itself and
+// the host behind the shared client, and the history of its containers.
+// Sampling runs until ctx is cancelled. Invalid constant labels in cfg are
+// an error.
+func RegisterRoutes(ctx context.Context, app *fiber.App, cfg config.Config, cli *client.Client) error {
+	extra, err := metrics.ConstantLabels(cfg.Metrics.Labels)
+	if err != nil {
+		return err
+	}
+	collector := Shared(ctx, cfg, cli)
+	host := append([]metrics.Label{{Name: "endpoint", Value: docker.LocalEndpoint}}, extra...)
+
+	// Scrape harborview and local host metrics
+	app.Get("/metrics", func(c *fiber.Ctx) error {
+		var buf bytes.Buffer
+		if err := metrics.Write(&buf, metrics.Self.Collect(), extra); err != nil {
+			return httputil.Error(c, err)
+		}
+		if err := metrics.Write(&buf, collector.Collect(), host); err != nil {
+			return httputil.Error(c, err)
+		}
+		c.Set(fiber.HeaderContentType, metrics.ContentType)
+		return c.Send(buf.Bytes())
+	})
+
+	// Get a container's usage history
+	app.Get("/containers/:id/metrics", func(c *fiber.Ctx) error {
+		return GetContainerHistory(c, collector)
+	})
+	return nil
+}
+
+// MountRoutes registers /metrics and the container history on router for
+// the host lookup picks, named by endpoint in every series. A *fiber.Error
+// from lookup sets the status. Invalid constant labels in cfg are an error.
+func MountRoutes(router fiber.Router, cfg config.Config, lookup func(c *fiber.Ctx) (*Collector, error), endpoint func(c *fiber.Ctx) string) error {
+	extra, err := metrics.ConstantLabels(cfg.Metrics.Labels)
+	if err != nil {
+		return err
+	}
+
+	// Scrape host metrics
+	router.Get("/metrics", func(c *fiber.Ctx) error {
+		collector, err := lookup(c)
+		if err != nil {
+			return httputil.Error(c, err)
+		}
+		labels := append([]metrics.Label{{Name: "endpoint", Value: endpoint(c)}}, extra...)
+		var buf bytes.Buffer
+		if err := metrics.Write(&buf, collector.Collect(), labels); err != nil {
+			return httputil.Error(c, err)
+		}
+		c.Set(fiber.HeaderContentType, metrics.ContentType)
+		return c.Send(buf.Bytes())
+	})
+
+	// Get a container's usage history
+	router.Get("/containers/:id/metrics", func(c *fiber.Ctx) error {
+		collector, err := lookup(c)
+		if err != nil {
+			return httputil.Error(c, err)
+		}
+		return GetContainerHistory(c, collector)
+	})
+	return nil
+}
+
+// GetContainerHistory handler for a container's CPU, memory, network and
+// block I/O history. ?from= and ?to= take RFC 3339 times, Unix seconds or
+// a duration ago such as 24h, defaulting to the last hour; ?step= is the
+// width of each point, such as 1m.
+func GetContainerHistory(c *fiber.Ctx, collector *Collector) error {
+	now := time.Now()
+	from, err := parseTime(c.Query("from"), now, now.Add(-time.Hour))
+	if err != nil {
+		return historyError(c, errdefs.InvalidParameter(fmt.Errorf("invalid from: %w", err)))
+	}
+	to, err := parseTime(c.Query("to"), now, now)
+	if err != nil {
+		return historyError(c, errdefs.InvalidParameter(fmt.Errorf("invalid to: %w", err)))
+	}
+	step, err := parseStep(c.Query("step"))
+	if err != nil {
+		return historyError(c, errdefs.InvalidParameter(fmt.Errorf("invalid step: %w", err)))
+	}
+
+	history, err := collector.History(c.Params("id"), HistoryQuery{From: from, To: to, Step: step})
+	if err != nil {
+		return historyError(c, err)
+	}
+	return c.JSON(history)
+}
+
+// parseTime reads an RFC 3339 time, Unix seconds, "now", or a duration
+// before now such as 1h or -1h
+func parseTime(value string, now, fallback time.Time) (time.Time, error) {
+	switch value {
+	case "":
+		return fallback, nil
+	case "now":
+		return now, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(value, "-")); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a time, Unix timestamp or duration", value)
+	}
+	return t, nil
+}
+
+// parseStep reads a duration such as 30s or 5m, or a number of seconds
+func parseStep(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration", value)
+	}
+	return d, nil
+}
+
+// historyError maps invalid queries to 400 and unknown containers to 404
+func historyError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errdefs.IsInvalidParameter(err):
+		status = fiber.StatusBadRequest
+	case errdefs.IsNotFound(err):
+		status = fiber.StatusNotFound
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
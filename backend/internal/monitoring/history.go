@@ -0,0 +1,427 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/fsutil"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// DefaultRetention is how long history is kept when config sets none
+const DefaultRetention = 7 * 24 * time.Hour
+
+// maxPoints bounds the points of one query
+const maxPoints = 11000
+
+// tier keeps samples at one resolution for a limited time. Every sample is
+// added to every tier, so coarser tiers downsample as they go.
+type tier struct {
+	// Resolution is the bucket width; 0 keeps every sample
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// tiers are ordered finest first. Their retention is capped by the
+// configured retention.
+var tiers = []tier{
+	{Resolution: 0, Retention: 6 * time.Hour},
+	{Resolution: time.Minute, Retention: 48 * time.Hour},
+	{Resolution: 10 * time.Minute, Retention: DefaultRetention},
+}
+
+// point is a stored reading. Gauges are averaged over the samples merged
+// into it; counters keep the latest cumulative value.
+type point struct {
+	Time        time.Time `json:"t"`
+	Count       int       `json:"n"`
+	CPUPercent  float64   `json:"cpu"`
+	MemoryUsage float64   `json:"mem"`
+	MemoryLimit uint64    `json:"memLimit"`
+	NetworkRx   uint64    `json:"rx"`
+	NetworkTx   uint64    `json:"tx"`
+	BlockRead   uint64    `json:"blkRead"`
+	BlockWrite  uint64    `json:"blkWrite"`
+}
+
+// merge folds b, which is newer, into p
+func (p *point) merge(b point) {
+	total := float64(p.Count + b.Count)
+	p.CPUPercent = (p.CPUPercent*float64(p.Count) + b.CPUPercent*float64(b.Count)) / total
+	p.MemoryUsage = (p.MemoryUsage*float64(p.Count) + b.MemoryUsage*float64(b.Count)) / total
+	p.Count += b.Count
+	p.Time = b.Time
+	p.MemoryLimit = b.MemoryLimit
+	p.NetworkRx, p.NetworkTx = b.NetworkRx, b.NetworkTx
+	p.BlockRead, p.BlockWrite = b.BlockRead, b.BlockWrite
+}
+
+// series is the history of one container
+type series struct {
+	ContainerID string    `json:"containerId"`
+	Name        string    `json:"name"`
+	Image       string    `json:"image"`
+	LastSeen    time.Time `json:"lastSeen"`
+	// Tiers holds the points of each tier, oldest first
+	Tiers [][]point `json:"tiers"`
+}
+
+// clone copies s, whose last points add keeps merging into
+func (s *series) clone() series {
+	c := *s
+	c.Tiers = make([][]point, len(s.Tiers))
+	for i, points := range s.Tiers {
+		c.Tiers[i] = slices.Clone(points)
+	}
+	return c
+}
+
+// history is an in-memory time-series store of container samples,
+// persisted to dir/<daemon ID>.json when dir is set
+type history struct {
+	tiers []tier
+	dir   string
+	// interval is how often samples arrive, the finest step worth asking for
+	interval time.Duration
+	// saveMu keeps saves in order, so an older copy never replaces a newer
+	saveMu sync.Mutex
+
+	mu      sync.Mutex
+	daemon  string
+	series  map[string]*series
+	changed bool
+}
+
+func newHistory(cfg config.Config, interval time.Duration) *history {
+	retention := DefaultRetention
+	if cfg.Metrics.History.Retention > 0 {
+		retention = time.Duration(cfg.Metrics.History.Retention) * time.Hour
+	}
+	h := &history{dir: cfg.Metrics.History.Dir, interval: interval, series: make(map[string]*series)}
+	for _, t := range tiers {
+		t.Retention = min(t.Retention, retention)
+		h.tiers = append(h.tiers, t)
+	}
+	// The coarsest tier always keeps the full retention
+	h.tiers[len(h.tiers)-1].Retention = retention
+	return h
+}
+
+// add records samples taken at one time and drops expired points
+func (h *history) add(samples []Sample, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, sample := range samples {
+		s, ok := h.series[sample.ContainerID]
+		if !ok {
+			s = &series{ContainerID: sample.ContainerID, Tiers: make([][]point, len(h.tiers))}
+			h.series[sample.ContainerID] = s
+		}
+		s.Name, s.Image, s.LastSeen = sample.Name, sample.Image, sample.Time
+		p := pointOf(sample)
+		for i, t := range h.tiers {
+			points := s.Tiers[i]
+			if n := len(points); n > 0 && t.Resolution > 0 && points[n-1].Time.Truncate(t.Resolution).Equal(p.Time.Truncate(t.Resolution)) {
+				points[n-1].merge(p)
+				continue
+			}
+			s.Tiers[i] = append(points, p)
+		}
+	}
+	h.expire(now)
+	h.changed = true
+}
+
+func pointOf(sample Sample) point {
+	p := point{
+		Time:        sample.Time,
+		Count:       1,
+		CPUPercent:  sample.CPUPercent,
+		MemoryUsage: float64(sample.MemoryUsage),
+		MemoryLimit: sample.MemoryLimit,
+		BlockRead:   sample.BlockRead,
+		BlockWrite:  sample.BlockWrite,
+	}
+	for _, network := range sample.Networks {
+		p.NetworkRx += network.RxBytes
+		p.NetworkTx += network.TxBytes
+	}
+	return p
+}
+
+// expire drops points older than their tier's retention, and containers
+// with no points left
+func (h *history) expire(now time.Time) {
+	for id, s := range h.series {
+		empty := true
+		for i, t := range h.tiers {
+			cutoff := now.Add(-t.Retention)
+			points := s.Tiers[i]
+			if drop := sort.Search(len(points), func(j int) bool { return !points[j].Time.Before(cutoff) }); drop > 0 {
+				// Copy so the dropped points can be freed
+				s.Tiers[i] = append(points[:0:0], points[drop:]...)
+			}
+			if len(s.Tiers[i]) > 0 {
+				empty = false
+			}
+		}
+		if empty {
+			delete(h.series, id)
+		}
+	}
+}
+
+// HistoryQuery selects a time range of a container's history
+type HistoryQuery struct {
+	From time.Time
+	To   time.Time
+	// Step is the width of each returned point; chosen from the range when
+	// zero
+	Step time.Duration
+}
+
+// HistoryPoint is a container's usage over one step. Rates are per second
+// and left out where there is no earlier reading to compare with.
+type HistoryPoint struct {
+	Time        time.Time `json:"time"`
+	CPUPercent  float64   `json:"cpuPercent"`
+	MemoryUsage float64   `json:"memoryUsage"`
+	MemoryLimit uint64    `json:"memoryLimit"`
+	NetworkRx   *float64  `json:"networkRxRate,omitempty"`
+	NetworkTx   *float64  `json:"networkTxRate,omitempty"`
+	BlockRead   *float64  `json:"blockReadRate,omitempty"`
+	BlockWrite  *float64  `json:"blockWriteRate,omitempty"`
+}
+
+// History is a container's usage over a time range. Steps without samples
+// are left out, so gaps show where the container was not running.
+type History struct {
+	ContainerID string         `json:"containerId"`
+	Name        string         `json:"name"`
+	Image       string         `json:"image"`
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	Step        string         `json:"step"`
+	Resolution  string         `json:"resolution"`
+	Points      []HistoryPoint `json:"points"`
+}
+
+// query returns the history of the container with the given ID, ID prefix
+// or name. It reads the finest tier that still covers query.From.
+func (h *history) query(ref string, query HistoryQuery, now time.Time) (*History, error) {
+	if !query.From.Before(query.To) {
+		return nil, errdefs.InvalidParameter(fmt.Errorf("from must be before to"))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.find(ref)
+	if s == nil {
+		return nil, errdefs.NotFound(fmt.Errorf("no metrics history for container %s", ref))
+	}
+
+	index := len(h.tiers) - 1
+	for i, t := range h.tiers {
+		if !query.From.Before(now.Add(-t.Retention)) {
+			index = i
+			break
+		}
+	}
+	resolution := h.tiers[index].Resolution
+
+	step := query.Step
+	if step <= 0 {
+		// Aim for a few hundred points, which suits a chart
+		step = query.To.Sub(query.From) / 300
+	}
+	step = max(step, resolution, h.interval, time.Second)
+	if resolution > 0 && step%resolution != 0 {
+		// Whole stored points per step keep every step comparable
+		step = (step/resolution + 1) * resolution
+	}
+	if query.To.Sub(query.From)/step > maxPoints {
+		return nil, errdefs.InvalidParameter(fmt.Errorf("step %s is too small for the range, which would return more than %d points", step, maxPoints))
+	}
+
+	result := &History{
+		ContainerID: s.ContainerID,
+		Name:        s.Name,
+		Image:       s.Image,
+		From:        query.From,
+		To:          query.To,
+		Step:        step.String(),
+		Resolution:  "raw",
+		Points:      []HistoryPoint{},
+	}
+	if resolution > 0 {
+		result.Resolution = resolution.String()
+	}
+
+	// Buckets align to the step, and the last point before the range seeds
+	// the first rate
+	var (
+		previous *point
+		bucket   *point
+		start    time.Time
+	)
+	flush := func() {
+		if bucket == nil {
+			return
+		}
+		hp := HistoryPoint{
+			Time:        start,
+			CPUPercent:  bucket.CPUPercent,
+			MemoryUsage: bucket.MemoryUsage,
+			MemoryLimit: bucket.MemoryLimit,
+		}
+		if previous != nil {
+			if seconds := bucket.Time.Sub(previous.Time).Seconds(); seconds > 0 {
+				hp.NetworkRx = rate(previous.NetworkRx, bucket.NetworkRx, seconds)
+				hp.NetworkTx = rate(previous.NetworkTx, bucket.NetworkTx, seconds)
+				hp.BlockRead = rate(previous.BlockRead, bucket.BlockRead, seconds)
+				hp.BlockWrite = rate(previous.BlockWrite, bucket.BlockWrite, seconds)
+			}
+		}
+		result.Points = append(result.Points, hp)
+		last := *bucket
+		previous, bucket = &last, nil
+	}
+	for _, p := range s.Tiers[index] {
+		if p.Time.Before(query.From) {
+			seed := p
+			previous = &seed
+			continue
+		}
+		if p.Time.After(query.To) {
+			break
+		}
+		bucketStart := p.Time.Truncate(step)
+		if bucket != nil && !bucketStart.Equal(start) {
+			flush()
+		}
+		if bucket == nil {
+			copied := p
+			bucket, start = &copied, bucketStart
+			continue
+		}
+		bucket.merge(p)
+	}
+	flush()
+	return result, nil
+}
+
+// rate is the per-second increase of a counter. A counter that went down
+// was reset by a restart, so it counts from zero.
+func rate(from, to uint64, seconds float64) *float64 {
+	delta := to
+	if to >= from {
+		delta = to - from
+	}
+	r := float64(delta) / seconds
+	return &r
+}
+
+// find looks a container up by ID, then unique ID prefix, then name. A
+// name reused by a recreated container finds the most recent one.
+func (h *history) find(ref string) *series {
+	if s, ok := h.series[ref]; ok {
+		return s
+	}
+	var match *series
+	for id, s := range h.series {
+		if strings.HasPrefix(id, ref) {
+			if match != nil {
+				return nil
+			}
+			match = s
+		}
+	}
+	if match != nil {
+		return match
+	}
+	name := strings.TrimPrefix(ref, "/")
+	for _, s := range h.series {
+		if s.Name == name && (match == nil || s.LastSeen.After(match.LastSeen)) {
+			match = s
+		}
+	}
+	return match
+}
+
+// setDaemon names the file history is kept in and loads it on first use
+func (h *history) setDaemon(id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.dir == "" || h.daemon == id {
+		return nil
+	}
+	h.daemon = id
+	data, err := os.ReadFile(h.path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var stored []*series
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(h.path()), err)
+	}
+	for _, s := range stored {
+		if len(s.Tiers) != len(h.tiers) {
+			// Written with other tiers; keep what is newer
+			continue
+		}
+		if _, ok := h.series[s.ContainerID]; !ok {
+			h.series[s.ContainerID] = s
+		}
+	}
+	h.expire(time.Now())
+	return nil
+}
+
+func (h *history) path() string {
+	return filepath.Join(h.dir, strings.ReplaceAll(h.daemon, ":", "_")+".json")
+}
+
+// save writes history to disk if it changed since the last save. The
+// series are copied under the lock and encoded and written outside it, so
+// sampling and queries do not wait for the disk.
+func (h *history) save() error {
+	h.saveMu.Lock()
+	defer h.saveMu.Unlock()
+
+	h.mu.Lock()
+	if h.dir == "" || h.daemon == "" || !h.changed {
+		h.mu.Unlock()
+		return nil
+	}
+	path := h.path()
+	stored := make([]series, 0, len(h.series))
+	for _, s := range h.series {
+		stored = append(stored, s.clone())
+	}
+	h.changed = false
+	h.mu.Unlock()
+
+	data, err := json.Marshal(stored)
+	if err == nil {
+		err = fsutil.WriteFileAtomic(path, data)
+	}
+	if err != nil {
+		// Try again on the next save
+		h.mu.Lock()
+		h.changed = true
+		h.mu.Unlock()
+		return fmt.Errorf("failed to store history: %w", err)
+	}
+	return nil
+}
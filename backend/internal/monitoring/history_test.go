@@ -0,0 +1,209 @@
+package monitoring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+var base = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// sampleAt is a reading of container id at base+offset. Traffic is split
+// over two interfaces, which the history adds up.
+func sampleAt(id string, offset time.Duration, cpu float64, rx uint64) Sample {
+	return Sample{
+		ContainerID: id,
+		Name:        "app-" + id,
+		Image:       "nginx:1.27",
+		Time:        base.Add(offset),
+		CPUPercent:  cpu,
+		MemoryUsage: 100,
+		MemoryLimit: 1000,
+		Networks: map[string]NetworkSample{
+			"eth0": {RxBytes: rx / 2, TxBytes: rx / 2},
+			"eth1": {RxBytes: rx - rx/2},
+		},
+		BlockRead: rx * 2,
+	}
+}
+
+func TestHistoryTiers(t *testing.T) {
+	h := newHistory(config.Config{}, 10*time.Second)
+	for i, cpu := range []float64{10, 20, 30, 40, 50} {
+		offset := time.Duration(i) * 20 * time.Second
+		h.add([]Sample{sampleAt("a", offset, cpu, uint64(i)*100)}, base.Add(offset))
+	}
+
+	s := h.series["a"]
+	if len(s.Tiers[0]) != 5 {
+		t.Fatalf("raw tier holds %d points, want every sample", len(s.Tiers[0]))
+	}
+	// One-minute buckets: 0s, 20s and 40s, then 60s and 80s
+	minute := s.Tiers[1]
+	if len(minute) != 2 {
+		t.Fatalf("minute tier holds %d points, want 2", len(minute))
+	}
+	tests := []struct {
+		got   point
+		time  time.Duration
+		count int
+		cpu   float64
+		rx    uint64
+	}{
+		{minute[0], 40 * time.Second, 3, 20, 200},
+		{minute[1], 80 * time.Second, 2, 45, 400},
+		{s.Tiers[2][0], 80 * time.Second, 5, 30, 400},
+	}
+	for i, tt := range tests {
+		p := tt.got
+		if !p.Time.Equal(base.Add(tt.time)) || p.Count != tt.count || p.CPUPercent != tt.cpu || p.NetworkRx != tt.rx || p.MemoryUsage != 100 {
+			t.Errorf("point %d = %+v, want time +%s, count %d, cpu %g, rx %d", i, p, tt.time, tt.count, tt.cpu, tt.rx)
+		}
+	}
+
+	// Past the raw retention only the coarser tiers keep the old samples
+	later := 7 * time.Hour
+	h.add([]Sample{sampleAt("a", later, 60, 500)}, base.Add(later))
+	if got := len(s.Tiers[0]); got != 1 {
+		t.Errorf("raw tier holds %d points after 7h, want 1", got)
+	}
+	if got := len(s.Tiers[1]); got != 3 {
+		t.Errorf("minute tier holds %d points after 7h, want 3", got)
+	}
+
+	// A container gone for longer than every retention is dropped
+	h.add([]Sample{sampleAt("b", 0, 1, 0)}, base)
+	h.add([]Sample{sampleAt("a", 8*24*time.Hour, 1, 0)}, base.Add(8*24*time.Hour))
+	if _, ok := h.series["b"]; ok {
+		t.Error("expired container kept")
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	h := newHistory(config.Config{}, 10*time.Second)
+	for i := range 13 {
+		offset := time.Duration(i) * 10 * time.Second
+		h.add([]Sample{sampleAt("3f2a9c", offset, float64(i), uint64(i)*100)}, base.Add(offset))
+	}
+	now := base.Add(2 * time.Minute)
+
+	result, err := h.query("app-3f2a9c", HistoryQuery{From: base, To: now, Step: 30 * time.Second}, now)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if result.ContainerID != "3f2a9c" || result.Step != "30s" || result.Resolution != "raw" {
+		t.Errorf("query = %s, step %s, resolution %s", result.ContainerID, result.Step, result.Resolution)
+	}
+	// Steps average CPU over 0-20s, 30-50s, 60-80s, 90-110s and 120s. The
+	// counters grow 10/s, compared with the last reading of the step before.
+	cpus := []float64{1, 4, 7, 10, 12}
+	if len(result.Points) != len(cpus) {
+		t.Fatalf("query returned %d points, want %d", len(result.Points), len(cpus))
+	}
+	for i, p := range result.Points {
+		if !p.Time.Equal(base.Add(time.Duration(i)*30*time.Second)) || p.CPUPercent != cpus[i] {
+			t.Errorf("point %d = %s cpu %g, want +%ds cpu %g", i, p.Time, p.CPUPercent, i*30, cpus[i])
+		}
+		if i == 0 {
+			if p.NetworkRx != nil {
+				t.Errorf("first point has rate %g with no earlier reading", *p.NetworkRx)
+			}
+			continue
+		}
+		if p.NetworkRx == nil || *p.NetworkRx != 10 || *p.NetworkTx != 5 || *p.BlockRead != 20 {
+			t.Errorf("point %d rates = %v %v %v, want 10, 5 and 20", i, p.NetworkRx, p.NetworkTx, p.BlockRead)
+		}
+	}
+
+	// A reading before the range seeds the first rate
+	result, err = h.query("3f2a", HistoryQuery{From: base.Add(30 * time.Second), To: now, Step: 30 * time.Second}, now)
+	if err != nil {
+		t.Fatalf("query by prefix: %v", err)
+	}
+	if p := result.Points[0]; p.NetworkRx == nil || *p.NetworkRx != 10 {
+		t.Errorf("first point rate = %v, want 10 from the reading before the range", p.NetworkRx)
+	}
+
+	// An old range reads a coarser tier, and the step rounds up to whole
+	// points of it
+	result, err = h.query("3f2a9c", HistoryQuery{From: now.Add(-24 * time.Hour), To: now}, now)
+	if err != nil {
+		t.Fatalf("query a day: %v", err)
+	}
+	if result.Resolution != "1m0s" || result.Step != "5m0s" {
+		t.Errorf("day query resolution %s step %s, want 1m0s and 5m0s", result.Resolution, result.Step)
+	}
+}
+
+func TestHistoryQueryErrors(t *testing.T) {
+	h := newHistory(config.Config{}, time.Second)
+	h.add([]Sample{sampleAt("a", 0, 1, 0)}, base)
+
+	tests := []struct {
+		name  string
+		ref   string
+		query HistoryQuery
+		check func(error) bool
+	}{
+		{"reversed range", "a", HistoryQuery{From: base, To: base}, errdefs.IsInvalidParameter},
+		{"too many points", "a", HistoryQuery{From: base.Add(-5 * time.Hour), To: base, Step: time.Second}, errdefs.IsInvalidParameter},
+		{"unknown container", "b", HistoryQuery{From: base.Add(-time.Hour), To: base}, errdefs.IsNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := h.query(tt.ref, tt.query, base); !tt.check(err) {
+			t.Errorf("%s: query = %v", tt.name, err)
+		}
+	}
+}
+
+func TestRate(t *testing.T) {
+	if r := *rate(100, 600, 10); r != 50 {
+		t.Errorf("rate = %g, want 50", r)
+	}
+	// A counter that went down restarted from zero
+	if r := *rate(600, 100, 10); r != 10 {
+		t.Errorf("rate after a reset = %g, want 10", r)
+	}
+}
+
+func TestHistorySave(t *testing.T) {
+	var cfg config.Config
+	cfg.Metrics.History.Dir = t.TempDir()
+	now := time.Now()
+	sample := sampleAt("a", 0, 5, 100)
+	sample.Time = now
+
+	h := newHistory(cfg, 10*time.Second)
+	if err := h.setDaemon("ABCD:EFGH"); err != nil {
+		t.Fatal(err)
+	}
+	h.add([]Sample{sample}, now)
+	if err := h.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Metrics.History.Dir, "ABCD_EFGH.json")); err != nil {
+		t.Fatalf("history file: %v", err)
+	}
+	// Later samples merge into the saved points in memory only
+	sample.Time = now.Add(time.Second)
+	sample.CPUPercent = 15
+	h.add([]Sample{sample}, now)
+
+	loaded := newHistory(cfg, 10*time.Second)
+	if err := loaded.setDaemon("ABCD:EFGH"); err != nil {
+		t.Fatalf("setDaemon: %v", err)
+	}
+	s := loaded.series["a"]
+	if s == nil || s.Name != "app-a" || len(s.Tiers) != len(tiers) || len(s.Tiers[0]) != 1 {
+		t.Fatalf("loaded series = %+v", s)
+	}
+	for i, points := range s.Tiers {
+		if len(points) != 1 || points[0].Count != 1 || points[0].CPUPercent != 5 {
+			t.Errorf("tier %d = %+v, want the point as saved", i, points)
+		}
+	}
+}
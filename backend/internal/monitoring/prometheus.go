@@ -0,0 +1,153 @@
+package monitoring
+
+import (
+	"strings"
+
+	"github.com/genc-murat/harborview/internal/metrics"
+	"github.com/genc-murat/harborview/internal/system"
+)
+
+// containerStates are always reported, so a state dropping to zero
+// containers reads 0 rather than disappearing
+var containerStates = []string{"created", "running", "paused", "restarting", "removing", "exited", "dead"}
+
+// Collect returns the host metrics from the latest samples. Scrapes never
+// call the daemon themselves.
+func (c *Collector) Collect() []metrics.Family {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	up := 0.0
+	if c.up {
+		up = 1
+	}
+	families := []metrics.Family{{
+		Name:    "harborview_docker_up",
+		Help:    "Whether the last container listing succeeded.",
+		Type:    metrics.TypeGauge,
+		Samples: []metrics.Sample{{Value: up}},
+	}}
+
+	states := map[string]int{}
+	for _, ctr := range c.containers {
+		states[ctr.State]++
+	}
+	byState := metrics.Family{Name: "harborview_containers", Help: "Containers by state.", Type: metrics.TypeGauge}
+	for _, state := range containerStates {
+		byState.Samples = append(byState.Samples, metrics.Sample{Labels: []metrics.Label{{Name: "state", Value: state}}, Value: float64(states[state])})
+		delete(states, state)
+	}
+	for state, count := range states {
+		byState.Samples = append(byState.Samples, metrics.Sample{Labels: []metrics.Label{{Name: "state", Value: state}}, Value: float64(count)})
+	}
+	families = append(families, byState)
+
+	families = append(families, c.containerFamilies()...)
+	if c.disk != nil {
+		families = append(families, diskFamilies(c.disk)...)
+	}
+	return families
+}
+
+// containerFamilies reports the per-container samples
+func (c *Collector) containerFamilies() []metrics.Family {
+	cpuSeconds := metrics.Family{Name: "harborview_container_cpu_usage_seconds_total", Help: "CPU time used by the container.", Type: metrics.TypeCounter}
+	cpuPercent := metrics.Family{Name: "harborview_container_cpu_usage_percent", Help: "CPU used between the last two samples, as a percentage of one CPU.", Type: metrics.TypeGauge}
+	memory := metrics.Family{Name: "harborview_container_memory_usage_bytes", Help: "Memory used by the container, without reclaimable page cache.", Type: metrics.TypeGauge}
+	memoryLimit := metrics.Family{Name: "harborview_container_memory_limit_bytes", Help: "Memory available to the container.", Type: metrics.TypeGauge}
+	rx := metrics.Family{Name: "harborview_container_network_receive_bytes_total", Help: "Bytes received, by interface.", Type: metrics.TypeCounter}
+	tx := metrics.Family{Name: "harborview_container_network_transmit_bytes_total", Help: "Bytes transmitted, by interface.", Type: metrics.TypeCounter}
+	blockRead := metrics.Family{Name: "harborview_container_block_read_bytes_total", Help: "Bytes read from block devices.", Type: metrics.TypeCounter}
+	blockWrite := metrics.Family{Name: "harborview_container_block_write_bytes_total", Help: "Bytes written to block devices.", Type: metrics.TypeCounter}
+	pids := metrics.Family{Name: "harborview_container_pids", Help: "Processes and threads in the container.", Type: metrics.TypeGauge}
+
+	for _, sample := range c.samples {
+		labels := c.containerLabels(sample)
+		cpuSeconds.Samples = append(cpuSeconds.Samples, metrics.Sample{Labels: labels, Value: sample.CPUSeconds})
+		cpuPercent.Samples = append(cpuPercent.Samples, metrics.Sample{Labels: labels, Value: sample.CPUPercent})
+		memory.Samples = append(memory.Samples, metrics.Sample{Labels: labels, Value: float64(sample.MemoryUsage)})
+		memoryLimit.Samples = append(memoryLimit.Samples, metrics.Sample{Labels: labels, Value: float64(sample.MemoryLimit)})
+		blockRead.Samples = append(blockRead.Samples, metrics.Sample{Labels: labels, Value: float64(sample.BlockRead)})
+		blockWrite.Samples = append(blockWrite.Samples, metrics.Sample{Labels: labels, Value: float64(sample.BlockWrite)})
+		pids.Samples = append(pids.Samples, metrics.Sample{Labels: labels, Value: float64(sample.PIDs)})
+		for name, network := range sample.Networks {
+			withInterface := append(append([]metrics.Label(nil), labels...), metrics.Label{Name: "interface", Value: name})
+			rx.Samples = append(rx.Samples, metrics.Sample{Labels: withInterface, Value: float64(network.RxBytes)})
+			tx.Samples = append(tx.Samples, metrics.Sample{Labels: withInterface, Value: float64(network.TxBytes)})
+		}
+	}
+	return []metrics.Family{cpuSeconds, cpuPercent, memory, memoryLimit, rx, tx, blockRead, blockWrite, pids}
+}
+
+// containerLabels identifies a container's series. Configured container
+// labels are copied as container_label_<name>, empty when a container does
+// not carry them, so every series of a family has the same labels.
+func (c *Collector) containerLabels(sample Sample) []metrics.Label {
+	labels := []metrics.Label{
+		{Name: "id", Value: sample.ContainerID[:min(12, len(sample.ContainerID))]},
+		{Name: "name", Value: sample.Name},
+		{Name: "image", Value: sample.Image},
+	}
+	for _, key := range c.labels {
+		labels = append(labels, metrics.Label{Name: "container_label_" + metrics.LabelName(key), Value: sample.Labels[key]})
+	}
+	return labels
+}
+
+// diskFamilies reports disk usage by resource type, and per image and
+// volume
+func diskFamilies(usage *system.DiskUsage) []metrics.Family {
+	size := metrics.Family{Name: "harborview_disk_usage_bytes", Help: "Disk used, by resource type.", Type: metrics.TypeGauge}
+	reclaimable := metrics.Family{Name: "harborview_disk_reclaimable_bytes", Help: "Disk held by unused resources, by resource type.", Type: metrics.TypeGauge}
+	objects := metrics.Family{Name: "harborview_disk_objects", Help: "Resources on disk, by resource type.", Type: metrics.TypeGauge}
+	active := metrics.Family{Name: "harborview_disk_objects_active", Help: "Resources in use, by resource type.", Type: metrics.TypeGauge}
+
+	for _, row := range []struct {
+		name    string
+		summary system.UsageSummary
+	}{
+		{system.TypeImages, usage.Images},
+		{system.TypeContainers, usage.Containers},
+		{system.TypeVolumes, usage.Volumes},
+		{system.TypeBuildCache, usage.BuildCache},
+	} {
+		labels := []metrics.Label{{Name: "type", Value: row.name}}
+		size.Samples = append(size.Samples, metrics.Sample{Labels: labels, Value: float64(row.summary.Size)})
+		reclaimable.Samples = append(reclaimable.Samples, metrics.Sample{Labels: labels, Value: float64(row.summary.Reclaimable)})
+		objects.Samples = append(objects.Samples, metrics.Sample{Labels: labels, Value: float64(row.summary.Total)})
+		active.Samples = append(active.Samples, metrics.Sample{Labels: labels, Value: float64(row.summary.Active)})
+	}
+	families := []metrics.Family{size, reclaimable, objects, active}
+	if usage.Details == nil {
+		return families
+	}
+
+	images := metrics.Family{Name: "harborview_image_size_bytes", Help: "Size of each image, including layers shared with other images.", Type: metrics.TypeGauge}
+	for _, img := range usage.Details.Images {
+		tag := "<none>"
+		if len(img.RepoTags) > 0 {
+			tag = img.RepoTags[0]
+		}
+		images.Samples = append(images.Samples, metrics.Sample{
+			Labels: []metrics.Label{{Name: "id", Value: shortID(img.ID)}, {Name: "tag", Value: tag}},
+			Value:  float64(img.Size),
+		})
+	}
+	volumes := metrics.Family{Name: "harborview_volume_size_bytes", Help: "Size of each local volume.", Type: metrics.TypeGauge}
+	for _, v := range usage.Details.Volumes {
+		// Only local volumes report a size
+		if v.UsageData == nil || v.UsageData.Size < 0 {
+			continue
+		}
+		volumes.Samples = append(volumes.Samples, metrics.Sample{
+			Labels: []metrics.Label{{Name: "volume", Value: v.Name}, {Name: "driver", Value: v.Driver}},
+			Value:  float64(v.UsageData.Size),
+		})
+	}
+	return append(families, images, volumes)
+}
+
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	return id[:min(12, len(id))]
+}
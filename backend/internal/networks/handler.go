@@ -0,0 +1,158 @@
+package networks
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/httputil"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterRoutes registers all routes for networks on the shared client
+func RegisterRoutes(app *fiber.App, cfg config.Config, cli *client.Client) {
+	// Initialize the service
+	service := NewNetworkService(cfg, cli)
+
+	MountRoutes(app.Group("/networks"), func(*fiber.Ctx) (NetworkService, error) {
+		return service, nil
+	})
+}
+
+// MountRoutes registers the network routes on networksGroup. lookup picks
+// the service each request runs against; a *fiber.Error from it sets the
+// status.
+func MountRoutes(networksGroup fiber.Router, lookup func(c *fiber.Ctx) (NetworkService, error)) {
+	handle := httputil.Bind(lookup)
+
+	// List networks, optionally filtered by ?name=, ?driver=, ?scope= and ?label=
+	networksGroup.Get("/", handle(ListNetworks))
+
+	// Create a network
+	networksGroup.Post("/", handle(CreateNetwork))
+
+	// Prune unused networks
+	networksGroup.Post("/prune", handle(PruneNetworks))
+
+	// Inspect a specific network
+	networksGroup.Get("/:id", handle(InspectNetwork))
+
+	// Remove a network
+	networksGroup.Delete("/:id", handle(RemoveNetwork))
+
+	// Connect a container to a network
+	networksGroup.Post("/:id/connect", handle(ConnectContainer))
+
+	// Disconnect a container from a network
+	networksGroup.Post("/:id/disconnect/:container", handle(DisconnectContainer))
+}
+
+// networkError maps daemon and validation errors to statuses, so a missing
+// network is 404 and removing a predefined or in-use network is 403
+func networkError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errdefs.IsInvalidParameter(err):
+		status = fiber.StatusBadRequest
+	case errdefs.IsNotFound(err):
+		status = fiber.StatusNotFound
+	case errdefs.IsConflict(err):
+		status = fiber.StatusConflict
+	case errdefs.IsForbidden(err):
+		status = fiber.StatusForbidden
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
+
+// queryFilters builds daemon filters from the given query parameters. Each
+// may repeat, and label takes key or key=value.
+func queryFilters(c *fiber.Ctx, keys ...string) filters.Args {
+	args := filters.NewArgs()
+	for _, key := range keys {
+		for _, value := range c.Context().QueryArgs().PeekMulti(key) {
+			if v := strings.TrimSpace(string(value)); v != "" {
+				args.Add(key, v)
+			}
+		}
+	}
+	return args
+}
+
+// ListNetworks handler for fetching networks
+func ListNetworks(c *fiber.Ctx, service NetworkService) error {
+	networks, err := service.ListNetworks(c.UserContext(), queryFilters(c, "name", "driver", "scope", "label"))
+	if err != nil {
+		return networkError(c, err)
+	}
+	return c.JSON(networks)
+}
+
+// InspectNetwork handler for inspecting a specific network. ?verbose=true
+// includes swarm service details.
+func InspectNetwork(c *fiber.Ctx, service NetworkService) error {
+	networkID := c.Params("id")
+	verbose := c.QueryBool("verbose", false)
+	network, err := service.InspectNetwork(c.UserContext(), networkID, verbose)
+	if err != nil {
+		return networkError(c, err)
+	}
+	return c.JSON(network)
+}
+
+// CreateNetwork handler for creating a network
+func CreateNetwork(c *fiber.Ctx, service NetworkService) error {
+	var request CreateRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	response, err := service.CreateNetwork(c.UserContext(), request)
+	if err != nil {
+		return networkError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// RemoveNetwork handler for deleting a network
+func RemoveNetwork(c *fiber.Ctx, service NetworkService) error {
+	networkID := c.Params("id")
+	if err := service.RemoveNetwork(c.UserContext(), networkID); err != nil {
+		return networkError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Network removed successfully"})
+}
+
+// PruneNetworks handler for pruning unused networks, optionally limited by
+// ?until= and ?label=
+func PruneNetworks(c *fiber.Ctx, service NetworkService) error {
+	report, err := service.PruneNetworks(c.UserContext(), queryFilters(c, "until", "label"))
+	if err != nil {
+		return networkError(c, err)
+	}
+	return c.JSON(report)
+}
+
+// ConnectContainer handler for attaching a container to a network
+func ConnectContainer(c *fiber.Ctx, service NetworkService) error {
+	networkID := c.Params("id")
+	var request ConnectRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := service.ConnectContainer(c.UserContext(), networkID, request); err != nil {
+		return networkError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Container connected successfully"})
+}
+
+// DisconnectContainer handler for detaching a container from a network
+func DisconnectContainer(c *fiber.Ctx, service NetworkService) error {
+	networkID := c.Params("id")
+	containerID := c.Params("container")
+	force := c.QueryBool("force", false) // Default: do not force
+	if err := service.DisconnectContainer(c.UserContext(), networkID, containerID, force); err != nil {
+		return networkError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Container disconnected successfully"})
+}
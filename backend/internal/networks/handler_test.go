@@ -0,0 +1,95 @@
+package networks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/network"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// serve mounts the network routes on a service over cli
+func serve(cli *fakeClient) *fiber.App {
+	app := fiber.New()
+	service := NewNetworkService(config.Config{}, cli)
+	MountRoutes(app.Group("/networks"), func(*fiber.Ctx) (NetworkService, error) {
+		return service, nil
+	})
+	return app
+}
+
+func request(t *testing.T, app *fiber.App, method, url, body string) (int, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, data
+}
+
+func TestNetworkRoutes(t *testing.T) {
+	tests := []struct {
+		method, url, body string
+		status            int
+	}{
+		{"GET", "/networks/backend", "", fiber.StatusOK},
+		{"GET", "/networks/missing", "", fiber.StatusNotFound},
+		{"POST", "/networks", `{"name":"frontend","ipam":[{"subnet":"10.10.0.0/24"}]}`, fiber.StatusCreated},
+		{"POST", "/networks", `{"name":"frontend","ipam":[{"subnet":"10.10.0.1/24"}]}`, fiber.StatusBadRequest},
+		{"POST", "/networks", `{"name":"backend"}`, fiber.StatusConflict},
+		{"POST", "/networks", `{"name":`, fiber.StatusBadRequest},
+		{"DELETE", "/networks/missing", "", fiber.StatusNotFound},
+		{"DELETE", "/networks/bridge", "", fiber.StatusForbidden},
+		{"DELETE", "/networks/backend", "", fiber.StatusForbidden},
+		{"POST", "/networks/backend/connect", `{"container":"web","ipv4Address":"fd00::1"}`, fiber.StatusBadRequest},
+		{"POST", "/networks/missing/connect", `{"container":"web"}`, fiber.StatusNotFound},
+		{"POST", "/networks/backend/connect", `{"container":"web","aliases":["www"]}`, fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		app := serve(newFakeClient())
+		status, body := request(t, app, tt.method, tt.url, tt.body)
+		if status != tt.status {
+			t.Errorf("%s %s = %d %s, want %d", tt.method, tt.url, status, body, tt.status)
+		}
+	}
+}
+
+func TestListNetworks(t *testing.T) {
+	cli := newFakeClient()
+	app := serve(cli)
+
+	status, body := request(t, app, "GET", "/networks?name=backend&label=team&label=env%3Dprod&driver=+", "")
+	if status != fiber.StatusOK {
+		t.Fatalf("GET /networks = %d %s", status, body)
+	}
+	var list []network.Summary
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "backend" {
+		t.Errorf("networks = %+v", list)
+	}
+	labels := cli.filters.Get("label")
+	slices.Sort(labels)
+	if !slices.Equal(labels, []string{"env=prod", "team"}) || cli.filters.Contains("driver") {
+		t.Errorf("filters = %v", cli.filters)
+	}
+
+	if status, _ := request(t, app, "POST", "/networks/prune?until=24h", ""); status != fiber.StatusOK || !cli.filters.ExactMatch("until", "24h") {
+		t.Errorf("prune = %d, filters %v", status, cli.filters)
+	}
+
+	if status, _ := request(t, app, "POST", "/networks/backend/disconnect/api?force=true", ""); status != fiber.StatusOK || cli.disconnect != "backend api force=true" {
+		t.Errorf("disconnect = %d, %s", status, cli.disconnect)
+	}
+}
@@ -0,0 +1,271 @@
+package networks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// NetworkService interface for dependency injection
+type NetworkService interface {
+	ListNetworks(ctx context.Context, filter filters.Args) ([]network.Summary, error)
+	InspectNetwork(ctx context.Context, networkID string, verbose bool) (*network.Inspect, error)
+	CreateNetwork(ctx context.Context, request CreateRequest) (network.CreateResponse, error)
+	RemoveNetwork(ctx context.Context, networkID string) error
+	PruneNetworks(ctx context.Context, filter filters.Args) (network.PruneReport, error)
+	ConnectContainer(ctx context.Context, networkID string, request ConnectRequest) error
+	DisconnectContainer(ctx context.Context, networkID, containerID string, force bool) error
+}
+
+// NetworkClient is the part of the Docker client the network service needs
+type NetworkClient interface {
+	NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error)
+	NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error)
+	NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error)
+	NetworkRemove(ctx context.Context, networkID string) error
+	NetworksPrune(ctx context.Context, pruneFilter filters.Args) (network.PruneReport, error)
+	NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error
+	NetworkDisconnect(ctx context.Context, networkID, containerID string, force bool) error
+}
+
+type networkService struct {
+	cli      NetworkClient
+	timeouts docker.Timeouts
+}
+
+// IPAMPool is one address pool of a network
+type IPAMPool struct {
+	Subnet       string            `json:"subnet"`
+	Gateway      string            `json:"gateway,omitempty"`
+	IPRange      string            `json:"ipRange,omitempty"`
+	AuxAddresses map[string]string `json:"auxAddresses,omitempty"`
+}
+
+// CreateRequest is the body of a network create call
+type CreateRequest struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	Internal   bool              `json:"internal"`
+	Attachable bool              `json:"attachable"`
+	EnableIPv6 *bool             `json:"enableIPv6,omitempty"`
+	IPAMDriver string            `json:"ipamDriver,omitempty"`
+	IPAM       []IPAMPool        `json:"ipam,omitempty"`
+	Options    map[string]string `json:"options,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// ConnectRequest is the body of a container connect call
+type ConnectRequest struct {
+	Container   string   `json:"container"`
+	Aliases     []string `json:"aliases,omitempty"`
+	IPv4Address string   `json:"ipv4Address,omitempty"`
+	IPv6Address string   `json:"ipv6Address,omitempty"`
+}
+
+// NewNetworkService creates a NetworkService for the daemon behind cli
+func NewNetworkService(cfg config.Config, cli NetworkClient) NetworkService {
+	return &networkService{cli: cli, timeouts: docker.NewTimeouts(cfg)}
+}
+
+func (s *networkService) ListNetworks(ctx context.Context, filter filters.Args) ([]network.Summary, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	networks, err := s.cli.NetworkList(ctx, network.ListOptions{Filters: filter})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	return networks, nil
+}
+
+func (s *networkService) InspectNetwork(ctx context.Context, networkID string, verbose bool) (*network.Inspect, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	inspect, err := s.cli.NetworkInspect(ctx, networkID, network.InspectOptions{Verbose: verbose})
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect network: %w", err)
+	}
+	return &inspect, nil
+}
+
+// CreateNetwork creates a network. IPAM pools are validated up front so a
+// typo in a subnet gets a clear error instead of the daemon's.
+func (s *networkService) CreateNetwork(ctx context.Context, request CreateRequest) (network.CreateResponse, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	if request.Name == "" {
+		return network.CreateResponse{}, invalid("network name is required")
+	}
+	options := network.CreateOptions{
+		Driver:     request.Driver,
+		Internal:   request.Internal,
+		Attachable: request.Attachable,
+		EnableIPv6: request.EnableIPv6,
+		Options:    request.Options,
+		Labels:     request.Labels,
+	}
+	if len(request.IPAM) > 0 || request.IPAMDriver != "" {
+		ipam, err := buildIPAM(request.IPAMDriver, request.IPAM)
+		if err != nil {
+			return network.CreateResponse{}, err
+		}
+		options.IPAM = ipam
+	}
+
+	response, err := s.cli.NetworkCreate(ctx, request.Name, options)
+	if err != nil {
+		return network.CreateResponse{}, fmt.Errorf("failed to create network: %w", err)
+	}
+	if response.Warning != "" {
+		log.Printf("Network %s created with warning: %s", request.Name, response.Warning)
+	}
+	log.Printf("Network %s created successfully", request.Name)
+	return response, nil
+}
+
+func (s *networkService) RemoveNetwork(ctx context.Context, networkID string) error {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	if err := s.cli.NetworkRemove(ctx, networkID); err != nil {
+		return fmt.Errorf("failed to remove network: %w", err)
+	}
+	log.Printf("Network %s removed successfully", networkID)
+	return nil
+}
+
+func (s *networkService) PruneNetworks(ctx context.Context, filter filters.Args) (network.PruneReport, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpPrune)
+	defer cancel()
+
+	report, err := s.cli.NetworksPrune(ctx, filter)
+	if err != nil {
+		return network.PruneReport{}, fmt.Errorf("failed to prune networks: %w", err)
+	}
+	return report, nil
+}
+
+// ConnectContainer attaches a container to a network, optionally with DNS
+// aliases and static addresses from the network's subnets
+func (s *networkService) ConnectContainer(ctx context.Context, networkID string, request ConnectRequest) error {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	if request.Container == "" {
+		return invalid("container is required")
+	}
+	settings := &network.EndpointSettings{Aliases: request.Aliases}
+	if request.IPv4Address != "" || request.IPv6Address != "" {
+		if err := validateAddress(request.IPv4Address, true); err != nil {
+			return err
+		}
+		if err := validateAddress(request.IPv6Address, false); err != nil {
+			return err
+		}
+		settings.IPAMConfig = &network.EndpointIPAMConfig{
+			IPv4Address: request.IPv4Address,
+			IPv6Address: request.IPv6Address,
+		}
+	}
+
+	if err := s.cli.NetworkConnect(ctx, networkID, request.Container, settings); err != nil {
+		return fmt.Errorf("failed to connect container: %w", err)
+	}
+	log.Printf("Container %s connected to network %s", request.Container, networkID)
+	return nil
+}
+
+func (s *networkService) DisconnectContainer(ctx context.Context, networkID, containerID string, force bool) error {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	if err := s.cli.NetworkDisconnect(ctx, networkID, containerID, force); err != nil {
+		return fmt.Errorf("failed to disconnect container: %w", err)
+	}
+	log.Printf("Container %s disconnected from network %s", containerID, networkID)
+	return nil
+}
+
+// buildIPAM checks that every pool has a valid subnet and that gateways, IP
+// ranges and auxiliary addresses fall inside it
+func buildIPAM(driver string, pools []IPAMPool) (*network.IPAM, error) {
+	ipam := &network.IPAM{Driver: driver}
+	for _, pool := range pools {
+		subnet, err := netip.ParsePrefix(pool.Subnet)
+		if err != nil {
+			return nil, invalid("invalid subnet %q: %w", pool.Subnet, err)
+		}
+		if subnet != subnet.Masked() {
+			return nil, invalid("subnet %s has host bits set; use %s", pool.Subnet, subnet.Masked())
+		}
+		if pool.Gateway != "" {
+			if err := inSubnet(subnet, pool.Gateway, "gateway"); err != nil {
+				return nil, err
+			}
+		}
+		if pool.IPRange != "" {
+			ipRange, err := netip.ParsePrefix(pool.IPRange)
+			if err != nil {
+				return nil, invalid("invalid IP range %q: %w", pool.IPRange, err)
+			}
+			if !subnet.Contains(ipRange.Addr()) || ipRange.Bits() < subnet.Bits() {
+				return nil, invalid("IP range %s is not inside subnet %s", pool.IPRange, pool.Subnet)
+			}
+		}
+		for name, address := range pool.AuxAddresses {
+			if err := inSubnet(subnet, address, "auxiliary address "+name); err != nil {
+				return nil, err
+			}
+		}
+		ipam.Config = append(ipam.Config, network.IPAMConfig{
+			Subnet:     pool.Subnet,
+			Gateway:    pool.Gateway,
+			IPRange:    pool.IPRange,
+			AuxAddress: pool.AuxAddresses,
+		})
+	}
+	return ipam, nil
+}
+
+func inSubnet(subnet netip.Prefix, address, what string) error {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return invalid("invalid %s %q: %w", what, address, err)
+	}
+	if !subnet.Contains(addr) {
+		return invalid("%s %s is not inside subnet %s", what, address, subnet)
+	}
+	return nil
+}
+
+// validateAddress checks an optional static address is of the right family
+func validateAddress(address string, v4 bool) error {
+	if address == "" {
+		return nil
+	}
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return invalid("invalid IP address %q: %w", address, err)
+	}
+	if addr.Is4() != v4 {
+		if v4 {
+			return invalid("%s is not an IPv4 address", address)
+		}
+		return invalid("%s is not an IPv6 address", address)
+	}
+	return nil
+}
+
+// invalid marks a request error so handlers answer 400 instead of 500
+func invalid(format string, args ...any) error {
+	return errdefs.InvalidParameter(fmt.Errorf(format, args...))
+}
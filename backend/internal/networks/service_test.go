@@ -0,0 +1,195 @@
+package networks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// fakeClient keeps networks in memory and records what the service asks of
+// the daemon
+type fakeClient struct {
+	networks map[string]network.Inspect
+
+	created    network.CreateOptions
+	filters    filters.Args
+	connected  *network.EndpointSettings
+	disconnect string
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{networks: map[string]network.Inspect{
+		"bridge":  {ID: "b1", Name: "bridge", Driver: "bridge"},
+		"backend": {ID: "n2", Name: "backend", Driver: "bridge", Containers: map[string]network.EndpointResource{"c1": {Name: "api"}}},
+	}}
+}
+
+func (f *fakeClient) NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+	f.filters = options.Filters
+	var list []network.Summary
+	for _, n := range f.networks {
+		if options.Filters.Contains("name") && !options.Filters.ExactMatch("name", n.Name) {
+			continue
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+func (f *fakeClient) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	n, ok := f.networks[networkID]
+	if !ok {
+		return network.Inspect{}, errdefs.NotFound(fmt.Errorf("network %s not found", networkID))
+	}
+	return n, nil
+}
+
+func (f *fakeClient) NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error) {
+	if _, ok := f.networks[name]; ok {
+		return network.CreateResponse{}, errdefs.Conflict(fmt.Errorf("network with name %s already exists", name))
+	}
+	f.created = options
+	f.networks[name] = network.Inspect{ID: "new-" + name, Name: name, Driver: options.Driver}
+	return network.CreateResponse{ID: "new-" + name}, nil
+}
+
+func (f *fakeClient) NetworkRemove(ctx context.Context, networkID string) error {
+	switch n, ok := f.networks[networkID]; {
+	case !ok:
+		return errdefs.NotFound(fmt.Errorf("network %s not found", networkID))
+	case n.Name == "bridge":
+		return errdefs.Forbidden(errors.New("bridge is a pre-defined network and cannot be removed"))
+	case len(n.Containers) > 0:
+		return errdefs.Forbidden(fmt.Errorf("error while removing network: network %s has active endpoints", networkID))
+	}
+	delete(f.networks, networkID)
+	return nil
+}
+
+func (f *fakeClient) NetworksPrune(ctx context.Context, pruneFilter filters.Args) (network.PruneReport, error) {
+	f.filters = pruneFilter
+	return network.PruneReport{NetworksDeleted: []string{"unused"}}, nil
+}
+
+func (f *fakeClient) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	if _, ok := f.networks[networkID]; !ok {
+		return errdefs.NotFound(fmt.Errorf("network %s not found", networkID))
+	}
+	f.connected = config
+	return nil
+}
+
+func (f *fakeClient) NetworkDisconnect(ctx context.Context, networkID, containerID string, force bool) error {
+	f.disconnect = fmt.Sprintf("%s %s force=%t", networkID, containerID, force)
+	return nil
+}
+
+func TestBuildIPAM(t *testing.T) {
+	tests := []struct {
+		name string
+		pool IPAMPool
+		ok   bool
+	}{
+		{"subnet only", IPAMPool{Subnet: "172.28.0.0/16"}, true},
+		{"full pool", IPAMPool{Subnet: "172.28.0.0/16", Gateway: "172.28.0.1", IPRange: "172.28.5.0/24", AuxAddresses: map[string]string{"host": "172.28.1.5"}}, true},
+		{"ipv6", IPAMPool{Subnet: "fd00:28::/64", Gateway: "fd00:28::1"}, true},
+		{"bad subnet", IPAMPool{Subnet: "172.28.0.0/33"}, false},
+		{"host bits", IPAMPool{Subnet: "172.28.0.1/16"}, false},
+		{"gateway outside", IPAMPool{Subnet: "172.28.0.0/16", Gateway: "10.0.0.1"}, false},
+		{"bad gateway", IPAMPool{Subnet: "172.28.0.0/16", Gateway: "gateway"}, false},
+		{"range outside", IPAMPool{Subnet: "172.28.0.0/16", IPRange: "172.29.0.0/24"}, false},
+		{"range wider", IPAMPool{Subnet: "172.28.0.0/16", IPRange: "172.28.0.0/8"}, false},
+		{"aux outside", IPAMPool{Subnet: "172.28.0.0/16", AuxAddresses: map[string]string{"host": "192.168.1.5"}}, false},
+	}
+	for _, tt := range tests {
+		ipam, err := buildIPAM("default", []IPAMPool{tt.pool})
+		if !tt.ok {
+			if !errdefs.IsInvalidParameter(err) {
+				t.Errorf("%s: buildIPAM = %v, want an invalid parameter", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: buildIPAM: %v", tt.name, err)
+			continue
+		}
+		config := ipam.Config[0]
+		if ipam.Driver != "default" || config.Subnet != tt.pool.Subnet || config.Gateway != tt.pool.Gateway || config.IPRange != tt.pool.IPRange {
+			t.Errorf("%s: buildIPAM = %+v", tt.name, ipam)
+		}
+	}
+}
+
+func TestCreateNetwork(t *testing.T) {
+	cli := newFakeClient()
+	service := NewNetworkService(config.Config{}, cli)
+	ctx := context.Background()
+
+	request := CreateRequest{
+		Name:       "frontend",
+		Driver:     "bridge",
+		Internal:   true,
+		Attachable: true,
+		IPAM:       []IPAMPool{{Subnet: "10.10.0.0/24", Gateway: "10.10.0.1"}},
+		Labels:     map[string]string{"team": "web"},
+	}
+	response, err := service.CreateNetwork(ctx, request)
+	if err != nil || response.ID != "new-frontend" {
+		t.Fatalf("CreateNetwork = %+v, %v", response, err)
+	}
+	created := cli.created
+	if created.Driver != "bridge" || !created.Internal || !created.Attachable || created.Labels["team"] != "web" {
+		t.Errorf("create options = %+v", created)
+	}
+	if created.IPAM == nil || len(created.IPAM.Config) != 1 || created.IPAM.Config[0].Gateway != "10.10.0.1" {
+		t.Errorf("create IPAM = %+v", created.IPAM)
+	}
+
+	// Without pools or a driver the daemon picks the addresses
+	if _, err := service.CreateNetwork(ctx, CreateRequest{Name: "plain"}); err != nil || cli.created.IPAM != nil {
+		t.Errorf("CreateNetwork without IPAM = %v, IPAM %+v", err, cli.created.IPAM)
+	}
+	if _, err := service.CreateNetwork(ctx, CreateRequest{}); !errdefs.IsInvalidParameter(err) {
+		t.Errorf("CreateNetwork without a name = %v", err)
+	}
+	if _, err := service.CreateNetwork(ctx, CreateRequest{Name: "frontend"}); !errdefs.IsConflict(err) {
+		t.Errorf("CreateNetwork of an existing name = %v, want the daemon's conflict kept", err)
+	}
+}
+
+func TestConnectContainer(t *testing.T) {
+	cli := newFakeClient()
+	service := NewNetworkService(config.Config{}, cli)
+	ctx := context.Background()
+
+	request := ConnectRequest{Container: "api", Aliases: []string{"db"}, IPv4Address: "172.28.0.10", IPv6Address: "fd00::10"}
+	if err := service.ConnectContainer(ctx, "backend", request); err != nil {
+		t.Fatalf("ConnectContainer: %v", err)
+	}
+	settings := cli.connected
+	if len(settings.Aliases) != 1 || settings.Aliases[0] != "db" || settings.IPAMConfig == nil || settings.IPAMConfig.IPv4Address != "172.28.0.10" || settings.IPAMConfig.IPv6Address != "fd00::10" {
+		t.Errorf("endpoint settings = %+v", settings)
+	}
+
+	if err := service.ConnectContainer(ctx, "backend", ConnectRequest{Container: "api"}); err != nil || cli.connected.IPAMConfig != nil {
+		t.Errorf("ConnectContainer without addresses = %v, IPAM %+v", err, cli.connected.IPAMConfig)
+	}
+
+	tests := []ConnectRequest{
+		{},
+		{Container: "api", IPv4Address: "fd00::10"},
+		{Container: "api", IPv6Address: "172.28.0.10"},
+		{Container: "api", IPv4Address: "host"},
+	}
+	for _, request := range tests {
+		if err := service.ConnectContainer(ctx, "backend", request); !errdefs.IsInvalidParameter(err) {
+			t.Errorf("ConnectContainer(%+v) = %v, want an invalid parameter", request, err)
+		}
+	}
+}
@@ -0,0 +1,555 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Manifest media types understood by the client
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = ocispec.MediaTypeImageManifest
+	MediaTypeOCIIndex           = ocispec.MediaTypeImageIndex
+)
+
+// manifestAccept is sent on every manifest request so the registry returns
+// the stored manifest instead of converting it to schema 1.
+var manifestAccept = strings.Join([]string{
+	MediaTypeOCIIndex,
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeDockerManifest,
+}, ", ")
+
+// Error is returned for any non-2xx registry response
+type Error struct {
+	StatusCode int
+	Errors     []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("registry returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	messages := make([]string, 0, len(e.Errors))
+	for _, item := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", item.Code, item.Message))
+	}
+	return fmt.Sprintf("registry returned %d: %s", e.StatusCode, strings.Join(messages, "; "))
+}
+
+// IsNotFound reports whether err is a registry 404
+func IsNotFound(err error) bool {
+	var registryErr *Error
+	return errors.As(err, &registryErr) && registryErr.StatusCode == http.StatusNotFound
+}
+
+// Manifest is a fetched manifest. Exactly one of Manifest and Index is set,
+// depending on whether the reference points at a single image or at a
+// multi-arch manifest list / OCI index.
+type Manifest struct {
+	Digest    string            `json:"digest"`
+	MediaType string            `json:"mediaType"`
+	Size      int64             `json:"size"`
+	Manifest  *ocispec.Manifest `json:"manifest,omitempty"`
+	Index     *ocispec.Index    `json:"index,omitempty"`
+	Raw       []byte            `json:"-"`
+}
+
+// IsIndex reports whether the manifest is a manifest list or OCI index
+func (m *Manifest) IsIndex() bool {
+	return m.Index != nil
+}
+
+// Client talks to a registry through the Docker Registry HTTP API v2. It
+// answers bearer-token and basic auth challenges using the configured
+// credentials and caches issued tokens per scope.
+type Client struct {
+	baseURL  *url.URL
+	username string
+	password string
+	http     *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// ClientOptions configures a Client
+type ClientOptions struct {
+	Username           string
+	Password           string
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+	// HTTPClient overrides the transport, e.g. to reach an in-process fake
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client for the registry at baseURL
+func NewClient(baseURL string, options ClientOptions) (*Client, error) {
+	if baseURL == "" {
+		return nil, errors.New("registry base URL is not configured")
+	}
+	parsed, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry base URL: %w", err)
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid registry base URL %q", baseURL)
+	}
+
+	httpClient := options.HTTPClient
+	if httpClient == nil {
+		timeout := options.Timeout
+		if timeout == 0 {
+			timeout = 30 * time.Second
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if options.InsecureSkipVerify {
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		httpClient = &http.Client{Transport: transport, Timeout: timeout}
+	}
+
+	return &Client{
+		baseURL:  parsed,
+		username: options.Username,
+		password: options.Password,
+		http:     httpClient,
+		tokens:   make(map[string]string),
+	}, nil
+}
+
+// Host returns the registry host, as used in image references
+func (c *Client) Host() string {
+	return c.baseURL.Host
+}
+
+// Catalog lists up to n repositories after last. next is the cursor for the
+// following page, or empty when there are no more repositories.
+func (c *Client) Catalog(ctx context.Context, n int, last string) (repositories []string, next string, err error) {
+	var body struct {
+		Repositories []string `json:"repositories"`
+	}
+	next, err = c.getPage(ctx, "/v2/_catalog", "registry:catalog:*", n, last, &body)
+	return body.Repositories, next, err
+}
+
+// Tags lists up to n tags of repo after last. next is the cursor for the
+// following page, or empty when there are no more tags.
+func (c *Client) Tags(ctx context.Context, repo string, n int, last string) (tags []string, next string, err error) {
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	next, err = c.getPage(ctx, "/v2/"+repo+"/tags/list", pullScope(repo), n, last, &body)
+	return body.Tags, next, err
+}
+
+// AllTags follows pagination until every tag of repo has been listed
+func (c *Client) AllTags(ctx context.Context, repo string) ([]string, error) {
+	var all []string
+	last := ""
+	for {
+		tags, next, err := c.Tags(ctx, repo, 0, last)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, tags...)
+		if next == "" || next == last {
+			return all, nil
+		}
+		last = next
+	}
+}
+
+// Manifest fetches the manifest, manifest list or index for ref, which may be
+// a tag or a digest.
+func (c *Client) Manifest(ctx context.Context, repo, ref string) (*Manifest, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/v2/"+repo+"/manifests/"+ref, nil, pullScope(repo), manifestAccept)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return parseManifest(raw, resp.Header.Get("Content-Type"), resp.Header.Get("Docker-Content-Digest"))
+}
+
+// ManifestDigest resolves ref to the digest of the manifest it points at
+// without downloading the manifest.
+func (c *Client) ManifestDigest(ctx context.Context, repo, ref string) (string, error) {
+	resp, err := c.do(ctx, http.MethodHead, "/v2/"+repo+"/manifests/"+ref, nil, pullScope(repo), manifestAccept)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		// Some registries omit the header on HEAD; fall back to a GET
+		manifest, err := c.Manifest(ctx, repo, ref)
+		if err != nil {
+			return "", err
+		}
+		digest = manifest.Digest
+	}
+	return digest, nil
+}
+
+// Blob downloads a blob. It is meant for small JSON blobs such as image
+// configs; layers should be streamed with BlobReader.
+func (c *Client) Blob(ctx context.Context, repo, digest string) ([]byte, error) {
+	body, err := c.BlobReader(ctx, repo, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// BlobReader streams a blob. The caller must close the returned reader.
+func (c *Client) BlobReader(ctx context.Context, repo, digest string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/v2/"+repo+"/blobs/"+digest, nil, pullScope(repo), "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// ImageConfig downloads and decodes the image config blob with digest
+func (c *Client) ImageConfig(ctx context.Context, repo, digest string) (*ocispec.Image, error) {
+	raw, err := c.Blob(ctx, repo, digest)
+	if err != nil {
+		return nil, err
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode image config: %w", err)
+	}
+	return &config, nil
+}
+
+// DeleteManifest deletes the manifest with digest, removing every tag that
+// points at it. Registries reject deletion by tag, so callers must resolve
+// tags with ManifestDigest first.
+func (c *Client) DeleteManifest(ctx context.Context, repo, digest string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/v2/"+repo+"/manifests/"+digest, nil, deleteScope(repo), "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// getPage fetches one page of a paginated list endpoint into out and returns
+// the "last" cursor from the Link header.
+func (c *Client) getPage(ctx context.Context, path, scope string, n int, last string, out any) (string, error) {
+	query := url.Values{}
+	if n > 0 {
+		query.Set("n", fmt.Sprint(n))
+	}
+	if last != "" {
+		query.Set("last", last)
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, path, query, scope, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return "", fmt.Errorf("failed to decode registry response: %w", err)
+	}
+	return nextCursor(resp.Header.Get("Link")), nil
+}
+
+// do sends a request, answering at most one auth challenge, and turns non-2xx
+// responses into *Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, scope, accept string) (*http.Response, error) {
+	newRequest := func() (*http.Request, error) {
+		target := *c.baseURL
+		target.Path = c.baseURL.Path + path
+		target.RawQuery = query.Encode()
+		req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req, scope)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("registry request failed: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+		resp.Body.Close()
+
+		if err := c.answerChallenge(ctx, challenge, scope); err != nil {
+			return nil, err
+		}
+		if req, err = newRequest(); err != nil {
+			return nil, err
+		}
+		c.authorize(req, scope)
+		if resp, err = c.http.Do(req); err != nil {
+			return nil, fmt.Errorf("registry request failed: %w", err)
+		}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		registryErr := &Error{StatusCode: resp.StatusCode}
+		if method != http.MethodHead {
+			json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(registryErr)
+		}
+		return nil, registryErr
+	}
+	return resp, nil
+}
+
+// authorize attaches a cached token for scope, or basic credentials when the
+// registry asked for basic auth.
+func (c *Client) authorize(req *http.Request, scope string) {
+	c.mu.Lock()
+	token, ok := c.tokens[scope]
+	c.mu.Unlock()
+
+	switch {
+	case ok && token == basicAuthMarker:
+		req.SetBasicAuth(c.username, c.password)
+	case ok:
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// basicAuthMarker is cached instead of a token when the registry answered
+// with a Basic challenge
+const basicAuthMarker = "\x00basic"
+
+// answerChallenge obtains credentials for scope from a WWW-Authenticate
+// challenge and caches them.
+func (c *Client) answerChallenge(ctx context.Context, ch challenge, scope string) error {
+	switch strings.ToLower(ch.scheme) {
+	case "basic":
+		if c.username == "" {
+			return &Error{StatusCode: http.StatusUnauthorized}
+		}
+		c.storeToken(scope, basicAuthMarker)
+		return nil
+	case "bearer":
+		token, err := c.fetchToken(ctx, ch, scope)
+		if err != nil {
+			return err
+		}
+		c.storeToken(scope, token)
+		return nil
+	default:
+		return &Error{StatusCode: http.StatusUnauthorized}
+	}
+}
+
+func (c *Client) storeToken(scope, token string) {
+	c.mu.Lock()
+	c.tokens[scope] = token
+	c.mu.Unlock()
+}
+
+// fetchToken requests a bearer token from the challenge realm
+func (c *Client) fetchToken(ctx context.Context, ch challenge, scope string) (string, error) {
+	realm := ch.params["realm"]
+	if realm == "" {
+		return "", errors.New("bearer challenge without realm")
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid token realm: %w", err)
+	}
+
+	query := tokenURL.Query()
+	if service := ch.params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if challengeScope := ch.params["scope"]; challengeScope != "" {
+		scope = challengeScope
+	}
+	for _, s := range strings.Split(scope, " ") {
+		query.Add("scope", s)
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request returned %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", errors.New("token response did not contain a token")
+}
+
+// challenge is a parsed WWW-Authenticate header
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseChallenge parses `Bearer realm="...",service="...",scope="..."`.
+// Quoted values may contain commas, e.g. scope="repository:foo:pull,push".
+func parseChallenge(header string) challenge {
+	ch := challenge{params: make(map[string]string)}
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	ch.scheme = scheme
+
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, after, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		after = strings.TrimSpace(after)
+
+		var value string
+		if strings.HasPrefix(after, `"`) {
+			end := strings.Index(after[1:], `"`)
+			if end < 0 {
+				value, rest = after[1:], ""
+			} else {
+				value, rest = after[1:end+1], after[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(after, ",")
+		}
+		ch.params[key] = value
+		rest = strings.TrimLeft(strings.TrimSpace(rest), ",")
+		rest = strings.TrimSpace(rest)
+	}
+	return ch
+}
+
+// nextCursor extracts the "last" parameter from a `Link: <...>; rel="next"`
+// header, returning "" when there is no next page.
+func nextCursor(link string) string {
+	if link == "" || !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start := strings.Index(link, "<")
+	end := strings.Index(link, ">")
+	if start < 0 || end <= start {
+		return ""
+	}
+	next, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+	return next.Query().Get("last")
+}
+
+// parseManifest decodes raw according to its media type. Registries that
+// omit Content-Type are handled by looking at the mediaType field.
+func parseManifest(raw []byte, contentType, digest string) (*Manifest, error) {
+	var probe struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	if mediaType == "" || mediaType == "application/json" || mediaType == "text/plain" {
+		mediaType = probe.MediaType
+	}
+	if mediaType == "" {
+		mediaType = MediaTypeOCIManifest
+		if probe.Manifests != nil {
+			mediaType = MediaTypeOCIIndex
+		}
+	}
+	if digest == "" {
+		digest = digestOf(raw)
+	}
+
+	manifest := &Manifest{Digest: digest, MediaType: mediaType, Size: int64(len(raw)), Raw: raw}
+	switch mediaType {
+	case MediaTypeOCIIndex, MediaTypeDockerManifestList:
+		manifest.Index = &ocispec.Index{}
+		if err := json.Unmarshal(raw, manifest.Index); err != nil {
+			return nil, fmt.Errorf("failed to decode manifest index: %w", err)
+		}
+	case MediaTypeOCIManifest, MediaTypeDockerManifest:
+		manifest.Manifest = &ocispec.Manifest{}
+		if err := json.Unmarshal(raw, manifest.Manifest); err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported manifest media type %q", mediaType)
+	}
+	return manifest, nil
+}
+
+// digestOf returns the sha256 content digest of raw
+func digestOf(raw []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(raw))
+}
+
+func pullScope(repo string) string {
+	return "repository:" + repo + ":pull"
+}
+
+func deleteScope(repo string) string {
+	return "repository:" + repo + ":delete"
+}
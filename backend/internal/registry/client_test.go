@@ -0,0 +1,217 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/genc-murat/harborview/internal/registry/registrytest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	linuxAMD64 = ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	linuxARM64 = ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+)
+
+func newTestClient(t *testing.T, server *httptest.Server, options ClientOptions) *Client {
+	t.Helper()
+	client, err := NewClient(server.URL, options)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return client
+}
+
+func TestClientPagination(t *testing.T) {
+	reg, server := registrytest.NewServer()
+	defer server.Close()
+	for _, tag := range []string{"1.0", "1.1", "1.2", "2.0", "latest"} {
+		reg.PushImage("team/app", tag, linuxAMD64, time.Now(), []byte(tag))
+	}
+	reg.PushImage("team/db", "1", linuxAMD64, time.Now(), []byte("db"))
+	reg.PushImage("web", "1", linuxAMD64, time.Now(), []byte("web"))
+	client := newTestClient(t, server, ClientOptions{})
+	ctx := context.Background()
+
+	var pages [][]string
+	last := ""
+	for {
+		tags, next, err := client.Tags(ctx, "team/app", 2, last)
+		if err != nil {
+			t.Fatalf("Tags: %v", err)
+		}
+		pages = append(pages, tags)
+		if next == "" {
+			break
+		}
+		last = next
+	}
+	want := [][]string{{"1.0", "1.1"}, {"1.2", "2.0"}, {"latest"}}
+	if !slices.EqualFunc(pages, want, slices.Equal[[]string]) {
+		t.Errorf("tag pages = %v, want %v", pages, want)
+	}
+
+	all, err := client.AllTags(ctx, "team/app")
+	if err != nil {
+		t.Fatalf("AllTags: %v", err)
+	}
+	if len(all) != 5 {
+		t.Errorf("AllTags = %v, want 5 tags", all)
+	}
+
+	repositories, next, err := client.Catalog(ctx, 2, "")
+	if err != nil {
+		t.Fatalf("Catalog: %v", err)
+	}
+	if !slices.Equal(repositories, []string{"team/app", "team/db"}) || next != "team/db" {
+		t.Errorf("Catalog = %v, %q", repositories, next)
+	}
+	repositories, next, err = client.Catalog(ctx, 2, next)
+	if err != nil {
+		t.Fatalf("Catalog: %v", err)
+	}
+	if !slices.Equal(repositories, []string{"web"}) || next != "" {
+		t.Errorf("second catalog page = %v, %q", repositories, next)
+	}
+}
+
+func TestClientBearerAuth(t *testing.T) {
+	reg := registrytest.New()
+	reg.Username, reg.Password = "ci", "secret"
+	reg.PushImage("team/app", "1", linuxAMD64, time.Now(), []byte("layer"))
+
+	var tokenRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests.Add(1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	client := newTestClient(t, server, ClientOptions{Username: "ci", Password: "secret"})
+	for range 3 {
+		tags, _, err := client.Tags(ctx, "team/app", 0, "")
+		if err != nil {
+			t.Fatalf("Tags: %v", err)
+		}
+		if !slices.Equal(tags, []string{"1"}) {
+			t.Errorf("Tags = %v", tags)
+		}
+	}
+	if got := tokenRequests.Load(); got != 1 {
+		t.Errorf("token requests = %d, want 1 for a cached scope", got)
+	}
+
+	wrong := newTestClient(t, server, ClientOptions{Username: "ci", Password: "wrong"})
+	if _, _, err := wrong.Tags(ctx, "team/app", 0, ""); err == nil {
+		t.Error("Tags with a wrong password succeeded")
+	}
+	anonymous := newTestClient(t, server, ClientOptions{})
+	if _, _, err := anonymous.Tags(ctx, "team/app", 0, ""); err == nil {
+		t.Error("anonymous Tags succeeded against a registry that requires auth")
+	}
+}
+
+func TestClientManifestIndex(t *testing.T) {
+	reg, server := registrytest.NewServer()
+	defer server.Close()
+	amd64 := reg.PushImage("team/app", "", linuxAMD64, time.Now(), []byte("amd64"))
+	arm64 := reg.PushImage("team/app", "", linuxARM64, time.Now(), []byte("arm64"))
+	amd64.Platform, arm64.Platform = &linuxAMD64, &linuxARM64
+	index := reg.PushIndex("team/app", "1", amd64, arm64)
+	client := newTestClient(t, server, ClientOptions{})
+	ctx := context.Background()
+
+	manifest, err := client.Manifest(ctx, "team/app", "1")
+	if err != nil {
+		t.Fatalf("Manifest: %v", err)
+	}
+	if !manifest.IsIndex() || manifest.Digest != index.Digest.String() || manifest.MediaType != MediaTypeOCIIndex {
+		t.Fatalf("Manifest = %+v, want the index %s", manifest, index.Digest)
+	}
+	if len(manifest.Index.Manifests) != 2 || manifest.Index.Manifests[1].Digest != arm64.Digest {
+		t.Errorf("index entries = %+v", manifest.Index.Manifests)
+	}
+
+	child, err := client.Manifest(ctx, "team/app", arm64.Digest.String())
+	if err != nil {
+		t.Fatalf("Manifest by digest: %v", err)
+	}
+	if child.IsIndex() || child.Manifest == nil {
+		t.Fatalf("platform manifest = %+v", child)
+	}
+	config, err := client.ImageConfig(ctx, "team/app", child.Manifest.Config.Digest.String())
+	if err != nil {
+		t.Fatalf("ImageConfig: %v", err)
+	}
+	if config.Architecture != "arm64" || config.Variant != "v8" {
+		t.Errorf("config platform = %s/%s", config.Architecture, config.Variant)
+	}
+
+	digest, err := client.ManifestDigest(ctx, "team/app", "1")
+	if err != nil {
+		t.Fatalf("ManifestDigest: %v", err)
+	}
+	if digest != index.Digest.String() {
+		t.Errorf("ManifestDigest = %s, want %s", digest, index.Digest)
+	}
+	if _, err := client.Manifest(ctx, "team/app", "missing"); !IsNotFound(err) {
+		t.Errorf("missing tag error = %v, want a 404", err)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		header string
+		scheme string
+		params map[string]string
+	}{
+		{
+			header: `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`,
+			scheme: "Bearer",
+			params: map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:library/nginx:pull"},
+		},
+		{
+			header: `Bearer realm="https://r/token", scope="repository:a:pull,push"`,
+			scheme: "Bearer",
+			params: map[string]string{"realm": "https://r/token", "scope": "repository:a:pull,push"},
+		},
+		{
+			header: `Basic realm=registry`,
+			scheme: "Basic",
+			params: map[string]string{"realm": "registry"},
+		},
+	}
+	for _, tt := range tests {
+		ch := parseChallenge(tt.header)
+		if ch.scheme != tt.scheme {
+			t.Errorf("parseChallenge(%q) scheme = %q, want %q", tt.header, ch.scheme, tt.scheme)
+		}
+		for key, want := range tt.params {
+			if got := ch.params[key]; got != want {
+				t.Errorf("parseChallenge(%q)[%s] = %q, want %q", tt.header, key, got, want)
+			}
+		}
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	tests := map[string]string{
+		``: "",
+		`</v2/app/tags/list?last=1.2&n=2>; rel="next"`:  "1.2",
+		`</v2/_catalog?n=2&last=team%2Fdb>; rel="next"`: "team/db",
+		`</v2/_catalog?last=x>; rel="prev"`:             "",
+	}
+	for link, want := range tests {
+		if got := nextCursor(link); got != want {
+			t.Errorf("nextCursor(%q) = %q, want %q", link, got, want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"log"
+
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterRoutes registers all routes for the remote registry. Repository
+// names contain slashes, so they are passed as the `repo` query parameter.
+func RegisterRoutes(app *fiber.App, cfg config.Config) {
+	service, err := NewRegistryService(cfg)
+	if err != nil {
+		log.Printf("Registry routes disabled: %v", err)
+		return
+	}
+
+	registryGroup := app.Group("/registry")
+
+	// List repositories
+	registryGroup.Get("/repositories", func(c *fiber.Ctx) error {
+		return ListRepositories(c, service)
+	})
+
+	// List tags of a repository
+	registryGroup.Get("/tags", func(c *fiber.Ctx) error {
+		return ListTags(c, service)
+	})
+
+	// Get size, created date and platforms of a tag
+	registryGroup.Get("/tag", func(c *fiber.Ctx) error {
+		return GetTag(c, service)
+	})
+
+	// Delete a tag by its manifest digest
+	registryGroup.Delete("/tag", func(c *fiber.Ctx) error {
+		return DeleteTag(c, service)
+	})
+
+	// Get a manifest, manifest list or OCI index
+	registryGroup.Get("/manifest", func(c *fiber.Ctx) error {
+		return GetManifest(c, service)
+	})
+}
+
+// ListRepositories handler for listing registry repositories
+func ListRepositories(c *fiber.Ctx, service RegistryService) error {
+	n := c.QueryInt("n", 100)
+	page, err := service.ListRepositories(c.UserContext(), n, c.Query("last"))
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(page)
+}
+
+// ListTags handler for listing the tags of a repository
+func ListTags(c *fiber.Ctx, service RegistryService) error {
+	repo := c.Query("repo")
+	if repo == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "repo is required"})
+	}
+	n := c.QueryInt("n", 100)
+	details := c.QueryBool("details", false)
+	page, err := service.ListTags(c.UserContext(), repo, n, c.Query("last"), details)
+	if err != nil {
+		return registryError(c, err)
+	}
+	return c.JSON(page)
+}
+
+// GetTag handler for describing a single tag
+func GetTag(c *fiber.Ctx, service RegistryService) error {
+	repo, tag := c.Query("repo"), c.Query("tag")
+	if repo == "" || tag == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "repo and tag are required"})
+	}
+	detail, err := service.GetTag(c.UserContext(), repo, tag)
+	if err != nil {
+		return registryError(c, err)
+	}
+	return c.JSON(detail)
+}
+
+// DeleteTag handler for deleting a tag from the registry
+func DeleteTag(c *fiber.Ctx, service RegistryService) error {
+	repo, tag := c.Query("repo"), c.Query("tag")
+	if repo == "" || tag == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "repo and tag are required"})
+	}
+	digest, err := service.DeleteTag(c.UserContext(), repo, tag)
+	if err != nil {
+		return registryError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Tag deleted successfully", "digest": digest})
+}
+
+// GetManifest handler for fetching a manifest by tag or digest
+func GetManifest(c *fiber.Ctx, service RegistryService) error {
+	repo, ref := c.Query("repo"), c.Query("ref")
+	if repo == "" || ref == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "repo and ref are required"})
+	}
+	manifest, err := service.GetManifest(c.UserContext(), repo, ref)
+	if err != nil {
+		return registryError(c, err)
+	}
+	return c.JSON(manifest)
+}
+
+// registryError maps registry 404s through and reports anything else as a
+// bad gateway, since the failure is upstream of harborview
+func registryError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusBadGateway
+	if IsNotFound(err) {
+		status = fiber.StatusNotFound
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
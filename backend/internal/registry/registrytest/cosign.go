@@ -0,0 +1,109 @@
+package registrytest
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Media types and annotations of cosign's tag-based registry layout
+const (
+	mediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	mediaTypeDSSE          = "application/vnd.dsse.envelope.v1+json"
+	annotationSignature    = "dev.cosignproject.cosign/signature"
+	annotationPredicate    = "predicateType"
+	payloadTypeInToto      = "application/vnd.in-toto+json"
+)
+
+// SignImage stores a cosign signature of the manifest with digest in repo,
+// the way `cosign sign --key` does: a simple-signing payload layer under the
+// tag sha256-<hex>.sig, with the signature in the layer annotation. Signing
+// again appends another signature layer.
+func (r *Registry) SignImage(repo, digest string, signer crypto.Signer) ocispec.Descriptor {
+	payload, _ := json.Marshal(map[string]any{
+		"critical": map[string]any{
+			"identity": map[string]string{"docker-reference": repo},
+			"image":    map[string]string{"docker-manifest-digest": digest},
+			"type":     "cosign container image signature",
+		},
+		"optional": nil,
+	})
+	layer := r.PutBlob(repo, mediaTypeSimpleSigning, payload)
+	layer.Annotations = map[string]string{annotationSignature: sign(signer, payload)}
+	return r.appendArtifact(repo, artifactTag(digest, "sig"), layer)
+}
+
+// AttestImage stores a signed in-toto attestation of the manifest with digest
+// in repo, the way `cosign attest --key` does: a DSSE envelope layer under
+// the tag sha256-<hex>.att.
+func (r *Registry) AttestImage(repo, digest, predicateType string, predicate any, signer crypto.Signer) ocispec.Descriptor {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	statement, _ := json.Marshal(map[string]any{
+		"_type":         "https://in-toto.io/Statement/v0.1",
+		"predicateType": predicateType,
+		"subject": []map[string]any{{
+			"name":   repo,
+			"digest": map[string]string{algorithm: hex},
+		}},
+		"predicate": predicate,
+	})
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadTypeInToto), payloadTypeInToto, len(statement), statement)
+	envelope, _ := json.Marshal(map[string]any{
+		"payloadType": payloadTypeInToto,
+		"payload":     base64.StdEncoding.EncodeToString(statement),
+		"signatures":  []map[string]string{{"keyid": "", "sig": sign(signer, []byte(pae))}},
+	})
+
+	layer := r.PutBlob(repo, mediaTypeDSSE, envelope)
+	layer.Annotations = map[string]string{annotationSignature: "", annotationPredicate: predicateType}
+	return r.appendArtifact(repo, artifactTag(digest, "att"), layer)
+}
+
+// appendArtifact adds layer to the artifact manifest tagged tag, creating it
+// when missing
+func (r *Registry) appendArtifact(repo, tag string, layer ocispec.Descriptor) ocispec.Descriptor {
+	var manifest ocispec.Manifest
+	r.mu.Lock()
+	stored := r.repo(repo)
+	if digest, ok := stored.tags[tag]; ok {
+		json.Unmarshal(stored.manifests[digest].raw, &manifest)
+	}
+	r.mu.Unlock()
+
+	if manifest.SchemaVersion == 0 {
+		manifest.SchemaVersion = 2
+		manifest.MediaType = ocispec.MediaTypeImageManifest
+		manifest.Config = r.PutBlob(repo, ocispec.MediaTypeImageConfig, []byte("{}"))
+	}
+	manifest.Layers = append(manifest.Layers, layer)
+	raw, _ := json.Marshal(manifest)
+	return r.PutManifest(repo, tag, ocispec.MediaTypeImageManifest, raw)
+}
+
+// sign signs message as cosign does for the signer's key type and returns
+// the base64 signature
+func sign(signer crypto.Signer, message []byte) string {
+	var sig []byte
+	var err error
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		sig, err = signer.Sign(rand.Reader, message, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(message)
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("registrytest: signing failed: %v", err))
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func artifactTag(digest, suffix string) string {
+	return strings.Replace(digest, ":", "-", 1) + "." + suffix
+}
@@ -0,0 +1,350 @@
+// Package registrytest provides an in-memory Docker Registry HTTP API v2
+// server for exercising the registry client without a real registry:2.
+package registrytest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	godigest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Registry is an in-memory registry. Set Username and Password before the
+// first request to require bearer-token auth issued by its /token endpoint.
+type Registry struct {
+	Username string
+	Password string
+
+	mu    sync.Mutex
+	repos map[string]*repository
+}
+
+type repository struct {
+	manifests map[string]storedManifest
+	tags      map[string]string
+	blobs     map[string][]byte
+}
+
+type storedManifest struct {
+	mediaType string
+	raw       []byte
+}
+
+// token is the only bearer token the fake issues
+const token = "registrytest-token"
+
+// New creates an empty registry
+func New() *Registry {
+	return &Registry{repos: make(map[string]*repository)}
+}
+
+// NewServer starts an httptest server backed by a new registry
+func NewServer() (*Registry, *httptest.Server) {
+	registry := New()
+	return registry, httptest.NewServer(registry)
+}
+
+func (r *Registry) repo(name string) *repository {
+	repo, ok := r.repos[name]
+	if !ok {
+		repo = &repository{
+			manifests: make(map[string]storedManifest),
+			tags:      make(map[string]string),
+			blobs:     make(map[string][]byte),
+		}
+		r.repos[name] = repo
+	}
+	return repo
+}
+
+// PutBlob stores data in repo and returns its descriptor
+func (r *Registry) PutBlob(repo, mediaType string, data []byte) ocispec.Descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := digestOf(data)
+	r.repo(repo).blobs[digest] = data
+	return descriptor(mediaType, digest, data)
+}
+
+// PutManifest stores a manifest in repo, tags it when tag is not empty and
+// returns its descriptor
+func (r *Registry) PutManifest(repo, tag, mediaType string, raw []byte) ocispec.Descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := digestOf(raw)
+	stored := r.repo(repo)
+	stored.manifests[digest] = storedManifest{mediaType: mediaType, raw: raw}
+	if tag != "" {
+		stored.tags[tag] = digest
+	}
+	return descriptor(mediaType, digest, raw)
+}
+
+// PushImage stores a single-layer image for platform and tags it. layer is
+// stored as-is; its content is not inspected.
+func (r *Registry) PushImage(repo, tag string, platform ocispec.Platform, created time.Time, layer []byte) ocispec.Descriptor {
+	config, _ := json.Marshal(ocispec.Image{
+		Created:  &created,
+		Platform: platform,
+		RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: nil},
+	})
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    r.PutBlob(repo, ocispec.MediaTypeImageConfig, config),
+		Layers:    []ocispec.Descriptor{r.PutBlob(repo, ocispec.MediaTypeImageLayerGzip, layer)},
+	}
+	manifest.SchemaVersion = 2
+	raw, _ := json.Marshal(manifest)
+	return r.PutManifest(repo, tag, ocispec.MediaTypeImageManifest, raw)
+}
+
+// PushIndex stores an OCI index over the given image manifests and tags it
+func (r *Registry) PushIndex(repo, tag string, manifests ...ocispec.Descriptor) ocispec.Descriptor {
+	index := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: manifests}
+	index.SchemaVersion = 2
+	raw, _ := json.Marshal(index)
+	return r.PutManifest(repo, tag, ocispec.MediaTypeImageIndex, raw)
+}
+
+// ServeHTTP implements the subset of the v2 API used by the client:
+// catalog, tag listing, manifest GET/HEAD/PUT/DELETE and blob GET/HEAD.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	if !strings.HasPrefix(req.URL.Path, "/v2/") {
+		http.NotFound(w, req)
+		return
+	}
+	if !r.authorized(w, req) {
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case path == "":
+		w.WriteHeader(http.StatusOK)
+	case path == "_catalog":
+		r.serveCatalog(w, req)
+	case strings.HasSuffix(path, "/tags/list"):
+		r.serveTags(w, req, strings.TrimSuffix(path, "/tags/list"))
+	case strings.Contains(path, "/manifests/"):
+		name, ref, _ := cutLast(path, "/manifests/")
+		r.serveManifest(w, req, name, ref)
+	case strings.Contains(path, "/blobs/"):
+		name, digest, _ := cutLast(path, "/blobs/")
+		r.serveBlob(w, req, name, digest)
+	default:
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+	}
+}
+
+func (r *Registry) authorized(w http.ResponseWriter, req *http.Request) bool {
+	if r.Username == "" || req.Header.Get("Authorization") == "Bearer "+token {
+		return true
+	}
+	scope := "registry:catalog:*"
+	if name := repoName(strings.TrimPrefix(req.URL.Path, "/v2/")); name != "" {
+		scope = "repository:" + name + ":pull"
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="registrytest",scope="%s"`, req.Host, scope))
+	writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+	return false
+}
+
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	username, password, _ := req.BasicAuth()
+	if username != r.Username || password != r.Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+func (r *Registry) serveCatalog(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.repos))
+	for name := range r.repos {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+
+	page, next := paginate(names, req)
+	writePage(w, req, next)
+	json.NewEncoder(w).Encode(map[string][]string{"repositories": page})
+}
+
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, name string) {
+	r.mu.Lock()
+	repo, ok := r.repos[name]
+	var tags []string
+	if ok {
+		for tag := range repo.tags {
+			tags = append(tags, tag)
+		}
+	}
+	r.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+
+	page, next := paginate(tags, req)
+	writePage(w, req, next)
+	json.NewEncoder(w).Encode(map[string]any{"name": name, "tags": page})
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, name, ref string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.Method == http.MethodPut {
+		raw, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		digest := digestOf(raw)
+		repo := r.repo(name)
+		repo.manifests[digest] = storedManifest{mediaType: req.Header.Get("Content-Type"), raw: raw}
+		if !strings.HasPrefix(ref, "sha256:") {
+			repo.tags[ref] = digest
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	repo, ok := r.repos[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+	digest := ref
+	if !strings.HasPrefix(ref, "sha256:") {
+		if digest, ok = repo.tags[ref]; !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+	}
+	manifest, ok := repo.manifests[digest]
+	if !ok {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodDelete:
+		if ref != digest {
+			writeError(w, http.StatusBadRequest, "UNSUPPORTED", "manifests can only be deleted by digest")
+			return
+		}
+		delete(repo.manifests, digest)
+		for tag, tagged := range repo.tags {
+			if tagged == digest {
+				delete(repo.tags, tag)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", manifest.mediaType)
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest.raw)))
+		if req.Method == http.MethodGet {
+			w.Write(manifest.raw)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, name, digest string) {
+	r.mu.Lock()
+	var data []byte
+	repo, ok := r.repos[name]
+	if ok {
+		data, ok = repo.blobs[digest]
+	}
+	r.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if req.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
+
+// paginate sorts items and applies the n/last query parameters
+func paginate(items []string, req *http.Request) (page []string, next string) {
+	sort.Strings(items)
+	if last := req.URL.Query().Get("last"); last != "" {
+		start := sort.SearchStrings(items, last)
+		if start < len(items) && items[start] == last {
+			start++
+		}
+		items = items[start:]
+	}
+	n, _ := strconv.Atoi(req.URL.Query().Get("n"))
+	if n <= 0 || n >= len(items) {
+		return items, ""
+	}
+	return items[:n], items[n-1]
+}
+
+func writePage(w http.ResponseWriter, req *http.Request, next string) {
+	if next != "" {
+		query := req.URL.Query()
+		query.Set("last", next)
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, req.URL.Path, query.Encode()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
+
+func descriptor(mediaType, digest string, data []byte) ocispec.Descriptor {
+	return ocispec.Descriptor{MediaType: mediaType, Digest: godigest.Digest(digest), Size: int64(len(data))}
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// repoName extracts the repository name from a path below /v2/
+func repoName(path string) string {
+	for _, sep := range []string{"/manifests/", "/blobs/", "/tags/list"} {
+		if name, _, ok := cutLast(path, sep); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// cutLast splits s around the last occurrence of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/distribution/reference"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// DockerHubURL is the registry API endpoint behind docker.io image names
+const DockerHubURL = "https://registry-1.docker.io"
+
+// Reference is an image reference split into the parts the registry API
+// works with
+type Reference struct {
+	Domain     string `json:"domain"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	// Familiar is the short name Docker shows, e.g. "nginx" for
+	// docker.io/library/nginx
+	Familiar string `json:"familiar"`
+}
+
+// ParseReference parses an image name such as "nginx", "nginx:1.27" or
+// "registry.local:5000/team/app@sha256:..." using Docker's normalisation.
+func ParseReference(name string) (Reference, error) {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return Reference{}, fmt.Errorf("invalid image reference %q: %w", name, err)
+	}
+	ref := Reference{
+		Domain:     reference.Domain(named),
+		Repository: reference.Path(named),
+		Familiar:   reference.FamiliarName(named),
+	}
+	if tagged, ok := named.(reference.Tagged); ok {
+		ref.Tag = tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		ref.Digest = digested.Digest().String()
+	}
+	return ref, nil
+}
+
+// Resolver hands out registry clients per registry domain. The configured
+// registry gets its credentials, docker.io maps to Docker Hub and any other
+// domain is reached anonymously over HTTPS. Clients are reused so their
+// token caches survive between requests.
+type Resolver struct {
+	configured string
+
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+// NewResolver creates a resolver for the registry settings in cfg
+func NewResolver(cfg config.Config) *Resolver {
+	resolver := &Resolver{clients: make(map[string]*Client)}
+	if cfg.Registry.BaseURL != "" {
+		if client, err := NewClient(cfg.Registry.BaseURL, ClientOptions{
+			Username:           cfg.Registry.Username,
+			Password:           cfg.Registry.Password,
+			InsecureSkipVerify: cfg.Registry.InsecureSkipVerify,
+		}); err == nil {
+			resolver.configured = client.Host()
+			resolver.clients[client.Host()] = client
+		}
+	}
+	return resolver
+}
+
+// Client returns the client for a registry domain
+func (r *Resolver) Client(domain string) (*Client, error) {
+	if domain == "docker.io" || domain == "index.docker.io" {
+		domain = "registry-1.docker.io"
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if client, ok := r.clients[domain]; ok {
+		return client, nil
+	}
+
+	baseURL := "https://" + domain
+	if domain == "registry-1.docker.io" {
+		baseURL = DockerHubURL
+	}
+	client, err := NewClient(baseURL, ClientOptions{})
+	if err != nil {
+		return nil, err
+	}
+	r.clients[domain] = client
+	return client, nil
+}
+
+// IsConfigured reports whether domain is the registry from config
+func (r *Resolver) IsConfigured(domain string) bool {
+	return r.configured != "" && strings.EqualFold(domain, r.configured)
+}
@@ -0,0 +1,237 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/genc-murat/harborview/pkg/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// detailWorkers bounds the concurrent manifest/config fetches made when
+// listing tags with details
+const detailWorkers = 4
+
+// RegistryService interface for dependency injection
+type RegistryService interface {
+	ListRepositories(ctx context.Context, n int, last string) (*Page, error)
+	ListTags(ctx context.Context, repo string, n int, last string, details bool) (*TagPage, error)
+	GetManifest(ctx context.Context, repo, ref string) (*Manifest, error)
+	GetTag(ctx context.Context, repo, tag string) (*TagDetail, error)
+	DeleteTag(ctx context.Context, repo, tag string) (string, error)
+}
+
+// Page is one page of repository names
+type Page struct {
+	Items []string `json:"items"`
+	Next  string   `json:"next,omitempty"`
+}
+
+// TagPage is one page of tags. Details is only filled when requested.
+type TagPage struct {
+	Repository string       `json:"repository"`
+	Tags       []string     `json:"tags"`
+	Details    []*TagDetail `json:"details,omitempty"`
+	Next       string       `json:"next,omitempty"`
+}
+
+// TagDetail summarises what a tag points at
+type TagDetail struct {
+	Repository string           `json:"repository"`
+	Tag        string           `json:"tag"`
+	Digest     string           `json:"digest"`
+	MediaType  string           `json:"mediaType"`
+	Size       int64            `json:"size"`
+	Created    *time.Time       `json:"created,omitempty"`
+	Platforms  []PlatformDetail `json:"platforms"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// PlatformDetail describes one image of a (possibly multi-arch) tag
+type PlatformDetail struct {
+	OS           string     `json:"os"`
+	Architecture string     `json:"architecture"`
+	Variant      string     `json:"variant,omitempty"`
+	Digest       string     `json:"digest"`
+	Size         int64      `json:"size"`
+	Created      *time.Time `json:"created,omitempty"`
+}
+
+type registryService struct {
+	client *Client
+}
+
+// NewRegistryService creates a new instance of RegistryService for the
+// registry configured under `registry`
+func NewRegistryService(cfg config.Config) (RegistryService, error) {
+	client, err := NewClient(cfg.Registry.BaseURL, ClientOptions{
+		Username:           cfg.Registry.Username,
+		Password:           cfg.Registry.Password,
+		InsecureSkipVerify: cfg.Registry.InsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewRegistryServiceWithClient(client), nil
+}
+
+// NewRegistryServiceWithClient creates a RegistryService around an existing
+// client, e.g. one pointed at an in-process fake registry
+func NewRegistryServiceWithClient(client *Client) RegistryService {
+	return &registryService{client: client}
+}
+
+func (s *registryService) ListRepositories(ctx context.Context, n int, last string) (*Page, error) {
+	repositories, next, err := s.client.Catalog(ctx, n, last)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repositories: %w", err)
+	}
+	return &Page{Items: repositories, Next: next}, nil
+}
+
+func (s *registryService) ListTags(ctx context.Context, repo string, n int, last string, details bool) (*TagPage, error) {
+	tags, next, err := s.client.Tags(ctx, repo, n, last)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	page := &TagPage{Repository: repo, Tags: tags, Next: next}
+	if !details {
+		return page, nil
+	}
+
+	page.Details = make([]*TagDetail, len(tags))
+	sem := make(chan struct{}, detailWorkers)
+	var wg sync.WaitGroup
+	for i, tag := range tags {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, tag string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			detail, err := s.GetTag(ctx, repo, tag)
+			if err != nil {
+				// One broken tag should not hide the rest of the page
+				detail = &TagDetail{Repository: repo, Tag: tag, Error: err.Error()}
+			}
+			page.Details[i] = detail
+		}(i, tag)
+	}
+	wg.Wait()
+	return page, nil
+}
+
+func (s *registryService) GetManifest(ctx context.Context, repo, ref string) (*Manifest, error) {
+	manifest, err := s.client.Manifest(ctx, repo, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// GetTag resolves a tag and computes its size and creation date. For
+// multi-arch tags every platform image is inspected; the tag size is the sum
+// of the platform sizes and the creation date is the newest one.
+func (s *registryService) GetTag(ctx context.Context, repo, tag string) (*TagDetail, error) {
+	manifest, err := s.client.Manifest(ctx, repo, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+
+	detail := &TagDetail{
+		Repository: repo,
+		Tag:        tag,
+		Digest:     manifest.Digest,
+		MediaType:  manifest.MediaType,
+		Platforms:  []PlatformDetail{},
+	}
+
+	if !manifest.IsIndex() {
+		platform, err := s.platformDetail(ctx, repo, manifest)
+		if err != nil {
+			return nil, err
+		}
+		detail.Platforms = append(detail.Platforms, platform)
+	} else {
+		for _, descriptor := range manifest.Index.Manifests {
+			if isAttestation(descriptor) {
+				continue
+			}
+			child, err := s.client.Manifest(ctx, repo, descriptor.Digest.String())
+			if err != nil {
+				return nil, fmt.Errorf("failed to get platform manifest: %w", err)
+			}
+			if child.IsIndex() {
+				continue
+			}
+			platform, err := s.platformDetail(ctx, repo, child)
+			if err != nil {
+				return nil, err
+			}
+			if descriptor.Platform != nil {
+				platform.OS = descriptor.Platform.OS
+				platform.Architecture = descriptor.Platform.Architecture
+				platform.Variant = descriptor.Platform.Variant
+			}
+			detail.Platforms = append(detail.Platforms, platform)
+		}
+	}
+
+	for _, platform := range detail.Platforms {
+		detail.Size += platform.Size
+		if platform.Created != nil && (detail.Created == nil || platform.Created.After(*detail.Created)) {
+			detail.Created = platform.Created
+		}
+	}
+	return detail, nil
+}
+
+// platformDetail reads the config of a single-image manifest
+func (s *registryService) platformDetail(ctx context.Context, repo string, manifest *Manifest) (PlatformDetail, error) {
+	platform := PlatformDetail{
+		Digest: manifest.Digest,
+		Size:   manifest.Manifest.Config.Size,
+	}
+	for _, layer := range manifest.Manifest.Layers {
+		platform.Size += layer.Size
+	}
+
+	config, err := s.client.ImageConfig(ctx, repo, manifest.Manifest.Config.Digest.String())
+	if err != nil {
+		return platform, fmt.Errorf("failed to get image config: %w", err)
+	}
+	platform.OS = config.OS
+	platform.Architecture = config.Architecture
+	platform.Variant = config.Variant
+	platform.Created = config.Created
+	return platform, nil
+}
+
+// DeleteTag deletes the manifest tag points at and returns its digest. Every
+// other tag sharing that manifest is removed with it.
+func (s *registryService) DeleteTag(ctx context.Context, repo, tag string) (string, error) {
+	if tag == "" {
+		return "", errors.New("tag is required")
+	}
+	digest, err := s.client.ManifestDigest(ctx, repo, tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve tag: %w", err)
+	}
+	if err := s.client.DeleteManifest(ctx, repo, digest); err != nil {
+		return "", fmt.Errorf("failed to delete tag: %w", err)
+	}
+	log.Printf("Deleted %s:%s (%s) from registry", repo, tag, digest)
+	return digest, nil
+}
+
+// isAttestation reports whether an index entry is a BuildKit attestation
+// manifest rather than a runnable image
+func isAttestation(descriptor ocispec.Descriptor) bool {
+	if descriptor.Annotations["vnd.docker.reference.type"] == "attestation-manifest" {
+		return true
+	}
+	return descriptor.Platform != nil && descriptor.Platform.OS == "unknown"
+}
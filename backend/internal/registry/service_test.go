@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/genc-murat/harborview/internal/registry/registrytest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestGetTagMultiArch(t *testing.T) {
+	reg, server := registrytest.NewServer()
+	defer server.Close()
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	amd64 := reg.PushImage("team/app", "", linuxAMD64, older, []byte("amd64 layer"))
+	arm64 := reg.PushImage("team/app", "", linuxARM64, newer, []byte("arm64"))
+	amd64.Platform, arm64.Platform = &linuxAMD64, &linuxARM64
+	attestation := reg.PushImage("team/app", "", ocispec.Platform{OS: "unknown", Architecture: "unknown"}, newer, []byte("provenance"))
+	attestation.Annotations = map[string]string{"vnd.docker.reference.type": "attestation-manifest"}
+	index := reg.PushIndex("team/app", "1", amd64, arm64, attestation)
+	service := NewRegistryServiceWithClient(newTestClient(t, server, ClientOptions{}))
+
+	detail, err := service.GetTag(context.Background(), "team/app", "1")
+	if err != nil {
+		t.Fatalf("GetTag: %v", err)
+	}
+	if detail.Digest != index.Digest.String() {
+		t.Errorf("Digest = %s, want %s", detail.Digest, index.Digest)
+	}
+	if len(detail.Platforms) != 2 {
+		t.Fatalf("Platforms = %+v, want amd64 and arm64 without the attestation", detail.Platforms)
+	}
+	arm := detail.Platforms[1]
+	if arm.Architecture != "arm64" || arm.Variant != "v8" || arm.Digest != arm64.Digest.String() {
+		t.Errorf("arm64 platform = %+v", arm)
+	}
+	if detail.Size != detail.Platforms[0].Size+arm.Size || arm.Size <= int64(len("arm64")) {
+		t.Errorf("Size = %d, platforms %d + %d", detail.Size, detail.Platforms[0].Size, arm.Size)
+	}
+	if detail.Created == nil || !detail.Created.Equal(newer) {
+		t.Errorf("Created = %v, want the newest platform %v", detail.Created, newer)
+	}
+}
+
+func TestListTagsDetails(t *testing.T) {
+	reg, server := registrytest.NewServer()
+	defer server.Close()
+	for _, tag := range []string{"a", "b", "c"} {
+		reg.PushImage("app", tag, linuxAMD64, time.Now(), []byte(tag))
+	}
+	service := NewRegistryServiceWithClient(newTestClient(t, server, ClientOptions{}))
+
+	page, err := service.ListTags(context.Background(), "app", 2, "", true)
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	if !slices.Equal(page.Tags, []string{"a", "b"}) || page.Next != "b" {
+		t.Errorf("page = %v next %q", page.Tags, page.Next)
+	}
+	for i, detail := range page.Details {
+		if detail.Tag != page.Tags[i] || detail.Error != "" || len(detail.Platforms) != 1 {
+			t.Errorf("detail %d = %+v", i, detail)
+		}
+	}
+}
+
+func TestDeleteTag(t *testing.T) {
+	reg, server := registrytest.NewServer()
+	defer server.Close()
+	shared := reg.PushImage("app", "1.0", linuxAMD64, time.Now(), []byte("v1"))
+	reg.PushImage("app", "2.0", linuxAMD64, time.Now(), []byte("v2"))
+	client := newTestClient(t, server, ClientOptions{})
+	service := NewRegistryServiceWithClient(client)
+	ctx := context.Background()
+
+	manifest, err := client.Manifest(ctx, "app", "1.0")
+	if err != nil {
+		t.Fatalf("Manifest: %v", err)
+	}
+	reg.PutManifest("app", "stable", shared.MediaType, manifest.Raw)
+
+	digest, err := service.DeleteTag(ctx, "app", "stable")
+	if err != nil {
+		t.Fatalf("DeleteTag: %v", err)
+	}
+	if digest != shared.Digest.String() {
+		t.Errorf("deleted digest = %s, want %s", digest, shared.Digest)
+	}
+	tags, _, err := client.Tags(ctx, "app", 0, "")
+	if err != nil {
+		t.Fatalf("Tags: %v", err)
+	}
+	if !slices.Equal(tags, []string{"2.0"}) {
+		t.Errorf("tags after delete = %v, want every tag of the digest gone", tags)
+	}
+	if _, err := service.DeleteTag(ctx, "app", "stable"); !IsNotFound(err) {
+		t.Errorf("deleting a missing tag = %v, want a 404", err)
+	}
+}
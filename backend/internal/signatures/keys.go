@@ -0,0 +1,90 @@
+package signatures
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PublicKey is a trusted signing key
+type PublicKey struct {
+	// ID is the key's source: its file path, or "inline-<n>" for keys
+	// embedded in config
+	ID  string
+	Key crypto.PublicKey
+}
+
+// LoadPublicKeys reads PEM public keys. Each entry is either a path to a PEM
+// file or the PEM text itself.
+func LoadPublicKeys(entries []string) ([]PublicKey, error) {
+	var keys []PublicKey
+	for i, entry := range entries {
+		id := entry
+		data := []byte(entry)
+		if !strings.HasPrefix(strings.TrimSpace(entry), "-----BEGIN") {
+			var err error
+			if data, err = os.ReadFile(entry); err != nil {
+				return nil, fmt.Errorf("failed to read public key: %w", err)
+			}
+		} else {
+			id = fmt.Sprintf("inline-%d", i)
+		}
+
+		parsed, err := parsePublicKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", id, err)
+		}
+		for _, key := range parsed {
+			keys = append(keys, PublicKey{ID: id, Key: key})
+		}
+	}
+	return keys, nil
+}
+
+// parsePublicKeys decodes every PUBLIC KEY block in data
+func parsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no PUBLIC KEY block found")
+	}
+	return keys, nil
+}
+
+// verifySignature checks sig over message the way cosign signs with each key
+// type: ECDSA and RSA PKCS#1 v1.5 over SHA-256, Ed25519 over the raw message.
+func verifySignature(key crypto.PublicKey, message, sig []byte) bool {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(k, digest[:], sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, message, sig)
+	}
+	return false
+}
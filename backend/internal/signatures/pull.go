@@ -0,0 +1,48 @@
+package signatures
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// ImagePuller is the part of the Docker client a pull needs
+type ImagePuller interface {
+	ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error)
+	ImageTag(ctx context.Context, source, target string) error
+}
+
+// Pull pulls imageRef under the signing policy. When the policy verifies
+// the image, the verified digest is pulled and tagged as imageRef, so a tag
+// moved in between cannot slip an unverified image through. Errors in the
+// pull progress stream are returned too.
+func (v *Verifier) Pull(ctx context.Context, cli ImagePuller, imageRef string) error {
+	result, err := v.Check(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+	pullRef := imageRef
+	if result != nil && result.Pinned != "" {
+		pullRef = result.Pinned
+	}
+
+	progress, err := cli.ImagePull(ctx, pullRef, image.PullOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", imageRef, err)
+	}
+	defer progress.Close()
+	if err := jsonmessage.DisplayJSONMessagesStream(progress, io.Discard, 0, false, nil); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", imageRef, err)
+	}
+	if pullRef != imageRef {
+		if err := cli.ImageTag(ctx, pullRef, imageRef); err != nil {
+			return fmt.Errorf("failed to tag pulled image: %w", err)
+		}
+	}
+	log.Printf("Pulled image %s", imageRef)
+	return nil
+}
@@ -0,0 +1,69 @@
+package signatures
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+// fakePuller records the pulls and tags it is asked for
+type fakePuller struct {
+	calls    []string
+	progress string
+}
+
+func (p *fakePuller) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	p.calls = append(p.calls, "pull "+ref)
+	progress := p.progress
+	if progress == "" {
+		progress = `{"status":"Downloaded newer image"}`
+	}
+	return io.NopCloser(strings.NewReader(progress)), nil
+}
+
+func (p *fakePuller) ImageTag(ctx context.Context, source, target string) error {
+	p.calls = append(p.calls, "tag "+source+" "+target)
+	return nil
+}
+
+func TestPull(t *testing.T) {
+	reg := newTestRegistry(t)
+	ctx := context.Background()
+	pinned := reg.host + "/team/app@" + reg.signed.Digest.String()
+	pinnedUnsigned := reg.host + "/team/app@" + reg.unsigned.Digest.String()
+
+	tests := []struct {
+		name   string
+		policy string
+		tag    string
+		calls  []string
+		denied bool
+	}{
+		{"disabled", PolicyDisabled, "unsigned", []string{"pull " + reg.ref("unsigned")}, false},
+		{"signed", PolicyEnforce, "signed", []string{"pull " + pinned, "tag " + pinned + " " + reg.ref("signed")}, false},
+		{"unsigned", PolicyEnforce, "unsigned", nil, true},
+		{"wrong key", PolicyEnforce, "other-key", nil, true},
+		{"warn", PolicyWarn, "unsigned", []string{"pull " + pinnedUnsigned, "tag " + pinnedUnsigned + " " + reg.ref("unsigned")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := &fakePuller{}
+			err := reg.verifier(t, tt.policy).Pull(ctx, cli, reg.ref(tt.tag))
+			if tt.denied != IsPolicyViolation(err) || (!tt.denied && err != nil) {
+				t.Fatalf("Pull = %v", err)
+			}
+			if !slices.Equal(cli.calls, tt.calls) {
+				t.Errorf("daemon calls = %q, want %q", cli.calls, tt.calls)
+			}
+		})
+	}
+
+	cli := &fakePuller{progress: `{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}`}
+	if err := reg.verifier(t, PolicyDisabled).Pull(ctx, cli, reg.ref("gone")); err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("Pull with a failing progress stream = %v", err)
+	}
+}
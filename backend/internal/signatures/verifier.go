@@ -0,0 +1,473 @@
+// Package signatures verifies cosign-style image signatures and in-toto
+// attestations stored next to images in their registry, and applies the
+// configured signing policy before images are pulled or run.
+package signatures
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/genc-murat/harborview/internal/registry"
+	"github.com/genc-murat/harborview/pkg/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Signing policies
+const (
+	// PolicyDisabled skips verification
+	PolicyDisabled = "disabled"
+	// PolicyWarn verifies and logs failures but lets the operation proceed
+	PolicyWarn = "warn"
+	// PolicyEnforce rejects images that are unsigned, badly signed or miss a
+	// required attestation
+	PolicyEnforce = "enforce"
+)
+
+// Media types and annotations of cosign's registry layout
+const (
+	MediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	MediaTypeDSSE          = "application/vnd.dsse.envelope.v1+json"
+	AnnotationSignature    = "dev.cosignproject.cosign/signature"
+	AnnotationPredicate    = "predicateType"
+	payloadTypeInToto      = "application/vnd.in-toto+json"
+)
+
+// maxArtifactSize bounds the signature and attestation blobs read into memory
+const maxArtifactSize = 4 << 20
+
+// SignatureTag returns the tag cosign stores the signatures of digest under
+func SignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// AttestationTag returns the tag cosign stores the attestations of digest under
+func AttestationTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".att"
+}
+
+// Signature is one signature layer found for an image
+type Signature struct {
+	Layer    string `json:"layer"`
+	KeyID    string `json:"keyID,omitempty"`
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Attestation is one attestation layer found for an image
+type Attestation struct {
+	Layer         string `json:"layer"`
+	PredicateType string `json:"predicateType,omitempty"`
+	KeyID         string `json:"keyID,omitempty"`
+	Verified      bool   `json:"verified"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Result is the outcome of verifying one image digest
+type Result struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+	// Pinned is the image reference by digest, for pulling exactly what was
+	// verified
+	Pinned       string        `json:"pinned,omitempty"`
+	Verified     bool          `json:"verified"`
+	Signatures   []Signature   `json:"signatures"`
+	Attestations []Attestation `json:"attestations"`
+	// Missing lists required predicate types without a verified attestation
+	Missing  []string `json:"missing,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// PolicyError is returned when the enforce policy rejects an image
+type PolicyError struct {
+	Result *Result
+}
+
+func (e *PolicyError) Error() string {
+	reason := "no valid signature"
+	if len(e.Result.Problems) > 0 {
+		reason = strings.Join(e.Result.Problems, "; ")
+	}
+	return fmt.Sprintf("image %s rejected by signing policy: %s", e.Result.Image, reason)
+}
+
+// IsPolicyViolation reports whether err is a signing policy rejection
+func IsPolicyViolation(err error) bool {
+	var policyErr *PolicyError
+	return errors.As(err, &policyErr)
+}
+
+// Verifier checks image signatures against the trusted keys from config
+type Verifier struct {
+	registries           *registry.Resolver
+	keys                 []PublicKey
+	policy               string
+	requiredAttestations []string
+}
+
+// NewVerifier creates a verifier for the signing settings in cfg. Keys are
+// loaded once; an enforce policy without keys rejects every image.
+func NewVerifier(cfg config.Config, registries *registry.Resolver) (*Verifier, error) {
+	policy := cfg.Signing.Policy
+	switch policy {
+	case "":
+		policy = PolicyDisabled
+	case PolicyDisabled, PolicyWarn, PolicyEnforce:
+	default:
+		return nil, fmt.Errorf("unknown signing policy %q", policy)
+	}
+
+	keys, err := LoadPublicKeys(cfg.Signing.PublicKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{
+		registries:           registries,
+		keys:                 keys,
+		policy:               policy,
+		requiredAttestations: cfg.Signing.RequiredAttestations,
+	}, nil
+}
+
+// Policy returns the configured signing policy
+func (v *Verifier) Policy() string {
+	return v.policy
+}
+
+// Enabled reports whether pulls and container creation are checked
+func (v *Verifier) Enabled() bool {
+	return v.policy != PolicyDisabled
+}
+
+// Verify resolves imageRef in its registry and verifies the signatures and
+// attestations of the manifest it points at.
+func (v *Verifier) Verify(ctx context.Context, imageRef string) (*Result, error) {
+	ref, err := registry.ParseReference(imageRef)
+	if err != nil {
+		return nil, err
+	}
+	client, err := v.registries.Client(ref.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := ref.Digest
+	if digest == "" {
+		tag := ref.Tag
+		if tag == "" {
+			tag = "latest"
+		}
+		if digest, err = client.ManifestDigest(ctx, ref.Repository, tag); err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", imageRef, err)
+		}
+	}
+	return v.verifyDigest(ctx, client, imageRef, ref, digest)
+}
+
+// VerifyLocal verifies a local image through the registry digests Docker
+// recorded when it was pulled. Images without a digest for imageRef's
+// repository were built or loaded locally and cannot carry a signature.
+func (v *Verifier) VerifyLocal(ctx context.Context, imageRef string, repoDigests []string) (*Result, error) {
+	ref, err := registry.ParseReference(imageRef)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Digest != "" {
+		return v.Verify(ctx, imageRef)
+	}
+
+	for _, repoDigest := range repoDigests {
+		candidate, err := registry.ParseReference(repoDigest)
+		if err != nil || candidate.Domain != ref.Domain || candidate.Repository != ref.Repository {
+			continue
+		}
+		client, err := v.registries.Client(ref.Domain)
+		if err != nil {
+			return nil, err
+		}
+		return v.verifyDigest(ctx, client, imageRef, ref, candidate.Digest)
+	}
+
+	return &Result{
+		Image:        imageRef,
+		Signatures:   []Signature{},
+		Attestations: []Attestation{},
+		Problems:     []string{"image has no registry digest; it was built or loaded locally"},
+	}, nil
+}
+
+// Check verifies imageRef in its registry and applies the policy
+func (v *Verifier) Check(ctx context.Context, imageRef string) (*Result, error) {
+	if !v.Enabled() {
+		return nil, nil
+	}
+	result, err := v.Verify(ctx, imageRef)
+	return v.apply(imageRef, result, err)
+}
+
+// CheckLocal verifies a local image and applies the policy
+func (v *Verifier) CheckLocal(ctx context.Context, imageRef string, repoDigests []string) (*Result, error) {
+	if !v.Enabled() {
+		return nil, nil
+	}
+	result, err := v.VerifyLocal(ctx, imageRef, repoDigests)
+	return v.apply(imageRef, result, err)
+}
+
+// apply turns a verification outcome into the policy decision. Registry
+// errors fail closed under enforce.
+func (v *Verifier) apply(imageRef string, result *Result, err error) (*Result, error) {
+	if err != nil {
+		if v.policy == PolicyEnforce {
+			return nil, &PolicyError{Result: &Result{Image: imageRef, Problems: []string{err.Error()}}}
+		}
+		log.Printf("Signature verification of %s failed: %v", imageRef, err)
+		return nil, nil
+	}
+	if result.Verified {
+		return result, nil
+	}
+	if v.policy == PolicyEnforce {
+		return result, &PolicyError{Result: result}
+	}
+	log.Printf("Image %s is not validly signed: %s", imageRef, strings.Join(result.Problems, "; "))
+	return result, nil
+}
+
+func (v *Verifier) verifyDigest(ctx context.Context, client *registry.Client, imageRef string, ref registry.Reference, digest string) (*Result, error) {
+	result := &Result{
+		Image:        imageRef,
+		Digest:       digest,
+		Pinned:       ref.Domain + "/" + ref.Repository + "@" + digest,
+		Signatures:   []Signature{},
+		Attestations: []Attestation{},
+	}
+	if len(v.keys) == 0 {
+		result.Problems = append(result.Problems, "no trusted public keys are configured")
+	}
+
+	signatures, err := v.artifactLayers(ctx, client, ref.Repository, SignatureTag(digest))
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range signatures {
+		if layer.MediaType != MediaTypeSimpleSigning {
+			continue
+		}
+		result.Signatures = append(result.Signatures, v.verifySignatureLayer(ctx, client, ref.Repository, digest, layer))
+	}
+
+	attestations, err := v.artifactLayers(ctx, client, ref.Repository, AttestationTag(digest))
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range attestations {
+		if layer.MediaType != MediaTypeDSSE {
+			continue
+		}
+		result.Attestations = append(result.Attestations, v.verifyAttestationLayer(ctx, client, ref.Repository, digest, layer))
+	}
+
+	signed := false
+	for _, signature := range result.Signatures {
+		signed = signed || signature.Verified
+	}
+	switch {
+	case len(result.Signatures) == 0:
+		result.Problems = append(result.Problems, "image is not signed")
+	case !signed:
+		result.Problems = append(result.Problems, "no signature verifies with a trusted key")
+	}
+
+	for _, predicateType := range v.requiredAttestations {
+		found := false
+		for _, attestation := range result.Attestations {
+			found = found || (attestation.Verified && attestation.PredicateType == predicateType)
+		}
+		if !found {
+			result.Missing = append(result.Missing, predicateType)
+			result.Problems = append(result.Problems, "missing verified attestation "+predicateType)
+		}
+	}
+
+	result.Verified = signed && len(result.Missing) == 0
+	return result, nil
+}
+
+// artifactLayers returns the layers of the signature or attestation manifest
+// stored under tag, or none when the tag does not exist
+func (v *Verifier) artifactLayers(ctx context.Context, client *registry.Client, repo, tag string) ([]ocispec.Descriptor, error) {
+	manifest, err := client.Manifest(ctx, repo, tag)
+	if registry.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", tag, err)
+	}
+	if manifest.Manifest == nil {
+		return nil, fmt.Errorf("%s is not an image manifest", tag)
+	}
+	return manifest.Manifest.Layers, nil
+}
+
+// simpleSigningPayload is the document cosign signs for an image
+type simpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+func (v *Verifier) verifySignatureLayer(ctx context.Context, client *registry.Client, repo, digest string, layer ocispec.Descriptor) Signature {
+	signature := Signature{Layer: layer.Digest.String()}
+
+	payload, err := fetchArtifact(ctx, client, repo, layer)
+	if err != nil {
+		signature.Error = err.Error()
+		return signature
+	}
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[AnnotationSignature])
+	if err != nil || len(sig) == 0 {
+		signature.Error = "signature annotation is missing or not base64"
+		return signature
+	}
+
+	keyID, ok := v.matchKey(payload, sig)
+	if !ok {
+		signature.Error = "signature does not verify with any trusted key"
+		return signature
+	}
+	signature.KeyID = keyID
+
+	var claims simpleSigningPayload
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		signature.Error = "invalid signature payload: " + err.Error()
+		return signature
+	}
+	if claims.Critical.Image.DockerManifestDigest != digest {
+		signature.Error = fmt.Sprintf("signature is for %s", claims.Critical.Image.DockerManifestDigest)
+		return signature
+	}
+	signature.Verified = true
+	return signature
+}
+
+// dsseEnvelope is a DSSE envelope holding an in-toto statement
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// inTotoStatement is the part of an in-toto statement checked here
+type inTotoStatement struct {
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+}
+
+func (v *Verifier) verifyAttestationLayer(ctx context.Context, client *registry.Client, repo, digest string, layer ocispec.Descriptor) Attestation {
+	attestation := Attestation{Layer: layer.Digest.String(), PredicateType: layer.Annotations[AnnotationPredicate]}
+
+	raw, err := fetchArtifact(ctx, client, repo, layer)
+	if err != nil {
+		attestation.Error = err.Error()
+		return attestation
+	}
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		attestation.Error = "invalid DSSE envelope: " + err.Error()
+		return attestation
+	}
+	if envelope.PayloadType != payloadTypeInToto {
+		attestation.Error = "unexpected payload type " + envelope.PayloadType
+		return attestation
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		attestation.Error = "payload is not base64"
+		return attestation
+	}
+
+	message := preAuthEncoding(envelope.PayloadType, payload)
+	for _, signature := range envelope.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			continue
+		}
+		if keyID, ok := v.matchKey(message, sig); ok {
+			attestation.KeyID = keyID
+			break
+		}
+	}
+	if attestation.KeyID == "" {
+		attestation.Error = "attestation does not verify with any trusted key"
+		return attestation
+	}
+
+	var statement inTotoStatement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		attestation.Error = "invalid in-toto statement: " + err.Error()
+		return attestation
+	}
+	attestation.PredicateType = statement.PredicateType
+
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	for _, subject := range statement.Subject {
+		if subject.Digest[algorithm] == hex {
+			attestation.Verified = true
+			return attestation
+		}
+	}
+	attestation.Error = "attestation subject does not match the image digest"
+	return attestation
+}
+
+// preAuthEncoding is the DSSE PAE the envelope signatures are computed over
+func preAuthEncoding(payloadType string, payload []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// matchKey returns the ID of the first trusted key sig verifies with
+func (v *Verifier) matchKey(message, sig []byte) (string, bool) {
+	for _, key := range v.keys {
+		if verifySignature(key.Key, message, sig) {
+			return key.ID, true
+		}
+	}
+	return "", false
+}
+
+// fetchArtifact downloads a small signature or attestation blob and checks
+// it against its descriptor digest
+func fetchArtifact(ctx context.Context, client *registry.Client, repo string, layer ocispec.Descriptor) ([]byte, error) {
+	if layer.Size > maxArtifactSize {
+		return nil, fmt.Errorf("artifact is too large (%d bytes)", layer.Size)
+	}
+	data, err := client.Blob(ctx, repo, layer.Digest.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch artifact: %w", err)
+	}
+	if err := layer.Digest.Validate(); err != nil || layer.Digest.Algorithm().FromBytes(data) != layer.Digest {
+		return nil, errors.New("artifact does not match its digest")
+	}
+	return data, nil
+}
@@ -0,0 +1,220 @@
+package signatures
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/genc-murat/harborview/internal/registry"
+	"github.com/genc-murat/harborview/internal/registry/registrytest"
+	"github.com/genc-murat/harborview/pkg/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const provenance = "https://slsa.dev/provenance/v1"
+
+// testRegistry is a fake registry holding team/app with a signed tag, an
+// unsigned tag and a tag signed by an untrusted key
+type testRegistry struct {
+	*registrytest.Registry
+	host     string
+	trusted  crypto.Signer
+	signed   ocispec.Descriptor
+	unsigned ocispec.Descriptor
+	cfg      func(policy string, required ...string) config.Config
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	t.Helper()
+	reg, server := registrytest.NewServer()
+	t.Cleanup(server.Close)
+
+	trusted, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	platform := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	signed := reg.PushImage("team/app", "signed", platform, time.Now(), []byte("signed"))
+	reg.SignImage("team/app", signed.Digest.String(), trusted)
+	unsigned := reg.PushImage("team/app", "unsigned", platform, time.Now(), []byte("unsigned"))
+	other := reg.PushImage("team/app", "other-key", platform, time.Now(), []byte("other"))
+	reg.SignImage("team/app", other.Digest.String(), untrusted)
+
+	publicKey := publicKeyPEM(t, trusted.Public())
+	return &testRegistry{
+		Registry: reg,
+		host:     strings.TrimPrefix(server.URL, "http://"),
+		trusted:  trusted,
+		signed:   signed,
+		unsigned: unsigned,
+		cfg: func(policy string, required ...string) config.Config {
+			var cfg config.Config
+			cfg.Registry.BaseURL = server.URL
+			cfg.Signing.Policy = policy
+			cfg.Signing.PublicKeys = []string{publicKey}
+			cfg.Signing.RequiredAttestations = required
+			return cfg
+		},
+	}
+}
+
+func (r *testRegistry) ref(tag string) string {
+	return r.host + "/team/app:" + tag
+}
+
+func (r *testRegistry) verifier(t *testing.T, policy string, required ...string) *Verifier {
+	t.Helper()
+	cfg := r.cfg(policy, required...)
+	verifier, err := NewVerifier(cfg, registry.NewResolver(cfg))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	return verifier
+}
+
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestVerify(t *testing.T) {
+	reg := newTestRegistry(t)
+	verifier := reg.verifier(t, PolicyEnforce)
+	ctx := context.Background()
+
+	result, err := verifier.Verify(ctx, reg.ref("signed"))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !result.Verified || len(result.Signatures) != 1 || result.Signatures[0].KeyID != "inline-0" {
+		t.Errorf("signed result = %+v", result)
+	}
+	if want := reg.host + "/team/app@" + reg.signed.Digest.String(); result.Pinned != want {
+		t.Errorf("Pinned = %s, want %s", result.Pinned, want)
+	}
+
+	tests := []struct {
+		tag     string
+		problem string
+	}{
+		{"unsigned", "image is not signed"},
+		{"other-key", "no signature verifies with a trusted key"},
+	}
+	for _, tt := range tests {
+		result, err := verifier.Verify(ctx, reg.ref(tt.tag))
+		if err != nil {
+			t.Fatalf("Verify(%s): %v", tt.tag, err)
+		}
+		if result.Verified || !strings.Contains(strings.Join(result.Problems, "; "), tt.problem) {
+			t.Errorf("Verify(%s) = verified %v, problems %v, want %q", tt.tag, result.Verified, result.Problems, tt.problem)
+		}
+	}
+}
+
+func TestVerifyAttestations(t *testing.T) {
+	reg := newTestRegistry(t)
+	verifier := reg.verifier(t, PolicyEnforce, provenance)
+	ctx := context.Background()
+
+	result, err := verifier.Verify(ctx, reg.ref("signed"))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if result.Verified || len(result.Missing) != 1 || result.Missing[0] != provenance {
+		t.Errorf("without attestation: verified %v, missing %v", result.Verified, result.Missing)
+	}
+
+	reg.AttestImage("team/app", reg.signed.Digest.String(), provenance, map[string]string{"builder": "ci"}, reg.trusted)
+	result, err = verifier.Verify(ctx, reg.ref("signed"))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !result.Verified || len(result.Attestations) != 1 || result.Attestations[0].PredicateType != provenance {
+		t.Errorf("with attestation: %+v", result)
+	}
+}
+
+func TestCheckPolicies(t *testing.T) {
+	reg := newTestRegistry(t)
+	ctx := context.Background()
+
+	enforce := reg.verifier(t, PolicyEnforce)
+	if _, err := enforce.Check(ctx, reg.ref("signed")); err != nil {
+		t.Errorf("enforce rejected a signed image: %v", err)
+	}
+	for _, tag := range []string{"unsigned", "other-key", "missing"} {
+		if _, err := enforce.Check(ctx, reg.ref(tag)); !IsPolicyViolation(err) {
+			t.Errorf("enforce Check(%s) = %v, want a policy violation", tag, err)
+		}
+	}
+
+	warn := reg.verifier(t, PolicyWarn)
+	result, err := warn.Check(ctx, reg.ref("unsigned"))
+	if err != nil || result == nil || result.Verified {
+		t.Errorf("warn Check(unsigned) = %+v, %v, want an unverified result without error", result, err)
+	}
+
+	disabled := reg.verifier(t, PolicyDisabled)
+	if result, err := disabled.Check(ctx, reg.ref("unsigned")); result != nil || err != nil {
+		t.Errorf("disabled Check = %+v, %v, want nothing checked", result, err)
+	}
+}
+
+func TestCheckLocal(t *testing.T) {
+	reg := newTestRegistry(t)
+	verifier := reg.verifier(t, PolicyEnforce)
+	ctx := context.Background()
+
+	repoDigests := []string{"docker.io/library/app@sha256:0000000000000000000000000000000000000000000000000000000000000000", reg.host + "/team/app@" + reg.signed.Digest.String()}
+	if _, err := verifier.CheckLocal(ctx, reg.ref("signed"), repoDigests); err != nil {
+		t.Errorf("CheckLocal with the signed digest: %v", err)
+	}
+	if _, err := verifier.CheckLocal(ctx, reg.ref("signed"), nil); !IsPolicyViolation(err) {
+		t.Errorf("CheckLocal of a locally built image = %v, want a policy violation", err)
+	}
+}
+
+func TestVerifySignatureKeyTypes(t *testing.T) {
+	message := []byte("payload")
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for name, signer := range map[string]crypto.Signer{"ecdsa": ecKey, "rsa": rsaKey, "ed25519": edKey} {
+		var sig []byte
+		var err error
+		if name == "ed25519" {
+			sig, err = signer.Sign(rand.Reader, message, crypto.Hash(0))
+		} else {
+			digest := crypto.SHA256.New()
+			digest.Write(message)
+			sig, err = signer.Sign(rand.Reader, digest.Sum(nil), crypto.SHA256)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !verifySignature(signer.Public(), message, sig) {
+			t.Errorf("%s signature did not verify", name)
+		}
+		if verifySignature(signer.Public(), []byte("tampered"), sig) {
+			t.Errorf("%s signature verified a different message", name)
+		}
+	}
+}
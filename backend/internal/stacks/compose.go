@@ -0,0 +1,567 @@
+package stacks
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Project is a parsed compose file with variables interpolated
+type Project struct {
+	Name     string
+	Services map[string]*Service
+	Networks map[string]*Network
+	Volumes  map[string]*Volume
+	// Order lists services so every service comes after its dependencies
+	Order    []string
+	Warnings []string
+}
+
+// composeFile is the top level of a compose file
+type composeFile struct {
+	Version  string              `yaml:"version"`
+	Name     string              `yaml:"name"`
+	Services map[string]*Service `yaml:"services"`
+	Networks map[string]*Network `yaml:"networks"`
+	Volumes  map[string]*Volume  `yaml:"volumes"`
+}
+
+// Service is a compose service. Keys harborview does not support are
+// reported as warnings rather than rejected.
+type Service struct {
+	Name string `yaml:"-"`
+
+	Image           string          `yaml:"image"`
+	Build           yaml.Node       `yaml:"build"`
+	ContainerName   string          `yaml:"container_name"`
+	Command         shellCommand    `yaml:"command"`
+	Entrypoint      shellCommand    `yaml:"entrypoint"`
+	Environment     mappingList     `yaml:"environment"`
+	EnvFile         stringList      `yaml:"env_file"`
+	Ports           []portSpec      `yaml:"ports"`
+	Expose          stringList      `yaml:"expose"`
+	Volumes         []volumeSpec    `yaml:"volumes"`
+	Networks        serviceNetworks `yaml:"networks"`
+	NetworkMode     string          `yaml:"network_mode"`
+	DependsOn       dependsOn       `yaml:"depends_on"`
+	Restart         string          `yaml:"restart"`
+	Labels          mappingList     `yaml:"labels"`
+	WorkingDir      string          `yaml:"working_dir"`
+	User            string          `yaml:"user"`
+	Hostname        string          `yaml:"hostname"`
+	Healthcheck     *Healthcheck    `yaml:"healthcheck"`
+	ExtraHosts      stringList      `yaml:"extra_hosts"`
+	Privileged      bool            `yaml:"privileged"`
+	CapAdd          []string        `yaml:"cap_add"`
+	CapDrop         []string        `yaml:"cap_drop"`
+	StopSignal      string          `yaml:"stop_signal"`
+	StopGracePeriod string          `yaml:"stop_grace_period"`
+	Tty             bool            `yaml:"tty"`
+	StdinOpen       bool            `yaml:"stdin_open"`
+	Scale           *int            `yaml:"scale"`
+	Deploy          *Deploy         `yaml:"deploy"`
+
+	// env is Environment merged over the env files
+	env map[string]string
+	// replicas is the number of containers, from scale or deploy.replicas
+	replicas int
+}
+
+// Replicas is the number of containers the service runs
+func (s *Service) Replicas() int {
+	return s.replicas
+}
+
+// Deploy is the part of a service's deploy section harborview applies
+type Deploy struct {
+	Replicas *int `yaml:"replicas"`
+}
+
+// Healthcheck is a service healthcheck
+type Healthcheck struct {
+	Test        stringList `yaml:"test"`
+	Interval    string     `yaml:"interval"`
+	Timeout     string     `yaml:"timeout"`
+	StartPeriod string     `yaml:"start_period"`
+	Retries     int        `yaml:"retries"`
+	Disable     bool       `yaml:"disable"`
+}
+
+// Network is a top-level compose network
+type Network struct {
+	Name       string            `yaml:"name"`
+	Driver     string            `yaml:"driver"`
+	DriverOpts map[string]string `yaml:"driver_opts"`
+	External   bool              `yaml:"external"`
+	Internal   bool              `yaml:"internal"`
+	Attachable bool              `yaml:"attachable"`
+	EnableIPv6 bool              `yaml:"enable_ipv6"`
+	Labels     mappingList       `yaml:"labels"`
+	IPAM       struct {
+		Driver string `yaml:"driver"`
+		Config []struct {
+			Subnet  string `yaml:"subnet"`
+			Gateway string `yaml:"gateway"`
+			IPRange string `yaml:"ip_range"`
+		} `yaml:"config"`
+	} `yaml:"ipam"`
+}
+
+// Volume is a top-level compose volume
+type Volume struct {
+	Name       string            `yaml:"name"`
+	Driver     string            `yaml:"driver"`
+	DriverOpts map[string]string `yaml:"driver_opts"`
+	External   bool              `yaml:"external"`
+	Labels     mappingList       `yaml:"labels"`
+}
+
+// ServiceNetwork is a service's attachment to a network
+type ServiceNetwork struct {
+	Aliases     []string `yaml:"aliases"`
+	IPv4Address string   `yaml:"ipv4_address"`
+	IPv6Address string   `yaml:"ipv6_address"`
+}
+
+// Dependency conditions of depends_on
+const (
+	ConditionStarted   = "service_started"
+	ConditionHealthy   = "service_healthy"
+	ConditionCompleted = "service_completed_successfully"
+)
+
+// Dependency is one depends_on entry
+type Dependency struct {
+	Condition string `yaml:"condition"`
+}
+
+// DefaultNetwork is the network of services that declare none
+const DefaultNetwork = "default"
+
+var projectName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Parse parses a stack's compose file. Variables are taken from the stack's
+// env, then from its .env file; env_file entries name files of the stack.
+func Parse(stack Stack) (*Project, error) {
+	if !projectName.MatchString(stack.Name) {
+		return nil, fmt.Errorf("invalid stack name %q: use lowercase letters, digits, '-' and '_'", stack.Name)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(stack.Compose), &root); err != nil {
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+	if len(root.Content) == 0 {
+		return nil, errors.New("compose file is empty")
+	}
+
+	project := &Project{Name: stack.Name}
+	variables := map[string]string{}
+	if dotenv, ok := stack.EnvFiles[".env"]; ok {
+		parsed, err := parseEnvFile(dotenv)
+		if err != nil {
+			return nil, fmt.Errorf(".env: %w", err)
+		}
+		variables = parsed
+	}
+	for key, value := range stack.Env {
+		variables[key] = value
+	}
+	missing := map[string]bool{}
+	if err := interpolateNode(root.Content[0], variables, missing); err != nil {
+		return nil, err
+	}
+	for _, name := range sortedKeys(missing) {
+		project.Warnings = append(project.Warnings, fmt.Sprintf("variable %s is not set, defaulting to an empty string", name))
+	}
+	project.Warnings = append(project.Warnings, unsupportedKeys(root.Content[0], composeFile{}, "")...)
+
+	var file composeFile
+	if err := root.Content[0].Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+	if len(file.Services) == 0 {
+		return nil, errors.New("compose file defines no services")
+	}
+	project.Services = file.Services
+	project.Networks = file.Networks
+	project.Volumes = file.Volumes
+	if project.Networks == nil {
+		project.Networks = map[string]*Network{}
+	}
+	if project.Volumes == nil {
+		project.Volumes = map[string]*Volume{}
+	}
+
+	for name, network := range project.Networks {
+		if network == nil {
+			project.Networks[name] = &Network{}
+		}
+	}
+	for name, volume := range project.Volumes {
+		if volume == nil {
+			project.Volumes[name] = &Volume{}
+		}
+	}
+
+	if file.Name != "" && file.Name != stack.Name {
+		project.Warnings = append(project.Warnings, fmt.Sprintf("name %s is ignored; the project is named after the stack", file.Name))
+	}
+
+	envFiles := map[string]string{}
+	for name, content := range stack.EnvFiles {
+		envFiles[path.Clean(name)] = content
+	}
+	services := mappingValue(root.Content[0], "services")
+	for _, name := range sortedKeys(project.Services) {
+		service := project.Services[name]
+		if service == nil {
+			return nil, fmt.Errorf("service %s is empty", name)
+		}
+		service.Name = name
+		if node := mappingValue(services, name); node != nil {
+			project.Warnings = append(project.Warnings, unsupportedKeys(node, Service{}, "services."+name+".")...)
+			project.Warnings = append(project.Warnings, unsupportedKeys(mappingValue(node, "deploy"), Deploy{}, "services."+name+".deploy.")...)
+		}
+		if err := project.resolveService(service, envFiles); err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+	order, err := dependencyOrder(project.Services)
+	if err != nil {
+		return nil, err
+	}
+	project.Order = order
+	return project, nil
+}
+
+// resolveService validates a service's references and merges its
+// environment
+func (p *Project) resolveService(service *Service, envFiles map[string]string) error {
+	if !service.Build.IsZero() {
+		return errors.New("build is not supported; push the image to a registry and set image")
+	}
+	if service.Image == "" {
+		return errors.New("image is required")
+	}
+
+	service.replicas = 1
+	if service.Deploy != nil && service.Deploy.Replicas != nil {
+		service.replicas = *service.Deploy.Replicas
+	}
+	if service.Scale != nil {
+		if service.Deploy != nil && service.Deploy.Replicas != nil && *service.Deploy.Replicas != *service.Scale {
+			return errors.New("scale and deploy.replicas disagree")
+		}
+		service.replicas = *service.Scale
+	}
+	if service.replicas < 0 {
+		return fmt.Errorf("invalid replica count %d", service.replicas)
+	}
+	if service.replicas > 1 && service.ContainerName != "" {
+		return errors.New("container_name cannot be used with more than one replica")
+	}
+
+	service.env = map[string]string{}
+	for _, file := range service.EnvFile {
+		content, ok := envFiles[path.Clean(file)]
+		if !ok {
+			return fmt.Errorf("env_file %s is not part of the stack", file)
+		}
+		values, err := parseEnvFile(content)
+		if err != nil {
+			return fmt.Errorf("env_file %s: %w", file, err)
+		}
+		for key, value := range values {
+			service.env[key] = value
+		}
+	}
+	for key, value := range service.Environment {
+		if value != nil {
+			service.env[key] = *value
+		}
+	}
+
+	if service.NetworkMode != "" && len(service.Networks) > 0 {
+		return errors.New("network_mode and networks cannot be combined")
+	}
+	if service.NetworkMode == "" && len(service.Networks) == 0 {
+		service.Networks = serviceNetworks{DefaultNetwork: nil}
+	}
+	for name := range service.Networks {
+		if _, ok := p.Networks[name]; ok {
+			continue
+		}
+		if name != DefaultNetwork {
+			return fmt.Errorf("network %s is not defined", name)
+		}
+		p.Networks[DefaultNetwork] = &Network{}
+	}
+	if target, ok := strings.CutPrefix(service.NetworkMode, "service:"); ok {
+		if _, ok := p.Services[target]; !ok {
+			return fmt.Errorf("network_mode refers to unknown service %s", target)
+		}
+	}
+
+	for _, volume := range service.Volumes {
+		if volume.Type == "volume" && volume.Source != "" {
+			if _, ok := p.Volumes[volume.Source]; !ok {
+				return fmt.Errorf("volume %s is not defined", volume.Source)
+			}
+		}
+		if volume.Type == "bind" && !strings.HasPrefix(volume.Source, "/") {
+			return fmt.Errorf("bind mount %s must use an absolute path; relative paths have no meaning on a remote daemon", volume.Source)
+		}
+	}
+	for name := range service.DependsOn {
+		if _, ok := p.Services[name]; !ok {
+			return fmt.Errorf("depends_on refers to unknown service %s", name)
+		}
+	}
+	return nil
+}
+
+// dependencyOrder sorts services so dependencies come first, alphabetically
+// among equals so deployments are repeatable
+func dependencyOrder(services map[string]*Service) ([]string, error) {
+	pending := map[string]int{}
+	dependents := map[string][]string{}
+	for name := range services {
+		pending[name] = 0
+	}
+	for name, service := range services {
+		for dependency := range service.DependsOn {
+			pending[name]++
+			dependents[dependency] = append(dependents[dependency], name)
+		}
+		if target, ok := strings.CutPrefix(service.NetworkMode, "service:"); ok {
+			if _, declared := service.DependsOn[target]; !declared {
+				pending[name]++
+				dependents[target] = append(dependents[target], name)
+			}
+		}
+	}
+
+	var ready, order []string
+	for name, count := range pending {
+		if count == 0 {
+			ready = append(ready, name)
+		}
+	}
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, dependent := range dependents[name] {
+			if pending[dependent]--; pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if len(order) != len(services) {
+		var cycle []string
+		for name, count := range pending {
+			if count > 0 {
+				cycle = append(cycle, name)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("dependency cycle between services %s", strings.Join(cycle, ", "))
+	}
+	return order, nil
+}
+
+// NetworkName is the Docker name of a project network
+func (p *Project) NetworkName(key string) string {
+	if network, ok := p.Networks[key]; ok && network.Name != "" {
+		return network.Name
+	}
+	if network, ok := p.Networks[key]; ok && network.External {
+		return key
+	}
+	return p.Name + "_" + key
+}
+
+// VolumeName is the Docker name of a project volume
+func (p *Project) VolumeName(key string) string {
+	if volume, ok := p.Volumes[key]; ok && volume.Name != "" {
+		return volume.Name
+	}
+	if volume, ok := p.Volumes[key]; ok && volume.External {
+		return key
+	}
+	return p.Name + "_" + key
+}
+
+// ContainerName is the Docker name of a service's container with the given
+// replica number, counted from 1
+func (p *Project) ContainerName(service *Service, number int) string {
+	if service.ContainerName != "" {
+		return service.ContainerName
+	}
+	return fmt.Sprintf("%s-%s-%d", p.Name, service.Name, number)
+}
+
+// interpolateNode substitutes variables in every scalar value below node.
+// Mapping keys are left alone, as compose does.
+func interpolateNode(node *yaml.Node, variables map[string]string, missing map[string]bool) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolateNode(node.Content[i], variables, missing); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := interpolateNode(child, variables, missing); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "$") {
+			return nil
+		}
+		value, err := interpolate(node.Value, variables, missing)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value = value
+		// Let an unquoted "${PORT}" decode as a number again
+		if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			node.Tag = ""
+		}
+	}
+	return nil
+}
+
+var variablePattern = regexp.MustCompile(`\$(?:\$|\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-?+])((?:[^{}]|\{[^{}]*\})*))?\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// interpolate expands $VAR, ${VAR}, ${VAR:-default}, ${VAR-default},
+// ${VAR:?error}, ${VAR?error}, ${VAR:+alt} and ${VAR+alt}; $$ is a literal $
+func interpolate(value string, variables map[string]string, missing map[string]bool) (string, error) {
+	var failure error
+	result := variablePattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		groups := variablePattern.FindStringSubmatch(match)
+		name, operator, argument := groups[1], groups[2], groups[3]
+		if name == "" {
+			name = groups[4]
+		}
+		current, set := variables[name]
+		nonEmpty := set && current != ""
+
+		switch operator {
+		case ":-":
+			if !nonEmpty {
+				return argument
+			}
+		case "-":
+			if !set {
+				return argument
+			}
+		case ":?", "?":
+			if (operator == ":?" && !nonEmpty) || (operator == "?" && !set) {
+				if argument == "" {
+					argument = "is not set"
+				}
+				failure = fmt.Errorf("required variable %s %s", name, argument)
+			}
+		case ":+":
+			if nonEmpty {
+				return argument
+			}
+			return ""
+		case "+":
+			if set {
+				return argument
+			}
+			return ""
+		}
+		if !set {
+			missing[name] = true
+		}
+		return current
+	})
+	return result, failure
+}
+
+// parseEnvFile parses KEY=VALUE lines, ignoring blanks and # comments. An
+// optional "export " prefix and matching quotes around values are stripped.
+func parseEnvFile(content string) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", number)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// unsupportedKeys lists the keys of a mapping node that v's yaml tags do not
+// cover
+func unsupportedKeys(node *yaml.Node, v any, prefix string) []string {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	known := map[string]bool{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if tag != "" && tag != "-" {
+			known[tag] = true
+		}
+	}
+	var warnings []string
+	for i := 0; i < len(node.Content); i += 2 {
+		if key := node.Content[i].Value; !known[key] && !strings.HasPrefix(key, "x-") {
+			warnings = append(warnings, fmt.Sprintf("%s%s is not supported and was ignored", prefix, key))
+		}
+	}
+	return warnings
+}
+
+// mappingValue returns the value of key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,304 @@
+package stacks
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	variables := map[string]string{"TAG": "1.2", "EMPTY": ""}
+	tests := []struct {
+		value   string
+		want    string
+		missing string
+		err     string
+	}{
+		{"nginx:$TAG", "nginx:1.2", "", ""},
+		{"nginx:${TAG}", "nginx:1.2", "", ""},
+		{"${UNSET:-latest}", "latest", "", ""},
+		{"${EMPTY:-latest}", "latest", "", ""},
+		{"${EMPTY-latest}", "", "", ""},
+		{"${UNSET-latest}", "latest", "", ""},
+		{"${TAG:+pinned}", "pinned", "", ""},
+		{"${EMPTY:+pinned}", "", "", ""},
+		{"${EMPTY+pinned}", "pinned", "", ""},
+		{"${UNSET+pinned}", "", "", ""},
+		{"$$TAG costs $$5", "$TAG costs $5", "", ""},
+		{"${UNSET}", "", "UNSET", ""},
+		{"${EMPTY?must be set}", "", "", ""},
+		{"${EMPTY:?must not be empty}", "", "", "required variable EMPTY must not be empty"},
+		{"${UNSET?}", "", "", "required variable UNSET is not set"},
+	}
+	for _, tt := range tests {
+		missing := map[string]bool{}
+		got, err := interpolate(tt.value, variables, missing)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("interpolate(%q) error = %v, want %q", tt.value, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("interpolate(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("interpolate(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+		if tt.missing != "" && !missing[tt.missing] {
+			t.Errorf("interpolate(%q) did not report %s as missing", tt.value, tt.missing)
+		}
+	}
+}
+
+func TestParseEnvironment(t *testing.T) {
+	stack := Stack{
+		Name: "shop",
+		Compose: `
+services:
+  api:
+    image: shop/api:${TAG}
+    env_file: config/api.env
+    environment:
+      MODE: ${MODE:-production}
+      REGION: eu
+      UNSET_HERE:
+      PRICE: "$$5"
+`,
+		Env: map[string]string{"TAG": "2.0"},
+		EnvFiles: map[string]string{
+			".env":           "TAG=1.0\nMODE=staging\n",
+			"config/api.env": "# api settings\nREGION=us\nexport TOKEN='secret'\n",
+		},
+	}
+	project, err := Parse(stack)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	api := project.Services["api"]
+	if api.Image != "shop/api:2.0" {
+		t.Errorf("image = %s, want the stack's env to override .env", api.Image)
+	}
+	want := map[string]string{"MODE": "staging", "REGION": "eu", "TOKEN": "secret", "PRICE": "$5"}
+	for key, value := range want {
+		if api.env[key] != value {
+			t.Errorf("env %s = %q, want %q", key, api.env[key], value)
+		}
+	}
+	if _, ok := api.env["UNSET_HERE"]; ok {
+		t.Error("a variable without a value is passed to the container")
+	}
+	if len(project.Warnings) != 0 {
+		t.Errorf("warnings = %v, want none", project.Warnings)
+	}
+
+	stack.Env, stack.EnvFiles = nil, map[string]string{"config/api.env": ""}
+	project, err = Parse(stack)
+	if err != nil {
+		t.Fatalf("Parse without variables: %v", err)
+	}
+	if !slices.Contains(project.Warnings, "variable TAG is not set, defaulting to an empty string") {
+		t.Errorf("warnings = %v, want TAG reported as not set", project.Warnings)
+	}
+
+	stack.Compose = "services:\n  api:\n    image: shop/api:${TAG:?pick a release}\n"
+	if _, err := Parse(stack); err == nil || !strings.Contains(err.Error(), "required variable TAG pick a release") {
+		t.Errorf("Parse with a required variable unset = %v", err)
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		compose string
+		order   []string
+		err     string
+	}{
+		{
+			name: "alphabetical without dependencies",
+			compose: `
+services:
+  web: {image: nginx}
+  cache: {image: redis}
+  api: {image: api}
+`,
+			order: []string{"api", "cache", "web"},
+		},
+		{
+			name: "dependencies first",
+			compose: `
+services:
+  web:
+    image: nginx
+    depends_on: [api]
+  api:
+    image: api
+    depends_on:
+      db: {condition: service_healthy}
+      cache: {condition: service_started}
+  db: {image: postgres}
+  cache: {image: redis}
+`,
+			order: []string{"cache", "db", "api", "web"},
+		},
+		{
+			name: "network_mode service",
+			compose: `
+services:
+  app: {image: app, network_mode: "service:vpn"}
+  vpn: {image: vpn}
+`,
+			order: []string{"vpn", "app"},
+		},
+		{
+			name: "cycle",
+			compose: `
+services:
+  a: {image: a, depends_on: [b]}
+  b: {image: b, depends_on: [c]}
+  c: {image: c, depends_on: [a]}
+  d: {image: d}
+`,
+			err: "dependency cycle between services a, b, c",
+		},
+		{
+			name:    "unknown dependency",
+			compose: "services:\n  web: {image: nginx, depends_on: [api]}\n",
+			err:     "service web: depends_on refers to unknown service api",
+		},
+		{
+			name:    "unknown network_mode service",
+			compose: "services:\n  web: {image: nginx, network_mode: \"service:vpn\"}\n",
+			err:     "service web: network_mode refers to unknown service vpn",
+		},
+	}
+	for _, tt := range tests {
+		project, err := Parse(Stack{Name: "shop", Compose: tt.compose})
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: Parse error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Parse: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(project.Order, tt.order) {
+			t.Errorf("%s: order = %v, want %v", tt.name, project.Order, tt.order)
+		}
+	}
+}
+
+func TestParseNetworksAndVolumes(t *testing.T) {
+	project, err := Parse(Stack{Name: "shop", Compose: `
+services:
+  web:
+    image: nginx
+  api:
+    image: api
+    networks: [back, shared]
+    volumes:
+      - data:/var/lib/data
+      - /srv/config:/etc/api:ro
+      - /tmp/cache
+networks:
+  back:
+  shared:
+    external: true
+  named:
+    name: custom-net
+volumes:
+  data:
+  logs:
+    external: true
+`})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	names := [][2]string{
+		{project.NetworkName(DefaultNetwork), "shop_default"},
+		{project.NetworkName("back"), "shop_back"},
+		{project.NetworkName("shared"), "shared"},
+		{project.NetworkName("named"), "custom-net"},
+		{project.VolumeName("data"), "shop_data"},
+		{project.VolumeName("logs"), "logs"},
+		{project.ContainerName(project.Services["api"], 1), "shop-api-1"},
+	}
+	for _, name := range names {
+		if name[0] != name[1] {
+			t.Errorf("name = %s, want %s", name[0], name[1])
+		}
+	}
+	if _, ok := project.Services["web"].Networks[DefaultNetwork]; !ok {
+		t.Error("a service without networks is not on the default network")
+	}
+	if _, ok := project.Services["api"].Networks[DefaultNetwork]; ok {
+		t.Error("a service with networks is also put on the default network")
+	}
+	volumes := project.Services["api"].Volumes
+	want := []volumeSpec{
+		{Type: "volume", Source: "data", Target: "/var/lib/data"},
+		{Type: "bind", Source: "/srv/config", Target: "/etc/api", ReadOnly: true},
+		{Type: "volume", Target: "/tmp/cache"},
+	}
+	if !slices.Equal(volumes, want) {
+		t.Errorf("volumes = %+v, want %+v", volumes, want)
+	}
+
+	invalid := []struct {
+		compose string
+		err     string
+	}{
+		{"services:\n  web: {image: nginx, networks: [front]}\n", "service web: network front is not defined"},
+		{"services:\n  web: {image: nginx, volumes: ['data:/data']}\n", "service web: volume data is not defined"},
+		{"services:\n  web: {image: nginx, volumes: ['./html:/usr/share/nginx/html']}\n", "service web: bind mount ./html must use an absolute path; relative paths have no meaning on a remote daemon"},
+		{"services:\n  web: {image: nginx, network_mode: host, networks: [default]}\n", "service web: network_mode and networks cannot be combined"},
+		{"services:\n  web: {build: .}\n", "service web: build is not supported; push the image to a registry and set image"},
+		{"services:\n  web:\n    build: {context: ., dockerfile: Dockerfile}\n", "service web: build is not supported; push the image to a registry and set image"},
+	}
+	for _, tt := range invalid {
+		if _, err := Parse(Stack{Name: "shop", Compose: tt.compose}); err == nil || err.Error() != tt.err {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.compose, err, tt.err)
+		}
+	}
+}
+
+func TestParseReplicas(t *testing.T) {
+	tests := []struct {
+		service  string
+		replicas int
+		warning  string
+		err      string
+	}{
+		{"{image: nginx}", 1, "", ""},
+		{"{image: nginx, scale: 3}", 3, "", ""},
+		{"{image: nginx, deploy: {replicas: 2}}", 2, "", ""},
+		{"{image: nginx, scale: 0}", 0, "", ""},
+		{"{image: nginx, scale: 2, deploy: {replicas: 2}}", 2, "", ""},
+		{"{image: nginx, deploy: {replicas: 2, resources: {}}}", 2, "services.web.deploy.resources is not supported and was ignored", ""},
+		{"{image: nginx, scale: 2, deploy: {replicas: 3}}", 0, "", "service web: scale and deploy.replicas disagree"},
+		{"{image: nginx, scale: -1}", 0, "", "service web: invalid replica count -1"},
+		{"{image: nginx, scale: 2, container_name: web}", 0, "", "service web: container_name cannot be used with more than one replica"},
+	}
+	for _, tt := range tests {
+		project, err := Parse(Stack{Name: "shop", Compose: "services:\n  web: " + tt.service + "\n"})
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: Parse error = %v, want %q", tt.service, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Parse: %v", tt.service, err)
+			continue
+		}
+		if replicas := project.Services["web"].Replicas(); replicas != tt.replicas {
+			t.Errorf("%s: replicas = %d, want %d", tt.service, replicas, tt.replicas)
+		}
+		if tt.warning != "" && !slices.Contains(project.Warnings, tt.warning) {
+			t.Errorf("%s: warnings = %v, want %q", tt.service, project.Warnings, tt.warning)
+		}
+	}
+}
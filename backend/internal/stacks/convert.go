@@ -0,0 +1,270 @@
+package stacks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"github.com/genc-murat/harborview/internal/containers"
+)
+
+// Labels docker compose puts on the resources it creates. harborview sets
+// the same ones so stacks and compose CLI projects see each other.
+const (
+	LabelProject    = "com.docker.compose.project"
+	LabelService    = "com.docker.compose.service"
+	LabelNumber     = "com.docker.compose.container-number"
+	LabelOneoff     = "com.docker.compose.oneoff"
+	LabelConfigHash = "com.docker.compose.config-hash"
+	LabelNetwork    = "com.docker.compose.network"
+	LabelVolume     = "com.docker.compose.volume"
+)
+
+// containerSpec is everything needed to create one container of a service
+type containerSpec struct {
+	containers.Spec
+	Hash string
+}
+
+// containerSpec converts a service to the Docker API configuration of its
+// replica number. Hash covers the whole spec, so a changed service is
+// recreated on the next up.
+func (p *Project) containerSpec(service *Service, number int) (*containerSpec, error) {
+	labels := map[string]string{}
+	for key, value := range service.Labels.values() {
+		labels[key] = value
+	}
+	labels[LabelProject] = p.Name
+	labels[LabelService] = service.Name
+	labels[LabelNumber] = strconv.Itoa(number)
+	labels[LabelOneoff] = "False"
+
+	env := make([]string, 0, len(service.env))
+	for _, key := range sortedKeys(service.env) {
+		env = append(env, key+"="+service.env[key])
+	}
+
+	config := &container.Config{
+		Image:      service.Image,
+		Cmd:        []string(service.Command),
+		Entrypoint: []string(service.Entrypoint),
+		Env:        env,
+		Labels:     labels,
+		WorkingDir: service.WorkingDir,
+		User:       service.User,
+		Hostname:   service.Hostname,
+		StopSignal: service.StopSignal,
+		Tty:        service.Tty,
+		OpenStdin:  service.StdinOpen,
+	}
+	hostConfig := &container.HostConfig{
+		Privileged: service.Privileged,
+		CapAdd:     service.CapAdd,
+		CapDrop:    service.CapDrop,
+		ExtraHosts: []string(service.ExtraHosts),
+	}
+
+	if service.StopGracePeriod != "" {
+		grace, err := time.ParseDuration(service.StopGracePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stop_grace_period: %w", err)
+		}
+		seconds := int(grace.Seconds())
+		config.StopTimeout = &seconds
+	}
+	if service.Healthcheck != nil {
+		health, err := healthConfig(service.Healthcheck)
+		if err != nil {
+			return nil, err
+		}
+		config.Healthcheck = health
+	}
+
+	restart, err := restartPolicy(service.Restart)
+	if err != nil {
+		return nil, err
+	}
+	hostConfig.RestartPolicy = restart
+
+	specs := make([]string, 0, len(service.Ports))
+	for _, port := range service.Ports {
+		specs = append(specs, string(port))
+	}
+	exposed, bindings, err := nat.ParsePortSpecs(specs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ports: %w", err)
+	}
+	for _, expose := range service.Expose {
+		proto, port := nat.SplitProtoPort(expose)
+		natPort, err := nat.NewPort(proto, port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expose %s: %w", expose, err)
+		}
+		exposed[natPort] = struct{}{}
+	}
+	if len(exposed) > 0 {
+		config.ExposedPorts = exposed
+	}
+	if len(bindings) > 0 {
+		hostConfig.PortBindings = bindings
+	}
+
+	for _, volume := range service.Volumes {
+		m := mount.Mount{Target: volume.Target, ReadOnly: volume.ReadOnly}
+		switch volume.Type {
+		case "bind":
+			m.Type = mount.TypeBind
+			m.Source = volume.Source
+		case "tmpfs":
+			m.Type = mount.TypeTmpfs
+		default:
+			m.Type = mount.TypeVolume
+			if volume.Source != "" {
+				m.Source = p.VolumeName(volume.Source)
+			}
+		}
+		hostConfig.Mounts = append(hostConfig.Mounts, m)
+	}
+
+	spec := &containerSpec{Spec: containers.Spec{Name: p.ContainerName(service, number), Config: config, HostConfig: hostConfig}}
+	switch {
+	case strings.HasPrefix(service.NetworkMode, "service:"):
+		target := p.Services[strings.TrimPrefix(service.NetworkMode, "service:")]
+		hostConfig.NetworkMode = container.NetworkMode("container:" + p.ContainerName(target, 1))
+	case service.NetworkMode != "":
+		hostConfig.NetworkMode = container.NetworkMode(service.NetworkMode)
+	default:
+		for _, key := range sortedKeys(service.Networks) {
+			settings := &network.EndpointSettings{Aliases: []string{service.Name}}
+			if attachment := service.Networks[key]; attachment != nil {
+				settings.Aliases = append(settings.Aliases, attachment.Aliases...)
+				if attachment.IPv4Address != "" || attachment.IPv6Address != "" {
+					settings.IPAMConfig = &network.EndpointIPAMConfig{
+						IPv4Address: attachment.IPv4Address,
+						IPv6Address: attachment.IPv6Address,
+					}
+				}
+			}
+			spec.Networks = append(spec.Networks, containers.NetworkAttachment{Name: p.NetworkName(key), Settings: settings})
+		}
+		if len(spec.Networks) > 0 {
+			hostConfig.NetworkMode = container.NetworkMode(spec.Networks[0].Name)
+		}
+	}
+
+	hash, err := specHash(spec)
+	if err != nil {
+		return nil, err
+	}
+	spec.Hash = hash
+	labels[LabelConfigHash] = hash
+	return spec, nil
+}
+
+// specHash hashes a spec before the hash label is added
+func specHash(spec *containerSpec) (string, error) {
+	data, err := json.Marshal(struct {
+		Name       string
+		Config     *container.Config
+		HostConfig *container.HostConfig
+		Networks   []containers.NetworkAttachment
+	}{spec.Name, spec.Config, spec.HostConfig, spec.Networks})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func healthConfig(check *Healthcheck) (*container.HealthConfig, error) {
+	if check.Disable {
+		return &container.HealthConfig{Test: []string{"NONE"}}, nil
+	}
+	health := &container.HealthConfig{Retries: check.Retries}
+	switch {
+	case len(check.Test) == 0:
+	case check.Test[0] == "CMD" || check.Test[0] == "CMD-SHELL" || check.Test[0] == "NONE":
+		health.Test = check.Test
+	case len(check.Test) == 1:
+		// A plain string test runs through the shell
+		health.Test = []string{"CMD-SHELL", check.Test[0]}
+	default:
+		return nil, fmt.Errorf("healthcheck test must start with CMD, CMD-SHELL or NONE")
+	}
+	for _, field := range []struct {
+		value  string
+		target *time.Duration
+		name   string
+	}{
+		{check.Interval, &health.Interval, "interval"},
+		{check.Timeout, &health.Timeout, "timeout"},
+		{check.StartPeriod, &health.StartPeriod, "start_period"},
+	} {
+		if field.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid healthcheck %s: %w", field.name, err)
+		}
+		*field.target = duration
+	}
+	return health, nil
+}
+
+// restartPolicy parses "no", "always", "unless-stopped" and
+// "on-failure[:max-retries]"
+func restartPolicy(policy string) (container.RestartPolicy, error) {
+	name, retries, hasRetries := strings.Cut(policy, ":")
+	switch name {
+	case "", "no":
+		return container.RestartPolicy{Name: container.RestartPolicyDisabled}, nil
+	case "always", "unless-stopped":
+		if !hasRetries {
+			return container.RestartPolicy{Name: container.RestartPolicyMode(name)}, nil
+		}
+	case "on-failure":
+		result := container.RestartPolicy{Name: container.RestartPolicyOnFailure}
+		if hasRetries {
+			count, err := strconv.Atoi(retries)
+			if err != nil {
+				return result, fmt.Errorf("invalid restart policy %s", policy)
+			}
+			result.MaximumRetryCount = count
+		}
+		return result, nil
+	}
+	return container.RestartPolicy{}, fmt.Errorf("invalid restart policy %s", policy)
+}
+
+// sortedServices returns the services in dependency order
+func (p *Project) sortedServices() []*Service {
+	services := make([]*Service, 0, len(p.Order))
+	for _, name := range p.Order {
+		services = append(services, p.Services[name])
+	}
+	return services
+}
+
+// Images lists the distinct images of the project
+func (p *Project) Images() []string {
+	seen := map[string]bool{}
+	var images []string
+	for _, service := range p.Services {
+		if !seen[service.Image] {
+			seen[service.Image] = true
+			images = append(images, service.Image)
+		}
+	}
+	sort.Strings(images)
+	return images
+}
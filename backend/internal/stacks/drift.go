@@ -0,0 +1,366 @@
+package stacks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/genc-murat/harborview/internal/docker"
+)
+
+// Service drift states
+const (
+	DriftInSync  = "in sync"
+	DriftChanged = "drifted"
+	DriftMissing = "missing"
+)
+
+// Difference is one setting of a container that does not match the stack
+// definition. Key names the entry within Field, such as an environment
+// variable or a mount target; a nil Expected or Actual means absent.
+// Container is empty for differences of the service as a whole.
+type Difference struct {
+	Container string  `json:"container,omitempty"`
+	Field     string  `json:"field"`
+	Key       string  `json:"key,omitempty"`
+	Expected  *string `json:"expected"`
+	Actual    *string `json:"actual"`
+}
+
+// ServiceDrift compares one service with its containers
+type ServiceDrift struct {
+	Service     string       `json:"service"`
+	Status      string       `json:"status"`
+	Containers  []string     `json:"containers"`
+	Differences []Difference `json:"differences"`
+}
+
+// DriftReport compares a deployed stack with its stored definition
+type DriftReport struct {
+	Stack    string         `json:"stack"`
+	InSync   bool           `json:"inSync"`
+	Services []ServiceDrift `json:"services"`
+	// Orphans are containers of services that are no longer defined
+	Orphans []string `json:"orphans,omitempty"`
+}
+
+// Drifted lists the services that do not match the definition
+func (r *DriftReport) Drifted() []string {
+	var drifted []string
+	for _, service := range r.Services {
+		if service.Status != DriftInSync {
+			drifted = append(drifted, service.Service)
+		}
+	}
+	return drifted
+}
+
+// changed names the containers whose settings differ from the definition
+func (r *DriftReport) changed() map[string]bool {
+	changed := map[string]bool{}
+	for _, service := range r.Services {
+		for _, difference := range service.Differences {
+			if difference.Field != "replicas" && difference.Container != "" {
+				changed[difference.Container] = true
+			}
+		}
+	}
+	return changed
+}
+
+// ReconcileResult is the drift found and what was done about it
+type ReconcileResult struct {
+	Drift *DriftReport `json:"drift"`
+	Up    *UpResult    `json:"up,omitempty"`
+}
+
+// Diff compares the containers of a stack with its stored definition:
+// image, environment, ports, mounts, labels, and the replica count against
+// the running containers. A container
+// whose config hash no longer matches but shows none of those differences
+// was changed in a setting that is not compared, such as its command.
+func (s *stackService) Diff(ctx context.Context, name string) (*DriftReport, error) {
+	project, err := s.project(name)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	return s.diff(ctx, project)
+}
+
+func (s *stackService) diff(ctx context.Context, project *Project) (*DriftReport, error) {
+	members, err := s.projectContainers(ctx, project.Name)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string][]types.Container{}
+	for _, c := range members {
+		service := c.Labels[LabelService]
+		current[service] = append(current[service], c)
+	}
+
+	report := &DriftReport{Stack: project.Name, InSync: true, Services: []ServiceDrift{}}
+	for _, service := range project.sortedServices() {
+		drift, err := s.serviceDrift(ctx, project, service, current[service.Name])
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", service.Name, err)
+		}
+		if drift.Status != DriftInSync {
+			report.InSync = false
+		}
+		report.Services = append(report.Services, *drift)
+	}
+	for _, serviceName := range sortedKeys(current) {
+		if _, defined := project.Services[serviceName]; defined {
+			continue
+		}
+		for _, orphan := range current[serviceName] {
+			report.Orphans = append(report.Orphans, containerName(orphan))
+		}
+		report.InSync = false
+	}
+	return report, nil
+}
+
+func (s *stackService) serviceDrift(ctx context.Context, project *Project, service *Service, current []types.Container) (*ServiceDrift, error) {
+	drift := &ServiceDrift{Service: service.Name, Status: DriftInSync, Containers: []string{}, Differences: []Difference{}}
+	running := 0
+	for _, c := range current {
+		drift.Containers = append(drift.Containers, containerName(c))
+		if c.State == "running" {
+			running++
+		}
+	}
+	if len(current) == 0 && service.Replicas() > 0 {
+		drift.Status = DriftMissing
+		return drift, nil
+	}
+	if running != service.Replicas() {
+		drift.Differences = append(drift.Differences, difference("replicas", "", strconv.Itoa(service.Replicas()), strconv.Itoa(running)))
+	}
+
+	for _, c := range current {
+		// Containers past the replica count are extra, whatever their config
+		number, err := strconv.Atoi(c.Labels[LabelNumber])
+		if err != nil || number < 1 || number > service.Replicas() {
+			state := c.State
+			drift.Differences = append(drift.Differences, Difference{Container: containerName(c), Field: "replicas", Actual: &state})
+			continue
+		}
+		spec, err := project.containerSpec(service, number)
+		if err != nil {
+			return nil, err
+		}
+		differences, err := s.containerDrift(ctx, spec, c.ID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range differences {
+			differences[i].Container = containerName(c)
+		}
+		drift.Differences = append(drift.Differences, differences...)
+	}
+	if len(drift.Differences) > 0 {
+		drift.Status = DriftChanged
+	}
+	return drift, nil
+}
+
+// containerDrift compares one container with spec
+func (s *stackService) containerDrift(ctx context.Context, spec *containerSpec, id string) ([]Difference, error) {
+	inspect, err := s.cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", id, err)
+	}
+	// Settings the container inherited from its image are not drift
+	inherited := map[string]string{}
+	inheritedLabels := map[string]string{}
+	if imageInspect, _, err := s.cli.ImageInspectWithRaw(ctx, inspect.Image); err == nil && imageInspect.Config != nil {
+		inherited = envMap(imageInspect.Config.Env)
+		inheritedLabels = imageInspect.Config.Labels
+	}
+
+	var differences []Difference
+	if inspect.Config.Image != spec.Config.Image {
+		differences = append(differences, difference("image", "", spec.Config.Image, inspect.Config.Image))
+	} else {
+		expected, _, err := s.cli.ImageInspectWithRaw(ctx, spec.Config.Image)
+		switch {
+		case client.IsErrNotFound(err):
+			// Not pulled yet; up will pull it
+		case err != nil:
+			return nil, fmt.Errorf("failed to inspect image: %w", err)
+		case expected.ID != inspect.Image:
+			differences = append(differences, difference("image", "digest", expected.ID, inspect.Image))
+		}
+	}
+
+	differences = append(differences, diffMaps("env", envMap(spec.Config.Env), envMap(inspect.Config.Env), inherited)...)
+	differences = append(differences, diffMaps("ports", portMap(spec.HostConfig.PortBindings), portMap(inspect.HostConfig.PortBindings), nil)...)
+	differences = append(differences, diffMaps("mounts", specMounts(spec.HostConfig.Mounts), containerMounts(inspect.Mounts, spec.HostConfig.Mounts), nil)...)
+	differences = append(differences, diffMaps("labels", userLabels(spec.Config.Labels), userLabels(inspect.Config.Labels), inheritedLabels)...)
+
+	if hash := inspect.Config.Labels[LabelConfigHash]; len(differences) == 0 && hash != spec.Hash {
+		differences = append(differences, difference("config", "", spec.Hash, hash))
+	}
+	return differences, nil
+}
+
+// difference records expected and actual values that are both present
+func difference(field, key, expected, actual string) Difference {
+	return Difference{Field: field, Key: key, Expected: &expected, Actual: &actual}
+}
+
+// diffMaps compares expected and actual entries. Actual entries that are
+// not expected are ignored when inherited has the same value.
+func diffMaps(field string, expected, actual, inherited map[string]string) []Difference {
+	keys := map[string]bool{}
+	for key := range expected {
+		keys[key] = true
+	}
+	for key := range actual {
+		keys[key] = true
+	}
+
+	var differences []Difference
+	for _, key := range sortedKeys(keys) {
+		want, wanted := expected[key]
+		got, present := actual[key]
+		switch {
+		case wanted && present && want == got:
+		case !wanted && present:
+			if value, ok := inherited[key]; ok && value == got {
+				continue
+			}
+			differences = append(differences, Difference{Field: field, Key: key, Actual: &got})
+		case wanted && !present:
+			differences = append(differences, Difference{Field: field, Key: key, Expected: &want})
+		default:
+			differences = append(differences, difference(field, key, want, got))
+		}
+	}
+	return differences
+}
+
+func envMap(env []string) map[string]string {
+	values := make(map[string]string, len(env))
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		values[key] = value
+	}
+	return values
+}
+
+// portMap describes port bindings by container port
+func portMap(bindings nat.PortMap) map[string]string {
+	ports := make(map[string]string, len(bindings))
+	for port, hosts := range bindings {
+		published := make([]string, 0, len(hosts))
+		for _, host := range hosts {
+			published = append(published, strings.TrimPrefix(host.HostIP+":"+host.HostPort, ":"))
+		}
+		sort.Strings(published)
+		ports[string(port)] = strings.Join(published, ",")
+	}
+	return ports
+}
+
+// specMounts describes the mounts of a spec by target
+func specMounts(mounts []mount.Mount) map[string]string {
+	described := make(map[string]string, len(mounts))
+	for _, m := range mounts {
+		described[m.Target] = describeMount(string(m.Type), m.Source, !m.ReadOnly)
+	}
+	return described
+}
+
+// containerMounts describes a container's mounts by target. Anonymous
+// volumes get a generated name, so the name is left out where the spec
+// has an anonymous volume.
+func containerMounts(points []types.MountPoint, expected []mount.Mount) map[string]string {
+	anonymous := map[string]bool{}
+	for _, m := range expected {
+		if m.Type == mount.TypeVolume && m.Source == "" {
+			anonymous[m.Target] = true
+		}
+	}
+	described := make(map[string]string, len(points))
+	for _, point := range points {
+		source := point.Source
+		if point.Type == mount.TypeVolume {
+			source = point.Name
+			if anonymous[point.Destination] {
+				source = ""
+			}
+		}
+		if point.Type == mount.TypeTmpfs {
+			source = ""
+		}
+		described[point.Destination] = describeMount(string(point.Type), source, point.RW)
+	}
+	return described
+}
+
+func describeMount(kind, source string, rw bool) string {
+	description := kind
+	if source != "" {
+		description += " " + source
+	}
+	if !rw {
+		description += " (read-only)"
+	}
+	return description
+}
+
+// userLabels drops the compose labels, which are bookkeeping rather than
+// configuration; the config hash is compared on its own
+func userLabels(labels map[string]string) map[string]string {
+	user := make(map[string]string, len(labels))
+	for key, value := range labels {
+		if !strings.HasPrefix(key, "com.docker.compose.") {
+			user[key] = value
+		}
+	}
+	return user
+}
+
+// Reconcile acts on the drift Diff finds rather than running a full up:
+// only the drifted services are deployed. Their containers are recreated
+// when their settings differ, replicas are created, started or removed to match
+// the replica count, and services in sync are left alone. Orphans are
+// removed when options.RemoveOrphans is set.
+func (s *stackService) Reconcile(ctx context.Context, name string, options UpOptions) (*ReconcileResult, error) {
+	project, err := s.project(name)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDeploy)
+	defer cancel()
+
+	report, err := s.diff(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	result := &ReconcileResult{Drift: report}
+	if report.InSync {
+		return result, nil
+	}
+
+	scope := map[string]bool{}
+	for _, service := range report.Drifted() {
+		scope[service] = true
+	}
+	options.ForceRecreate = false
+	if result.Up, err = s.up(ctx, project, options, scope, report.changed()); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,119 @@
+package stacks
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// services lists the status of every service of a drift report
+func services(report *DriftReport) []string {
+	var list []string
+	for _, service := range report.Services {
+		list = append(list, service.Service+" "+service.Status)
+	}
+	return list
+}
+
+func TestDiff(t *testing.T) {
+	service, cli := newShop(t)
+	ctx := context.Background()
+	if _, err := service.Up(ctx, "shop", UpOptions{}); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	report, err := service.Diff(ctx, "shop")
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if !report.InSync || len(report.Drifted()) != 0 {
+		t.Fatalf("a fresh deployment drifted: %+v", report.Services)
+	}
+
+	web := cli.find("shop-web-1")
+	web.config.Env = append(web.config.Env, "DEBUG=1")
+	cli.find("shop-db-1").state = "exited"
+	cli.add("shop", "worker", "shop-worker-1")
+	report, err = service.Diff(ctx, "shop")
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if got, want := services(report), []string{"db drifted", "web drifted"}; report.InSync || !slices.Equal(got, want) {
+		t.Errorf("services = %v, want %v", got, want)
+	}
+	db := report.Services[0].Differences
+	if len(db) != 1 || db[0].Field != "replicas" || *db[0].Expected != "1" || *db[0].Actual != "0" {
+		t.Errorf("db differences = %+v, want 1 replica expected and none running", db)
+	}
+	env := report.Services[1].Differences
+	if len(env) != 1 || env[0].Container != "shop-web-1" || env[0].Field != "env" || env[0].Key != "DEBUG" || env[0].Expected != nil || *env[0].Actual != "1" {
+		t.Errorf("web differences = %+v, want an unexpected DEBUG", env)
+	}
+	if !slices.Equal(report.Orphans, []string{"shop-worker-1"}) {
+		t.Errorf("orphans = %v", report.Orphans)
+	}
+
+	// Replicas past the count are reported by container
+	scaled := strings.Replace(shopCompose, "    image: nginx\n", "    image: nginx\n    scale: 0\n", 1)
+	if _, err := service.UpdateStack(ctx, Stack{Name: "shop", Compose: scaled}); err != nil {
+		t.Fatalf("UpdateStack: %v", err)
+	}
+	report, err = service.Diff(ctx, "shop")
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	extra := report.Services[1].Differences
+	if len(extra) != 2 || extra[1].Container != "shop-web-1" || extra[1].Expected != nil || *extra[1].Actual != "running" {
+		t.Errorf("web differences = %+v, want the replica count and shop-web-1 as extra", extra)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	service, cli := newShop(t)
+	ctx := context.Background()
+	if _, err := service.Up(ctx, "shop", UpOptions{}); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	result, err := service.Reconcile(ctx, "shop", UpOptions{})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if !result.Drift.InSync || result.Up != nil {
+		t.Errorf("reconcile of a stack in sync deployed %+v", result.Up)
+	}
+
+	// A stopped service is started rather than recreated, and a changed
+	// container is recreated although its config hash still matches
+	cli.find("shop-db-1").state = "exited"
+	web := cli.find("shop-web-1")
+	web.config.Env = append(web.config.Env, "DEBUG=1")
+	result, err = service.Reconcile(ctx, "shop", UpOptions{})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if got, want := actions(result.Up), []string{"db started", "web recreated"}; !slices.Equal(got, want) {
+		t.Errorf("reconcile = %v, want %v", got, want)
+	}
+	if report, err := service.Diff(ctx, "shop"); err != nil || !report.InSync {
+		t.Errorf("stack after reconcile: %+v, %v", report, err)
+	}
+
+	// Only drifted services are deployed
+	scaled := strings.Replace(shopCompose, "    image: nginx\n", "    image: nginx\n    deploy:\n      replicas: 2\n", 1)
+	if _, err := service.UpdateStack(ctx, Stack{Name: "shop", Compose: scaled}); err != nil {
+		t.Fatalf("UpdateStack: %v", err)
+	}
+	cli.calls = nil
+	result, err = service.Reconcile(ctx, "shop", UpOptions{})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if got, want := actions(result.Up), []string{"web unchanged", "web created"}; !slices.Equal(got, want) {
+		t.Errorf("reconcile of a scaled service = %v, want %v", got, want)
+	}
+	if want := []string{"create shop-web-2", "start shop-web-2"}; !slices.Equal(cli.calls, want) {
+		t.Errorf("calls = %v, want %v", cli.calls, want)
+	}
+}
@@ -0,0 +1,246 @@
+package stacks
+
+import (
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/httputil"
+	"github.com/genc-murat/harborview/internal/signatures"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterRoutes registers all routes for stacks on the shared client
+func RegisterRoutes(app *fiber.App, cfg config.Config, cli *client.Client) error {
+	// Initialize the service
+	service, err := NewStackService(cfg, cli)
+	if err != nil {
+		return err
+	}
+
+	MountRoutes(app.Group("/stacks"), func(*fiber.Ctx) (StackService, error) {
+		return service, nil
+	})
+	return nil
+}
+
+// MountRoutes registers the stack routes on stacksGroup. lookup picks the
+// service each request runs against; a *fiber.Error from it sets the status.
+func MountRoutes(stacksGroup fiber.Router, lookup func(c *fiber.Ctx) (StackService, error)) {
+	handle := httputil.Bind(lookup)
+
+	// List stored stacks and compose projects with their status
+	stacksGroup.Get("/", handle(ListStacks))
+
+	// Store a new stack, deploying it with ?deploy=true
+	stacksGroup.Post("/", handle(CreateStack))
+
+	// Get a stack with its definition and containers
+	stacksGroup.Get("/:name", handle(GetStack))
+
+	// Replace a stack's definition, redeploying it with ?deploy=true
+	stacksGroup.Put("/:name", handle(UpdateStack))
+
+	// Delete a stored stack, taking it down first with ?down=true
+	stacksGroup.Delete("/:name", handle(DeleteStack))
+
+	// Deploy a stack
+	stacksGroup.Post("/:name/up", handle(UpStack))
+
+	// Remove a stack's containers and networks
+	stacksGroup.Post("/:name/down", handle(DownStack))
+
+	// Restart a stack's containers
+	stacksGroup.Post("/:name/restart", handle(RestartStack))
+
+	// Pull a stack's images
+	stacksGroup.Post("/:name/pull", handle(PullStack))
+
+	// Get the aggregated logs of a stack
+	stacksGroup.Get("/:name/logs", handle(StackLogs))
+
+	// Compare a stack's containers with its stored definition
+	stacksGroup.Get("/:name/drift", handle(StackDrift))
+
+	// Recreate the services that drifted from the stored definition
+	stacksGroup.Post("/:name/reconcile", handle(ReconcileStack))
+}
+
+// stackError maps errors to statuses, so an invalid compose file is 400, a
+// missing stack 404 and an image rejected by the signing policy 403
+func stackError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errdefs.IsInvalidParameter(err):
+		status = fiber.StatusBadRequest
+	case errdefs.IsNotFound(err):
+		status = fiber.StatusNotFound
+	case errdefs.IsConflict(err):
+		status = fiber.StatusConflict
+	case errdefs.IsForbidden(err), signatures.IsPolicyViolation(err):
+		status = fiber.StatusForbidden
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
+
+// upOptions reads the ?pull=, ?forceRecreate= and ?removeOrphans= flags
+func upOptions(c *fiber.Ctx) UpOptions {
+	return UpOptions{
+		Pull:          c.QueryBool("pull", false),
+		ForceRecreate: c.QueryBool("forceRecreate", false),
+		RemoveOrphans: c.QueryBool("removeOrphans", false),
+	}
+}
+
+// ListStacks handler for listing stacks
+func ListStacks(c *fiber.Ctx, service StackService) error {
+	stacks, err := service.ListStacks(c.UserContext())
+	if err != nil {
+		return stackError(c, err)
+	}
+	return c.JSON(stacks)
+}
+
+// GetStack handler for fetching a stack
+func GetStack(c *fiber.Ctx, service StackService) error {
+	stack, err := service.GetStack(c.UserContext(), c.Params("name"))
+	if err != nil {
+		return stackError(c, err)
+	}
+	return c.JSON(stack)
+}
+
+// CreateStack handler for storing a stack given as {name, compose, env,
+// envFiles}. ?deploy=true also brings it up, taking the up flags.
+func CreateStack(c *fiber.Ctx, service StackService) error {
+	var stack Stack
+	if err := c.BodyParser(&stack); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	warnings, err := service.CreateStack(c.UserContext(), stack)
+	if err != nil {
+		return stackError(c, err)
+	}
+	return saved(c, service, stack.Name, warnings, fiber.StatusCreated)
+}
+
+// UpdateStack handler for replacing the definition of the :name stack.
+// ?deploy=true also brings it up, taking the up flags.
+func UpdateStack(c *fiber.Ctx, service StackService) error {
+	var stack Stack
+	if err := c.BodyParser(&stack); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	stack.Name = c.Params("name")
+	warnings, err := service.UpdateStack(c.UserContext(), stack)
+	if err != nil {
+		return stackError(c, err)
+	}
+	return saved(c, service, stack.Name, warnings, fiber.StatusOK)
+}
+
+// saved answers a stored stack, deploying it first when asked
+func saved(c *fiber.Ctx, service StackService, name string, warnings []string, status int) error {
+	if !c.QueryBool("deploy", false) {
+		return c.Status(status).JSON(fiber.Map{"name": name, "warnings": warnings})
+	}
+	result, err := service.Up(c.UserContext(), name, upOptions(c))
+	if err != nil {
+		return stackError(c, err)
+	}
+	return c.Status(status).JSON(result)
+}
+
+// DeleteStack handler for deleting a stored stack. ?down=true removes its
+// containers and networks first, and ?volumes=true its volumes too.
+func DeleteStack(c *fiber.Ctx, service StackService) error {
+	name := c.Params("name")
+	if c.QueryBool("down", false) {
+		if _, err := service.Down(c.UserContext(), name, DownOptions{Volumes: c.QueryBool("volumes", false)}); err != nil {
+			return stackError(c, err)
+		}
+	}
+	if err := service.DeleteStack(c.UserContext(), name); err != nil {
+		return stackError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Stack deleted successfully"})
+}
+
+// UpStack handler for deploying a stack. ?pull=true pulls every image,
+// ?forceRecreate=true recreates unchanged containers and
+// ?removeOrphans=true removes containers of services no longer defined.
+func UpStack(c *fiber.Ctx, service StackService) error {
+	result, err := service.Up(c.UserContext(), c.Params("name"), upOptions(c))
+	if err != nil {
+		return stackError(c, err)
+	}
+	return c.JSON(result)
+}
+
+// DownStack handler for taking a stack down; ?volumes=true also removes
+// its volumes
+func DownStack(c *fiber.Ctx, service StackService) error {
+	result, err := service.Down(c.UserContext(), c.Params("name"), DownOptions{Volumes: c.QueryBool("volumes", false)})
+	if err != nil {
+		return stackError(c, err)
+	}
+	return c.JSON(result)
+}
+
+// RestartStack handler for restarting a stack's containers
+func RestartStack(c *fiber.Ctx, service StackService) error {
+	restarted, err := service.Restart(c.UserContext(), c.Params("name"))
+	if err != nil {
+		return stackError(c, err)
+	}
+	return c.JSON(fiber.Map{"containers": restarted})
+}
+
+// PullStack handler for pulling a stack's images
+func PullStack(c *fiber.Ctx, service StackService) error {
+	images, err := service.Pull(c.UserContext(), c.Params("name"))
+	if err != nil {
+		return stackError(c, err)
+	}
+	return c.JSON(fiber.Map{"images": images})
+}
+
+// StackLogs handler for streaming a stack's logs as plain text. ?service=
+// limits them to one service; ?tail=, ?since=, ?follow= and ?timestamps=
+// work as for docker compose logs.
+func StackLogs(c *fiber.Ctx, service StackService) error {
+	// Followed logs go on after the handler returns and UserContext is
+	// cancelled, so they run on the request's own context
+	logs, err := service.Logs(c.Context(), c.Params("name"), LogOptions{
+		Service:    c.Query("service"),
+		Tail:       c.Query("tail", "all"),
+		Since:      c.Query("since"),
+		Follow:     c.QueryBool("follow", false),
+		Timestamps: c.QueryBool("timestamps", false),
+	})
+	if err != nil {
+		return stackError(c, err)
+	}
+
+	c.Type("txt", "utf-8")
+	return c.SendStream(logs)
+}
+
+// StackDrift handler for reporting how a stack's containers differ from
+// its stored definition
+func StackDrift(c *fiber.Ctx, service StackService) error {
+	report, err := service.Diff(c.UserContext(), c.Params("name"))
+	if err != nil {
+		return stackError(c, err)
+	}
+	return c.JSON(report)
+}
+
+// ReconcileStack handler for recreating only the drifted services of a
+// stack. ?pull=true and ?removeOrphans=true work as for up.
+func ReconcileStack(c *fiber.Ctx, service StackService) error {
+	result, err := service.Reconcile(c.UserContext(), c.Params("name"), upOptions(c))
+	if err != nil {
+		return stackError(c, err)
+	}
+	return c.JSON(result)
+}
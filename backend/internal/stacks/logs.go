@@ -0,0 +1,227 @@
+package stacks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/genc-murat/harborview/internal/docker"
+)
+
+// LogOptions select the logs of a stack
+type LogOptions struct {
+	// Service limits the logs to one service
+	Service string
+	// Tail is the number of lines per container, or "all"
+	Tail string
+	// Since is a timestamp or relative duration, as for docker logs
+	Since      string
+	Follow     bool
+	Timestamps bool
+}
+
+// logLine is one line of a container's output
+type logLine struct {
+	time time.Time
+	text string
+}
+
+// Logs aggregates the logs of a stack's containers. Every line is prefixed
+// with its container name, like docker compose logs. Without follow the
+// lines are merged in timestamp order; with follow they are interleaved as
+// they arrive until the reader is closed.
+func (s *stackService) Logs(ctx context.Context, name string, options LogOptions) (io.ReadCloser, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpLogs)
+
+	members, err := s.projectContainers(ctx, name)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	width := 0
+	var selected []string
+	for _, c := range members {
+		if options.Service != "" && c.Labels[LabelService] != options.Service {
+			continue
+		}
+		selected = append(selected, c.ID)
+		width = max(width, len(containerName(c)))
+	}
+	if len(selected) == 0 {
+		cancel()
+		if options.Service != "" {
+			return nil, errdefs.NotFound(fmt.Errorf("service %s of stack %s has no containers", options.Service, name))
+		}
+		return nil, errdefs.NotFound(fmt.Errorf("stack %s has no containers", name))
+	}
+
+	streams := make([]*containerLog, 0, len(selected))
+	for _, id := range selected {
+		stream, err := s.openLog(ctx, id, width, options)
+		if err != nil {
+			for _, opened := range streams {
+				opened.body.Close()
+			}
+			cancel()
+			return nil, err
+		}
+		streams = append(streams, stream)
+	}
+
+	if !options.Follow {
+		defer cancel()
+		return mergeLogs(streams, options.Timestamps)
+	}
+
+	reader, writer := io.Pipe()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, stream := range streams {
+		wg.Add(1)
+		go func(stream *containerLog) {
+			defer wg.Done()
+			defer stream.body.Close()
+			stream.copy(func(line logLine) error {
+				mu.Lock()
+				defer mu.Unlock()
+				_, err := io.WriteString(writer, stream.format(line, options.Timestamps))
+				return err
+			})
+		}(stream)
+	}
+	go func() {
+		wg.Wait()
+		writer.Close()
+	}()
+	return docker.CancelOnClose(reader, cancel), nil
+}
+
+// containerLog is the log stream of one container
+type containerLog struct {
+	prefix string
+	tty    bool
+	body   io.ReadCloser
+}
+
+func (s *stackService) openLog(ctx context.Context, id string, width int, options LogOptions) (*containerLog, error) {
+	inspect, err := s.cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", id, err)
+	}
+	body, err := s.cli.ContainerLogs(ctx, id, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     options.Follow,
+		Tail:       options.Tail,
+		Since:      options.Since,
+		// Always requested so lines can be merged in order
+		Timestamps: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch logs for container %s: %w", id, err)
+	}
+	name := strings.TrimPrefix(inspect.Name, "/")
+	return &containerLog{
+		prefix: fmt.Sprintf("%-*s | ", width, name),
+		tty:    inspect.Config != nil && inspect.Config.Tty,
+		body:   body,
+	}, nil
+}
+
+// copy passes every line of the stream to emit until the stream ends or
+// emit fails
+func (l *containerLog) copy(emit func(logLine) error) error {
+	lines := &lineWriter{emit: emit}
+	var err error
+	if l.tty {
+		_, err = io.Copy(lines, l.body)
+	} else {
+		_, err = stdcopy.StdCopy(lines, lines, l.body)
+	}
+	if flushErr := lines.flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func (l *containerLog) format(line logLine, timestamps bool) string {
+	if timestamps && !line.time.IsZero() {
+		return l.prefix + line.time.Format(time.RFC3339Nano) + " " + line.text + "\n"
+	}
+	return l.prefix + line.text + "\n"
+}
+
+// mergeLogs reads every stream to the end and orders the lines by time
+func mergeLogs(streams []*containerLog, timestamps bool) (io.ReadCloser, error) {
+	type entry struct {
+		line   logLine
+		stream *containerLog
+	}
+	var entries []entry
+	for _, stream := range streams {
+		err := stream.copy(func(line logLine) error {
+			entries = append(entries, entry{line, stream})
+			return nil
+		})
+		stream.body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read logs: %w", err)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].line.time.Before(entries[j].line.time) })
+
+	var out bytes.Buffer
+	for _, e := range entries {
+		out.WriteString(e.stream.format(e.line, timestamps))
+	}
+	return io.NopCloser(&out), nil
+}
+
+// lineWriter splits written output into lines and parses the timestamp
+// Docker puts in front of each
+type lineWriter struct {
+	emit func(logLine) error
+	buf  []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := strings.TrimSuffix(string(w.buf[:i]), "\r")
+		w.buf = w.buf[i+1:]
+		if err := w.emit(parseLogLine(line)); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (w *lineWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := string(w.buf)
+	w.buf = nil
+	return w.emit(parseLogLine(line))
+}
+
+func parseLogLine(line string) logLine {
+	stamp, text, ok := strings.Cut(line, " ")
+	if ok {
+		if t, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+			return logLine{time: t, text: text}
+		}
+	}
+	return logLine{text: line}
+}
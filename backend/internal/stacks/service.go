@@ -0,0 +1,869 @@
+// Package stacks deploys compose projects through the Docker API. Stack
+// definitions are stored so they can be redeployed, and containers are
+// grouped by the compose project label, so projects started with the
+// compose CLI show up too.
+package stacks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/containers"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/internal/registry"
+	"github.com/genc-murat/harborview/internal/signatures"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// Stack states
+const (
+	StatusRunning     = "running"
+	StatusPartial     = "partial"
+	StatusStopped     = "stopped"
+	StatusNotDeployed = "not deployed"
+)
+
+// Up actions per service
+const (
+	ActionCreated   = "created"
+	ActionRecreated = "recreated"
+	ActionStarted   = "started"
+	ActionUnchanged = "unchanged"
+	ActionRemoved   = "removed"
+)
+
+// StackService interface for dependency injection
+type StackService interface {
+	ListStacks(ctx context.Context) ([]Summary, error)
+	GetStack(ctx context.Context, name string) (*Details, error)
+	CreateStack(ctx context.Context, stack Stack) ([]string, error)
+	UpdateStack(ctx context.Context, stack Stack) ([]string, error)
+	DeleteStack(ctx context.Context, name string) error
+	Up(ctx context.Context, name string, options UpOptions) (*UpResult, error)
+	Down(ctx context.Context, name string, options DownOptions) (*DownResult, error)
+	Restart(ctx context.Context, name string) ([]string, error)
+	Pull(ctx context.Context, name string) ([]string, error)
+	Logs(ctx context.Context, name string, options LogOptions) (io.ReadCloser, error)
+	Diff(ctx context.Context, name string) (*DriftReport, error)
+	Reconcile(ctx context.Context, name string, options UpOptions) (*ReconcileResult, error)
+}
+
+// StackClient is the part of the Docker client the stack service needs
+type StackClient interface {
+	containers.ReplaceClient
+	signatures.ImagePuller
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error)
+	ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error)
+	ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error
+	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+	NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error)
+	NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error)
+	NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error)
+	NetworkRemove(ctx context.Context, networkID string) error
+	VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error)
+	VolumeInspect(ctx context.Context, volumeID string) (volume.Volume, error)
+	VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error)
+	VolumeRemove(ctx context.Context, volumeID string, force bool) error
+}
+
+type stackService struct {
+	cli      StackClient
+	store    *stackStore
+	verifier *signatures.Verifier
+	timeouts docker.Timeouts
+}
+
+// Summary is a stack in a listing. Stored stacks that are not deployed and
+// compose projects without a stored definition are both listed.
+type Summary struct {
+	Name      string     `json:"name"`
+	Stored    bool       `json:"stored"`
+	Status    string     `json:"status"`
+	Services  []string   `json:"services"`
+	Running   int        `json:"running"`
+	Total     int        `json:"total"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// ContainerStatus is one container of a stack
+type ContainerStatus struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	Image   string `json:"image"`
+	State   string `json:"state"`
+	Status  string `json:"status"`
+}
+
+// Details is a stack with its definition and containers
+type Details struct {
+	Summary
+	Definition *Stack            `json:"definition,omitempty"`
+	Warnings   []string          `json:"warnings,omitempty"`
+	Containers []ContainerStatus `json:"containers"`
+}
+
+// UpOptions control a deployment
+type UpOptions struct {
+	// Pull pulls every image first instead of only missing ones
+	Pull bool
+	// ForceRecreate recreates containers even when nothing changed
+	ForceRecreate bool
+	// RemoveOrphans removes containers of services no longer defined
+	RemoveOrphans bool
+}
+
+// ServiceResult is what up did to one container of a service
+type ServiceResult struct {
+	Service   string `json:"service"`
+	Container string `json:"container"`
+	Action    string `json:"action"`
+}
+
+// UpResult describes a deployment
+type UpResult struct {
+	Stack    string          `json:"stack"`
+	Services []ServiceResult `json:"services"`
+	Orphans  []string        `json:"orphans,omitempty"`
+	Warnings []string        `json:"warnings,omitempty"`
+}
+
+// DownOptions control tearing a stack down
+type DownOptions struct {
+	// Volumes also removes the stack's volumes
+	Volumes bool
+}
+
+// DownResult lists what down removed
+type DownResult struct {
+	Containers []string `json:"containers"`
+	Networks   []string `json:"networks"`
+	Volumes    []string `json:"volumes,omitempty"`
+}
+
+// NewStackService creates a StackService for the daemon behind cli
+func NewStackService(cfg config.Config, cli StackClient) (StackService, error) {
+	return NewEndpointStackService(cfg, cli, "")
+}
+
+// NewEndpointStackService creates a StackService for the daemon of an
+// endpoint, with the stacks stored for that endpoint
+func NewEndpointStackService(cfg config.Config, cli StackClient, endpoint string) (StackService, error) {
+	verifier, err := signatures.NewVerifier(cfg, registry.NewResolver(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	return &stackService{
+		cli:      cli,
+		store:    sharedStore(cfg.Stacks.Dir, endpoint),
+		verifier: verifier,
+		timeouts: docker.NewTimeouts(cfg),
+	}, nil
+}
+
+// ListStacks lists stored stacks and the compose projects on the daemon
+func (s *stackService) ListStacks(ctx context.Context) ([]Summary, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	containers, err := s.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelProject)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	byProject := map[string][]types.Container{}
+	for _, c := range containers {
+		project := c.Labels[LabelProject]
+		byProject[project] = append(byProject[project], c)
+	}
+	summaries := map[string]*Summary{}
+	for _, stack := range s.store.list() {
+		summary := summarize(stack.Name, byProject[stack.Name])
+		summary.Stored = true
+		updatedAt := stack.UpdatedAt
+		summary.UpdatedAt = &updatedAt
+		if project, err := Parse(stack); err == nil {
+			summary.Services = project.Order
+		}
+		summaries[stack.Name] = &summary
+	}
+	for project, members := range byProject {
+		if _, ok := summaries[project]; !ok {
+			summary := summarize(project, members)
+			summaries[project] = &summary
+		}
+	}
+
+	result := make([]Summary, 0, len(summaries))
+	for _, name := range sortedKeys(summaries) {
+		result = append(result, *summaries[name])
+	}
+	return result, nil
+}
+
+// summarize counts a project's containers; Services comes from the
+// containers' service labels
+func summarize(name string, members []types.Container) Summary {
+	summary := Summary{Name: name, Total: len(members), Services: []string{}}
+	services := map[string]bool{}
+	for _, c := range members {
+		if c.State == "running" {
+			summary.Running++
+		}
+		services[c.Labels[LabelService]] = true
+	}
+	summary.Services = append(summary.Services, sortedKeys(services)...)
+	switch {
+	case summary.Total == 0:
+		summary.Status = StatusNotDeployed
+	case summary.Running == summary.Total:
+		summary.Status = StatusRunning
+	case summary.Running == 0:
+		summary.Status = StatusStopped
+	default:
+		summary.Status = StatusPartial
+	}
+	return summary
+}
+
+func (s *stackService) GetStack(ctx context.Context, name string) (*Details, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	members, err := s.projectContainers(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	stack, stored := s.store.get(name)
+	if !stored && len(members) == 0 {
+		return nil, errdefs.NotFound(fmt.Errorf("stack %s not found", name))
+	}
+
+	details := &Details{Summary: summarize(name, members), Containers: []ContainerStatus{}}
+	if stored {
+		details.Stored = true
+		details.UpdatedAt = &stack.UpdatedAt
+		details.Definition = &stack
+		if project, err := Parse(stack); err == nil {
+			details.Services = project.Order
+			details.Warnings = project.Warnings
+		} else {
+			details.Warnings = []string{err.Error()}
+		}
+	}
+	for _, c := range members {
+		details.Containers = append(details.Containers, ContainerStatus{
+			ID:      c.ID,
+			Name:    containerName(c),
+			Service: c.Labels[LabelService],
+			Image:   c.Image,
+			State:   c.State,
+			Status:  c.Status,
+		})
+	}
+	return details, nil
+}
+
+// CreateStack stores a new stack definition, returning the compose warnings
+func (s *stackService) CreateStack(ctx context.Context, stack Stack) ([]string, error) {
+	if _, exists := s.store.get(stack.Name); exists {
+		return nil, errdefs.Conflict(fmt.Errorf("stack %s already exists", stack.Name))
+	}
+	return s.saveStack(stack)
+}
+
+// UpdateStack replaces a stored stack definition. Running containers are
+// only changed by the next up.
+func (s *stackService) UpdateStack(ctx context.Context, stack Stack) ([]string, error) {
+	if _, exists := s.store.get(stack.Name); !exists {
+		return nil, errdefs.NotFound(fmt.Errorf("stack %s not found", stack.Name))
+	}
+	return s.saveStack(stack)
+}
+
+func (s *stackService) saveStack(stack Stack) ([]string, error) {
+	project, err := Parse(stack)
+	if err != nil {
+		return nil, errdefs.InvalidParameter(err)
+	}
+	stack.UpdatedAt = time.Now().UTC()
+	if err := s.store.save(stack); err != nil {
+		return nil, err
+	}
+	log.Printf("Stack %s saved", stack.Name)
+	return project.Warnings, nil
+}
+
+// DeleteStack removes a stored definition; deployed resources stay until
+// down is called
+func (s *stackService) DeleteStack(ctx context.Context, name string) error {
+	if err := s.store.remove(name); err != nil {
+		return err
+	}
+	log.Printf("Stack %s deleted", name)
+	return nil
+}
+
+// project parses the stored definition of a stack
+func (s *stackService) project(name string) (*Project, error) {
+	stack, ok := s.store.get(name)
+	if !ok {
+		return nil, errdefs.NotFound(fmt.Errorf("stack %s not found", name))
+	}
+	project, err := Parse(stack)
+	if err != nil {
+		return nil, errdefs.InvalidParameter(err)
+	}
+	return project, nil
+}
+
+// Up deploys a stack: networks and volumes are created when missing, then
+// services in dependency order, one container per replica. Containers whose
+// config and image are unchanged are kept, and only started when stopped.
+func (s *stackService) Up(ctx context.Context, name string, options UpOptions) (*UpResult, error) {
+	project, err := s.project(name)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDeploy)
+	defer cancel()
+
+	return s.up(ctx, project, options, nil, nil)
+}
+
+// up deploys the services of project in scope, or all of them when scope is
+// nil, recreating the containers named in recreate even when unchanged
+func (s *stackService) up(ctx context.Context, project *Project, options UpOptions, scope, recreate map[string]bool) (*UpResult, error) {
+	result := &UpResult{Stack: project.Name, Services: []ServiceResult{}, Warnings: project.Warnings}
+	if err := s.ensureNetworks(ctx, project); err != nil {
+		return nil, err
+	}
+	if err := s.ensureVolumes(ctx, project); err != nil {
+		return nil, err
+	}
+
+	members, err := s.projectContainers(ctx, project.Name)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string][]types.Container{}
+	for _, c := range members {
+		service := c.Labels[LabelService]
+		current[service] = append(current[service], c)
+	}
+
+	for _, service := range project.sortedServices() {
+		if scope != nil && !scope[service.Name] {
+			continue
+		}
+		if err := s.waitDependencies(ctx, project, service); err != nil {
+			return nil, fmt.Errorf("service %s: %w", service.Name, err)
+		}
+		serviceResults, err := s.upService(ctx, project, service, current[service.Name], options, recreate)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", service.Name, err)
+		}
+		result.Services = append(result.Services, serviceResults...)
+	}
+
+	for _, serviceName := range sortedKeys(current) {
+		if _, defined := project.Services[serviceName]; defined {
+			continue
+		}
+		for _, orphan := range current[serviceName] {
+			if !options.RemoveOrphans {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("container %s belongs to service %s, which is no longer defined", containerName(orphan), serviceName))
+				continue
+			}
+			if err := s.removeContainer(ctx, orphan.ID, nil, false); err != nil {
+				return nil, err
+			}
+			result.Orphans = append(result.Orphans, containerName(orphan))
+		}
+	}
+	log.Printf("Stack %s is up", project.Name)
+	return result, nil
+}
+
+// upService brings one service to its desired state: a container per
+// replica, numbered from 1. Containers numbered past the replica count, and
+// extra containers started by hand, are removed once the replicas are up.
+func (s *stackService) upService(ctx context.Context, project *Project, service *Service, current []types.Container, options UpOptions, recreate map[string]bool) ([]ServiceResult, error) {
+	replicas := map[int]*types.Container{}
+	var extra []types.Container
+	for i, c := range current {
+		number, err := strconv.Atoi(c.Labels[LabelNumber])
+		if err != nil || number < 1 || number > service.Replicas() || replicas[number] != nil {
+			extra = append(extra, c)
+			continue
+		}
+		replicas[number] = &current[i]
+	}
+
+	var imageID string
+	if service.Replicas() > 0 {
+		var err error
+		if imageID, err = s.ensureImage(ctx, service.Image, options.Pull); err != nil {
+			return nil, err
+		}
+	}
+	results := []ServiceResult{}
+	verified := !s.verifier.Enabled()
+	for number := 1; number <= service.Replicas(); number++ {
+		spec, err := project.containerSpec(service, number)
+		if err != nil {
+			return nil, err
+		}
+		existing := replicas[number]
+		result := ServiceResult{Service: service.Name, Container: spec.Name, Action: ActionCreated}
+		if existing != nil {
+			unchanged := existing.Labels[LabelConfigHash] == spec.Hash && existing.ImageID == imageID
+			if unchanged && !options.ForceRecreate && !recreate[containerName(*existing)] {
+				result.Action = ActionUnchanged
+				if existing.State != "running" {
+					if err := s.cli.ContainerStart(ctx, existing.ID, container.StartOptions{}); err != nil {
+						return nil, fmt.Errorf("failed to start container: %w", err)
+					}
+					result.Action = ActionStarted
+				}
+				results = append(results, result)
+				continue
+			}
+			result.Action = ActionRecreated
+		}
+
+		if !verified {
+			if err := s.verifyImage(ctx, service.Image); err != nil {
+				return nil, err
+			}
+			verified = true
+		}
+		if existing != nil {
+			if err := s.replaceContainer(ctx, spec, existing.ID); err != nil {
+				return nil, err
+			}
+		} else {
+			created, err := containers.Create(ctx, s.cli, spec.Spec)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.cli.ContainerStart(ctx, created, container.StartOptions{}); err != nil {
+				return nil, fmt.Errorf("failed to start container: %w", err)
+			}
+		}
+		results = append(results, result)
+	}
+
+	if len(extra) > 0 {
+		base, err := project.containerSpec(service, 1)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range extra {
+			if err := s.removeContainer(ctx, c.ID, base.Config.StopTimeout, false); err != nil {
+				return nil, err
+			}
+			results = append(results, ServiceResult{Service: service.Name, Container: containerName(c), Action: ActionRemoved})
+		}
+	}
+	return results, nil
+}
+
+// verifyImage checks the local image of ref against the signing policy
+func (s *stackService) verifyImage(ctx context.Context, ref string) error {
+	inspect, _, err := s.cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("failed to inspect image: %w", err)
+	}
+	_, err = s.verifier.CheckLocal(ctx, ref, inspect.RepoDigests)
+	return err
+}
+
+// replaceContainer swaps the container id for one created from spec, with
+// the rollback of containers.Replace. Anonymous volumes are carried over,
+// so their data survives the recreate.
+func (s *stackService) replaceContainer(ctx context.Context, spec *containerSpec, id string) error {
+	old, err := s.cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if err := s.carryVolumes(ctx, spec, old); err != nil {
+		return err
+	}
+	_, warnings, err := containers.Replace(ctx, s.cli, old, spec.Spec, containers.ReplaceOptions{Start: true})
+	for _, warning := range warnings {
+		log.Printf("Stack service %s: %s", spec.Name, warning)
+	}
+	return err
+}
+
+// carryVolumes mounts the anonymous volumes of old into spec by name, like
+// compose does: for the service's anonymous volumes, and for the volumes
+// its image declares that the service does not mount otherwise
+func (s *stackService) carryVolumes(ctx context.Context, spec *containerSpec, old types.ContainerJSON) error {
+	anonymous := map[string]string{}
+	for _, m := range old.Mounts {
+		if m.Type == mount.TypeVolume && m.Name != "" {
+			anonymous[m.Destination] = m.Name
+		}
+	}
+	if len(anonymous) == 0 {
+		return nil
+	}
+
+	mounts := slices.Clone(spec.HostConfig.Mounts)
+	declared := map[string]bool{}
+	for i, m := range mounts {
+		declared[m.Target] = true
+		if m.Type == mount.TypeVolume && m.Source == "" {
+			mounts[i].Source = anonymous[m.Target]
+		}
+	}
+	inspect, _, err := s.cli.ImageInspectWithRaw(ctx, spec.Config.Image)
+	if err != nil {
+		return fmt.Errorf("failed to inspect image: %w", err)
+	}
+	if inspect.Config != nil {
+		for _, target := range sortedKeys(inspect.Config.Volumes) {
+			if name, ok := anonymous[target]; ok && !declared[target] {
+				mounts = append(mounts, mount.Mount{Type: mount.TypeVolume, Source: name, Target: target})
+			}
+		}
+	}
+	spec.HostConfig.Mounts = mounts
+	return nil
+}
+
+// waitDependencies waits for the conditions of a service's depends_on on
+// the first replica of each dependency. Dependencies are deployed first, so
+// service_started already holds.
+func (s *stackService) waitDependencies(ctx context.Context, project *Project, service *Service) error {
+	for _, name := range sortedKeys(service.DependsOn) {
+		condition := service.DependsOn[name].Condition
+		if condition == ConditionStarted {
+			continue
+		}
+		dependency := project.Services[name]
+		if dependency.Replicas() == 0 {
+			return fmt.Errorf("dependency %s runs no replicas", name)
+		}
+		containerName := project.ContainerName(dependency, 1)
+		for {
+			inspect, err := s.cli.ContainerInspect(ctx, containerName)
+			if err != nil {
+				return fmt.Errorf("failed to inspect dependency %s: %w", name, err)
+			}
+			done, err := conditionMet(condition, inspect.State)
+			if err != nil {
+				return fmt.Errorf("dependency %s: %w", name, err)
+			}
+			if done {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("timed out waiting for dependency %s to be %s", name, strings.TrimPrefix(condition, "service_"))
+			case <-time.After(time.Second):
+			}
+		}
+	}
+	return nil
+}
+
+func conditionMet(condition string, state *types.ContainerState) (bool, error) {
+	switch condition {
+	case ConditionHealthy:
+		if state.Health == nil {
+			return false, fmt.Errorf("it has no healthcheck")
+		}
+		switch {
+		case state.Health.Status == types.Healthy:
+			return true, nil
+		case state.Health.Status == types.Unhealthy:
+			return false, fmt.Errorf("it is unhealthy")
+		case !state.Running:
+			return false, fmt.Errorf("it exited with code %d", state.ExitCode)
+		}
+	case ConditionCompleted:
+		if state.Running || state.Status == "created" {
+			return false, nil
+		}
+		if state.ExitCode != 0 {
+			return false, fmt.Errorf("it exited with code %d", state.ExitCode)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// ensureImage returns the local image ID of ref, pulling it when missing or
+// when pull is set
+func (s *stackService) ensureImage(ctx context.Context, ref string, pull bool) (string, error) {
+	if !pull {
+		inspect, _, err := s.cli.ImageInspectWithRaw(ctx, ref)
+		if err == nil {
+			return inspect.ID, nil
+		}
+		if !client.IsErrNotFound(err) {
+			return "", fmt.Errorf("failed to inspect image: %w", err)
+		}
+	}
+	if err := s.pullImage(ctx, ref); err != nil {
+		return "", err
+	}
+	inspect, _, err := s.cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image: %w", err)
+	}
+	return inspect.ID, nil
+}
+
+// pullImage pulls ref under the signing policy
+func (s *stackService) pullImage(ctx context.Context, ref string) error {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpPull)
+	defer cancel()
+	return s.verifier.Pull(ctx, s.cli, ref)
+}
+
+// ensureNetworks creates the project's networks that do not exist yet.
+// External networks must already exist.
+func (s *stackService) ensureNetworks(ctx context.Context, project *Project) error {
+	for _, key := range sortedKeys(project.Networks) {
+		spec := project.Networks[key]
+		name := project.NetworkName(key)
+		_, err := s.cli.NetworkInspect(ctx, name, network.InspectOptions{})
+		if err == nil {
+			continue
+		}
+		if !client.IsErrNotFound(err) {
+			return fmt.Errorf("failed to inspect network %s: %w", name, err)
+		}
+		if spec.External {
+			return fmt.Errorf("external network %s does not exist", name)
+		}
+
+		labels := spec.Labels.values()
+		labels[LabelProject] = project.Name
+		labels[LabelNetwork] = key
+		options := network.CreateOptions{
+			Driver:     spec.Driver,
+			Options:    spec.DriverOpts,
+			Internal:   spec.Internal,
+			Attachable: spec.Attachable,
+			Labels:     labels,
+		}
+		if spec.EnableIPv6 {
+			enabled := true
+			options.EnableIPv6 = &enabled
+		}
+		if spec.IPAM.Driver != "" || len(spec.IPAM.Config) > 0 {
+			options.IPAM = &network.IPAM{Driver: spec.IPAM.Driver}
+			for _, pool := range spec.IPAM.Config {
+				options.IPAM.Config = append(options.IPAM.Config, network.IPAMConfig{
+					Subnet:  pool.Subnet,
+					Gateway: pool.Gateway,
+					IPRange: pool.IPRange,
+				})
+			}
+		}
+		if _, err := s.cli.NetworkCreate(ctx, name, options); err != nil {
+			return fmt.Errorf("failed to create network %s: %w", name, err)
+		}
+		log.Printf("Network %s created for stack %s", name, project.Name)
+	}
+	return nil
+}
+
+// ensureVolumes creates the project's volumes that do not exist yet.
+// External volumes must already exist.
+func (s *stackService) ensureVolumes(ctx context.Context, project *Project) error {
+	for _, key := range sortedKeys(project.Volumes) {
+		spec := project.Volumes[key]
+		name := project.VolumeName(key)
+		_, err := s.cli.VolumeInspect(ctx, name)
+		if err == nil {
+			continue
+		}
+		if !client.IsErrNotFound(err) {
+			return fmt.Errorf("failed to inspect volume %s: %w", name, err)
+		}
+		if spec.External {
+			return fmt.Errorf("external volume %s does not exist", name)
+		}
+
+		labels := spec.Labels.values()
+		labels[LabelProject] = project.Name
+		labels[LabelVolume] = key
+		if _, err := s.cli.VolumeCreate(ctx, volume.CreateOptions{
+			Name:       name,
+			Driver:     spec.Driver,
+			DriverOpts: spec.DriverOpts,
+			Labels:     labels,
+		}); err != nil {
+			return fmt.Errorf("failed to create volume %s: %w", name, err)
+		}
+		log.Printf("Volume %s created for stack %s", name, project.Name)
+	}
+	return nil
+}
+
+// Down stops and removes a stack's containers and networks, and its volumes
+// when asked. It works from labels, so compose CLI projects can be taken
+// down too.
+func (s *stackService) Down(ctx context.Context, name string, options DownOptions) (*DownResult, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDeploy)
+	defer cancel()
+
+	members, err := s.projectContainers(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	_, stored := s.store.get(name)
+	if !stored && len(members) == 0 {
+		return nil, errdefs.NotFound(fmt.Errorf("stack %s not found", name))
+	}
+
+	result := &DownResult{Containers: []string{}, Networks: []string{}}
+	for _, c := range s.reverseOrder(name, members) {
+		if err := s.removeContainer(ctx, c.ID, nil, options.Volumes); err != nil {
+			return nil, err
+		}
+		result.Containers = append(result.Containers, containerName(c))
+	}
+
+	projectFilter := filters.NewArgs(filters.Arg("label", LabelProject+"="+name))
+	networks, err := s.cli.NetworkList(ctx, network.ListOptions{Filters: projectFilter})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	for _, n := range networks {
+		if err := s.cli.NetworkRemove(ctx, n.ID); err != nil {
+			return nil, fmt.Errorf("failed to remove network %s: %w", n.Name, err)
+		}
+		result.Networks = append(result.Networks, n.Name)
+	}
+
+	if options.Volumes {
+		volumes, err := s.cli.VolumeList(ctx, volume.ListOptions{Filters: projectFilter})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list volumes: %w", err)
+		}
+		for _, v := range volumes.Volumes {
+			if err := s.cli.VolumeRemove(ctx, v.Name, false); err != nil {
+				return nil, fmt.Errorf("failed to remove volume %s: %w", v.Name, err)
+			}
+			result.Volumes = append(result.Volumes, v.Name)
+		}
+	}
+	log.Printf("Stack %s is down", name)
+	return result, nil
+}
+
+// Restart restarts a stack's containers in dependency order
+func (s *stackService) Restart(ctx context.Context, name string) ([]string, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDeploy)
+	defer cancel()
+
+	members, err := s.projectContainers(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, errdefs.NotFound(fmt.Errorf("stack %s has no containers", name))
+	}
+	ordered := s.reverseOrder(name, members)
+	restarted := []string{}
+	for i := len(ordered) - 1; i >= 0; i-- {
+		if err := s.cli.ContainerRestart(ctx, ordered[i].ID, container.StopOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to restart container %s: %w", containerName(ordered[i]), err)
+		}
+		restarted = append(restarted, containerName(ordered[i]))
+	}
+	return restarted, nil
+}
+
+// Pull pulls every image of a stored stack
+func (s *stackService) Pull(ctx context.Context, name string) ([]string, error) {
+	project, err := s.project(name)
+	if err != nil {
+		return nil, err
+	}
+	images := project.Images()
+	for _, ref := range images {
+		if err := s.pullImage(ctx, ref); err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+// projectContainers lists every container of a compose project
+func (s *stackService) projectContainers(ctx context.Context, name string) ([]types.Container, error) {
+	containers, err := s.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelProject+"="+name)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containerName(containers[i]) < containerName(containers[j]) })
+	return containers, nil
+}
+
+// reverseOrder sorts containers so dependents come before their
+// dependencies, using the stored definition when there is one
+func (s *stackService) reverseOrder(name string, members []types.Container) []types.Container {
+	rank := map[string]int{}
+	if stack, ok := s.store.get(name); ok {
+		if project, err := Parse(stack); err == nil {
+			for i, service := range project.Order {
+				rank[service] = i + 1
+			}
+		}
+	}
+	ordered := append([]types.Container(nil), members...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return rank[ordered[i].Labels[LabelService]] > rank[ordered[j].Labels[LabelService]]
+	})
+	return ordered
+}
+
+// removeContainer stops and removes a container. timeout is the stop grace
+// period in seconds; nil uses the container's own. volumes also removes its
+// anonymous volumes.
+func (s *stackService) removeContainer(ctx context.Context, id string, timeout *int, volumes bool) error {
+	if err := s.cli.ContainerStop(ctx, id, container.StopOptions{Timeout: timeout}); err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to stop container %s: %w", id, err)
+	}
+	if err := s.cli.ContainerRemove(ctx, id, container.RemoveOptions{RemoveVolumes: volumes}); err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to remove container %s: %w", id, err)
+	}
+	return nil
+}
+
+func containerName(c types.Container) string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
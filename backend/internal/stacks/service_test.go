@@ -0,0 +1,489 @@
+package stacks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/pkg/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// fakeContainer is a container of fakeClient
+type fakeContainer struct {
+	id         string
+	name       string
+	imageID    string
+	state      string
+	config     *container.Config
+	hostConfig *container.HostConfig
+}
+
+// fakeClient keeps containers, networks and volumes in memory and records
+// the container operations the service asks of the daemon
+type fakeClient struct {
+	// images maps references to image IDs
+	images     map[string]string
+	containers []*fakeContainer
+	networks   map[string]map[string]string
+	volumes    map[string]map[string]string
+
+	calls []string
+	next  int
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		images:   map[string]string{"nginx": "sha256:nginx1", "postgres": "sha256:postgres1"},
+		networks: map[string]map[string]string{},
+		volumes:  map[string]map[string]string{},
+	}
+}
+
+func (f *fakeClient) find(ref string) *fakeContainer {
+	for _, c := range f.containers {
+		if c.id == ref || c.name == ref {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *fakeClient) lookup(ref string) (*fakeContainer, error) {
+	if c := f.find(ref); c != nil {
+		return c, nil
+	}
+	return nil, errdefs.NotFound(fmt.Errorf("no such container: %s", ref))
+}
+
+// add puts a container of a compose service on the daemon, as the compose
+// CLI would have created it
+func (f *fakeClient) add(project, service, name string) {
+	f.next++
+	f.containers = append(f.containers, &fakeContainer{
+		id:         fmt.Sprintf("c%d", f.next),
+		name:       name,
+		imageID:    f.images["nginx"],
+		state:      "running",
+		config:     &container.Config{Image: "nginx", Labels: map[string]string{LabelProject: project, LabelService: service}},
+		hostConfig: &container.HostConfig{},
+	})
+}
+
+// matches reports whether labels pass the label filters of args
+func matches(labels map[string]string, args filters.Args) bool {
+	for _, filter := range args.Get("label") {
+		key, value, hasValue := strings.Cut(filter, "=")
+		if actual, ok := labels[key]; !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeClient) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	var list []types.Container
+	for _, c := range f.containers {
+		if !matches(c.config.Labels, options.Filters) {
+			continue
+		}
+		list = append(list, types.Container{ID: c.id, Names: []string{"/" + c.name}, Image: c.config.Image, ImageID: c.imageID, Labels: c.config.Labels, State: c.state})
+	}
+	return list, nil
+}
+
+func (f *fakeClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	var mounts []types.MountPoint
+	for _, m := range c.hostConfig.Mounts {
+		point := types.MountPoint{Type: m.Type, Source: m.Source, Destination: m.Target, RW: !m.ReadOnly}
+		if m.Type == mount.TypeVolume {
+			point.Name = m.Source
+		}
+		mounts = append(mounts, point)
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         c.id,
+			Name:       "/" + c.name,
+			Image:      c.imageID,
+			State:      &types.ContainerState{Status: c.state, Running: c.state == "running"},
+			HostConfig: c.hostConfig,
+		},
+		Config: c.config,
+		Mounts: mounts,
+	}, nil
+}
+
+func (f *fakeClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	if f.find(containerName) != nil {
+		return container.CreateResponse{}, errdefs.Conflict(fmt.Errorf("container name %s is already in use", containerName))
+	}
+	f.next++
+	id := fmt.Sprintf("c%d", f.next)
+	f.containers = append(f.containers, &fakeContainer{id: id, name: containerName, imageID: f.images[config.Image], state: "created", config: config, hostConfig: hostConfig})
+	f.calls = append(f.calls, "create "+containerName)
+	return container.CreateResponse{ID: id}, nil
+}
+
+func (f *fakeClient) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	c.state = "running"
+	f.calls = append(f.calls, "start "+c.name)
+	return nil
+}
+
+func (f *fakeClient) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	c.state = "exited"
+	f.calls = append(f.calls, "stop "+c.name)
+	return nil
+}
+
+func (f *fakeClient) ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	c.state = "running"
+	f.calls = append(f.calls, "restart "+c.name)
+	return nil
+}
+
+func (f *fakeClient) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	f.containers = slices.DeleteFunc(f.containers, func(other *fakeContainer) bool { return other == c })
+	f.calls = append(f.calls, "remove "+c.name)
+	return nil
+}
+
+func (f *fakeClient) ContainerRename(ctx context.Context, containerID, newContainerName string) error {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	f.calls = append(f.calls, "rename "+c.name+" "+newContainerName)
+	c.name = newContainerName
+	return nil
+}
+
+func (f *fakeClient) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	for ref, id := range f.images {
+		if ref == imageID || id == imageID {
+			return types.ImageInspect{ID: id, Config: &container.Config{}}, nil, nil
+		}
+	}
+	return types.ImageInspect{}, nil, errdefs.NotFound(fmt.Errorf("no such image: %s", imageID))
+}
+
+func (f *fakeClient) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	if _, ok := f.images[ref]; !ok {
+		f.images[ref] = "sha256:" + ref
+	}
+	f.calls = append(f.calls, "pull "+ref)
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeClient) ImageTag(ctx context.Context, source, target string) error {
+	return nil
+}
+
+func (f *fakeClient) NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+	var list []network.Summary
+	for _, name := range sortedKeys(f.networks) {
+		if matches(f.networks[name], options.Filters) {
+			list = append(list, network.Summary{ID: name, Name: name, Labels: f.networks[name]})
+		}
+	}
+	return list, nil
+}
+
+func (f *fakeClient) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	labels, ok := f.networks[networkID]
+	if !ok {
+		return network.Inspect{}, errdefs.NotFound(fmt.Errorf("network %s not found", networkID))
+	}
+	return network.Inspect{ID: networkID, Name: networkID, Labels: labels}, nil
+}
+
+func (f *fakeClient) NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error) {
+	f.networks[name] = options.Labels
+	return network.CreateResponse{ID: name}, nil
+}
+
+func (f *fakeClient) NetworkRemove(ctx context.Context, networkID string) error {
+	delete(f.networks, networkID)
+	return nil
+}
+
+func (f *fakeClient) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	return nil
+}
+
+func (f *fakeClient) VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+	var list volume.ListResponse
+	for _, name := range sortedKeys(f.volumes) {
+		if matches(f.volumes[name], options.Filters) {
+			list.Volumes = append(list.Volumes, &volume.Volume{Name: name, Labels: f.volumes[name]})
+		}
+	}
+	return list, nil
+}
+
+func (f *fakeClient) VolumeInspect(ctx context.Context, volumeID string) (volume.Volume, error) {
+	labels, ok := f.volumes[volumeID]
+	if !ok {
+		return volume.Volume{}, errdefs.NotFound(fmt.Errorf("get %s: no such volume", volumeID))
+	}
+	return volume.Volume{Name: volumeID, Labels: labels}, nil
+}
+
+func (f *fakeClient) VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error) {
+	f.volumes[options.Name] = options.Labels
+	return volume.Volume{Name: options.Name, Labels: options.Labels}, nil
+}
+
+func (f *fakeClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	delete(f.volumes, volumeID)
+	return nil
+}
+
+const shopCompose = `
+services:
+  web:
+    image: nginx
+    depends_on: [db]
+    ports: ["8080:80"]
+  db:
+    image: postgres
+    environment:
+      POSTGRES_DB: shop
+    volumes:
+      - data:/var/lib/postgresql/data
+volumes:
+  data:
+`
+
+// newShop returns a stack service with the shop stack stored
+func newShop(t *testing.T) (StackService, *fakeClient) {
+	t.Helper()
+	cli := newFakeClient()
+	var cfg config.Config
+	cfg.Stacks.Dir = t.TempDir()
+	service, err := NewStackService(cfg, cli)
+	if err != nil {
+		t.Fatalf("NewStackService: %v", err)
+	}
+	if _, err := service.CreateStack(context.Background(), Stack{Name: "shop", Compose: shopCompose}); err != nil {
+		t.Fatalf("CreateStack: %v", err)
+	}
+	return service, cli
+}
+
+// actions lists the action of every service of an up
+func actions(result *UpResult) []string {
+	var list []string
+	for _, service := range result.Services {
+		list = append(list, service.Service+" "+service.Action)
+	}
+	return list
+}
+
+func TestUp(t *testing.T) {
+	service, cli := newShop(t)
+	ctx := context.Background()
+
+	result, err := service.Up(ctx, "shop", UpOptions{})
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if got, want := actions(result), []string{"db created", "web created"}; !slices.Equal(got, want) {
+		t.Errorf("first up = %v, want %v", got, want)
+	}
+	want := []string{"create shop-db-1", "start shop-db-1", "create shop-web-1", "start shop-web-1"}
+	if !slices.Equal(cli.calls, want) {
+		t.Errorf("first up calls = %v, want %v", cli.calls, want)
+	}
+	if labels, ok := cli.networks["shop_default"]; !ok || labels[LabelProject] != "shop" || labels[LabelNetwork] != DefaultNetwork {
+		t.Errorf("default network = %v, %t", labels, ok)
+	}
+	if labels, ok := cli.volumes["shop_data"]; !ok || labels[LabelVolume] != "data" {
+		t.Errorf("data volume = %v, %t", labels, ok)
+	}
+
+	cli.calls = nil
+	result, err = service.Up(ctx, "shop", UpOptions{})
+	if err != nil {
+		t.Fatalf("second Up: %v", err)
+	}
+	if got, want := actions(result), []string{"db unchanged", "web unchanged"}; !slices.Equal(got, want) || len(cli.calls) != 0 {
+		t.Errorf("second up = %v with calls %v, want %v and no calls", got, cli.calls, want)
+	}
+
+	cli.find("shop-web-1").state = "exited"
+	result, err = service.Up(ctx, "shop", UpOptions{})
+	if err != nil {
+		t.Fatalf("Up of a stopped service: %v", err)
+	}
+	if got, want := actions(result), []string{"db unchanged", "web started"}; !slices.Equal(got, want) {
+		t.Errorf("up of a stopped service = %v, want %v", got, want)
+	}
+
+	// A new image is picked up by recreating the service's container, and
+	// the previous one is only removed once its replacement runs
+	cli.images["nginx"] = "sha256:nginx2"
+	previous := cli.find("shop-web-1").id
+	cli.calls = nil
+	result, err = service.Up(ctx, "shop", UpOptions{})
+	if err != nil {
+		t.Fatalf("Up of a new image: %v", err)
+	}
+	if got, want := actions(result), []string{"db unchanged", "web recreated"}; !slices.Equal(got, want) {
+		t.Errorf("up of a new image = %v, want %v", got, want)
+	}
+	aside := "shop-web-1-old-" + previous
+	want = []string{"rename shop-web-1 " + aside, "create shop-web-1", "stop " + aside, "start shop-web-1", "remove " + aside}
+	if !slices.Equal(cli.calls, want) {
+		t.Errorf("recreate calls = %v, want %v", cli.calls, want)
+	}
+	if web := cli.find("shop-web-1"); web == nil || web.imageID != "sha256:nginx2" || web.state != "running" {
+		t.Errorf("recreated container = %+v", web)
+	}
+}
+
+func TestUpOrphans(t *testing.T) {
+	service, cli := newShop(t)
+	ctx := context.Background()
+	cli.add("shop", "worker", "shop-worker-1")
+
+	result, err := service.Up(ctx, "shop", UpOptions{})
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if len(result.Orphans) != 0 || !slices.Contains(result.Warnings, "container shop-worker-1 belongs to service worker, which is no longer defined") {
+		t.Errorf("up kept orphans %v with warnings %v", result.Orphans, result.Warnings)
+	}
+	if cli.find("shop-worker-1") == nil {
+		t.Fatal("an orphan was removed without RemoveOrphans")
+	}
+
+	result, err = service.Up(ctx, "shop", UpOptions{RemoveOrphans: true})
+	if err != nil {
+		t.Fatalf("Up removing orphans: %v", err)
+	}
+	if !slices.Equal(result.Orphans, []string{"shop-worker-1"}) || cli.find("shop-worker-1") != nil {
+		t.Errorf("orphans = %v, want shop-worker-1 removed", result.Orphans)
+	}
+}
+
+func TestUpReplicas(t *testing.T) {
+	service, cli := newShop(t)
+	ctx := context.Background()
+	scaled := strings.Replace(shopCompose, "    image: nginx\n", "    image: nginx\n    deploy:\n      replicas: 3\n", 1)
+	if _, err := service.UpdateStack(ctx, Stack{Name: "shop", Compose: scaled}); err != nil {
+		t.Fatalf("UpdateStack: %v", err)
+	}
+	cli.add("shop", "web", "shop-web-9")
+
+	result, err := service.Up(ctx, "shop", UpOptions{})
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	want := []string{"db created", "web created", "web created", "web created", "web removed"}
+	if got := actions(result); !slices.Equal(got, want) {
+		t.Errorf("up = %v, want %v", got, want)
+	}
+	for number := 1; number <= 3; number++ {
+		name := fmt.Sprintf("shop-web-%d", number)
+		if c := cli.find(name); c == nil || c.config.Labels[LabelNumber] != strconv.Itoa(number) {
+			t.Errorf("replica %s = %+v", name, c)
+		}
+	}
+	if cli.find("shop-web-9") != nil {
+		t.Error("a container started by hand was kept")
+	}
+
+	if _, err := service.UpdateStack(ctx, Stack{Name: "shop", Compose: shopCompose}); err != nil {
+		t.Fatalf("UpdateStack: %v", err)
+	}
+	result, err = service.Up(ctx, "shop", UpOptions{})
+	if err != nil {
+		t.Fatalf("Up scaling down: %v", err)
+	}
+	want = []string{"db unchanged", "web unchanged", "web removed", "web removed"}
+	if got := actions(result); !slices.Equal(got, want) {
+		t.Errorf("up scaling down = %v, want %v", got, want)
+	}
+	if cli.find("shop-web-2") != nil || cli.find("shop-web-3") != nil {
+		t.Error("replicas past the count were kept")
+	}
+}
+
+func TestDown(t *testing.T) {
+	service, cli := newShop(t)
+	ctx := context.Background()
+	if _, err := service.Up(ctx, "shop", UpOptions{}); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	result, err := service.Down(ctx, "shop", DownOptions{})
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	// Dependents go first
+	if want := []string{"shop-web-1", "shop-db-1"}; !slices.Equal(result.Containers, want) {
+		t.Errorf("removed containers = %v, want %v", result.Containers, want)
+	}
+	if !slices.Equal(result.Networks, []string{"shop_default"}) || len(cli.networks) != 0 {
+		t.Errorf("removed networks = %v, left %v", result.Networks, cli.networks)
+	}
+	if len(result.Volumes) != 0 || cli.volumes["shop_data"] == nil {
+		t.Errorf("down removed volumes %v without being asked", result.Volumes)
+	}
+	if len(cli.containers) != 0 {
+		t.Errorf("%d containers left", len(cli.containers))
+	}
+
+	if _, err := service.Up(ctx, "shop", UpOptions{}); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	result, err = service.Down(ctx, "shop", DownOptions{Volumes: true})
+	if err != nil {
+		t.Fatalf("Down with volumes: %v", err)
+	}
+	if !slices.Equal(result.Volumes, []string{"shop_data"}) || len(cli.volumes) != 0 {
+		t.Errorf("removed volumes = %v, left %v", result.Volumes, cli.volumes)
+	}
+
+	if _, err := service.Down(ctx, "unknown", DownOptions{}); !errdefs.IsNotFound(err) {
+		t.Errorf("Down of an unknown stack = %v, want not found", err)
+	}
+}
@@ -0,0 +1,143 @@
+package stacks
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+
+	"github.com/genc-murat/harborview/internal/fsutil"
+)
+
+// Stack is a stored compose project definition
+type Stack struct {
+	Name    string `json:"name"`
+	Compose string `json:"compose"`
+	// Env holds variables for interpolation; they override the stack's .env
+	Env map[string]string `json:"env,omitempty"`
+	// EnvFiles are the files env_file entries and .env refer to, by path
+	EnvFiles  map[string]string `json:"envFiles,omitempty"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// stackStore keeps stack definitions as dir/<name>.json, or in memory when
+// dir is empty
+type stackStore struct {
+	dir string
+
+	mu     sync.Mutex
+	stacks map[string]Stack
+}
+
+// storeKey names the store of one endpoint under a stacks directory
+type storeKey struct {
+	dir, endpoint string
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = map[storeKey]*stackStore{}
+)
+
+// sharedStore returns the store of an endpoint's stacks, shared by the
+// services of that endpoint. Stacks are deployed to the host they are
+// stored for, so each endpoint keeps its own under dir/endpoints/<name>;
+// the default routes ("") use dir itself.
+func sharedStore(dir, endpoint string) *stackStore {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	key := storeKey{dir, endpoint}
+	store, ok := stores[key]
+	if !ok {
+		store = &stackStore{dir: dir, stacks: make(map[string]Stack)}
+		if dir != "" && endpoint != "" {
+			store.dir = filepath.Join(dir, "endpoints", endpoint)
+		}
+		if err := store.load(); err != nil {
+			log.Printf("Failed to load stacks from %s: %v", store.dir, err)
+		}
+		stores[key] = store
+	}
+	return store
+}
+
+func (s *stackStore) load() error {
+	if s.dir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var stack Stack
+		if err := json.Unmarshal(data, &stack); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		s.stacks[stack.Name] = stack
+	}
+	return nil
+}
+
+func (s *stackStore) list() []Stack {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stacks := make([]Stack, 0, len(s.stacks))
+	for _, stack := range s.stacks {
+		stacks = append(stacks, stack)
+	}
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].Name < stacks[j].Name })
+	return stacks
+}
+
+func (s *stackStore) get(name string) (Stack, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stack, ok := s.stacks[name]
+	return stack, ok
+}
+
+// save stores stack, replacing a previous definition of the same name
+func (s *stackStore) save(stack Stack) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		s.stacks[stack.Name] = stack
+		return nil
+	}
+
+	data, err := json.MarshalIndent(stack, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(filepath.Join(s.dir, stack.Name+".json"), data); err != nil {
+		return fmt.Errorf("failed to store stack: %w", err)
+	}
+	s.stacks[stack.Name] = stack
+	return nil
+}
+
+func (s *stackStore) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.stacks[name]; !ok {
+		return errdefs.NotFound(fmt.Errorf("stack %s not found", name))
+	}
+	if s.dir != "" {
+		if err := os.Remove(filepath.Join(s.dir, name+".json")); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stack: %w", err)
+		}
+	}
+	delete(s.stacks, name)
+	return nil
+}
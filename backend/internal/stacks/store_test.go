@@ -0,0 +1,30 @@
+package stacks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSharedStorePerEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	local, edge := sharedStore(dir, ""), sharedStore(dir, "edge")
+	if local == edge || sharedStore(dir, "edge") != edge {
+		t.Fatal("endpoints share a store, or one endpoint has several")
+	}
+
+	if err := edge.save(Stack{Name: "web", Compose: "services: {}"}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, ok := local.get("web"); ok {
+		t.Error("a stack stored for edge is listed for the default routes")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "endpoints", "edge", "web.json")); err != nil {
+		t.Errorf("edge stack file: %v", err)
+	}
+
+	// In memory, endpoints are kept apart too
+	if sharedStore("", "edge") == sharedStore("", "other") {
+		t.Error("in-memory stores are shared between endpoints")
+	}
+}
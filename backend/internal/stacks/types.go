@@ -0,0 +1,341 @@
+package stacks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The compose format allows several shapes for many keys; these types
+// normalize them while decoding.
+
+// stringList is a string or a list of strings
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*l = stringList{node.Value}
+		return nil
+	case yaml.SequenceNode:
+		var items []string
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+		*l = items
+		return nil
+	case yaml.MappingNode:
+		// extra_hosts may be a host: ip mapping
+		var items map[string]string
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(items) {
+			*l = append(*l, key+":"+items[key])
+		}
+		return nil
+	}
+	return fmt.Errorf("line %d: expected a string or a list", node.Line)
+}
+
+// shellCommand is a command as a list, or as a string split like a shell
+// would
+type shellCommand []string
+
+func (c *shellCommand) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		words, err := splitWords(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		*c = words
+		return nil
+	case yaml.SequenceNode:
+		var items []string
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+		*c = items
+		return nil
+	}
+	return fmt.Errorf("line %d: expected a command string or list", node.Line)
+}
+
+// splitWords splits s into words on unquoted whitespace, honouring single
+// quotes, double quotes and backslash escapes
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// mappingList is a KEY: value mapping or a list of KEY=value entries. A key
+// without a value maps to nil.
+type mappingList map[string]*string
+
+func (m *mappingList) UnmarshalYAML(node *yaml.Node) error {
+	result := mappingList{}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			if value.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: value of %s must be a scalar", value.Line, key)
+			}
+			if value.ShortTag() == "!!null" {
+				result[key] = nil
+				continue
+			}
+			v := value.Value
+			result[key] = &v
+		}
+	case yaml.SequenceNode:
+		var items []string
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			key, value, ok := strings.Cut(item, "=")
+			if !ok {
+				result[key] = nil
+				continue
+			}
+			result[key] = &value
+		}
+	default:
+		return fmt.Errorf("line %d: expected a mapping or a list", node.Line)
+	}
+	*m = result
+	return nil
+}
+
+// values returns the entries with a value, treating those without as empty
+func (m mappingList) values() map[string]string {
+	values := make(map[string]string, len(m))
+	for key, value := range m {
+		if value != nil {
+			values[key] = *value
+		} else {
+			values[key] = ""
+		}
+	}
+	return values
+}
+
+// portSpec is a port in the short "[ip:][host:]container[/proto]" syntax;
+// the long syntax is converted to it
+type portSpec string
+
+func (p *portSpec) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*p = portSpec(node.Value)
+		return nil
+	case yaml.MappingNode:
+		var long struct {
+			Target    int    `yaml:"target"`
+			Published string `yaml:"published"`
+			HostIP    string `yaml:"host_ip"`
+			Protocol  string `yaml:"protocol"`
+		}
+		if err := node.Decode(&long); err != nil {
+			return err
+		}
+		if long.Target == 0 {
+			return fmt.Errorf("line %d: port target is required", node.Line)
+		}
+		spec := strconv.Itoa(long.Target)
+		if long.Published != "" {
+			spec = long.Published + ":" + spec
+			if long.HostIP != "" {
+				spec = long.HostIP + ":" + spec
+			}
+		}
+		if long.Protocol != "" {
+			spec += "/" + long.Protocol
+		}
+		*p = portSpec(spec)
+		return nil
+	}
+	return fmt.Errorf("line %d: expected a port string or mapping", node.Line)
+}
+
+// volumeSpec is a service mount in either the short "source:target:mode"
+// or the long syntax. Type is "volume", "bind" or "tmpfs"; a volume without
+// source is anonymous.
+type volumeSpec struct {
+	Type     string
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+func (v *volumeSpec) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		parsed, err := parseVolumeSpec(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		*v = parsed
+		return nil
+	case yaml.MappingNode:
+		var long struct {
+			Type     string `yaml:"type"`
+			Source   string `yaml:"source"`
+			Target   string `yaml:"target"`
+			ReadOnly bool   `yaml:"read_only"`
+		}
+		if err := node.Decode(&long); err != nil {
+			return err
+		}
+		if long.Target == "" {
+			return fmt.Errorf("line %d: volume target is required", node.Line)
+		}
+		switch long.Type {
+		case "":
+			long.Type = "volume"
+		case "volume", "bind", "tmpfs":
+		default:
+			return fmt.Errorf("line %d: volume type %s is not supported", node.Line, long.Type)
+		}
+		*v = volumeSpec{Type: long.Type, Source: long.Source, Target: long.Target, ReadOnly: long.ReadOnly}
+		return nil
+	}
+	return fmt.Errorf("line %d: expected a volume string or mapping", node.Line)
+}
+
+func parseVolumeSpec(spec string) (volumeSpec, error) {
+	parts := strings.Split(spec, ":")
+	var v volumeSpec
+	switch len(parts) {
+	case 1:
+		return volumeSpec{Type: "volume", Target: parts[0]}, nil
+	case 2, 3:
+		v.Source, v.Target = parts[0], parts[1]
+		if len(parts) == 3 {
+			for _, option := range strings.Split(parts[2], ",") {
+				switch option {
+				case "ro":
+					v.ReadOnly = true
+				case "rw", "z", "Z", "nocopy":
+				default:
+					return v, fmt.Errorf("unknown volume option %q in %s", option, spec)
+				}
+			}
+		}
+	default:
+		return v, fmt.Errorf("invalid volume %s", spec)
+	}
+	if v.Source == "" || v.Target == "" {
+		return v, fmt.Errorf("invalid volume %s", spec)
+	}
+	v.Type = "volume"
+	if strings.HasPrefix(v.Source, "/") || strings.HasPrefix(v.Source, ".") || strings.HasPrefix(v.Source, "~") {
+		v.Type = "bind"
+	}
+	return v, nil
+}
+
+// serviceNetworks is a list of network names or a mapping of names to
+// attachment settings
+type serviceNetworks map[string]*ServiceNetwork
+
+func (n *serviceNetworks) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return err
+		}
+		*n = serviceNetworks{}
+		for _, name := range names {
+			(*n)[name] = nil
+		}
+		return nil
+	case yaml.MappingNode:
+		var networks map[string]*ServiceNetwork
+		if err := node.Decode(&networks); err != nil {
+			return err
+		}
+		*n = networks
+		return nil
+	}
+	return fmt.Errorf("line %d: expected a list or mapping of networks", node.Line)
+}
+
+// dependsOn is a list of service names or a mapping of names to conditions
+type dependsOn map[string]Dependency
+
+func (d *dependsOn) UnmarshalYAML(node *yaml.Node) error {
+	result := dependsOn{}
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return err
+		}
+		for _, name := range names {
+			result[name] = Dependency{Condition: ConditionStarted}
+		}
+	case yaml.MappingNode:
+		var dependencies map[string]Dependency
+		if err := node.Decode(&dependencies); err != nil {
+			return err
+		}
+		for name, dependency := range dependencies {
+			switch dependency.Condition {
+			case "":
+				dependency.Condition = ConditionStarted
+			case ConditionStarted, ConditionHealthy, ConditionCompleted:
+			default:
+				return fmt.Errorf("line %d: unknown depends_on condition %s", node.Line, dependency.Condition)
+			}
+			result[name] = dependency
+		}
+	default:
+		return fmt.Errorf("line %d: expected a list or mapping of services", node.Line)
+	}
+	*d = result
+	return nil
+}
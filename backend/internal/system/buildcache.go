@@ -0,0 +1,123 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-units"
+	"github.com/genc-murat/harborview/internal/docker"
+)
+
+// BuildCacheRecord is one BuildKit cache record
+type BuildCacheRecord struct {
+	ID          string     `json:"id"`
+	Parents     []string   `json:"parents,omitempty"`
+	Type        string     `json:"type"`
+	Description string     `json:"description"`
+	Size        int64      `json:"size"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	UsageCount  int        `json:"usageCount"`
+	InUse       bool       `json:"inUse"`
+	Shared      bool       `json:"shared"`
+}
+
+// BuildCache lists the build cache, most recently used first
+type BuildCache struct {
+	Records []BuildCacheRecord `json:"records"`
+	// Size and Reclaimable leave out shared records, which belong to images
+	Size        int64 `json:"size"`
+	Reclaimable int64 `json:"reclaimable"`
+}
+
+// BuildCachePruneOptions control a build cache prune
+type BuildCachePruneOptions struct {
+	// All removes internal and frontend records as well, not only the
+	// unused ones that are safe to drop
+	All bool
+	// KeepStorage is how much cache to keep, as bytes or a size like 10GB
+	KeepStorage string
+	// Until removes only records not used since this timestamp or
+	// duration, such as 72h
+	Until string
+}
+
+// ListBuildCache lists the daemon's build cache records
+func (s *systemService) ListBuildCache(ctx context.Context) (*BuildCache, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDF)
+	defer cancel()
+
+	du, err := s.cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.BuildCacheObject}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list build cache: %w", err)
+	}
+
+	cache := &BuildCache{Records: make([]BuildCacheRecord, 0, len(du.BuildCache))}
+	for _, record := range du.BuildCache {
+		parents := record.Parents
+		if len(parents) == 0 && record.Parent != "" {
+			// Daemons before API 1.42 report a single parent
+			parents = []string{record.Parent}
+		}
+		cache.Records = append(cache.Records, BuildCacheRecord{
+			ID:          record.ID,
+			Parents:     parents,
+			Type:        record.Type,
+			Description: record.Description,
+			Size:        record.Size,
+			CreatedAt:   record.CreatedAt,
+			LastUsedAt:  record.LastUsedAt,
+			UsageCount:  record.UsageCount,
+			InUse:       record.InUse,
+			Shared:      record.Shared,
+		})
+		if !record.Shared {
+			cache.Size += record.Size
+			if !record.InUse {
+				cache.Reclaimable += record.Size
+			}
+		}
+	}
+	sort.SliceStable(cache.Records, func(i, j int) bool {
+		return lastUsed(cache.Records[i]).After(lastUsed(cache.Records[j]))
+	})
+	return cache, nil
+}
+
+func lastUsed(record BuildCacheRecord) time.Time {
+	if record.LastUsedAt != nil {
+		return *record.LastUsedAt
+	}
+	return record.CreatedAt
+}
+
+// PruneBuildCache removes build cache records, keeping up to KeepStorage
+func (s *systemService) PruneBuildCache(ctx context.Context, options BuildCachePruneOptions) (*types.BuildCachePruneReport, error) {
+	pruneOptions := types.BuildCachePruneOptions{All: options.All, Filters: filters.NewArgs()}
+	if options.KeepStorage != "" {
+		keep, err := units.RAMInBytes(options.KeepStorage)
+		if err != nil || keep < 0 {
+			return nil, errdefs.InvalidParameter(fmt.Errorf("invalid keep storage %q, expected bytes or a size like 10GB", options.KeepStorage))
+		}
+		pruneOptions.KeepStorage = keep
+	}
+	if options.Until != "" {
+		pruneOptions.Filters.Add("until", options.Until)
+	}
+
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpPrune)
+	defer cancel()
+
+	report, err := s.cli.BuildCachePrune(ctx, pruneOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune build cache: %w", err)
+	}
+	log.Printf("Build cache prune reclaimed %d bytes", report.SpaceReclaimed)
+	return report, nil
+}
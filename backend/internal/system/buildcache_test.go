@@ -0,0 +1,99 @@
+package system
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+func TestListBuildCache(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	used := func(hours int) *time.Time {
+		at := created.Add(time.Duration(hours) * time.Hour)
+		return &at
+	}
+	cli := newFakeClient()
+	cli.usage = types.DiskUsage{BuildCache: []*types.BuildCache{
+		{ID: "never-used", Size: 10, CreatedAt: created.Add(2 * time.Hour)},
+		{ID: "old", Size: 20, CreatedAt: created, LastUsedAt: used(1), Parent: "base"},
+		{ID: "recent", Size: 30, CreatedAt: created, LastUsedAt: used(5), Parents: []string{"old", "never-used"}, InUse: true},
+		{ID: "shared", Size: 70, CreatedAt: created, LastUsedAt: used(3), Shared: true},
+	}}
+	service := NewSystemService(config.Config{}, cli)
+
+	cache, err := service.ListBuildCache(context.Background())
+	if err != nil {
+		t.Fatalf("ListBuildCache: %v", err)
+	}
+	var order []string
+	for _, record := range cache.Records {
+		order = append(order, record.ID)
+	}
+	if want := []string{"recent", "shared", "never-used", "old"}; !slices.Equal(order, want) {
+		t.Errorf("records = %v, want %v", order, want)
+	}
+	parents := map[string][]string{}
+	for _, record := range cache.Records {
+		parents[record.ID] = record.Parents
+	}
+	if !slices.Equal(parents["old"], []string{"base"}) || !slices.Equal(parents["recent"], []string{"old", "never-used"}) || parents["never-used"] != nil {
+		t.Errorf("parents = %v", parents)
+	}
+	// Shared records belong to images and are not counted
+	if cache.Size != 60 || cache.Reclaimable != 30 {
+		t.Errorf("size = %d, reclaimable = %d, want 60 and 30", cache.Size, cache.Reclaimable)
+	}
+
+	cli.usage = types.DiskUsage{}
+	if cache, err := service.ListBuildCache(context.Background()); err != nil || cache.Records == nil || len(cache.Records) != 0 {
+		t.Errorf("empty ListBuildCache = %+v, %v, want no records", cache, err)
+	}
+}
+
+func TestPruneBuildCache(t *testing.T) {
+	tests := []struct {
+		options BuildCachePruneOptions
+		keep    int64
+		all     bool
+		until   string
+		invalid bool
+	}{
+		{options: BuildCachePruneOptions{}},
+		{options: BuildCachePruneOptions{KeepStorage: "1048576"}, keep: 1 << 20},
+		{options: BuildCachePruneOptions{KeepStorage: "10GB"}, keep: 10 << 30},
+		{options: BuildCachePruneOptions{KeepStorage: "512m"}, keep: 512 << 20},
+		{options: BuildCachePruneOptions{All: true, Until: "72h"}, all: true, until: "72h"},
+		{options: BuildCachePruneOptions{KeepStorage: "lots"}, invalid: true},
+		{options: BuildCachePruneOptions{KeepStorage: "-1GB"}, invalid: true},
+	}
+	for _, tt := range tests {
+		cli := newFakeClient()
+		service := NewSystemService(config.Config{}, cli)
+		report, err := service.PruneBuildCache(context.Background(), tt.options)
+		if tt.invalid {
+			if !errdefs.IsInvalidParameter(err) || len(cli.pruned) != 0 {
+				t.Errorf("%+v: PruneBuildCache = %v, want an invalid parameter and nothing pruned", tt.options, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: PruneBuildCache: %v", tt.options, err)
+			continue
+		}
+		got := cli.cacheOptions
+		if got.KeepStorage != tt.keep || got.All != tt.all {
+			t.Errorf("%+v: keep storage %d, all %v, want %d and %v", tt.options, got.KeepStorage, got.All, tt.keep, tt.all)
+		}
+		if until := got.Filters.Get("until"); (tt.until == "" && len(until) != 0) || (tt.until != "" && !got.Filters.ExactMatch("until", tt.until)) {
+			t.Errorf("%+v: until filter = %v, want %q", tt.options, until, tt.until)
+		}
+		if report.SpaceReclaimed != 1000 || !slices.Equal(report.CachesDeleted, []string{"b1"}) {
+			t.Errorf("%+v: report = %+v", tt.options, report)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package system
+
+import (
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/httputil"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterRoutes registers all routes for the system on the shared client
+func RegisterRoutes(app *fiber.App, cfg config.Config, cli *client.Client) {
+	// Initialize the service
+	service := NewSystemService(cfg, cli)
+
+	MountRoutes(app.Group("/system"), func(*fiber.Ctx) (SystemService, error) {
+		return service, nil
+	})
+}
+
+// MountRoutes registers the system routes on systemGroup. lookup picks the
+// service each request runs against; a *fiber.Error from it sets the status.
+func MountRoutes(systemGroup fiber.Router, lookup func(c *fiber.Ctx) (SystemService, error)) {
+	handle := httputil.Bind(lookup)
+
+	// Get daemon info
+	systemGroup.Get("/info", handle(GetInfo))
+
+	// Get the daemon and component versions
+	systemGroup.Get("/version", handle(GetVersion))
+
+	// Get disk usage by resource type
+	systemGroup.Get("/df", handle(GetDiskUsage))
+
+	// Prune unused resources of every type
+	systemGroup.Post("/prune", handle(Prune))
+
+	// List build cache records
+	systemGroup.Get("/buildcache", handle(ListBuildCache))
+
+	// Prune the build cache
+	systemGroup.Post("/buildcache/prune", handle(PruneBuildCache))
+}
+
+// systemError maps invalid requests to 400 and daemon errors to 500
+func systemError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	if errdefs.IsInvalidParameter(err) {
+		status = fiber.StatusBadRequest
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
+
+// queryValues returns the values of a repeatable query parameter
+func queryValues(c *fiber.Ctx, key string) []string {
+	var values []string
+	for _, raw := range c.Context().QueryArgs().PeekMulti(key) {
+		if v := strings.TrimSpace(string(raw)); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// pruneTypes reads ?type=, which may repeat or list types separated by
+// commas
+func pruneTypes(c *fiber.Ctx) []string {
+	var types []string
+	for _, value := range queryValues(c, "type") {
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, t)
+			}
+		}
+	}
+	return types
+}
+
+// GetInfo handler for fetching daemon info
+func GetInfo(c *fiber.Ctx, service SystemService) error {
+	info, err := service.Info(c.UserContext())
+	if err != nil {
+		return systemError(c, err)
+	}
+	return c.JSON(info)
+}
+
+// GetVersion handler for fetching the daemon version
+func GetVersion(c *fiber.Ctx, service SystemService) error {
+	version, err := service.Version(c.UserContext())
+	if err != nil {
+		return systemError(c, err)
+	}
+	return c.JSON(version)
+}
+
+// GetDiskUsage handler for fetching disk usage. ?verbose=true adds the
+// per-image, container, volume and build cache details.
+func GetDiskUsage(c *fiber.Ctx, service SystemService) error {
+	usage, err := service.DiskUsage(c.UserContext(), c.QueryBool("verbose", false))
+	if err != nil {
+		return systemError(c, err)
+	}
+	return c.JSON(usage)
+}
+
+// Prune handler for pruning unused resources. ?type= selects containers,
+// images, networks, volumes and buildcache (all but volumes by default);
+// ?all=true includes unused images and volumes that are not dangling or
+// anonymous; ?until= and ?label= filter what is removed. The build cache
+// has no labels, so ?label= leaves it out.
+func Prune(c *fiber.Ctx, service SystemService) error {
+	report, err := service.Prune(c.UserContext(), PruneOptions{
+		Types:  pruneTypes(c),
+		All:    c.QueryBool("all", false),
+		Until:  c.Query("until"),
+		Labels: queryValues(c, "label"),
+	})
+	if err != nil {
+		return systemError(c, err)
+	}
+	return c.JSON(report)
+}
+
+// ListBuildCache handler for listing build cache records
+func ListBuildCache(c *fiber.Ctx, service SystemService) error {
+	cache, err := service.ListBuildCache(c.UserContext())
+	if err != nil {
+		return systemError(c, err)
+	}
+	return c.JSON(cache)
+}
+
+// PruneBuildCache handler for pruning the build cache. ?keepStorage= keeps
+// that much cache, ?until= removes only records unused since then and
+// ?all=true removes internal records too.
+func PruneBuildCache(c *fiber.Ctx, service SystemService) error {
+	report, err := service.PruneBuildCache(c.UserContext(), BuildCachePruneOptions{
+		All:         c.QueryBool("all", false),
+		KeepStorage: c.Query("keepStorage"),
+		Until:       c.Query("until"),
+	})
+	if err != nil {
+		return systemError(c, err)
+	}
+	return c.JSON(report)
+}
@@ -0,0 +1,62 @@
+package system
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestPruneBuildCacheRoute(t *testing.T) {
+	tests := []struct {
+		query  string
+		status int
+		keep   int64
+		all    bool
+		until  string
+	}{
+		{"?keepStorage=2GB&all=true&until=24h", fiber.StatusOK, 2 << 30, true, "24h"},
+		{"", fiber.StatusOK, 0, false, ""},
+		{"?keepStorage=plenty", fiber.StatusBadRequest, 0, false, ""},
+	}
+	for _, tt := range tests {
+		cli := newFakeClient()
+		service := NewSystemService(config.Config{}, cli)
+		app := fiber.New()
+		MountRoutes(app.Group("/system"), func(*fiber.Ctx) (SystemService, error) {
+			return service, nil
+		})
+
+		resp, err := app.Test(httptest.NewRequest("POST", "/system/buildcache/prune"+tt.query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%q: status = %d %s, want %d", tt.query, resp.StatusCode, data, tt.status)
+			continue
+		}
+		if tt.status != fiber.StatusOK {
+			continue
+		}
+		var report types.BuildCachePruneReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.SpaceReclaimed != 1000 {
+			t.Errorf("%q: reclaimed %d, want 1000", tt.query, report.SpaceReclaimed)
+		}
+		got := cli.cacheOptions
+		until := ""
+		if values := got.Filters.Get("until"); len(values) == 1 {
+			until = values[0]
+		}
+		if got.KeepStorage != tt.keep || got.All != tt.all || until != tt.until {
+			t.Errorf("%q: options = %+v", tt.query, got)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+// Package system reports on a Docker host as a whole: daemon info,
+// versions, disk usage, and pruning every resource type at once.
+package system
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	dockersystem "github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// Resource types, as disk usage categories and prune targets
+const (
+	TypeContainers = "containers"
+	TypeImages     = "images"
+	TypeNetworks   = "networks"
+	TypeVolumes    = "volumes"
+	TypeBuildCache = "buildcache"
+)
+
+// DefaultPruneTypes are pruned when a request names none. Volumes hold data
+// and are only pruned when asked for, as with docker system prune.
+var DefaultPruneTypes = []string{TypeContainers, TypeNetworks, TypeImages, TypeBuildCache}
+
+// SystemService interface for dependency injection
+type SystemService interface {
+	Info(ctx context.Context) (dockersystem.Info, error)
+	Version(ctx context.Context) (types.Version, error)
+	DiskUsage(ctx context.Context, verbose bool) (*DiskUsage, error)
+	Prune(ctx context.Context, options PruneOptions) (*PruneReport, error)
+	ListBuildCache(ctx context.Context) (*BuildCache, error)
+	PruneBuildCache(ctx context.Context, options BuildCachePruneOptions) (*types.BuildCachePruneReport, error)
+}
+
+// SystemClient is the part of the Docker client the system service needs
+type SystemClient interface {
+	Info(ctx context.Context) (dockersystem.Info, error)
+	ServerVersion(ctx context.Context) (types.Version, error)
+	DiskUsage(ctx context.Context, options types.DiskUsageOptions) (types.DiskUsage, error)
+	ContainersPrune(ctx context.Context, pruneFilters filters.Args) (container.PruneReport, error)
+	NetworksPrune(ctx context.Context, pruneFilter filters.Args) (network.PruneReport, error)
+	VolumesPrune(ctx context.Context, pruneFilter filters.Args) (volume.PruneReport, error)
+	ImagesPrune(ctx context.Context, pruneFilter filters.Args) (image.PruneReport, error)
+	BuildCachePrune(ctx context.Context, options types.BuildCachePruneOptions) (*types.BuildCachePruneReport, error)
+}
+
+type systemService struct {
+	cli      SystemClient
+	timeouts docker.Timeouts
+}
+
+// UsageSummary is the disk usage of one resource type, like a row of
+// docker system df
+type UsageSummary struct {
+	Total       int   `json:"total"`
+	Active      int   `json:"active"`
+	Size        int64 `json:"size"`
+	Reclaimable int64 `json:"reclaimable"`
+	// ReclaimablePercent is Reclaimable as a share of Size
+	ReclaimablePercent float64 `json:"reclaimablePercent"`
+}
+
+// DiskUsage breaks the host's Docker disk usage down by resource type.
+// Details holds the daemon's per-item report when asked for.
+type DiskUsage struct {
+	Images      UsageSummary     `json:"images"`
+	Containers  UsageSummary     `json:"containers"`
+	Volumes     UsageSummary     `json:"volumes"`
+	BuildCache  UsageSummary     `json:"buildCache"`
+	Size        int64            `json:"size"`
+	Reclaimable int64            `json:"reclaimable"`
+	Details     *types.DiskUsage `json:"details,omitempty"`
+}
+
+// PruneOptions select what a system prune removes
+type PruneOptions struct {
+	// Types are the resource types to prune; DefaultPruneTypes when empty
+	Types []string
+	// All removes every unused image rather than only dangling ones, named
+	// volumes as well as anonymous ones, and all unused build cache
+	All bool
+	// Until removes only resources created before this timestamp or
+	// duration, such as 24h
+	Until string
+	// Labels are label, label=value or label!=value filters. Build cache
+	// records carry no labels, so the build cache is left out of a default
+	// prune with labels, and naming it alongside labels is an error.
+	Labels []string
+}
+
+// PruneReport combines the reports of every pruned type
+type PruneReport struct {
+	Containers     *container.PruneReport       `json:"containers,omitempty"`
+	Networks       *network.PruneReport         `json:"networks,omitempty"`
+	Volumes        *volume.PruneReport          `json:"volumes,omitempty"`
+	Images         *image.PruneReport           `json:"images,omitempty"`
+	BuildCache     *types.BuildCachePruneReport `json:"buildCache,omitempty"`
+	SpaceReclaimed uint64                       `json:"spaceReclaimed"`
+}
+
+// NewSystemService creates a SystemService for the daemon behind cli
+func NewSystemService(cfg config.Config, cli SystemClient) SystemService {
+	return &systemService{cli: cli, timeouts: docker.NewTimeouts(cfg)}
+}
+
+func (s *systemService) Info(ctx context.Context) (dockersystem.Info, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	info, err := s.cli.Info(ctx)
+	if err != nil {
+		return dockersystem.Info{}, fmt.Errorf("failed to get daemon info: %w", err)
+	}
+	return info, nil
+}
+
+func (s *systemService) Version(ctx context.Context) (types.Version, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	version, err := s.cli.ServerVersion(ctx)
+	if err != nil {
+		return types.Version{}, fmt.Errorf("failed to get daemon version: %w", err)
+	}
+	return version, nil
+}
+
+// DiskUsage summarizes disk usage the way docker system df does. Shared
+// image layers are counted once, and only space held by nothing running is
+// reclaimable.
+func (s *systemService) DiskUsage(ctx context.Context, verbose bool) (*DiskUsage, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDF)
+	defer cancel()
+
+	du, err := s.cli.DiskUsage(ctx, types.DiskUsageOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk usage: %w", err)
+	}
+
+	usage := &DiskUsage{}
+	var imagesUsed int64
+	usage.Images.Size = du.LayersSize
+	for _, img := range du.Images {
+		usage.Images.Total++
+		if img.Containers > 0 {
+			usage.Images.Active++
+			if img.Size >= 0 && img.SharedSize >= 0 {
+				imagesUsed += img.Size - img.SharedSize
+			}
+		}
+	}
+	usage.Images.Reclaimable = max(du.LayersSize-imagesUsed, 0)
+
+	for _, c := range du.Containers {
+		usage.Containers.Total++
+		usage.Containers.Size += c.SizeRw
+		if c.State == "running" || c.State == "paused" || c.State == "restarting" {
+			usage.Containers.Active++
+		} else {
+			usage.Containers.Reclaimable += c.SizeRw
+		}
+	}
+
+	for _, v := range du.Volumes {
+		usage.Volumes.Total++
+		if v.UsageData == nil {
+			continue
+		}
+		if v.UsageData.RefCount > 0 {
+			usage.Volumes.Active++
+		}
+		if v.UsageData.Size < 0 {
+			continue
+		}
+		usage.Volumes.Size += v.UsageData.Size
+		if v.UsageData.RefCount == 0 {
+			usage.Volumes.Reclaimable += v.UsageData.Size
+		}
+	}
+
+	for _, record := range du.BuildCache {
+		usage.BuildCache.Total++
+		if record.InUse {
+			usage.BuildCache.Active++
+		}
+		// Shared records are counted with the images that own them
+		if record.Shared {
+			continue
+		}
+		usage.BuildCache.Size += record.Size
+		if !record.InUse {
+			usage.BuildCache.Reclaimable += record.Size
+		}
+	}
+
+	for _, summary := range []*UsageSummary{&usage.Images, &usage.Containers, &usage.Volumes, &usage.BuildCache} {
+		if summary.Size > 0 {
+			summary.ReclaimablePercent = float64(summary.Reclaimable) * 100 / float64(summary.Size)
+		}
+		usage.Size += summary.Size
+		usage.Reclaimable += summary.Reclaimable
+	}
+	if verbose {
+		usage.Details = &du
+	}
+	return usage, nil
+}
+
+// Prune removes unused resources of the selected types. Containers go
+// first so the networks, volumes and images they held can go too.
+func (s *systemService) Prune(ctx context.Context, options PruneOptions) (*PruneReport, error) {
+	selected := map[string]bool{}
+	pruneTypes := options.Types
+	if len(pruneTypes) == 0 {
+		pruneTypes = DefaultPruneTypes
+		if len(options.Labels) > 0 {
+			pruneTypes = slices.DeleteFunc(slices.Clone(pruneTypes), func(t string) bool { return t == TypeBuildCache })
+		}
+	}
+	for _, t := range pruneTypes {
+		switch t {
+		case TypeContainers, TypeImages, TypeNetworks, TypeVolumes, TypeBuildCache:
+			selected[t] = true
+		default:
+			return nil, errdefs.InvalidParameter(fmt.Errorf("unknown resource type %q", t))
+		}
+	}
+	// The daemon rejects until for volumes rather than ignoring it
+	if selected[TypeVolumes] && options.Until != "" {
+		return nil, errdefs.InvalidParameter(fmt.Errorf("until is not supported when pruning volumes"))
+	}
+	// Filtering the build cache by labels would remove all of it
+	if selected[TypeBuildCache] && len(options.Labels) > 0 {
+		return nil, errdefs.InvalidParameter(fmt.Errorf("label is not supported when pruning build cache"))
+	}
+
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpPrune)
+	defer cancel()
+
+	args := filters.NewArgs()
+	if options.Until != "" {
+		args.Add("until", options.Until)
+	}
+	for _, label := range options.Labels {
+		if key, value, negated := strings.Cut(label, "!="); negated {
+			args.Add("label!", key+"="+value)
+		} else {
+			args.Add("label", label)
+		}
+	}
+
+	report := &PruneReport{}
+	if selected[TypeContainers] {
+		containers, err := s.cli.ContainersPrune(ctx, args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prune containers: %w", err)
+		}
+		report.Containers = &containers
+		report.SpaceReclaimed += containers.SpaceReclaimed
+	}
+	if selected[TypeNetworks] {
+		networks, err := s.cli.NetworksPrune(ctx, args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prune networks: %w", err)
+		}
+		report.Networks = &networks
+	}
+	if selected[TypeVolumes] {
+		volumeArgs := args.Clone()
+		if options.All {
+			// Since API 1.42 only anonymous volumes are pruned by default
+			volumeArgs.Add("all", "true")
+		}
+		volumes, err := s.cli.VolumesPrune(ctx, volumeArgs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prune volumes: %w", err)
+		}
+		report.Volumes = &volumes
+		report.SpaceReclaimed += volumes.SpaceReclaimed
+	}
+	if selected[TypeImages] {
+		imageArgs := args.Clone()
+		if options.All {
+			imageArgs.Add("dangling", "false")
+		}
+		images, err := s.cli.ImagesPrune(ctx, imageArgs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prune images: %w", err)
+		}
+		report.Images = &images
+		report.SpaceReclaimed += images.SpaceReclaimed
+	}
+	if selected[TypeBuildCache] {
+		cacheArgs := filters.NewArgs()
+		if options.Until != "" {
+			cacheArgs.Add("until", options.Until)
+		}
+		buildCache, err := s.cli.BuildCachePrune(ctx, types.BuildCachePruneOptions{All: options.All, Filters: cacheArgs})
+		if err != nil {
+			return nil, fmt.Errorf("failed to prune build cache: %w", err)
+		}
+		report.BuildCache = buildCache
+		report.SpaceReclaimed += buildCache.SpaceReclaimed
+	}
+
+	log.Printf("System prune reclaimed %d bytes", report.SpaceReclaimed)
+	return report, nil
+}
@@ -0,0 +1,247 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	dockersystem "github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// fakeClient answers with canned reports and records what the service asks
+// of the daemon
+type fakeClient struct {
+	usage types.DiskUsage
+	// err fails every call
+	err error
+
+	pruned       []string
+	filters      map[string]filters.Args
+	cacheOptions types.BuildCachePruneOptions
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{filters: map[string]filters.Args{}}
+}
+
+func (f *fakeClient) Info(ctx context.Context) (dockersystem.Info, error) {
+	return dockersystem.Info{Name: "docker-host", Containers: 3}, f.err
+}
+
+func (f *fakeClient) ServerVersion(ctx context.Context) (types.Version, error) {
+	return types.Version{Version: "27.3.1", APIVersion: "1.47"}, f.err
+}
+
+func (f *fakeClient) DiskUsage(ctx context.Context, options types.DiskUsageOptions) (types.DiskUsage, error) {
+	return f.usage, f.err
+}
+
+func (f *fakeClient) prune(kind string, args filters.Args) error {
+	f.pruned = append(f.pruned, kind)
+	f.filters[kind] = args
+	return f.err
+}
+
+func (f *fakeClient) ContainersPrune(ctx context.Context, pruneFilters filters.Args) (container.PruneReport, error) {
+	return container.PruneReport{ContainersDeleted: []string{"c1"}, SpaceReclaimed: 1}, f.prune(TypeContainers, pruneFilters)
+}
+
+func (f *fakeClient) NetworksPrune(ctx context.Context, pruneFilter filters.Args) (network.PruneReport, error) {
+	return network.PruneReport{NetworksDeleted: []string{"n1"}}, f.prune(TypeNetworks, pruneFilter)
+}
+
+func (f *fakeClient) VolumesPrune(ctx context.Context, pruneFilter filters.Args) (volume.PruneReport, error) {
+	return volume.PruneReport{VolumesDeleted: []string{"v1"}, SpaceReclaimed: 10}, f.prune(TypeVolumes, pruneFilter)
+}
+
+func (f *fakeClient) ImagesPrune(ctx context.Context, pruneFilter filters.Args) (image.PruneReport, error) {
+	return image.PruneReport{SpaceReclaimed: 100}, f.prune(TypeImages, pruneFilter)
+}
+
+func (f *fakeClient) BuildCachePrune(ctx context.Context, options types.BuildCachePruneOptions) (*types.BuildCachePruneReport, error) {
+	f.cacheOptions = options
+	if err := f.prune(TypeBuildCache, options.Filters); err != nil {
+		return nil, err
+	}
+	return &types.BuildCachePruneReport{CachesDeleted: []string{"b1"}, SpaceReclaimed: 1000}, nil
+}
+
+func TestInfo(t *testing.T) {
+	cli := newFakeClient()
+	service := NewSystemService(config.Config{}, cli)
+	ctx := context.Background()
+
+	info, err := service.Info(ctx)
+	if err != nil || info.Name != "docker-host" {
+		t.Errorf("Info = %+v, %v", info, err)
+	}
+	version, err := service.Version(ctx)
+	if err != nil || version.APIVersion != "1.47" {
+		t.Errorf("Version = %+v, %v", version, err)
+	}
+
+	cli.err = errors.New("connection refused")
+	if _, err := service.Info(ctx); err == nil || err.Error() != "failed to get daemon info: connection refused" {
+		t.Errorf("Info of an unreachable daemon = %v", err)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	cli := newFakeClient()
+	cli.usage = types.DiskUsage{
+		LayersSize: 1000,
+		Images: []*image.Summary{
+			{ID: "used", Size: 600, SharedSize: 100, Containers: 1},
+			{ID: "unused", Size: 400, SharedSize: 100},
+			{ID: "unknown", Size: -1, SharedSize: -1, Containers: 1},
+		},
+		Containers: []*types.Container{
+			{ID: "running", State: "running", SizeRw: 10},
+			{ID: "paused", State: "paused", SizeRw: 5},
+			{ID: "exited", State: "exited", SizeRw: 30},
+		},
+		Volumes: []*volume.Volume{
+			{Name: "used", UsageData: &volume.UsageData{Size: 100, RefCount: 1}},
+			{Name: "unused", UsageData: &volume.UsageData{Size: 50}},
+			{Name: "unmeasured", UsageData: &volume.UsageData{Size: -1}},
+			{Name: "remote"},
+		},
+		BuildCache: []*types.BuildCache{
+			{ID: "in use", Size: 20, InUse: true},
+			{ID: "idle", Size: 30},
+			{ID: "shared", Size: 70, Shared: true},
+		},
+	}
+	service := NewSystemService(config.Config{}, cli)
+
+	usage, err := service.DiskUsage(context.Background(), false)
+	if err != nil {
+		t.Fatalf("DiskUsage: %v", err)
+	}
+	want := map[string]UsageSummary{
+		"images":     {Total: 3, Active: 2, Size: 1000, Reclaimable: 500, ReclaimablePercent: 50},
+		"containers": {Total: 3, Active: 2, Size: 45, Reclaimable: 30, ReclaimablePercent: float64(30) * 100 / 45},
+		"volumes":    {Total: 4, Active: 1, Size: 150, Reclaimable: 50, ReclaimablePercent: float64(50) * 100 / 150},
+		"buildCache": {Total: 3, Active: 1, Size: 50, Reclaimable: 30, ReclaimablePercent: 60},
+	}
+	got := map[string]UsageSummary{"images": usage.Images, "containers": usage.Containers, "volumes": usage.Volumes, "buildCache": usage.BuildCache}
+	for kind, summary := range want {
+		if got[kind] != summary {
+			t.Errorf("%s = %+v, want %+v", kind, got[kind], summary)
+		}
+	}
+	if usage.Size != 1245 || usage.Reclaimable != 610 || usage.Details != nil {
+		t.Errorf("usage = %d bytes, %d reclaimable, details %v", usage.Size, usage.Reclaimable, usage.Details)
+	}
+
+	usage, err = service.DiskUsage(context.Background(), true)
+	if err != nil || usage.Details == nil || len(usage.Details.Images) != 3 {
+		t.Errorf("verbose DiskUsage = %+v, %v", usage, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name    string
+		options PruneOptions
+		pruned  []string
+		space   uint64
+		invalid bool
+		check   func(t *testing.T, cli *fakeClient)
+	}{
+		{
+			name:   "defaults",
+			pruned: []string{TypeContainers, TypeNetworks, TypeImages, TypeBuildCache},
+			space:  1101,
+			check: func(t *testing.T, cli *fakeClient) {
+				if cli.filters[TypeImages].Len() != 0 || cli.cacheOptions.All {
+					t.Errorf("image filters = %v, build cache options = %+v", cli.filters[TypeImages], cli.cacheOptions)
+				}
+			},
+		},
+		{
+			name:    "all",
+			options: PruneOptions{Types: []string{TypeVolumes, TypeImages, TypeBuildCache}, All: true},
+			pruned:  []string{TypeVolumes, TypeImages, TypeBuildCache},
+			space:   1110,
+			check: func(t *testing.T, cli *fakeClient) {
+				if !cli.filters[TypeVolumes].ExactMatch("all", "true") || !cli.filters[TypeImages].ExactMatch("dangling", "false") || !cli.cacheOptions.All {
+					t.Errorf("filters = %v, build cache options = %+v", cli.filters, cli.cacheOptions)
+				}
+			},
+		},
+		{
+			name:    "until",
+			options: PruneOptions{Until: "24h"},
+			pruned:  []string{TypeContainers, TypeNetworks, TypeImages, TypeBuildCache},
+			space:   1101,
+			check: func(t *testing.T, cli *fakeClient) {
+				for _, kind := range cli.pruned {
+					if !cli.filters[kind].ExactMatch("until", "24h") {
+						t.Errorf("%s filters = %v, want until", kind, cli.filters[kind])
+					}
+				}
+			},
+		},
+		{
+			// The build cache has no labels, so a default prune skips it
+			name:    "labels",
+			options: PruneOptions{Labels: []string{"env=dev", "team!=core", "temporary"}},
+			pruned:  []string{TypeContainers, TypeNetworks, TypeImages},
+			space:   101,
+			check: func(t *testing.T, cli *fakeClient) {
+				args := cli.filters[TypeContainers]
+				labels := args.Get("label")
+				slices.Sort(labels)
+				if !slices.Equal(labels, []string{"env=dev", "temporary"}) || !args.ExactMatch("label!", "team=core") {
+					t.Errorf("container filters = %v", args)
+				}
+			},
+		},
+		{name: "labels with build cache", options: PruneOptions{Types: []string{TypeImages, TypeBuildCache}, Labels: []string{"env=dev"}}, invalid: true},
+		{name: "until with volumes", options: PruneOptions{Types: []string{TypeVolumes}, Until: "24h"}, invalid: true},
+		{name: "unknown type", options: PruneOptions{Types: []string{TypeImages, "layers"}}, invalid: true},
+	}
+	for _, tt := range tests {
+		cli := newFakeClient()
+		service := NewSystemService(config.Config{}, cli)
+		report, err := service.Prune(context.Background(), tt.options)
+		if tt.invalid {
+			if !errdefs.IsInvalidParameter(err) || len(cli.pruned) != 0 {
+				t.Errorf("%s: Prune = %v after pruning %v, want an invalid parameter and nothing pruned", tt.name, err, cli.pruned)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Prune: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(cli.pruned, tt.pruned) {
+			t.Errorf("%s: pruned %v, want %v", tt.name, cli.pruned, tt.pruned)
+		}
+		if report.SpaceReclaimed != tt.space {
+			t.Errorf("%s: reclaimed %d, want %d", tt.name, report.SpaceReclaimed, tt.space)
+		}
+		if (report.BuildCache != nil) != slices.Contains(tt.pruned, TypeBuildCache) || (report.Volumes != nil) != slices.Contains(tt.pruned, TypeVolumes) {
+			t.Errorf("%s: report = %+v", tt.name, report)
+		}
+		if tt.check != nil {
+			tt.check(t, cli)
+		}
+	}
+
+	cli := newFakeClient()
+	cli.err = errors.New("a prune operation is already running")
+	if _, err := NewSystemService(config.Config{}, cli).Prune(context.Background(), PruneOptions{}); err == nil || err.Error() != "failed to prune containers: a prune operation is already running" {
+		t.Errorf("Prune with a failing daemon = %v", err)
+	}
+}
@@ -0,0 +1,431 @@
+package volumes
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/docker"
+)
+
+// BackupOptions control a volume backup
+type BackupOptions struct {
+	// StopContainers stops the running containers using the volume for a
+	// consistent copy and starts them again once the archive is written
+	StopContainers bool
+}
+
+// BackupStream is a backup being written. Content is the gzip-compressed tar
+// of the volume's contents; the backup's size and checksum are recorded once
+// it has been read to the end.
+type BackupStream struct {
+	Backup  Backup
+	Content io.ReadCloser
+}
+
+// RestoreOptions control a volume restore
+type RestoreOptions struct {
+	// Backup is the ID of a recorded backup. Without an uploaded archive its
+	// stored archive is restored; with one, the upload must match its checksum.
+	Backup string
+	// SHA256 is the expected checksum of the uploaded archive
+	SHA256 string
+	// Replace empties an existing volume first instead of restoring on top
+	// of its contents
+	Replace bool
+	// StopContainers stops the running containers using the volume during
+	// the restore and starts them again afterwards
+	StopContainers bool
+}
+
+// RestoreResult describes a finished restore
+type RestoreResult struct {
+	Volume    string   `json:"volume"`
+	Created   bool     `json:"created"`
+	Backup    string   `json:"backup,omitempty"`
+	Size      int64    `json:"size"`
+	SHA256    string   `json:"sha256"`
+	Files     int      `json:"files"`
+	Verified  bool     `json:"verified"`
+	Restarted []string `json:"restarted,omitempty"`
+}
+
+// BackupVolume streams a compressed tar of a volume. The archive is read
+// through the daemon's copy API from a helper container that is never
+// started, so no tar binary is needed in the helper image.
+func (s *volumeService) BackupVolume(ctx context.Context, name string, options BackupOptions) (*BackupStream, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpSave)
+	inspect, err := s.cli.VolumeInspect(ctx, name)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to inspect volume: %w", err)
+	}
+
+	restart := func() []string { return nil }
+	if options.StopContainers {
+		if restart, err = s.stopUsers(ctx, name); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	id, err := s.createHelper(ctx, name, true, []string{"true"})
+	if err != nil {
+		restart()
+		cancel()
+		return nil, err
+	}
+	cleanup := func() {
+		s.removeHelper(ctx, id)
+		restart()
+		cancel()
+	}
+
+	archive, _, err := s.cli.CopyFromContainer(ctx, id, mountPoint)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to read volume: %w", err)
+	}
+	file, err := s.catalog.createArchive()
+	if err != nil {
+		archive.Close()
+		cleanup()
+		return nil, err
+	}
+
+	now := time.Now()
+	backup := Backup{
+		ID:        newBackupID(name, now),
+		Volume:    name,
+		Driver:    inspect.Driver,
+		Labels:    inspect.Labels,
+		Source:    s.cli.DaemonHost(),
+		CreatedAt: now.UTC(),
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		err := s.writeBackup(writer, archive, file, &backup)
+		archive.Close()
+		cleanup()
+		if file != nil {
+			// commit renamed it on success; otherwise drop the partial archive
+			os.Remove(file.Name())
+		}
+		if err != nil {
+			log.Printf("Backup of volume %s failed: %v", name, err)
+		} else {
+			log.Printf("Volume %s backed up as %s (%d bytes)", name, backup.ID, backup.Size)
+		}
+		writer.CloseWithError(err)
+	}()
+	return &BackupStream{Backup: backup, Content: reader}, nil
+}
+
+// writeBackup compresses archive to out and the stored file, rewriting the
+// daemon's "volume/..." entry names to be relative to the volume root, then
+// records the backup
+func (s *volumeService) writeBackup(out io.Writer, archive io.Reader, file *os.File, backup *Backup) error {
+	hash := sha256.New()
+	counter := &countingWriter{}
+	writers := []io.Writer{out, hash, counter}
+	if file != nil {
+		defer file.Close()
+		writers = append(writers, file)
+	}
+
+	compressed := gzip.NewWriter(io.MultiWriter(writers...))
+	files, err := rebaseTar(tar.NewWriter(compressed), tar.NewReader(archive), strings.TrimPrefix(mountPoint, "/"))
+	if err != nil {
+		return err
+	}
+	if err := compressed.Close(); err != nil {
+		return err
+	}
+	if file != nil {
+		// Sync before commit renames it, like the metadata written after it
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("failed to store backup archive: %w", err)
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to store backup archive: %w", err)
+		}
+	}
+
+	backup.Size = counter.n
+	backup.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	backup.Files = files
+	return s.catalog.commit(*backup, file)
+}
+
+// rebaseTar copies in to out with the leading root directory stripped from
+// entry and hard link names, dropping the root entry itself. It returns the
+// number of regular files copied.
+func rebaseTar(out *tar.Writer, in *tar.Reader, root string) (int, error) {
+	files := 0
+	for {
+		header, err := in.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return files, fmt.Errorf("failed to read volume archive: %w", err)
+		}
+		name, ok := stripRoot(header.Name, root)
+		if !ok {
+			continue
+		}
+		header.Name = name
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname, _ = stripRoot(header.Linkname, root)
+		}
+		if err := out.WriteHeader(header); err != nil {
+			return files, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(out, in); err != nil {
+				return files, err
+			}
+			files++
+		}
+	}
+	return files, out.Close()
+}
+
+// stripRoot turns "root/a/b" into "a/b"; the root itself reports false
+func stripRoot(name, root string) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(name, "./"), root)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return "", false
+	}
+	rest = strings.TrimPrefix(rest, "/")
+	return rest, rest != ""
+}
+
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// RestoreVolume restores a backup archive into a volume, creating the volume
+// when it does not exist. The archive is spooled to disk and checked first,
+// so a truncated upload or checksum mismatch leaves the volume untouched.
+func (s *volumeService) RestoreVolume(ctx context.Context, name string, archive io.Reader, options RestoreOptions) (*RestoreResult, error) {
+	// The helper is removed even when the load timed out or the caller went
+	// away, like a replacement that has to be undone
+	grace := context.WithoutCancel(ctx)
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpLoad)
+	defer cancel()
+
+	var expected *Backup
+	if options.Backup != "" {
+		backup, err := s.catalog.get(options.Backup)
+		if err != nil {
+			return nil, err
+		}
+		expected = &backup
+	}
+	if archive == nil {
+		if expected == nil {
+			return nil, errdefs.InvalidParameter(errors.New("an archive upload or a backup ID is required"))
+		}
+		_, file, err := s.catalog.open(expected.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		archive = file
+	}
+
+	spool, size, sum, files, err := s.spoolArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	result := &RestoreResult{Volume: name, Size: size, SHA256: sum, Files: files}
+	want := strings.ToLower(options.SHA256)
+	if expected != nil {
+		result.Backup = expected.ID
+		if want != "" && want != expected.SHA256 {
+			return nil, errdefs.InvalidParameter(fmt.Errorf("checksum %s does not match backup %s", want, expected.ID))
+		}
+		want = expected.SHA256
+	}
+	if want != "" {
+		if sum != want {
+			return nil, errdefs.InvalidParameter(fmt.Errorf("archive checksum %s does not match expected %s", sum, want))
+		}
+		result.Verified = true
+	}
+
+	if _, err := s.cli.VolumeInspect(ctx, name); errdefs.IsNotFound(err) {
+		createOptions := volume.CreateOptions{Name: name}
+		if expected != nil {
+			createOptions.Labels = expected.Labels
+		}
+		if _, err := s.cli.VolumeCreate(ctx, createOptions); err != nil {
+			return nil, fmt.Errorf("failed to create volume: %w", err)
+		}
+		result.Created = true
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to inspect volume: %w", err)
+	}
+
+	if options.StopContainers {
+		restart, err := s.stopUsers(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { result.Restarted = restart() }()
+	}
+
+	if options.Replace && !result.Created {
+		if _, err := s.runHelper(ctx, name, false, []string{"find", mountPoint, "-mindepth", "1", "-delete"}); err != nil {
+			return nil, fmt.Errorf("failed to empty volume: %w", err)
+		}
+	}
+
+	id, err := s.createHelper(ctx, name, false, []string{"true"})
+	if err != nil {
+		return nil, err
+	}
+	defer s.removeHelper(grace, id)
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	// The daemon decompresses gzip archives itself
+	if err := s.cli.CopyToContainer(ctx, id, mountPoint, spool, container.CopyToContainerOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to restore volume: %w", err)
+	}
+	log.Printf("Volume %s restored (%d files, verified: %t)", name, files, result.Verified)
+	return result, nil
+}
+
+// spoolArchive copies a gzip-compressed tar to a temporary file, returning
+// its size, checksum and number of regular files. Reading every entry also
+// proves the archive is complete.
+func (s *volumeService) spoolArchive(archive io.Reader) (*os.File, int64, string, int, error) {
+	spool, err := os.CreateTemp(s.catalog.tempDir(), ".restore-*")
+	if err != nil {
+		return nil, 0, "", 0, fmt.Errorf("failed to spool archive: %w", err)
+	}
+	fail := func(err error) (*os.File, int64, string, int, error) {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, 0, "", 0, err
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	source := io.TeeReader(archive, io.MultiWriter(spool, hash, counter))
+	decompressed, err := gzip.NewReader(source)
+	if err != nil {
+		return fail(errdefs.InvalidParameter(fmt.Errorf("archive is not gzip-compressed: %w", err)))
+	}
+	entries := tar.NewReader(decompressed)
+	files := 0
+	for {
+		header, err := entries.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fail(errdefs.InvalidParameter(fmt.Errorf("invalid archive: %w", err)))
+		}
+		if header.Typeflag == tar.TypeReg {
+			files++
+		}
+	}
+	// Drain what follows the tar so the checksum covers the whole upload
+	if _, err := io.Copy(io.Discard, source); err != nil {
+		return fail(fmt.Errorf("failed to spool archive: %w", err))
+	}
+	return spool, counter.n, hex.EncodeToString(hash.Sum(nil)), files, nil
+}
+
+// stopUsers stops the running containers that use a volume. The returned
+// func starts them again and reports which ones it started.
+func (s *volumeService) stopUsers(ctx context.Context, name string) (func() []string, error) {
+	running, err := s.cli.ContainerList(ctx, container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("volume", name), filters.Arg("status", "running")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var stopped []string
+	restart := func() []string {
+		ctx, cancel := s.timeouts.Context(context.WithoutCancel(ctx), docker.OpDefault)
+		defer cancel()
+
+		var started []string
+		for _, id := range stopped {
+			if err := s.cli.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+				log.Printf("Failed to restart container %s after volume %s: %v", id, name, err)
+				continue
+			}
+			started = append(started, id)
+		}
+		return started
+	}
+	for _, c := range running {
+		if _, ok := c.Labels[helperLabel]; ok {
+			continue
+		}
+		if err := s.cli.ContainerStop(ctx, c.ID, container.StopOptions{}); err != nil {
+			restart()
+			return nil, fmt.Errorf("failed to stop container %s: %w", c.ID, err)
+		}
+		stopped = append(stopped, c.ID)
+	}
+	if len(stopped) > 0 {
+		log.Printf("Stopped %d containers using volume %s", len(stopped), name)
+	}
+	return restart, nil
+}
+
+func (s *volumeService) ListBackups(ctx context.Context, name string) ([]Backup, error) {
+	return s.catalog.list(name), nil
+}
+
+func (s *volumeService) GetBackup(ctx context.Context, backupID string) (*Backup, error) {
+	backup, err := s.catalog.get(backupID)
+	if err != nil {
+		return nil, err
+	}
+	return &backup, nil
+}
+
+// OpenBackup opens the stored archive of a backup for download
+func (s *volumeService) OpenBackup(ctx context.Context, backupID string) (*Backup, io.ReadCloser, error) {
+	backup, file, err := s.catalog.open(backupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &backup, file, nil
+}
+
+func (s *volumeService) DeleteBackup(ctx context.Context, backupID string) error {
+	if err := s.catalog.remove(backupID); err != nil {
+		return err
+	}
+	log.Printf("Volume backup %s removed successfully", backupID)
+	return nil
+}
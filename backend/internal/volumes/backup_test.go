@@ -0,0 +1,430 @@
+package volumes
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/pkg/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// entry is one tar entry; a link is a hard link to link
+type entry struct {
+	name    string
+	content string
+	dir     bool
+	link    string
+}
+
+func tarball(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		switch {
+		case e.dir:
+			header.Typeflag, header.Mode, header.Size = tar.TypeDir, 0o755, 0
+		case e.link != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeLink, e.link, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			tw.Write([]byte(e.content))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readTar lists the entries of a gzip-compressed tar
+func readTar(t *testing.T, data []byte) []entry {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []entry
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(tr)
+		entries = append(entries, entry{name: header.Name, content: string(content), dir: header.Typeflag == tar.TypeDir, link: header.Linkname})
+	}
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// volumeArchive is what the daemon's copy API returns for /volume
+func volumeArchive(t *testing.T) []byte {
+	return tarball(t,
+		entry{name: "volume/", dir: true},
+		entry{name: "volume/a.txt", content: "alpha"},
+		entry{name: "volume/sub/", dir: true},
+		entry{name: "volume/sub/b.txt", content: "beta"},
+		entry{name: "volume/link", link: "volume/a.txt"},
+	)
+}
+
+// fakeClient keeps volumes and helper containers in memory and records the
+// archive a restore copies in
+type fakeClient struct {
+	volumes map[string]volume.Volume
+	// archive is the tar CopyFromContainer returns
+	archive []byte
+	running []types.Container
+	// blockCopy holds CopyToContainer until its ctx is done
+	blockCopy bool
+
+	helpers  map[string][]string
+	restored []byte
+	// removed is the state of the ctx of each ContainerRemove
+	removed []error
+	calls   []string
+}
+
+func newFakeClient(t *testing.T) *fakeClient {
+	return &fakeClient{
+		volumes: map[string]volume.Volume{"data": {Name: "data", Driver: "local", Labels: map[string]string{"team": "db"}}},
+		archive: volumeArchive(t),
+		helpers: map[string][]string{},
+	}
+}
+
+func (f *fakeClient) VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+	var list volume.ListResponse
+	for _, v := range f.volumes {
+		list.Volumes = append(list.Volumes, &v)
+	}
+	return list, nil
+}
+
+func (f *fakeClient) VolumeInspect(ctx context.Context, volumeID string) (volume.Volume, error) {
+	v, ok := f.volumes[volumeID]
+	if !ok {
+		return volume.Volume{}, errdefs.NotFound(fmt.Errorf("get %s: no such volume", volumeID))
+	}
+	return v, nil
+}
+
+func (f *fakeClient) VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error) {
+	f.calls = append(f.calls, "create volume "+options.Name)
+	v := volume.Volume{Name: options.Name, Driver: "local", Labels: options.Labels}
+	f.volumes[options.Name] = v
+	return v, nil
+}
+
+func (f *fakeClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	delete(f.volumes, volumeID)
+	return nil
+}
+
+func (f *fakeClient) VolumesPrune(ctx context.Context, pruneFilter filters.Args) (volume.PruneReport, error) {
+	return volume.PruneReport{}, nil
+}
+
+func (f *fakeClient) DiskUsage(ctx context.Context, options types.DiskUsageOptions) (types.DiskUsage, error) {
+	return types.DiskUsage{}, nil
+}
+
+func (f *fakeClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	return types.ImageInspect{ID: "sha256:busybox"}, nil, nil
+}
+
+func (f *fakeClient) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeClient) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	return f.running, nil
+}
+
+func (f *fakeClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	id := fmt.Sprintf("helper-%d", len(f.calls))
+	f.calls = append(f.calls, "create helper "+strings.Join(config.Cmd, " "))
+	f.helpers[id] = config.Cmd
+	return container.CreateResponse{ID: id}, nil
+}
+
+func (f *fakeClient) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	f.calls = append(f.calls, "start "+containerID)
+	return nil
+}
+
+func (f *fakeClient) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	f.calls = append(f.calls, "stop "+containerID)
+	return nil
+}
+
+func (f *fakeClient) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	f.removed = append(f.removed, ctx.Err())
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	delete(f.helpers, containerID)
+	return nil
+}
+
+func (f *fakeClient) ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.WaitResponse, <-chan error) {
+	waitC := make(chan container.WaitResponse, 1)
+	waitC <- container.WaitResponse{}
+	return waitC, make(chan error)
+}
+
+func (f *fakeClient) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeClient) CopyFromContainer(ctx context.Context, containerID, srcPath string) (io.ReadCloser, container.PathStat, error) {
+	return io.NopCloser(bytes.NewReader(f.archive)), container.PathStat{Name: "volume", Mode: os.ModeDir | 0o755}, nil
+}
+
+func (f *fakeClient) CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options container.CopyToContainerOptions) error {
+	f.calls = append(f.calls, "copy to "+dstPath)
+	if f.blockCopy {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	data, err := io.ReadAll(content)
+	f.restored = data
+	return err
+}
+
+func (f *fakeClient) DaemonHost() string {
+	return "unix:///var/run/docker.sock"
+}
+
+func newTestService(t *testing.T, cli *fakeClient) *volumeService {
+	t.Helper()
+	var cfg config.Config
+	cfg.Volumes.BackupDir = t.TempDir()
+	return NewVolumeService(cfg, cli).(*volumeService)
+}
+
+func TestStripRoot(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"volume", "", false},
+		{"volume/", "", false},
+		{"./volume/", "", false},
+		{"volume/a.txt", "a.txt", true},
+		{"./volume/sub/b.txt", "sub/b.txt", true},
+		{"volume/sub/", "sub/", true},
+		{"volumes/a.txt", "", false},
+		{"other/a.txt", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := stripRoot(tt.name, "volume")
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("stripRoot(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRebaseTar(t *testing.T) {
+	in := tarball(t,
+		entry{name: "volume/", dir: true},
+		entry{name: "volume/a.txt", content: "alpha"},
+		entry{name: "./volume/sub/", dir: true},
+		entry{name: "./volume/sub/b.txt", content: "beta"},
+		entry{name: "volume/link", link: "volume/a.txt"},
+		entry{name: "volumes/stray.txt", content: "stray"},
+	)
+	var out bytes.Buffer
+	files, err := rebaseTar(tar.NewWriter(&out), tar.NewReader(bytes.NewReader(in)), "volume")
+	if err != nil {
+		t.Fatalf("rebaseTar: %v", err)
+	}
+	if files != 2 {
+		t.Errorf("files = %d, want 2", files)
+	}
+	got := readTar(t, gzipped(t, out.Bytes()))
+	want := []entry{
+		{name: "a.txt", content: "alpha"},
+		{name: "sub/", dir: true},
+		{name: "sub/b.txt", content: "beta"},
+		{name: "link", link: "a.txt"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("entries = %+v, want %+v", got, want)
+	}
+
+	truncated := in[:700]
+	if _, err := rebaseTar(tar.NewWriter(io.Discard), tar.NewReader(bytes.NewReader(truncated)), "volume"); err == nil {
+		t.Error("rebaseTar accepted a truncated archive")
+	}
+}
+
+func TestSpoolArchive(t *testing.T) {
+	cli := newFakeClient(t)
+	service := newTestService(t, cli)
+	archive := gzipped(t, tarball(t, entry{name: "a.txt", content: "alpha"}, entry{name: "sub/", dir: true}, entry{name: "sub/b.txt", content: "beta"}))
+
+	// Bytes after the tar still count towards the checksum
+	upload := append(slices.Clone(archive), "trailing"...)
+	spool, size, sum, files, err := service.spoolArchive(bytes.NewReader(upload))
+	if err != nil {
+		t.Fatalf("spoolArchive: %v", err)
+	}
+	spooled, _ := os.ReadFile(spool.Name())
+	spool.Close()
+	os.Remove(spool.Name())
+	if size != int64(len(upload)) || sum != checksum(upload) || files != 2 || !bytes.Equal(spooled, upload) {
+		t.Errorf("spoolArchive = %d bytes, %s, %d files", size, sum, files)
+	}
+
+	invalid := map[string][]byte{
+		"truncated":       archive[:len(archive)/2],
+		"not compressed":  tarball(t, entry{name: "a.txt", content: "alpha"}),
+		"not a tar":       gzipped(t, []byte("just some text that is not a tar header")),
+		"empty":           nil,
+		"truncated entry": gzipped(t, tarball(t, entry{name: "a.txt", content: strings.Repeat("x", 2048)})[:1024]),
+	}
+	for name, data := range invalid {
+		if _, _, _, _, err := service.spoolArchive(bytes.NewReader(data)); !errdefs.IsInvalidParameter(err) {
+			t.Errorf("%s: spoolArchive = %v, want an invalid parameter", name, err)
+		}
+	}
+	if spools, _ := filepath.Glob(filepath.Join(service.catalog.tempDir(), ".restore-*")); len(spools) != 0 {
+		t.Errorf("spool files left behind: %v", spools)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	cli := newFakeClient(t)
+	service := newTestService(t, cli)
+	ctx := context.Background()
+
+	stream, err := service.BackupVolume(ctx, "data", BackupOptions{})
+	if err != nil {
+		t.Fatalf("BackupVolume: %v", err)
+	}
+	content, err := io.ReadAll(stream.Content)
+	if err != nil {
+		t.Fatalf("reading the backup: %v", err)
+	}
+	backup, err := service.GetBackup(ctx, stream.Backup.ID)
+	if err != nil {
+		t.Fatalf("GetBackup: %v", err)
+	}
+	if backup.SHA256 != checksum(content) || backup.Size != int64(len(content)) || backup.Files != 2 || !backup.Stored || backup.Labels["team"] != "db" {
+		t.Errorf("backup = %+v", backup)
+	}
+	want := []entry{
+		{name: "a.txt", content: "alpha"},
+		{name: "sub/", dir: true},
+		{name: "sub/b.txt", content: "beta"},
+		{name: "link", link: "a.txt"},
+	}
+	if got := readTar(t, content); !slices.Equal(got, want) {
+		t.Errorf("backup entries = %+v, want %+v", got, want)
+	}
+
+	// The stored archive restores into a new volume with the backup's labels
+	result, err := service.RestoreVolume(ctx, "copy", nil, RestoreOptions{Backup: backup.ID})
+	if err != nil {
+		t.Fatalf("RestoreVolume: %v", err)
+	}
+	if !result.Created || !result.Verified || result.SHA256 != backup.SHA256 || result.Files != 2 || cli.volumes["copy"].Labels["team"] != "db" {
+		t.Errorf("restore = %+v, volume %+v", result, cli.volumes["copy"])
+	}
+	if !bytes.Equal(cli.restored, content) {
+		t.Error("restored archive differs from the backup")
+	}
+
+	// An upload is checked against the backup it claims to be
+	cli.calls, cli.restored = nil, nil
+	if _, err := service.RestoreVolume(ctx, "data", bytes.NewReader(content[:len(content)-10]), RestoreOptions{Backup: backup.ID}); !errdefs.IsInvalidParameter(err) {
+		t.Errorf("RestoreVolume of a truncated upload = %v", err)
+	}
+	other := gzipped(t, tarball(t, entry{name: "a.txt", content: "changed"}))
+	if _, err := service.RestoreVolume(ctx, "data", bytes.NewReader(other), RestoreOptions{Backup: backup.ID}); !errdefs.IsInvalidParameter(err) {
+		t.Errorf("RestoreVolume of a different archive = %v", err)
+	}
+	if _, err := service.RestoreVolume(ctx, "data", bytes.NewReader(content), RestoreOptions{SHA256: strings.Repeat("0", 64)}); !errdefs.IsInvalidParameter(err) {
+		t.Errorf("RestoreVolume with a wrong checksum = %v", err)
+	}
+	if len(cli.calls) != 0 || cli.restored != nil {
+		t.Errorf("a rejected restore touched the daemon: %v", cli.calls)
+	}
+
+	// Replace empties the volume first
+	result, err = service.RestoreVolume(ctx, "data", bytes.NewReader(content), RestoreOptions{SHA256: strings.ToUpper(backup.SHA256), Replace: true})
+	if err != nil {
+		t.Fatalf("RestoreVolume with replace: %v", err)
+	}
+	if result.Created || !result.Verified || !strings.HasPrefix(cli.calls[0], "create helper find /volume -mindepth 1 -delete") {
+		t.Errorf("restore = %+v after %v", result, cli.calls)
+	}
+	if len(cli.helpers) != 0 {
+		t.Errorf("helpers left behind: %v", cli.helpers)
+	}
+}
+
+func TestRestoreRemovesHelperAfterTimeout(t *testing.T) {
+	cli := newFakeClient(t)
+	cli.blockCopy = true
+	cli.running = []types.Container{{ID: "db"}}
+	service := newTestService(t, cli)
+	archive := gzipped(t, tarball(t, entry{name: "a.txt", content: "alpha"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result, err := service.RestoreVolume(ctx, "data", bytes.NewReader(archive), RestoreOptions{StopContainers: true})
+	if !errors.Is(err, context.DeadlineExceeded) || result != nil {
+		t.Fatalf("RestoreVolume = %+v, %v, want the deadline", result, err)
+	}
+	if len(cli.removed) != 1 || cli.removed[0] != nil || len(cli.helpers) != 0 {
+		t.Errorf("helper removal ran on %v, helpers left %v", cli.removed, cli.helpers)
+	}
+	if !slices.Contains(cli.calls, "start db") {
+		t.Errorf("calls = %v, want the stopped container started again", cli.calls)
+	}
+}
@@ -0,0 +1,188 @@
+package volumes
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/docker"
+)
+
+// statFormat is busybox stat's format for one entry: type, size, mtime and
+// name. The name goes last so separators inside it survive parsing.
+const statFormat = "%F|%s|%Y|%n"
+
+// File is one entry of a volume directory
+type File struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Listing is the content of a directory inside a volume
+type Listing struct {
+	Volume  string `json:"volume"`
+	Path    string `json:"path"`
+	Entries []File `json:"entries"`
+}
+
+// Download is a file, or a tar of a directory, read out of a volume. Closing
+// Content removes the helper container it is read from.
+type Download struct {
+	Name    string
+	Size    int64
+	Dir     bool
+	Content io.ReadCloser
+}
+
+// ListFiles lists a directory of a volume without following symlinks
+func (s *volumeService) ListFiles(ctx context.Context, name, dir string) (*Listing, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpExec)
+	defer cancel()
+
+	target := volumePath(dir)
+	output, err := s.runHelper(ctx, name, true, []string{
+		"find", target, "-maxdepth", "1", "-exec", "stat", "-c", statFormat, "{}", "+",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", relativePath(target), err)
+	}
+
+	listing := &Listing{Volume: name, Path: relativePath(target), Entries: []File{}}
+	isDir := false
+	for _, line := range strings.Split(string(output), "\n") {
+		file, ok := parseStat(line)
+		if !ok {
+			continue
+		}
+		if file.Path == target {
+			isDir = file.Type == "dir"
+			continue
+		}
+		file.Path = relativePath(file.Path)
+		listing.Entries = append(listing.Entries, file)
+	}
+	if !isDir {
+		return nil, errdefs.InvalidParameter(fmt.Errorf("%s is not a directory", listing.Path))
+	}
+	sort.Slice(listing.Entries, func(i, j int) bool {
+		return listing.Entries[i].Name < listing.Entries[j].Name
+	})
+	return listing, nil
+}
+
+// parseStat parses one statFormat line; Path is still the helper's path
+func parseStat(line string) (File, bool) {
+	parts := strings.SplitN(line, "|", 4)
+	if len(parts) != 4 {
+		return File{}, false
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return File{}, false
+	}
+	mtime, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return File{}, false
+	}
+	return File{
+		Name:    path.Base(parts[3]),
+		Path:    parts[3],
+		Type:    fileType(parts[0]),
+		Size:    size,
+		ModTime: time.Unix(mtime, 0).UTC(),
+	}, true
+}
+
+// fileType shortens stat's %F descriptions
+func fileType(description string) string {
+	switch description {
+	case "regular file", "regular empty file":
+		return "file"
+	case "directory":
+		return "dir"
+	case "symbolic link":
+		return "symlink"
+	}
+	return "other"
+}
+
+// DownloadFile reads a regular file out of a volume, or a directory as a
+// tar archive. The helper container is created but never started; the
+// daemon mounts the volume for the copy.
+func (s *volumeService) DownloadFile(ctx context.Context, name, filePath string) (*Download, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpSave)
+	id, err := s.createHelper(ctx, name, true, []string{"true"})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	cleanup := func() {
+		s.removeHelper(ctx, id)
+		cancel()
+	}
+
+	target := volumePath(filePath)
+	archive, stat, err := s.cli.CopyFromContainer(ctx, id, target)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to read %s: %w", relativePath(target), err)
+	}
+
+	baseName := path.Base(target)
+	if target == mountPoint {
+		baseName = name
+	}
+	switch {
+	case stat.Mode.IsDir():
+		return &Download{
+			Name:    baseName + ".tar",
+			Size:    -1,
+			Dir:     true,
+			Content: &helperReader{Reader: archive, archive: archive, cleanup: cleanup},
+		}, nil
+	case stat.Mode.IsRegular():
+		reader := tar.NewReader(archive)
+		header, err := reader.Next()
+		if err != nil {
+			archive.Close()
+			cleanup()
+			return nil, fmt.Errorf("failed to read %s: %w", relativePath(target), err)
+		}
+		return &Download{
+			Name:    baseName,
+			Size:    header.Size,
+			Content: &helperReader{Reader: reader, archive: archive, cleanup: cleanup},
+		}, nil
+	}
+	archive.Close()
+	cleanup()
+	return nil, errdefs.InvalidParameter(fmt.Errorf("%s is not a regular file or directory", relativePath(target)))
+}
+
+// helperReader streams from a helper container and removes it on close
+type helperReader struct {
+	io.Reader
+	archive io.Closer
+	cleanup func()
+	closed  bool
+}
+
+func (r *helperReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	err := r.archive.Close()
+	r.cleanup()
+	return err
+}
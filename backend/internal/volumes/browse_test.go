@@ -0,0 +1,73 @@
+package volumes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVolumePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "/volume"},
+		{"/", "/volume"},
+		{"data/db", "/volume/data/db"},
+		{"/data/db/", "/volume/data/db"},
+		{"..", "/volume"},
+		{"../../etc/passwd", "/volume/etc/passwd"},
+		{"/data/../../../etc", "/volume/etc"},
+		{"data/./../logs", "/volume/logs"},
+		{"..data", "/volume/..data"},
+	}
+	for _, tt := range tests {
+		if got := volumePath(tt.path); got != tt.want {
+			t.Errorf("volumePath(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseStat(t *testing.T) {
+	tests := []struct {
+		line string
+		want File
+		ok   bool
+	}{
+		{
+			line: "regular file|1024|1700000000|/volume/data/app.log",
+			want: File{Name: "app.log", Path: "/volume/data/app.log", Type: "file", Size: 1024, ModTime: time.Unix(1700000000, 0).UTC()},
+			ok:   true,
+		},
+		{
+			line: "regular empty file|0|1700000000|/volume/empty",
+			want: File{Name: "empty", Path: "/volume/empty", Type: "file", ModTime: time.Unix(1700000000, 0).UTC()},
+			ok:   true,
+		},
+		{
+			line: "directory|4096|1700000000|/volume",
+			want: File{Name: "volume", Path: "/volume", Type: "dir", Size: 4096, ModTime: time.Unix(1700000000, 0).UTC()},
+			ok:   true,
+		},
+		{
+			// Separators in the name survive, since it is the last field
+			line: "symbolic link|7|1700000000|/volume/a|b|c",
+			want: File{Name: "a|b|c", Path: "/volume/a|b|c", Type: "symlink", Size: 7, ModTime: time.Unix(1700000000, 0).UTC()},
+			ok:   true,
+		},
+		{
+			line: "fifo|0|1700000000|/volume/pipe",
+			want: File{Name: "pipe", Path: "/volume/pipe", Type: "other", ModTime: time.Unix(1700000000, 0).UTC()},
+			ok:   true,
+		},
+		{line: ""},
+		{line: "directory|4096|/volume"},
+		{line: "directory|big|1700000000|/volume"},
+		{line: "directory|4096|yesterday|/volume"},
+	}
+	for _, tt := range tests {
+		got, ok := parseStat(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseStat(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package volumes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+
+	"github.com/genc-murat/harborview/internal/fsutil"
+)
+
+// Backup is the metadata of one volume backup. SHA256 and Size describe the
+// compressed archive, so a restore can check it got the same bytes.
+type Backup struct {
+	ID        string            `json:"id"`
+	Volume    string            `json:"volume"`
+	Driver    string            `json:"driver"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Source    string            `json:"source"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Size      int64             `json:"size"`
+	SHA256    string            `json:"sha256"`
+	Files     int               `json:"files"`
+	Stored    bool              `json:"stored"`
+}
+
+// backupCatalog keeps backup metadata, and archives when dir is set, as
+// dir/<id>.json and dir/<id>.tar.gz. Without dir metadata lives in memory.
+type backupCatalog struct {
+	dir string
+
+	mu      sync.Mutex
+	backups map[string]Backup
+}
+
+var (
+	catalogsMu sync.Mutex
+	catalogs   = map[string]*backupCatalog{}
+)
+
+// sharedCatalog returns the catalog of dir, shared by every endpoint's
+// service so backups taken on one host can be restored on another
+func sharedCatalog(dir string) *backupCatalog {
+	catalogsMu.Lock()
+	defer catalogsMu.Unlock()
+	catalog, ok := catalogs[dir]
+	if !ok {
+		catalog = &backupCatalog{dir: dir, backups: make(map[string]Backup)}
+		if err := catalog.load(); err != nil {
+			log.Printf("Failed to load volume backups from %s: %v", dir, err)
+		}
+		catalogs[dir] = catalog
+	}
+	return catalog
+}
+
+func (c *backupCatalog) load() error {
+	if c.dir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var backup Backup
+		if err := json.Unmarshal(data, &backup); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		c.backups[backup.ID] = backup
+	}
+	return nil
+}
+
+// newBackupID names a backup after its volume and time, with a random
+// suffix so two backups in the same second do not collide
+func newBackupID(volumeName string, now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s-%s", volumeName, now.UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+}
+
+// list returns the backups of volumeName, or all of them, newest first
+func (c *backupCatalog) list(volumeName string) []Backup {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	backups := []Backup{}
+	for _, backup := range c.backups {
+		if volumeName == "" || backup.Volume == volumeName {
+			backups = append(backups, backup)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups
+}
+
+func (c *backupCatalog) get(id string) (Backup, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	backup, ok := c.backups[id]
+	if !ok {
+		return Backup{}, errdefs.NotFound(fmt.Errorf("backup %s not found", id))
+	}
+	return backup, nil
+}
+
+// createArchive opens a temporary file for a backup archive; commit moves it
+// into place. It returns nil without a directory.
+func (c *backupCatalog) createArchive() (*os.File, error) {
+	if c.dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(c.dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	file, err := os.CreateTemp(c.dir, ".backup-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup archive: %w", err)
+	}
+	return file, nil
+}
+
+// commit records backup, moving its archive into place when there is one.
+// The archive goes first so stored metadata never points at a missing file.
+func (c *backupCatalog) commit(backup Backup, archive *os.File) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dir == "" {
+		c.backups[backup.ID] = backup
+		return nil
+	}
+
+	if archive != nil {
+		if err := os.Rename(archive.Name(), c.archivePath(backup.ID)); err != nil {
+			return fmt.Errorf("failed to store backup archive: %w", err)
+		}
+		backup.Stored = true
+	}
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(c.metadataPath(backup.ID), data); err != nil {
+		return fmt.Errorf("failed to store backup metadata: %w", err)
+	}
+	c.backups[backup.ID] = backup
+	return nil
+}
+
+// open returns the stored archive of a backup
+func (c *backupCatalog) open(id string) (Backup, *os.File, error) {
+	backup, err := c.get(id)
+	if err != nil {
+		return Backup{}, nil, err
+	}
+	if !backup.Stored {
+		return Backup{}, nil, errdefs.NotFound(fmt.Errorf("backup %s has no stored archive", id))
+	}
+	file, err := os.Open(c.archivePath(id))
+	if err != nil {
+		return Backup{}, nil, fmt.Errorf("failed to open backup archive: %w", err)
+	}
+	return backup, file, nil
+}
+
+func (c *backupCatalog) remove(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.backups[id]; !ok {
+		return errdefs.NotFound(fmt.Errorf("backup %s not found", id))
+	}
+	if c.dir != "" {
+		for _, path := range []string{c.metadataPath(id), c.archivePath(id)} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove backup: %w", err)
+			}
+		}
+	}
+	delete(c.backups, id)
+	return nil
+}
+
+// tempDir is where uploads are spooled before a restore
+func (c *backupCatalog) tempDir() string {
+	if c.dir != "" {
+		return c.dir
+	}
+	return os.TempDir()
+}
+
+func (c *backupCatalog) metadataPath(id string) string {
+	return filepath.Join(c.dir, id+".json")
+}
+
+func (c *backupCatalog) archivePath(id string) string {
+	return filepath.Join(c.dir, id+".tar.gz")
+}
@@ -0,0 +1,107 @@
+package volumes
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+func TestCatalog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+	catalog := sharedCatalog(dir)
+	if sharedCatalog(dir) != catalog {
+		t.Error("sharedCatalog returned a second catalog for the same directory")
+	}
+
+	now := time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)
+	commit := func(volume string, age time.Duration, content string) Backup {
+		t.Helper()
+		file, err := catalog.createArchive()
+		if err != nil {
+			t.Fatalf("createArchive: %v", err)
+		}
+		if _, err := file.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		backup := Backup{ID: newBackupID(volume, now.Add(-age)), Volume: volume, CreatedAt: now.Add(-age)}
+		if err := catalog.commit(backup, file); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		return backup
+	}
+	older := commit("data", time.Hour, "older")
+	newer := commit("data", time.Minute, "newer")
+	other := commit("logs", 0, "logs")
+
+	if !strings.HasPrefix(older.ID, "data-20260201T090000Z-") || older.ID == newBackupID("data", now.Add(-time.Hour)) {
+		t.Errorf("backup ID = %s, want the volume, time and a random suffix", older.ID)
+	}
+	ids := func(backups []Backup) []string {
+		var ids []string
+		for _, backup := range backups {
+			ids = append(ids, backup.ID)
+		}
+		return ids
+	}
+	if got := ids(catalog.list("data")); len(got) != 2 || got[0] != newer.ID || got[1] != older.ID {
+		t.Errorf("list(data) = %v, want newest first", got)
+	}
+	if got := catalog.list(""); len(got) != 3 || got[0].ID != other.ID {
+		t.Errorf("list() = %v", ids(got))
+	}
+
+	backup, file, err := catalog.open(newer.ID)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	data, _ := io.ReadAll(file)
+	file.Close()
+	if !backup.Stored || string(data) != "newer" {
+		t.Errorf("open = %+v with %q", backup, data)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(dir, ".backup-*")); len(temps) != 0 {
+		t.Errorf("temporary archives left behind: %v", temps)
+	}
+
+	// A new catalog of the directory finds the same backups
+	reloaded := &backupCatalog{dir: dir, backups: make(map[string]Backup)}
+	if err := reloaded.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got, err := reloaded.get(older.ID); err != nil || got.Volume != "data" || !got.CreatedAt.Equal(older.CreatedAt) || !got.Stored {
+		t.Errorf("reloaded get = %+v, %v", got, err)
+	}
+
+	if err := catalog.remove(older.ID); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	for _, path := range []string{catalog.metadataPath(older.ID), catalog.archivePath(older.ID)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after remove", path)
+		}
+	}
+	if _, err := catalog.get(older.ID); !errdefs.IsNotFound(err) {
+		t.Errorf("get of a removed backup = %v", err)
+	}
+	if err := catalog.remove(older.ID); !errdefs.IsNotFound(err) {
+		t.Errorf("second remove = %v", err)
+	}
+
+	// Without a directory backups are recorded but there is nothing to open
+	memory := &backupCatalog{backups: make(map[string]Backup)}
+	if file, err := memory.createArchive(); file != nil || err != nil {
+		t.Errorf("createArchive without a directory = %v, %v", file, err)
+	}
+	if err := memory.commit(Backup{ID: "data-1", Volume: "data"}, nil); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if _, _, err := memory.open("data-1"); !errdefs.IsNotFound(err) {
+		t.Errorf("open without an archive = %v, want not found", err)
+	}
+}
@@ -0,0 +1,297 @@
+package volumes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/httputil"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterRoutes registers all routes for volumes on the shared client
+func RegisterRoutes(app *fiber.App, cfg config.Config, cli *client.Client) {
+	// Initialize the service
+	service := NewVolumeService(cfg, cli)
+
+	MountRoutes(app.Group("/volumes"), func(*fiber.Ctx) (VolumeService, error) {
+		return service, nil
+	})
+}
+
+// MountRoutes registers the volume routes on volumesGroup. lookup picks the
+// service each request runs against; a *fiber.Error from it sets the status.
+func MountRoutes(volumesGroup fiber.Router, lookup func(c *fiber.Ctx) (VolumeService, error)) {
+	handle := httputil.Bind(lookup)
+
+	// List volumes with size and reference count, optionally filtered by
+	// ?name=, ?driver=, ?label= and ?dangling=
+	volumesGroup.Get("/", handle(ListVolumes))
+
+	// Create a volume
+	volumesGroup.Post("/", handle(CreateVolume))
+
+	// Prune unused volumes
+	volumesGroup.Post("/prune", handle(PruneVolumes))
+
+	// Inspect a specific volume
+	volumesGroup.Get("/:name", handle(InspectVolume))
+
+	// Remove a volume
+	volumesGroup.Delete("/:name", handle(RemoveVolume))
+
+	// List the containers using a volume
+	volumesGroup.Get("/:name/containers", handle(VolumeUsers))
+
+	// List a directory inside a volume
+	volumesGroup.Get("/:name/files", handle(ListFiles))
+
+	// Download a file, or a directory as tar, from a volume
+	volumesGroup.Get("/:name/download", handle(DownloadFile))
+
+	// Stream a compressed backup of a volume
+	volumesGroup.Post("/:name/backup", handle(BackupVolume))
+
+	// Restore an uploaded or stored backup into a volume
+	volumesGroup.Post("/:name/restore", handle(RestoreVolume))
+
+	// List the recorded backups of a volume
+	volumesGroup.Get("/:name/backups", handle(ListBackups))
+
+	// Get the metadata of a backup
+	volumesGroup.Get("/:name/backups/:backup", handle(GetBackup))
+
+	// Download the stored archive of a backup
+	volumesGroup.Get("/:name/backups/:backup/download", handle(DownloadBackup))
+
+	// Delete a backup and its stored archive
+	volumesGroup.Delete("/:name/backups/:backup", handle(DeleteBackup))
+}
+
+// volumeError maps daemon and validation errors to statuses, so a missing
+// volume is 404, removing one that is in use is 409 and an upload over the
+// body limit is 413
+func volumeError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, fiber.ErrRequestEntityTooLarge):
+		status = fiber.StatusRequestEntityTooLarge
+	case errdefs.IsInvalidParameter(err):
+		status = fiber.StatusBadRequest
+	case errdefs.IsNotFound(err):
+		status = fiber.StatusNotFound
+	case errdefs.IsConflict(err):
+		status = fiber.StatusConflict
+	case errdefs.IsForbidden(err):
+		status = fiber.StatusForbidden
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
+
+// queryFilters builds daemon filters from the given query parameters. Each
+// may repeat, and label takes key or key=value.
+func queryFilters(c *fiber.Ctx, keys ...string) filters.Args {
+	args := filters.NewArgs()
+	for _, key := range keys {
+		for _, value := range c.Context().QueryArgs().PeekMulti(key) {
+			if v := strings.TrimSpace(string(value)); v != "" {
+				args.Add(key, v)
+			}
+		}
+	}
+	return args
+}
+
+// ListVolumes handler for fetching volumes
+func ListVolumes(c *fiber.Ctx, service VolumeService) error {
+	volumes, err := service.ListVolumes(c.UserContext(), queryFilters(c, "name", "driver", "label", "dangling"))
+	if err != nil {
+		return volumeError(c, err)
+	}
+	return c.JSON(volumes)
+}
+
+// InspectVolume handler for inspecting a specific volume
+func InspectVolume(c *fiber.Ctx, service VolumeService) error {
+	volume, err := service.InspectVolume(c.UserContext(), c.Params("name"))
+	if err != nil {
+		return volumeError(c, err)
+	}
+	return c.JSON(volume)
+}
+
+// CreateVolume handler for creating a volume. An empty name creates an
+// anonymous volume.
+func CreateVolume(c *fiber.Ctx, service VolumeService) error {
+	var request CreateRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	volume, err := service.CreateVolume(c.UserContext(), request)
+	if err != nil {
+		return volumeError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(volume)
+}
+
+// RemoveVolume handler for deleting a volume
+func RemoveVolume(c *fiber.Ctx, service VolumeService) error {
+	force := c.QueryBool("force", false) // Default: do not force
+	if err := service.RemoveVolume(c.UserContext(), c.Params("name"), force); err != nil {
+		return volumeError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Volume removed successfully"})
+}
+
+// PruneVolumes handler for pruning unused volumes. ?all=true includes named
+// volumes; ?label= limits the prune.
+func PruneVolumes(c *fiber.Ctx, service VolumeService) error {
+	report, err := service.PruneVolumes(c.UserContext(), queryFilters(c, "all", "label"))
+	if err != nil {
+		return volumeError(c, err)
+	}
+	return c.JSON(report)
+}
+
+// VolumeUsers handler for listing the containers that mount a volume
+func VolumeUsers(c *fiber.Ctx, service VolumeService) error {
+	containers, err := service.VolumeUsers(c.UserContext(), c.Params("name"))
+	if err != nil {
+		return volumeError(c, err)
+	}
+	return c.JSON(containers)
+}
+
+// ListFiles handler for browsing a directory of a volume, given by ?path=
+func ListFiles(c *fiber.Ctx, service VolumeService) error {
+	listing, err := service.ListFiles(c.UserContext(), c.Params("name"), c.Query("path", "/"))
+	if err != nil {
+		return volumeError(c, err)
+	}
+	return c.JSON(listing)
+}
+
+// DownloadFile handler for downloading the file or directory at ?path=
+func DownloadFile(c *fiber.Ctx, service VolumeService) error {
+	// The file is sent after the handler returns, so the copy runs on the
+	// request's own context rather than UserContext
+	download, err := service.DownloadFile(c.Context(), c.Params("name"), c.Query("path", "/"))
+	if err != nil {
+		return volumeError(c, err)
+	}
+
+	// Attachment picks the content type from the extension
+	c.Attachment(download.Name)
+	if path.Ext(download.Name) == "" {
+		c.Type("bin")
+	}
+	return c.SendStream(download.Content, int(download.Size))
+}
+
+// BackupVolume handler for streaming a .tar.gz backup of a volume.
+// ?stopContainers=true stops the volume's running containers meanwhile. The
+// backup ID is sent in the X-Backup-Id header; its checksum is recorded once
+// the download completes.
+func BackupVolume(c *fiber.Ctx, service VolumeService) error {
+	options := BackupOptions{StopContainers: c.QueryBool("stopContainers", false)}
+	// Streamed after the handler returns, like DownloadFile
+	stream, err := service.BackupVolume(c.Context(), c.Params("name"), options)
+	if err != nil {
+		return volumeError(c, err)
+	}
+
+	c.Set("X-Backup-Id", stream.Backup.ID)
+	c.Attachment(stream.Backup.ID + ".tar.gz")
+	return c.SendStream(stream.Content)
+}
+
+// RestoreVolume handler for restoring a volume from the .tar.gz in the
+// request body, or from the stored archive of ?backup= when there is none.
+// ?sha256= or ?backup= make the restore fail unless the checksum matches;
+// ?replace=true empties the volume first and ?stopContainers=true stops its
+// running containers meanwhile.
+func RestoreVolume(c *fiber.Ctx, service VolumeService) error {
+	upload, err := httputil.Upload(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	var archive io.Reader
+	if upload != nil {
+		defer upload.Close()
+		archive = upload
+	}
+
+	result, err := service.RestoreVolume(c.UserContext(), c.Params("name"), archive, RestoreOptions{
+		Backup:         c.Query("backup"),
+		SHA256:         c.Query("sha256"),
+		Replace:        c.QueryBool("replace", false),
+		StopContainers: c.QueryBool("stopContainers", false),
+	})
+	if err != nil {
+		return volumeError(c, err)
+	}
+	return c.JSON(result)
+}
+
+// ListBackups handler for listing a volume's backups, newest first
+func ListBackups(c *fiber.Ctx, service VolumeService) error {
+	backups, err := service.ListBackups(c.UserContext(), c.Params("name"))
+	if err != nil {
+		return volumeError(c, err)
+	}
+	return c.JSON(backups)
+}
+
+// GetBackup handler for fetching the metadata of a backup
+func GetBackup(c *fiber.Ctx, service VolumeService) error {
+	backup, err := volumeBackup(c, service)
+	if err != nil {
+		return volumeError(c, err)
+	}
+	return c.JSON(backup)
+}
+
+// DownloadBackup handler for downloading a stored backup archive
+func DownloadBackup(c *fiber.Ctx, service VolumeService) error {
+	if _, err := volumeBackup(c, service); err != nil {
+		return volumeError(c, err)
+	}
+	backup, archive, err := service.OpenBackup(c.UserContext(), c.Params("backup"))
+	if err != nil {
+		return volumeError(c, err)
+	}
+
+	c.Set("X-Backup-Sha256", backup.SHA256)
+	c.Attachment(backup.ID + ".tar.gz")
+	return c.SendStream(archive, int(backup.Size))
+}
+
+// DeleteBackup handler for deleting a backup
+func DeleteBackup(c *fiber.Ctx, service VolumeService) error {
+	if _, err := volumeBackup(c, service); err != nil {
+		return volumeError(c, err)
+	}
+	if err := service.DeleteBackup(c.UserContext(), c.Params("backup")); err != nil {
+		return volumeError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Backup deleted successfully"})
+}
+
+// volumeBackup looks up the :backup of the :name volume. Backups of other
+// volumes are not found, even though any backup can be restored anywhere.
+func volumeBackup(c *fiber.Ctx, service VolumeService) (*Backup, error) {
+	backup, err := service.GetBackup(c.UserContext(), c.Params("backup"))
+	if err != nil {
+		return nil, err
+	}
+	if backup.Volume != c.Params("name") {
+		return nil, errdefs.NotFound(fmt.Errorf("backup %s not found", backup.ID))
+	}
+	return backup, nil
+}
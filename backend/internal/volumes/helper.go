@@ -0,0 +1,163 @@
+package volumes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/genc-murat/harborview/internal/docker"
+)
+
+const (
+	// mountPoint is where helper containers mount the volume
+	mountPoint = "/volume"
+	// helperLabel marks helper containers with the volume they work on
+	helperLabel = "io.harborview.volume-helper"
+)
+
+// HelperError is a helper command that exited non-zero
+type HelperError struct {
+	ExitCode int64
+	Stderr   string
+}
+
+func (e *HelperError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("helper container exited with code %d", e.ExitCode)
+	}
+	return fmt.Sprintf("helper container exited with code %d: %s", e.ExitCode, e.Stderr)
+}
+
+// volumePath maps a path inside the volume to the helper's mount. Cleaning
+// it as an absolute path keeps ".." from leaving the mount.
+func volumePath(p string) string {
+	return path.Join(mountPoint, path.Clean("/"+p))
+}
+
+// relativePath is the inverse of volumePath
+func relativePath(p string) string {
+	if rel := strings.TrimPrefix(p, mountPoint); rel != "" {
+		return rel
+	}
+	return "/"
+}
+
+// ensureHelperImage pulls the helper image unless it is already present
+func (s *volumeService) ensureHelperImage(ctx context.Context) error {
+	_, _, err := s.cli.ImageInspectWithRaw(ctx, s.helperImage)
+	if err == nil {
+		return nil
+	}
+	if !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to inspect helper image: %w", err)
+	}
+
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpPull)
+	defer cancel()
+
+	log.Printf("Pulling volume helper image %s", s.helperImage)
+	progress, err := s.cli.ImagePull(ctx, s.helperImage, image.PullOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to pull helper image: %w", err)
+	}
+	defer progress.Close()
+	if err := jsonmessage.DisplayJSONMessagesStream(progress, io.Discard, 0, false, nil); err != nil {
+		return fmt.Errorf("failed to pull helper image: %w", err)
+	}
+	return nil
+}
+
+// createHelper creates a container with the volume mounted at mountPoint.
+// The volume must exist; Docker would silently create a missing one.
+func (s *volumeService) createHelper(ctx context.Context, volumeName string, readOnly bool, cmd []string) (string, error) {
+	if _, err := s.cli.VolumeInspect(ctx, volumeName); err != nil {
+		return "", fmt.Errorf("failed to inspect volume: %w", err)
+	}
+	if err := s.ensureHelperImage(ctx); err != nil {
+		return "", err
+	}
+
+	created, err := s.cli.ContainerCreate(ctx, &container.Config{
+		Image:  s.helperImage,
+		Cmd:    cmd,
+		Labels: map[string]string{helperLabel: volumeName},
+	}, &container.HostConfig{
+		NetworkMode: "none",
+		Mounts: []mount.Mount{{
+			Type:     mount.TypeVolume,
+			Source:   volumeName,
+			Target:   mountPoint,
+			ReadOnly: readOnly,
+		}},
+	}, nil, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to create helper container: %w", err)
+	}
+	return created.ID, nil
+}
+
+// removeHelper removes a helper container, even when ctx is already done
+func (s *volumeService) removeHelper(ctx context.Context, id string) {
+	ctx, cancel := s.timeouts.Context(context.WithoutCancel(ctx), docker.OpDefault)
+	defer cancel()
+
+	if err := s.cli.ContainerRemove(ctx, id, container.RemoveOptions{Force: true}); err != nil {
+		log.Printf("Failed to remove volume helper container %s: %v", id, err)
+	}
+}
+
+// runHelper runs cmd in a helper container that mounts the volume and
+// returns its stdout. A non-zero exit is a *HelperError with stderr.
+func (s *volumeService) runHelper(ctx context.Context, volumeName string, readOnly bool, cmd []string) ([]byte, error) {
+	id, err := s.createHelper(ctx, volumeName, readOnly, cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer s.removeHelper(ctx, id)
+
+	// Wait must be registered before start or a fast exit is missed
+	waitC, errC := s.cli.ContainerWait(ctx, id, container.WaitConditionNextExit)
+	if err := s.cli.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to start helper container: %w", err)
+	}
+	var exitCode int64
+	select {
+	case result := <-waitC:
+		if result.Error != nil {
+			return nil, fmt.Errorf("helper container failed: %s", result.Error.Message)
+		}
+		exitCode = result.StatusCode
+	case err := <-errC:
+		return nil, fmt.Errorf("failed to wait for helper container: %w", err)
+	}
+
+	logs, err := s.cli.ContainerLogs(ctx, id, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read helper output: %w", err)
+	}
+	defer logs.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, logs); err != nil {
+		return nil, fmt.Errorf("failed to read helper output: %w", err)
+	}
+	if exitCode != 0 {
+		message := strings.TrimSpace(stderr.String())
+		if strings.Contains(message, "No such file or directory") {
+			return nil, errdefs.NotFound(&HelperError{ExitCode: exitCode, Stderr: message})
+		}
+		return nil, &HelperError{ExitCode: exitCode, Stderr: message}
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,196 @@
+package volumes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/pkg/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DefaultHelperImage runs the helper containers when config sets none
+const DefaultHelperImage = "busybox:latest"
+
+// VolumeService interface for dependency injection
+type VolumeService interface {
+	ListVolumes(ctx context.Context, filter filters.Args) ([]*volume.Volume, error)
+	InspectVolume(ctx context.Context, name string) (*volume.Volume, error)
+	CreateVolume(ctx context.Context, request CreateRequest) (*volume.Volume, error)
+	RemoveVolume(ctx context.Context, name string, force bool) error
+	PruneVolumes(ctx context.Context, filter filters.Args) (volume.PruneReport, error)
+	VolumeUsers(ctx context.Context, name string) ([]types.Container, error)
+	ListFiles(ctx context.Context, name, dir string) (*Listing, error)
+	DownloadFile(ctx context.Context, name, path string) (*Download, error)
+	BackupVolume(ctx context.Context, name string, options BackupOptions) (*BackupStream, error)
+	RestoreVolume(ctx context.Context, name string, archive io.Reader, options RestoreOptions) (*RestoreResult, error)
+	ListBackups(ctx context.Context, name string) ([]Backup, error)
+	GetBackup(ctx context.Context, backupID string) (*Backup, error)
+	OpenBackup(ctx context.Context, backupID string) (*Backup, io.ReadCloser, error)
+	DeleteBackup(ctx context.Context, backupID string) error
+}
+
+// VolumeClient is the part of the Docker client the volume service needs
+type VolumeClient interface {
+	VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error)
+	VolumeInspect(ctx context.Context, volumeID string) (volume.Volume, error)
+	VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error)
+	VolumeRemove(ctx context.Context, volumeID string, force bool) error
+	VolumesPrune(ctx context.Context, pruneFilter filters.Args) (volume.PruneReport, error)
+	DiskUsage(ctx context.Context, options types.DiskUsageOptions) (types.DiskUsage, error)
+	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+	ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error)
+	ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error)
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error
+	ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error
+	ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.WaitResponse, <-chan error)
+	ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error)
+	CopyFromContainer(ctx context.Context, containerID, srcPath string) (io.ReadCloser, container.PathStat, error)
+	CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options container.CopyToContainerOptions) error
+	DaemonHost() string
+}
+
+type volumeService struct {
+	cli         VolumeClient
+	timeouts    docker.Timeouts
+	helperImage string
+	catalog     *backupCatalog
+}
+
+// CreateRequest is the body of a volume create call
+type CreateRequest struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	DriverOpts map[string]string `json:"driverOpts,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// NewVolumeService creates a VolumeService for the daemon behind cli
+func NewVolumeService(cfg config.Config, cli VolumeClient) VolumeService {
+	helperImage := cfg.Volumes.HelperImage
+	if helperImage == "" {
+		helperImage = DefaultHelperImage
+	}
+	return &volumeService{
+		cli:         cli,
+		timeouts:    docker.NewTimeouts(cfg),
+		helperImage: helperImage,
+		catalog:     sharedCatalog(cfg.Volumes.BackupDir),
+	}
+}
+
+// ListVolumes lists volumes with their size and reference count from the
+// daemon's disk usage. Volumes are still listed when disk usage fails, e.g.
+// because another df is already running, just without usage data.
+func (s *volumeService) ListVolumes(ctx context.Context, filter filters.Args) ([]*volume.Volume, error) {
+	listCtx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	response, err := s.cli.VolumeList(listCtx, volume.ListOptions{Filters: filter})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	for _, warning := range response.Warnings {
+		log.Printf("Volume list warning: %s", warning)
+	}
+
+	dfCtx, cancel := s.timeouts.Context(ctx, docker.OpDF)
+	defer cancel()
+
+	usage, err := s.cli.DiskUsage(dfCtx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if err != nil {
+		log.Printf("Failed to get volume disk usage: %v", err)
+		return response.Volumes, nil
+	}
+	byName := make(map[string]*volume.UsageData, len(usage.Volumes))
+	for _, v := range usage.Volumes {
+		byName[v.Name] = v.UsageData
+	}
+	for _, v := range response.Volumes {
+		if data, ok := byName[v.Name]; ok {
+			v.UsageData = data
+		}
+	}
+	return response.Volumes, nil
+}
+
+func (s *volumeService) InspectVolume(ctx context.Context, name string) (*volume.Volume, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	inspect, err := s.cli.VolumeInspect(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect volume: %w", err)
+	}
+	return &inspect, nil
+}
+
+func (s *volumeService) CreateVolume(ctx context.Context, request CreateRequest) (*volume.Volume, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	created, err := s.cli.VolumeCreate(ctx, volume.CreateOptions{
+		Name:       request.Name,
+		Driver:     request.Driver,
+		DriverOpts: request.DriverOpts,
+		Labels:     request.Labels,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create volume: %w", err)
+	}
+	log.Printf("Volume %s created successfully", created.Name)
+	return &created, nil
+}
+
+func (s *volumeService) RemoveVolume(ctx context.Context, name string, force bool) error {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	if err := s.cli.VolumeRemove(ctx, name, force); err != nil {
+		return fmt.Errorf("failed to remove volume: %w", err)
+	}
+	log.Printf("Volume %s removed successfully", name)
+	return nil
+}
+
+// PruneVolumes removes unused volumes. Daemons since API 1.42 only prune
+// anonymous volumes unless filter has all=true.
+func (s *volumeService) PruneVolumes(ctx context.Context, filter filters.Args) (volume.PruneReport, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpPrune)
+	defer cancel()
+
+	report, err := s.cli.VolumesPrune(ctx, filter)
+	if err != nil {
+		return volume.PruneReport{}, fmt.Errorf("failed to prune volumes: %w", err)
+	}
+	return report, nil
+}
+
+// VolumeUsers lists the containers, running or not, that mount a volume
+func (s *volumeService) VolumeUsers(ctx context.Context, name string) ([]types.Container, error) {
+	ctx, cancel := s.timeouts.Context(ctx, docker.OpDefault)
+	defer cancel()
+
+	// Fail with not found for unknown volumes instead of an empty list
+	if _, err := s.cli.VolumeInspect(ctx, name); err != nil {
+		return nil, fmt.Errorf("failed to inspect volume: %w", err)
+	}
+	containers, err := s.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("volume", name)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	return containers, nil
+}
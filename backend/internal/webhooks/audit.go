@@ -0,0 +1,84 @@
+package webhooks
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/internal/httputil"
+	"github.com/gofiber/fiber/v2"
+)
+
+// AuditEntry is a change made through the harborview API
+type AuditEntry struct {
+	Method string `json:"method"`
+	// Route is the route template, such as /containers/:id/start
+	Route      string  `json:"route"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Endpoint   string  `json:"endpoint"`
+	RemoteAddr string  `json:"remoteAddr"`
+	Duration   float64 `json:"durationSeconds"`
+}
+
+var (
+	auditMu    sync.RWMutex
+	auditSinks = map[*Dispatcher]struct{}{}
+)
+
+// Audit publishes every POST, PUT, PATCH and DELETE request that matched a
+// route to the running dispatchers as an audit.<method> event
+func Audit(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	method := c.Method()
+	switch method {
+	case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+	default:
+		return err
+	}
+	route := c.Route().Path
+	if route == "/" && c.Path() != "/" {
+		return err
+	}
+	status := c.Response().StatusCode()
+	if err != nil {
+		// The error handler sets the status after the middleware returns
+		status = httputil.Status(err)
+	}
+	endpoint := c.Params("endpoint")
+	if endpoint == "" {
+		endpoint = docker.LocalEndpoint
+	}
+
+	path := c.Path()
+	// Deploy hook tokens are secrets, so their URLs are not passed on
+	if token := c.Params("token"); token != "" {
+		path = strings.ReplaceAll(path, token, "REDACTED")
+	}
+
+	entry := AuditEntry{
+		Method:     method,
+		Route:      route,
+		Path:       path,
+		Status:     status,
+		Endpoint:   endpoint,
+		RemoteAddr: c.IP(),
+		Duration:   time.Since(start).Seconds(),
+	}
+	event := Event{
+		Type:     "audit." + strings.ToLower(method),
+		Time:     time.Now().UTC(),
+		Endpoint: endpoint,
+		Data:     entry,
+	}
+
+	auditMu.RLock()
+	defer auditMu.RUnlock()
+	for d := range auditSinks {
+		d.Publish(event)
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Delivery statuses
+const (
+	// StatusPending deliveries are queued or waiting to be retried
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	// StatusFailed deliveries ran out of attempts or got an answer that is
+	// not worth retrying
+	StatusFailed = "failed"
+)
+
+// sendTimeout bounds a single attempt
+const sendTimeout = 15 * time.Second
+
+// Delivery is one event sent to one subscription
+type Delivery struct {
+	ID      string `json:"id"`
+	EventID string `json:"eventId"`
+	Event   string `json:"event"`
+	Status  string `json:"status"`
+	// RedeliveryOf is the delivery whose payload this one sends again
+	RedeliveryOf string          `json:"redeliveryOf,omitempty"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	CompletedAt  *time.Time      `json:"completedAt,omitempty"`
+	NextAttempt  *time.Time      `json:"nextAttempt,omitempty"`
+	Attempts     []Attempt       `json:"attempts"`
+	Payload      json.RawMessage `json:"payload"`
+}
+
+// Attempt is one try at a delivery
+type Attempt struct {
+	At time.Time `json:"at"`
+	// StatusCode is the receiver's answer, 0 when none arrived
+	StatusCode int     `json:"statusCode,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	Duration   float64 `json:"durationSeconds"`
+}
+
+// snapshot copies a logged delivery. d.mu must be held.
+func (delivery *Delivery) snapshot() Delivery {
+	copied := *delivery
+	copied.Attempts = append([]Attempt{}, delivery.Attempts...)
+	return copied
+}
+
+// work sends the deliveries queued for s until it is removed or the
+// dispatcher stops
+func (d *Dispatcher) work(s *subscriber) {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-d.ctx.Done():
+			return
+		case delivery := <-s.queue:
+			d.deliver(s, delivery)
+		}
+	}
+}
+
+// deliver makes one attempt at a delivery. A failure worth retrying is
+// queued again once its backoff has passed, so the wait does not hold up
+// the worker.
+func (d *Dispatcher) deliver(s *subscriber, delivery *Delivery) {
+	d.mu.Lock()
+	sub := s.sub
+	d.mu.Unlock()
+
+	result, retry := d.send(sub, delivery)
+
+	d.mu.Lock()
+	delivery.Attempts = append(delivery.Attempts, result)
+	delivery.NextAttempt = nil
+	var backoff time.Duration
+	switch {
+	case result.Error == "":
+		delivery.Status = StatusDelivered
+	case !retry || len(delivery.Attempts) >= d.maxAttempts:
+		delivery.Status = StatusFailed
+	default:
+		backoff = d.retryBackoff(len(delivery.Attempts))
+		next := time.Now().Add(backoff).UTC()
+		delivery.NextAttempt = &next
+	}
+	if delivery.Status != StatusPending {
+		completed := time.Now().UTC()
+		delivery.CompletedAt = &completed
+	}
+	d.mu.Unlock()
+
+	if backoff > 0 {
+		time.AfterFunc(backoff, func() { d.requeue(s, delivery) })
+	}
+}
+
+// retryBackoff is the wait after the given number of attempts: the
+// dispatcher's backoff, doubling with each attempt up to maxBackoff
+func (d *Dispatcher) retryBackoff(attempts int) time.Duration {
+	backoff := d.backoff
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff)
+}
+
+// requeue queues a delivery for its next attempt, unless the subscription
+// was removed or the dispatcher stopped in the meantime
+func (d *Dispatcher) requeue(s *subscriber, delivery *Delivery) {
+	select {
+	case <-s.stop:
+	case <-d.ctx.Done():
+	case s.queue <- delivery:
+	}
+}
+
+// send makes one attempt and reports whether a failure is worth retrying:
+// network errors, timeouts, 429 and 5xx answers are
+func (d *Dispatcher) send(sub Subscription, delivery *Delivery) (Attempt, bool) {
+	start := time.Now()
+	result := Attempt{At: start.UTC()}
+	fail := func(err error, retry bool) (Attempt, bool) {
+		result.Error = err.Error()
+		result.Duration = time.Since(start).Seconds()
+		return result, retry
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return fail(err, false)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "harborview")
+	for key, value := range sub.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(start.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, start, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fail(err, true)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+		return fail(fmt.Errorf("webhook answered %s", resp.Status), retry)
+	}
+	result.Duration = time.Since(start).Seconds()
+	return result, false
+}
@@ -0,0 +1,295 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+// testDispatcher has a short backoff, and stops delivering when the test
+// ends
+func testDispatcher(t *testing.T, cfg config.Config) *Dispatcher {
+	t.Helper()
+	d, err := NewDispatcher(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	d.backoff = 20 * time.Millisecond
+	t.Cleanup(d.cancel)
+	return d
+}
+
+// receiver answers deliveries with the given statuses in turn, then 200,
+// and records the requests
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// waitDelivered waits until every delivery in the log has completed
+func waitDelivered(t *testing.T, d *Dispatcher, id string, count int) []Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := d.Deliveries(id)
+		if err != nil {
+			t.Fatalf("Deliveries: %v", err)
+		}
+		done := len(deliveries) == count
+		for _, delivery := range deliveries {
+			done = done && delivery.Status != StatusPending
+		}
+		if done {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deliveries did not complete: %+v", deliveries)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSend(t *testing.T) {
+	d := testDispatcher(t, config.Config{})
+	r := newReceiver(t)
+	sub := Subscription{URL: r.URL, Secret: "s3cret", Headers: map[string]string{"Authorization": "Bearer t"}}
+	delivery := &Delivery{ID: "d1", Event: "container.start", Payload: json.RawMessage(`{"id":"e1"}`)}
+
+	attempt, retry := d.send(sub, delivery)
+	if attempt.Error != "" || attempt.StatusCode != http.StatusOK || retry {
+		t.Fatalf("send = %+v, %v", attempt, retry)
+	}
+	req := r.requests[0]
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp header: %v", err)
+	}
+	if got, want := req.Header.Get(HeaderSignature), Sign("s3cret", time.Unix(timestamp, 0), r.bodies[0]); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if req.Header.Get(HeaderEvent) != "container.start" || req.Header.Get(HeaderDelivery) != "d1" || req.Header.Get("Authorization") != "Bearer t" {
+		t.Errorf("headers = %v", req.Header)
+	}
+}
+
+func TestSendRetry(t *testing.T) {
+	d := testDispatcher(t, config.Config{})
+	delivery := &Delivery{ID: "d1", Event: "container.start", Payload: json.RawMessage(`{}`)}
+
+	tests := []struct {
+		status int
+		retry  bool
+	}{
+		{http.StatusRequestTimeout, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusNotFound, false},
+		{http.StatusGone, false},
+	}
+	for _, tt := range tests {
+		r := newReceiver(t, tt.status)
+		attempt, retry := d.send(Subscription{URL: r.URL}, delivery)
+		if attempt.StatusCode != tt.status || attempt.Error == "" || retry != tt.retry {
+			t.Errorf("%d: send = %+v, retry %v, want retry %v", tt.status, attempt, retry, tt.retry)
+		}
+	}
+
+	// Nothing listens on a closed server, which is worth retrying
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	attempt, retry := d.send(Subscription{URL: closed.URL}, delivery)
+	if attempt.StatusCode != 0 || attempt.Error == "" || !retry {
+		t.Errorf("network error: send = %+v, retry %v", attempt, retry)
+	}
+}
+
+func TestDeliverBackoff(t *testing.T) {
+	var cfg config.Config
+	cfg.Webhooks.MaxAttempts = 3
+
+	tests := []struct {
+		name     string
+		statuses []int
+		status   string
+		attempts int
+	}{
+		{"recovers", []int{503, 429}, StatusDelivered, 3},
+		{"runs out of attempts", []int{500, 500, 500, 500}, StatusFailed, 3},
+		{"not retried", []int{400}, StatusFailed, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := testDispatcher(t, cfg)
+			r := newReceiver(t, tt.statuses...)
+			sub, err := d.CreateSubscription(Subscription{URL: r.URL, Events: []string{"container.*"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Publish(Event{ID: "e1", Type: "container.start"})
+
+			delivery := waitDelivered(t, d, sub.ID, 1)[0]
+			if delivery.Status != tt.status || len(delivery.Attempts) != tt.attempts || delivery.CompletedAt == nil || delivery.NextAttempt != nil {
+				t.Fatalf("delivery = %+v", delivery)
+			}
+			// The wait starts at the backoff and doubles
+			for i := 1; i < len(delivery.Attempts); i++ {
+				wait := delivery.Attempts[i].At.Sub(delivery.Attempts[i-1].At)
+				if want := d.backoff << (i - 1); wait < want {
+					t.Errorf("attempt %d came %s after the previous one, want at least %s", i+1, wait, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetriesDoNotHoldWorkers(t *testing.T) {
+	d := testDispatcher(t, config.Config{})
+	d.backoff = maxBackoff
+	// The first delivery on every worker fails and waits minutes to retry
+	statuses := make([]int, workers)
+	for i := range statuses {
+		statuses[i] = http.StatusServiceUnavailable
+	}
+	r := newReceiver(t, statuses...)
+	sub, err := d.CreateSubscription(Subscription{URL: r.URL, Events: []string{"container.*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range workers + 1 {
+		d.Publish(Event{ID: "e" + strconv.Itoa(i), Type: "container.start"})
+	}
+
+	// The last event still goes out while the others wait
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := d.Deliveries(sub.ID)
+		if err != nil {
+			t.Fatalf("Deliveries: %v", err)
+		}
+		delivered, waiting := 0, 0
+		for _, delivery := range deliveries {
+			switch {
+			case delivery.Status == StatusDelivered:
+				delivered++
+			case delivery.Status == StatusPending && delivery.NextAttempt != nil && len(delivery.Attempts) == 1:
+				waiting++
+				if wait := time.Until(*delivery.NextAttempt); wait < maxBackoff-time.Minute {
+					t.Fatalf("next attempt in %s, want %s", wait, maxBackoff)
+				}
+			}
+		}
+		if delivered == 1 && waiting == workers {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d delivered and %d waiting to retry, want 1 and %d: %+v", delivered, waiting, workers, deliveries)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	d := testDispatcher(t, config.Config{})
+	d.backoff = initialBackoff
+	tests := map[int]time.Duration{
+		1:   10 * time.Second,
+		2:   20 * time.Second,
+		3:   40 * time.Second,
+		5:   160 * time.Second,
+		6:   maxBackoff,
+		100: maxBackoff,
+	}
+	for attempts, want := range tests {
+		if got := d.retryBackoff(attempts); got != want {
+			t.Errorf("retryBackoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestDeliveryLog(t *testing.T) {
+	var cfg config.Config
+	cfg.Webhooks.LogSize = 3
+	d := testDispatcher(t, cfg)
+	r := newReceiver(t)
+	sub, err := d.CreateSubscription(Subscription{URL: r.URL, Events: []string{"container.*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"e1", "e2", "e3", "e4"} {
+		d.Publish(Event{ID: id, Type: "container.start"})
+	}
+	d.Publish(Event{ID: "skipped", Type: "image.pull"})
+
+	// The log keeps the newest deliveries, newest first
+	deliveries := waitDelivered(t, d, sub.ID, 3)
+	for i, want := range []string{"e4", "e3", "e2"} {
+		if deliveries[i].EventID != want || deliveries[i].Status != StatusDelivered {
+			t.Errorf("delivery %d = %s %s, want %s delivered", i, deliveries[i].EventID, deliveries[i].Status, want)
+		}
+	}
+
+	redelivery, err := d.Redeliver(sub.ID, deliveries[0].ID)
+	if err != nil {
+		t.Fatalf("Redeliver: %v", err)
+	}
+	if redelivery.EventID != "e4" || redelivery.RedeliveryOf != deliveries[0].ID || string(redelivery.Payload) != string(deliveries[0].Payload) {
+		t.Errorf("redelivery = %+v", redelivery)
+	}
+	if _, err := d.GetDelivery(sub.ID, deliveries[2].ID); !errdefs.IsNotFound(err) {
+		t.Errorf("GetDelivery of a delivery dropped from the log = %v", err)
+	}
+
+	// Pings reach disabled subscriptions, events do not
+	disabled := Subscription{URL: r.URL, Events: []string{"*"}, Disabled: true}
+	if _, err := d.UpdateSubscription(sub.ID, disabled); err != nil {
+		t.Fatal(err)
+	}
+	d.Publish(Event{ID: "e5", Type: "container.stop"})
+	ping, err := d.Ping(sub.ID)
+	if err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	deliveries = waitDelivered(t, d, sub.ID, 3)
+	if deliveries[0].ID != ping.ID || deliveries[0].Event != EventPing || deliveries[1].ID != redelivery.ID {
+		t.Errorf("log after ping = %+v", deliveries)
+	}
+
+	if _, err := d.Deliveries("missing"); !errdefs.IsNotFound(err) {
+		t.Errorf("Deliveries of an unknown subscription = %v", err)
+	}
+}
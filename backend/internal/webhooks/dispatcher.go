@@ -0,0 +1,510 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/docker"
+	"github.com/genc-murat/harborview/internal/events"
+	"github.com/genc-murat/harborview/internal/fsutil"
+	"github.com/genc-murat/harborview/pkg/config"
+)
+
+const (
+	// DefaultMaxAttempts is how many times a delivery is tried when config
+	// sets none
+	DefaultMaxAttempts = 5
+	// DefaultLogSize is how many deliveries are kept per subscription when
+	// config sets none
+	DefaultLogSize = 100
+	// EventPing is sent by Ping whatever the subscription's events
+	EventPing = "ping"
+
+	// queueSize is how many deliveries may wait per subscription before new
+	// ones fail
+	queueSize = 256
+	// workers is how many deliveries of one subscription run at once
+	workers = 4
+	// initialBackoff is the wait before the first retry; it doubles up to
+	// maxBackoff
+	initialBackoff = 10 * time.Second
+	maxBackoff     = 5 * time.Minute
+)
+
+// WebhookService interface for dependency injection
+type WebhookService interface {
+	Subscriptions() []Subscription
+	GetSubscription(id string) (Subscription, error)
+	CreateSubscription(sub Subscription) (Subscription, error)
+	UpdateSubscription(id string, sub Subscription) (Subscription, error)
+	DeleteSubscription(id string) error
+	Deliveries(id string) ([]Delivery, error)
+	GetDelivery(id, deliveryID string) (Delivery, error)
+	Redeliver(id, deliveryID string) (Delivery, error)
+	Ping(id string) (Delivery, error)
+}
+
+// Event is the body of a delivery. ID is the same across redeliveries, so
+// receivers can drop duplicates.
+type Event struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Endpoint string    `json:"endpoint"`
+	Data     any       `json:"data"`
+}
+
+// subscriber is a subscription with its queue and delivery log
+type subscriber struct {
+	sub   Subscription
+	queue chan *Delivery
+	// log holds the most recent deliveries, oldest first
+	log  []*Delivery
+	stop chan struct{}
+}
+
+// Dispatcher delivers the events of the local daemon and the audit log to
+// the subscriptions
+type Dispatcher struct {
+	file        string
+	maxAttempts int
+	logSize     int
+	backoff     time.Duration
+	hub         *events.Hub
+	client      *http.Client
+
+	// ctx bounds deliveries and ends with Run
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	subs map[string]*subscriber
+}
+
+// NewDispatcher creates a dispatcher for the subscriptions persisted in the
+// configured file. It follows hub and the audit log once running.
+func NewDispatcher(cfg config.Config, hub *events.Hub) (*Dispatcher, error) {
+	d := &Dispatcher{
+		file:        cfg.Webhooks.File,
+		maxAttempts: DefaultMaxAttempts,
+		logSize:     DefaultLogSize,
+		backoff:     initialBackoff,
+		hub:         hub,
+		client:      &http.Client{Timeout: sendTimeout},
+		subs:        make(map[string]*subscriber),
+	}
+	if cfg.Webhooks.MaxAttempts > 0 {
+		d.maxAttempts = cfg.Webhooks.MaxAttempts
+	}
+	if cfg.Webhooks.LogSize > 0 {
+		d.logSize = cfg.Webhooks.LogSize
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	stored, err := d.readFile()
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range stored {
+		if err := sub.validate(); err != nil {
+			log.Printf("Skipping stored webhook %s: %v", sub.ID, err)
+			continue
+		}
+		d.add(sub)
+	}
+	return d, nil
+}
+
+// Run publishes daemon events and audit entries until ctx is cancelled,
+// then stops the deliveries in flight
+func (d *Dispatcher) Run(ctx context.Context) {
+	auditMu.Lock()
+	auditSinks[d] = struct{}{}
+	auditMu.Unlock()
+	defer func() {
+		auditMu.Lock()
+		delete(auditSinks, d)
+		auditMu.Unlock()
+		d.cancel()
+	}()
+	d.watch(ctx)
+}
+
+// watch publishes daemon events, resuming from the last one seen after the
+// subscription drops
+func (d *Dispatcher) watch(ctx context.Context) {
+	var after events.Cursor
+	for {
+		sub := d.hub.Subscribe(events.Filter{}, after)
+		func() {
+			defer sub.Close()
+			if sub.Gap != nil {
+				log.Printf("Webhook events lost after %s: history %s", sub.Gap.After, sub.Gap.Reason)
+			}
+			for _, event := range sub.Backlog {
+				after = event.Cursor()
+				d.Publish(dockerEvent(event))
+			}
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event, ok := <-sub.C:
+					if !ok {
+						return
+					}
+					after = event.Cursor()
+					d.Publish(dockerEvent(event))
+				}
+			}
+		}()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// dockerEvent names a daemon event by its type and action, dropping the
+// detail after ":" so health_status: healthy is container.health_status
+func dockerEvent(event events.Event) Event {
+	action, _, _ := strings.Cut(event.Action, ":")
+	return Event{
+		Type:     event.Type + "." + action,
+		Time:     event.Time,
+		Endpoint: docker.LocalEndpoint,
+		Data:     event,
+	}
+}
+
+// Publish queues event for every subscription that wants it
+func (d *Dispatcher) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.ID == "" {
+		id, err := newID()
+		if err != nil {
+			log.Printf("Dropping webhook event %s: %v", event.Type, err)
+			return
+		}
+		event.ID = id
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Dropping webhook event %s: %v", event.Type, err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range d.subs {
+		if s.sub.wants(event.Type) {
+			d.enqueue(s, event.ID, event.Type, payload, "")
+		}
+	}
+}
+
+// enqueue records a delivery in the log of s and queues it. d.mu must be
+// held.
+func (d *Dispatcher) enqueue(s *subscriber, eventID, eventType string, payload []byte, redeliveryOf string) (*Delivery, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	delivery := &Delivery{
+		ID:           id,
+		EventID:      eventID,
+		Event:        eventType,
+		Status:       StatusPending,
+		RedeliveryOf: redeliveryOf,
+		CreatedAt:    time.Now().UTC(),
+		Payload:      payload,
+	}
+	s.log = append(s.log, delivery)
+	if len(s.log) > d.logSize {
+		s.log = s.log[len(s.log)-d.logSize:]
+	}
+	select {
+	case s.queue <- delivery:
+	default:
+		now := time.Now().UTC()
+		delivery.Status, delivery.CompletedAt = StatusFailed, &now
+		delivery.Attempts = []Attempt{{At: now, Error: "delivery queue is full"}}
+	}
+	return delivery, nil
+}
+
+// add registers a subscription and starts its workers. d.mu must be held
+// or the dispatcher not yet shared.
+func (d *Dispatcher) add(sub Subscription) {
+	s := &subscriber{sub: sub, queue: make(chan *Delivery, queueSize), stop: make(chan struct{})}
+	d.subs[sub.ID] = s
+	for range workers {
+		go d.work(s)
+	}
+}
+
+// Subscriptions returns every subscription, oldest first
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	subs := make([]Subscription, 0, len(d.subs))
+	for _, s := range d.subs {
+		subs = append(subs, s.sub.redacted())
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+	return subs
+}
+
+// GetSubscription returns one subscription
+func (d *Dispatcher) GetSubscription(id string) (Subscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, err := d.subscriber(id)
+	if err != nil {
+		return Subscription{}, err
+	}
+	return s.sub.redacted(), nil
+}
+
+// CreateSubscription adds a subscription. The result carries the secret,
+// which is not returned again.
+func (d *Dispatcher) CreateSubscription(sub Subscription) (Subscription, error) {
+	if err := sub.validate(); err != nil {
+		return Subscription{}, err
+	}
+	id, err := newID()
+	if err != nil {
+		return Subscription{}, err
+	}
+	if sub.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Subscription{}, fmt.Errorf("failed to generate secret: %w", err)
+		}
+		sub.Secret = hex.EncodeToString(b)
+	}
+	now := time.Now().UTC()
+	sub.ID, sub.CreatedAt, sub.UpdatedAt = id, now, now
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.add(sub)
+	if err := d.writeFile(); err != nil {
+		d.remove(id)
+		return Subscription{}, err
+	}
+	return sub, nil
+}
+
+// UpdateSubscription replaces a subscription, keeping its secret when sub
+// sets none. Queued deliveries go out with the new settings.
+func (d *Dispatcher) UpdateSubscription(id string, sub Subscription) (Subscription, error) {
+	if err := sub.validate(); err != nil {
+		return Subscription{}, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, err := d.subscriber(id)
+	if err != nil {
+		return Subscription{}, err
+	}
+	previous := s.sub
+	if sub.Secret == "" {
+		sub.Secret = previous.Secret
+	}
+	sub.ID, sub.CreatedAt, sub.UpdatedAt = id, previous.CreatedAt, time.Now().UTC()
+	s.sub = sub
+	if err := d.writeFile(); err != nil {
+		s.sub = previous
+		return Subscription{}, err
+	}
+	return sub.redacted(), nil
+}
+
+// DeleteSubscription removes a subscription and drops its queued
+// deliveries
+func (d *Dispatcher) DeleteSubscription(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, err := d.subscriber(id)
+	if err != nil {
+		return err
+	}
+	delete(d.subs, id)
+	if err := d.writeFile(); err != nil {
+		d.subs[id] = s
+		return err
+	}
+	close(s.stop)
+	return nil
+}
+
+// remove drops a subscription that was just added. d.mu must be held.
+func (d *Dispatcher) remove(id string) {
+	if s, ok := d.subs[id]; ok {
+		delete(d.subs, id)
+		close(s.stop)
+	}
+}
+
+// Deliveries returns the delivery log of a subscription, newest first
+func (d *Dispatcher) Deliveries(id string) ([]Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, err := d.subscriber(id)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]Delivery, 0, len(s.log))
+	for i := len(s.log) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, s.log[i].snapshot())
+	}
+	return deliveries, nil
+}
+
+// GetDelivery returns one delivery from a subscription's log
+func (d *Dispatcher) GetDelivery(id, deliveryID string) (Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delivery, _, err := d.delivery(id, deliveryID)
+	if err != nil {
+		return Delivery{}, err
+	}
+	return delivery.snapshot(), nil
+}
+
+// Redeliver queues the payload of a logged delivery again
+func (d *Dispatcher) Redeliver(id, deliveryID string) (Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	original, s, err := d.delivery(id, deliveryID)
+	if err != nil {
+		return Delivery{}, err
+	}
+	delivery, err := d.enqueue(s, original.EventID, original.Event, original.Payload, original.ID)
+	if err != nil {
+		return Delivery{}, err
+	}
+	return delivery.snapshot(), nil
+}
+
+// Ping queues a ping event to a subscription, even a disabled one
+func (d *Dispatcher) Ping(id string) (Delivery, error) {
+	eventID, err := newID()
+	if err != nil {
+		return Delivery{}, err
+	}
+	event := Event{
+		ID:       eventID,
+		Type:     EventPing,
+		Time:     time.Now().UTC(),
+		Endpoint: docker.LocalEndpoint,
+		Data:     map[string]string{"subscription": id},
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, err := d.subscriber(id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	delivery, err := d.enqueue(s, event.ID, event.Type, payload, "")
+	if err != nil {
+		return Delivery{}, err
+	}
+	return delivery.snapshot(), nil
+}
+
+// subscriber looks up a subscription. d.mu must be held.
+func (d *Dispatcher) subscriber(id string) (*subscriber, error) {
+	s, ok := d.subs[id]
+	if !ok {
+		return nil, errdefs.NotFound(fmt.Errorf("webhook %s not found", id))
+	}
+	return s, nil
+}
+
+// delivery looks up a logged delivery. d.mu must be held.
+func (d *Dispatcher) delivery(id, deliveryID string) (*Delivery, *subscriber, error) {
+	s, err := d.subscriber(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, delivery := range s.log {
+		if delivery.ID == deliveryID {
+			return delivery, s, nil
+		}
+	}
+	return nil, nil, errdefs.NotFound(fmt.Errorf("delivery %s not found", deliveryID))
+}
+
+// readFile loads the persisted subscriptions
+func (d *Dispatcher) readFile() ([]Subscription, error) {
+	if d.file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(d.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks file: %w", err)
+	}
+	var stored []Subscription
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode webhooks file: %w", err)
+	}
+	return stored, nil
+}
+
+// writeFile persists the subscriptions with their secrets. d.mu must be
+// held.
+func (d *Dispatcher) writeFile() error {
+	if d.file == "" {
+		return nil
+	}
+
+	stored := make([]Subscription, 0, len(d.subs))
+	for _, s := range d.subs {
+		stored = append(stored, s.sub)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].ID < stored[j].ID })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	// The file is created 0600, which keeps the secrets private
+	if err := fsutil.WriteFileAtomic(d.file, data); err != nil {
+		return fmt.Errorf("failed to store webhooks: %w", err)
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,162 @@
+package webhooks
+
+import (
+	"context"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/genc-murat/harborview/internal/events"
+	"github.com/genc-murat/harborview/pkg/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterRoutes starts delivering the events of the daemon behind the
+// shared client and the audit log, and registers the webhook routes.
+// Delivery runs until ctx is cancelled.
+func RegisterRoutes(ctx context.Context, app *fiber.App, cfg config.Config, cli *client.Client) error {
+	dispatcher, err := NewDispatcher(cfg, events.Shared(ctx, cfg, cli))
+	if err != nil {
+		return err
+	}
+	go dispatcher.Run(ctx)
+
+	var service WebhookService = dispatcher
+	webhooksGroup := app.Group("/webhooks")
+
+	// List subscriptions
+	webhooksGroup.Get("/", func(c *fiber.Ctx) error {
+		return c.JSON(service.Subscriptions())
+	})
+
+	// Add a subscription
+	webhooksGroup.Post("/", func(c *fiber.Ctx) error {
+		return CreateSubscription(c, service)
+	})
+
+	// Get a subscription
+	webhooksGroup.Get("/:id", func(c *fiber.Ctx) error {
+		return GetSubscription(c, service)
+	})
+
+	// Replace a subscription
+	webhooksGroup.Put("/:id", func(c *fiber.Ctx) error {
+		return UpdateSubscription(c, service)
+	})
+
+	// Remove a subscription
+	webhooksGroup.Delete("/:id", func(c *fiber.Ctx) error {
+		return DeleteSubscription(c, service)
+	})
+
+	// Send a ping event
+	webhooksGroup.Post("/:id/ping", func(c *fiber.Ctx) error {
+		return Ping(c, service)
+	})
+
+	// List the delivery log, newest first
+	webhooksGroup.Get("/:id/deliveries", func(c *fiber.Ctx) error {
+		return ListDeliveries(c, service)
+	})
+
+	// Get a delivery
+	webhooksGroup.Get("/:id/deliveries/:delivery", func(c *fiber.Ctx) error {
+		return GetDelivery(c, service)
+	})
+
+	// Send a delivery's payload again
+	webhooksGroup.Post("/:id/deliveries/:delivery/redeliver", func(c *fiber.Ctx) error {
+		return Redeliver(c, service)
+	})
+	return nil
+}
+
+// webhookError maps dispatcher errors to statuses
+func webhookError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errdefs.IsInvalidParameter(err):
+		status = fiber.StatusBadRequest
+	case errdefs.IsNotFound(err):
+		status = fiber.StatusNotFound
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
+
+// CreateSubscription handler for adding a subscription
+func CreateSubscription(c *fiber.Ctx, service WebhookService) error {
+	var sub Subscription
+	if err := c.BodyParser(&sub); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	created, err := service.CreateSubscription(sub)
+	if err != nil {
+		return webhookError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+// GetSubscription handler for fetching one subscription
+func GetSubscription(c *fiber.Ctx, service WebhookService) error {
+	sub, err := service.GetSubscription(c.Params("id"))
+	if err != nil {
+		return webhookError(c, err)
+	}
+	return c.JSON(sub)
+}
+
+// UpdateSubscription handler for replacing a subscription
+func UpdateSubscription(c *fiber.Ctx, service WebhookService) error {
+	var sub Subscription
+	if err := c.BodyParser(&sub); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	updated, err := service.UpdateSubscription(c.Params("id"), sub)
+	if err != nil {
+		return webhookError(c, err)
+	}
+	return c.JSON(updated)
+}
+
+// DeleteSubscription handler for removing a subscription
+func DeleteSubscription(c *fiber.Ctx, service WebhookService) error {
+	if err := service.DeleteSubscription(c.Params("id")); err != nil {
+		return webhookError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Webhook removed successfully"})
+}
+
+// Ping handler for sending a ping event
+func Ping(c *fiber.Ctx, service WebhookService) error {
+	delivery, err := service.Ping(c.Params("id"))
+	if err != nil {
+		return webhookError(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(delivery)
+}
+
+// ListDeliveries handler for a subscription's delivery log
+func ListDeliveries(c *fiber.Ctx, service WebhookService) error {
+	deliveries, err := service.Deliveries(c.Params("id"))
+	if err != nil {
+		return webhookError(c, err)
+	}
+	return c.JSON(deliveries)
+}
+
+// GetDelivery handler for fetching one delivery
+func GetDelivery(c *fiber.Ctx, service WebhookService) error {
+	delivery, err := service.GetDelivery(c.Params("id"), c.Params("delivery"))
+	if err != nil {
+		return webhookError(c, err)
+	}
+	return c.JSON(delivery)
+}
+
+// Redeliver handler for sending a delivery's payload again
+func Redeliver(c *fiber.Ctx, service WebhookService) error {
+	delivery, err := service.Redeliver(c.Params("id"), c.Params("delivery"))
+	if err != nil {
+		return webhookError(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(delivery)
+}
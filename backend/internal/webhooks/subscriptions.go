@@ -0,0 +1,97 @@
+// Package webhooks posts Docker and harborview events to subscribed URLs.
+// Payloads are signed with the subscription's secret, retried with backoff
+// and recorded in a delivery log per subscription.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// Request headers sent with every delivery
+const (
+	HeaderEvent     = "X-Harborview-Event"
+	HeaderDelivery  = "X-Harborview-Delivery"
+	HeaderTimestamp = "X-Harborview-Timestamp"
+	// HeaderSignature is "sha256=" and the hex HMAC-SHA256 of the timestamp
+	// header, a dot and the body, keyed with the subscription's secret
+	HeaderSignature = "X-Harborview-Signature"
+)
+
+// Subscription posts the events whose type matches one of Events to URL.
+// Event types are the Docker event type and action, such as
+// container.start, image.pull or volume.prune, or audit.post, audit.put
+// and audit.delete for changes made through harborview. Events are path
+// patterns, so container.* and * work.
+type Subscription struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name,omitempty"`
+	URL     string            `json:"url"`
+	Events  []string          `json:"events"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Secret keys the signatures. One is generated when none is given; it is
+	// only returned when the subscription is created.
+	Secret    string    `json:"secret,omitempty"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// validate checks the fields a client sets
+func (s *Subscription) validate() error {
+	target, err := url.Parse(s.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errdefs.InvalidParameter(fmt.Errorf("url must be an http or https url"))
+	}
+	if len(s.Events) == 0 {
+		return errdefs.InvalidParameter(fmt.Errorf("events must name at least one event type"))
+	}
+	for _, pattern := range s.Events {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return errdefs.InvalidParameter(fmt.Errorf("invalid event pattern %q", pattern))
+		}
+	}
+	for key := range s.Headers {
+		if strings.HasPrefix(strings.ToLower(key), "x-harborview-") {
+			return errdefs.InvalidParameter(fmt.Errorf("header %s is set by harborview", key))
+		}
+	}
+	return nil
+}
+
+// wants reports whether the subscription receives events of eventType
+func (s *Subscription) wants(eventType string) bool {
+	if s.Disabled {
+		return false
+	}
+	for _, pattern := range s.Events {
+		if ok, _ := path.Match(pattern, eventType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted is the subscription as listed, without its secret
+func (s Subscription) redacted() Subscription {
+	s.Secret = ""
+	return s
+}
+
+// Sign returns the signature header value of body sent at timestamp
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
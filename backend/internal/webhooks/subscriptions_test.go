@@ -0,0 +1,52 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"e1","type":"container.start"}`)
+	at := time.Unix(1700000000, 0)
+
+	// What a receiver computes from the timestamp header and the body
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := Sign("s3cret", at, body); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	if Sign("other", at, body) == want {
+		t.Error("Sign ignores the secret")
+	}
+	if Sign("s3cret", at.Add(time.Second), body) == want {
+		t.Error("Sign ignores the timestamp")
+	}
+}
+
+func TestSubscriptionValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		sub  Subscription
+		ok   bool
+	}{
+		{"valid", Subscription{URL: "https://hooks.example.com/in", Events: []string{"container.*"}}, true},
+		{"no scheme", Subscription{URL: "hooks.example.com", Events: []string{"*"}}, false},
+		{"ftp", Subscription{URL: "ftp://hooks.example.com", Events: []string{"*"}}, false},
+		{"no events", Subscription{URL: "https://hooks.example.com"}, false},
+		{"bad pattern", Subscription{URL: "https://hooks.example.com", Events: []string{"container.["}}, false},
+		{"reserved header", Subscription{URL: "https://hooks.example.com", Events: []string{"*"}, Headers: map[string]string{"X-Harborview-Event": "x"}}, false},
+	}
+	for _, tt := range tests {
+		err := tt.sub.validate()
+		if tt.ok != (err == nil) || (err != nil && !errdefs.IsInvalidParameter(err)) {
+			t.Errorf("%s: validate = %v", tt.name, err)
+		}
+	}
+}
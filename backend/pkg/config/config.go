@@ -11,13 +11,196 @@ import (
 // Config struct defines the structure of the YAML configuration.
 type Config struct {
 	Server struct {
-		Port string `yaml:"port"`
+		Port        string `yaml:"port"`
+		MaxUploadMB int    `yaml:"maxUploadMB"`
 	} `yaml:"server"`
+	Docker struct {
+		// StartupTimeout is how many seconds startup waits for the local
+		// daemon to answer
+		StartupTimeout int `yaml:"startupTimeout"`
+		// Optional lets harborview start without a reachable local daemon,
+		// e.g. when it only manages remote endpoints
+		Optional bool `yaml:"optional"`
+		// Timeouts are per-operation limits in seconds, keyed by operation
+		// (default, pull, push, build, save, load, scan, exec, logs, stats,
+		// prune, df, deploy). 0 disables the limit.
+		Timeouts map[string]int `yaml:"timeouts"`
+	} `yaml:"docker"`
 	Registry struct {
 		BaseURL  string `yaml:"baseUrl"`
 		Username string `yaml:"username"`
 		Password string `yaml:"password"`
+		// InsecureSkipVerify disables TLS verification for registries with
+		// self-signed certificates
+		InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
 	} `yaml:"registry"`
+	Images struct {
+		// ExportDir is the only directory server-side image exports may be
+		// written to. Server-side export is disabled when it is empty.
+		ExportDir string `yaml:"exportDir"`
+	} `yaml:"images"`
+	Volumes struct {
+		// HelperImage runs the short-lived containers that read volume
+		// contents. It is pulled on first use and is not subject to the
+		// signing policy.
+		HelperImage string `yaml:"helperImage"`
+		// BackupDir keeps backup archives and their metadata. Backups are
+		// only streamed, with metadata kept in memory, when it is empty.
+		BackupDir string `yaml:"backupDir"`
+	} `yaml:"volumes"`
+	Stacks struct {
+		// Dir stores stack definitions so they can be redeployed. They are
+		// kept in memory only when it is empty.
+		Dir string `yaml:"dir"`
+	} `yaml:"stacks"`
+	Events struct {
+		// BufferSize is how many recent events are kept for queries and
+		// for clients resuming a stream
+		BufferSize int `yaml:"bufferSize"`
+	} `yaml:"events"`
+	Metrics struct {
+		// Interval is the number of seconds between container stats samples
+		Interval int `yaml:"interval"`
+		// DiskUsageInterval is the number of seconds between image and
+		// volume disk usage refreshes
+		DiskUsageInterval int `yaml:"diskUsageInterval"`
+		// ContainerLabels are container labels copied onto per-container
+		// series as container_label_<name>, e.g. com.docker.compose.project
+		ContainerLabels []string `yaml:"containerLabels"`
+		// Labels are added to every series, e.g. to tell harborview
+		// instances apart. Names harborview's series use, such as id, name
+		// and endpoint, are rejected at startup.
+		Labels  map[string]string `yaml:"labels"`
+		History struct {
+			// Dir keeps container usage history across restarts, one file
+			// per daemon. It is kept in memory only when it is empty.
+			Dir string `yaml:"dir"`
+			// Retention is the number of hours history is kept. Recent
+			// history keeps every sample; older history is downsampled to
+			// one point per minute, then per ten minutes.
+			Retention int `yaml:"retention"`
+		} `yaml:"history"`
+	} `yaml:"metrics"`
+	Alerts struct {
+		// File persists rules and silences added through the API. They are
+		// kept in memory only when it is empty.
+		File string `yaml:"file"`
+		// Interval is the number of seconds between rule evaluations
+		Interval int                  `yaml:"interval"`
+		Rules    []AlertRuleConfig    `yaml:"rules"`
+		Channels []AlertChannelConfig `yaml:"channels"`
+	} `yaml:"alerts"`
+	Webhooks struct {
+		// File persists webhook subscriptions. They are kept in memory only
+		// when it is empty.
+		File string `yaml:"file"`
+		// MaxAttempts is how many times a delivery is tried before it is
+		// marked failed
+		MaxAttempts int `yaml:"maxAttempts"`
+		// LogSize is how many deliveries are kept per subscription
+		LogSize int `yaml:"logSize"`
+	} `yaml:"webhooks"`
+	Deploy struct {
+		// File persists deploy hooks. They are kept in memory only when it
+		// is empty.
+		File string `yaml:"file"`
+		// HealthTimeout is how long, in seconds, a redeployed container has
+		// to become healthy before it is rolled back
+		HealthTimeout int `yaml:"healthTimeout"`
+	} `yaml:"deploy"`
+	Scanning struct {
+		// VulnDB is an OSV JSON file, osv.dev all.zip export or a directory
+		// of them
+		VulnDB string `yaml:"vulnDB"`
+		// ReportDir stores scan reports per image digest. Reports are kept
+		// in memory only when it is empty.
+		ReportDir string `yaml:"reportDir"`
+	} `yaml:"scanning"`
+	Signing struct {
+		// Policy is "disabled", "warn" or "enforce". Enforce makes pulls and
+		// container creation reject images without a valid signature.
+		Policy string `yaml:"policy"`
+		// PublicKeys are cosign public keys, as PEM file paths or inline PEM
+		PublicKeys []string `yaml:"publicKeys"`
+		// RequiredAttestations are in-toto predicate types every image must
+		// carry a verified attestation for, e.g. SLSA provenance
+		RequiredAttestations []string `yaml:"requiredAttestations"`
+	} `yaml:"signing"`
+	Endpoints struct {
+		// File persists endpoints added through the API. They are kept in
+		// memory only when it is empty.
+		File string `yaml:"file"`
+		// CredentialsDir is where endpoints added through the API may take
+		// TLS and SSH credential files from. Such endpoints cannot name
+		// credential files when it is empty.
+		CredentialsDir string `yaml:"credentialsDir"`
+		// HealthInterval is the number of seconds between endpoint health
+		// checks
+		HealthInterval int              `yaml:"healthInterval"`
+		Hosts          []EndpointConfig `yaml:"hosts"`
+	} `yaml:"endpoints"`
+}
+
+// EndpointConfig is a Docker host managed by harborview
+type EndpointConfig struct {
+	Name string `yaml:"name"`
+	// Host is a unix://, tcp:// or ssh://user@host[:port] Docker host. An
+	// empty host uses DOCKER_HOST and the other Docker environment variables.
+	Host   string            `yaml:"host"`
+	Labels map[string]string `yaml:"labels"`
+	TLS    struct {
+		CA                 string `yaml:"ca"`
+		Cert               string `yaml:"cert"`
+		Key                string `yaml:"key"`
+		InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	} `yaml:"tls"`
+	// SSHIdentityFile is the private key for ssh:// hosts; the SSH agent and
+	// ~/.ssh/config are used when it is empty
+	SSHIdentityFile string `yaml:"sshIdentityFile"`
+}
+
+// AlertRuleConfig is an alerting rule. Type is exited, restarts,
+// unhealthy, cpu or memory.
+type AlertRuleConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Threshold is a percentage for cpu and memory, and a number of
+	// restarts within Window for restarts
+	Threshold float64 `yaml:"threshold"`
+	// For is how long the condition must hold before the alert fires,
+	// e.g. 5m
+	For string `yaml:"for"`
+	// Window is the period restarts are counted over, e.g. 10m
+	Window string `yaml:"window"`
+	// Containers are container name patterns such as web-*; all
+	// containers when empty
+	Containers []string `yaml:"containers"`
+	// Labels are key or key=value container label matches
+	Labels   []string `yaml:"labels"`
+	Severity string   `yaml:"severity"`
+	// Channels are the channels notified; all of them when empty
+	Channels []string `yaml:"channels"`
+	// RepeatInterval re-sends a firing alert this often, e.g. 4h; firing
+	// alerts are sent once when it is empty
+	RepeatInterval string `yaml:"repeatInterval"`
+}
+
+// AlertChannelConfig is a notification channel. Type is webhook, slack or
+// smtp.
+type AlertChannelConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// URL is where webhook and slack channels post to
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	SMTP    struct {
+		// Host is host:port of the mail server
+		Host     string   `yaml:"host"`
+		Username string   `yaml:"username"`
+		Password string   `yaml:"password"`
+		From     string   `yaml:"from"`
+		To       []string `yaml:"to"`
+	} `yaml:"smtp"`
 }
 
 // Load reads the configuration from config/config.yaml.
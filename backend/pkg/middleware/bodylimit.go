@@ -0,0 +1,16 @@
+package middleware
+
+import "github.com/gofiber/fiber/v2"
+
+// BodyLimit answers 413 for requests whose declared length is over the app's
+// BodyLimit, before any of the body is read, and closes the connection since
+// the rest of the body is left unread. Request bodies are streamed, so Fiber
+// does not enforce the limit itself; bodies of unknown length are capped
+// where uploads are read, by httputil.Upload.
+func BodyLimit(c *fiber.Ctx) error {
+	if c.Request().Header.ContentLength() > c.App().Config().BodyLimit {
+		c.Context().SetConnectionClose()
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": fiber.ErrRequestEntityTooLarge.Message})
+	}
+	return c.Next()
+}
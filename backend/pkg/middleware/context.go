@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequestContext gives every request its own context through c.UserContext().
+// It is cancelled when the handler returns and when the server shuts down,
+// which cancels the Docker calls made on the request's behalf. Responses
+// streamed after the handler returns run on c.Context() instead, and are
+// cancelled when the stream is closed or the server shuts down.
+func RequestContext(c *fiber.Ctx) error {
+	ctx, cancel := context.WithCancel(c.Context())
+	defer cancel()
+	c.SetUserContext(ctx)
+	return c.Next()
+}